@@ -0,0 +1,191 @@
+package backup
+
+import (
+	"fmt"
+	api "github.com/aoxn/wdrip/pkg/apis/alibabacloud.com/v1"
+	"github.com/aoxn/wdrip/pkg/iaas"
+	"github.com/spf13/cobra"
+)
+
+const mhelp = `
+## Take a named backup before risky operation, named backup is never garbage collected
+wdrip backup create -c kubernetes-wdrip-64 --name pre-upgrade
+
+## Backup api objects of namespaces in yaml, restore with [wdrip restore resources]
+wdrip backup create -c kubernetes-wdrip-64 --name app-0918 --resources --namespace app,db
+
+## List backups of cluster
+wdrip backup list -c kubernetes-wdrip-64
+
+## Describe backup by identity or name
+wdrip backup describe -c kubernetes-wdrip-64 pre-upgrade
+
+## Pin backup to exclude it from automatic gc, unpin with --unpin
+wdrip backup pin -c kubernetes-wdrip-64 20211019-1200
+
+## Delete backup, pinned backup must be unpinned first
+wdrip backup delete -c kubernetes-wdrip-64 pre-upgrade
+
+## Prove the latest backup restorable in a throwaway etcd, result shown in [wdrip get backup]
+wdrip backup drill -c kubernetes-wdrip-64
+
+## Drill a chosen backup
+wdrip backup drill -c kubernetes-wdrip-64 pre-upgrade
+
+## Repair cluster index and backups on replica buckets configured by provider replicas
+wdrip backup verify -c kubernetes-wdrip-64
+`
+
+func NewCommand() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "backup",
+		Short: "manage etcd backups of kubernetes cluster",
+		Long:  mhelp,
+	}
+	cmd.AddCommand(NewCommandCreate())
+	cmd.AddCommand(NewCommandList())
+	cmd.AddCommand(NewCommandDescribe())
+	cmd.AddCommand(NewCommandDelete())
+	cmd.AddCommand(NewCommandPin())
+	cmd.AddCommand(NewCommandVerify())
+	cmd.AddCommand(NewCommandDrill())
+	return cmd
+}
+
+func NewCommandCreate() *cobra.Command {
+	flags := &api.WdripOptions{}
+	cmdLine := &api.CommandLineArgs{}
+	cmd := &cobra.Command{
+		Use:   "create",
+		Short: "backup create -c clusterid --name pre-upgrade",
+		Long:  "take an on-demand named etcd backup",
+		RunE: func(cmd *cobra.Command, args []string) error {
+			return iaas.CreateBackup(flags, cmdLine)
+		},
+	}
+	cmd.Flags().StringVarP(&flags.ClusterName, "cluster", "c", "", "cluster name")
+	cmd.Flags().StringVar(&cmdLine.BackupName, "name", "", "backup name")
+	cmd.Flags().BoolVar(&cmdLine.Local, "local", false, "take snapshot from current node, eg. master")
+	cmd.Flags().BoolVar(&cmdLine.Resources, "resources", false, "backup api objects in yaml instead of etcd snapshot")
+	cmd.Flags().StringSliceVar(&cmdLine.Namespaces, "namespace", nil, "namespaces to backup with --resources, default all but system namespaces")
+	cmd.Flags().StringSliceVar(&cmdLine.Kinds, "kind", nil, "kinds to backup with --resources, eg. Deployment,ConfigMap")
+	cmd.Flags().StringVarP(&cmdLine.Selector, "selector", "l", "", "label selector to backup with --resources")
+	return cmd
+}
+
+func NewCommandList() *cobra.Command {
+	flags := &api.WdripOptions{}
+	cmdLine := &api.CommandLineArgs{}
+	cmd := &cobra.Command{
+		Use:   "list",
+		Short: "backup list -c clusterid",
+		Long:  "list etcd backups of cluster",
+		RunE: func(cmd *cobra.Command, args []string) error {
+			return iaas.ListBackups(flags, cmdLine)
+		},
+	}
+	cmd.Flags().StringVarP(&flags.ClusterName, "cluster", "c", "", "cluster name")
+	cmd.Flags().StringVarP(&cmdLine.OutPutFormat, "output", "o", "", "output format [yaml|json]")
+	return cmd
+}
+
+func NewCommandDescribe() *cobra.Command {
+	flags := &api.WdripOptions{}
+	cmdLine := &api.CommandLineArgs{}
+	cmd := &cobra.Command{
+		Use:   "describe [identity|name]",
+		Short: "backup describe -c clusterid pre-upgrade",
+		Long:  "show detail of etcd backup",
+		RunE: func(cmd *cobra.Command, args []string) error {
+			if err := backupArg(cmdLine, args); err != nil {
+				return err
+			}
+			return iaas.DescribeBackup(flags, cmdLine)
+		},
+	}
+	cmd.Flags().StringVarP(&flags.ClusterName, "cluster", "c", "", "cluster name")
+	cmd.Flags().StringVarP(&cmdLine.OutPutFormat, "output", "o", "", "output format [yaml|json]")
+	return cmd
+}
+
+func NewCommandDelete() *cobra.Command {
+	flags := &api.WdripOptions{}
+	cmdLine := &api.CommandLineArgs{}
+	cmd := &cobra.Command{
+		Use:   "delete [identity|name]",
+		Short: "backup delete -c clusterid pre-upgrade",
+		Long:  "delete etcd backup",
+		RunE: func(cmd *cobra.Command, args []string) error {
+			if err := backupArg(cmdLine, args); err != nil {
+				return err
+			}
+			return iaas.DeleteBackup(flags, cmdLine)
+		},
+	}
+	cmd.Flags().StringVarP(&flags.ClusterName, "cluster", "c", "", "cluster name")
+	return cmd
+}
+
+func NewCommandPin() *cobra.Command {
+	flags := &api.WdripOptions{}
+	cmdLine := &api.CommandLineArgs{}
+	cmd := &cobra.Command{
+		Use:   "pin [identity|name]",
+		Short: "backup pin -c clusterid 20211019-1200",
+		Long:  "pin etcd backup to exclude it from automatic gc",
+		RunE: func(cmd *cobra.Command, args []string) error {
+			if err := backupArg(cmdLine, args); err != nil {
+				return err
+			}
+			return iaas.PinBackup(flags, cmdLine)
+		},
+	}
+	cmd.Flags().StringVarP(&flags.ClusterName, "cluster", "c", "", "cluster name")
+	cmd.Flags().BoolVar(&cmdLine.Unpin, "unpin", false, "unpin backup")
+	return cmd
+}
+
+func NewCommandVerify() *cobra.Command {
+	flags := &api.WdripOptions{}
+	cmdLine := &api.CommandLineArgs{}
+	cmd := &cobra.Command{
+		Use:   "verify",
+		Short: "backup verify -c clusterid",
+		Long:  "verify and repair replicas of cluster index and backups",
+		RunE: func(cmd *cobra.Command, args []string) error {
+			return iaas.VerifyReplicas(flags, cmdLine)
+		},
+	}
+	cmd.Flags().StringVarP(&flags.ClusterName, "cluster", "c", "", "cluster name")
+	return cmd
+}
+
+func backupArg(cmdLine *api.CommandLineArgs, args []string) error {
+	if len(args) != 1 {
+		return fmt.Errorf("exactly one backup identity or name expected, got %d", len(args))
+	}
+	cmdLine.BackupName = args[0]
+	return nil
+}
+
+func NewCommandDrill() *cobra.Command {
+	flags := &api.WdripOptions{}
+	cmdLine := &api.CommandLineArgs{}
+	cmd := &cobra.Command{
+		Use:   "drill [identity|name]",
+		Short: "backup drill -c clusterid pre-upgrade",
+		Long:  "restore backup into a throwaway etcd to prove it usable, the latest etcd backup by default",
+		RunE: func(cmd *cobra.Command, args []string) error {
+			if len(args) > 1 {
+				return fmt.Errorf("at most one backup identity or name expected, got %d", len(args))
+			}
+			if len(args) == 1 {
+				cmdLine.BackupName = args[0]
+			}
+			return iaas.DrillBackup(flags, cmdLine)
+		},
+	}
+	cmd.Flags().StringVarP(&flags.ClusterName, "cluster", "c", "", "cluster name")
+	cmd.Flags().BoolVar(&cmdLine.Local, "local", false, "find cluster from in-cluster Cluster object, eg. in CronJob")
+	return cmd
+}
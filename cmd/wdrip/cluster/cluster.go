@@ -109,11 +109,20 @@ var cmdLine = v1.CommandLineArgs{}
 func NewCommandGet() *cobra.Command {
 	flags := &v1.WdripOptions{}
 	cmd := &cobra.Command{
-		Use:   "get",
+		Use:   "get [resource]",
 		Short: "Kubernetes get -r cluster -n clusterid ",
 		Long:  "kubernetes get cluster information. ",
+		Example: `
+  # list clusters 20 a page, then the next page
+  wdrip get cluster --limit 20
+  wdrip get cluster --limit 20 --continue kubernetes-wdrip-64
+`,
+		Args: cobra.MaximumNArgs(1),
 		RunE: func(cmd *cobra.Command, args []string) error {
 			//return test(flags,cmd,args)
+			if len(args) == 1 {
+				flags.Resource = args[0]
+			}
 			return get(flags)
 		},
 	}
@@ -121,6 +130,8 @@ func NewCommandGet() *cobra.Command {
 	cmd.Flags().StringVarP(&flags.ClusterName, "name", "n", "", "cluster name")
 	cmd.Flags().StringVarP(&cmdLine.WriteTo, "write-to", "w", "", "write config file to the specified destination")
 	cmd.Flags().StringVarP(&cmdLine.OutPutFormat, "output", "o", "", "output format [yaml|json]")
+	cmd.Flags().IntVar(&cmdLine.Limit, "limit", 0, "max clusters to list, 0 means all")
+	cmd.Flags().StringVar(&cmdLine.Continue, "continue", "", "continue listing after the token printed by last page")
 	return cmd
 }
 
@@ -0,0 +1,37 @@
+package cluster
+
+import (
+	v1 "github.com/aoxn/wdrip/pkg/apis/alibabacloud.com/v1"
+	"github.com/aoxn/wdrip/pkg/iaas"
+	"github.com/spf13/cobra"
+)
+
+const DiagnoseLong = `
+## Show each node as the Healet sees it, the inconsistencies found and the action the Healet would take
+wdrip diagnose wdrip-stack-027
+
+## Access the cluster with a kubeconfig instead of an admin kubeconfig signed from the cluster root ca
+wdrip diagnose wdrip-stack-027 --kubeconfig ~/.kube/config
+
+## Print the report as yaml
+wdrip diagnose wdrip-stack-027 -o yaml
+`
+
+func NewCommandDiagnose() *cobra.Command {
+	flags := &v1.WdripOptions{}
+	cmdLine := &v1.CommandLineArgs{}
+	cmd := &cobra.Command{
+		Use:   "diagnose [cluster]",
+		Short: "Kubernetes diagnose clusterid",
+		Long:  DiagnoseLong,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			if err := clusterArg(flags, args); err != nil {
+				return err
+			}
+			return iaas.Diagnose(flags, cmdLine)
+		},
+	}
+	cmd.Flags().StringVar(&cmdLine.Kubeconfig, "kubeconfig", "", "kubeconfig to access the cluster")
+	cmd.Flags().StringVarP(&cmdLine.OutPutFormat, "output", "o", "", "output format [yaml|json]")
+	return cmd
+}
@@ -0,0 +1,64 @@
+package cluster
+
+import (
+	"fmt"
+	v1 "github.com/aoxn/wdrip/pkg/apis/alibabacloud.com/v1"
+	"github.com/aoxn/wdrip/pkg/iaas"
+	"github.com/spf13/cobra"
+)
+
+const HistoryLong = `
+## List revisions of cluster spec, each wdrip edit|scale|rollback makes a revision
+wdrip history wdrip-stack-027
+
+## Show who made revision 5 and what changed
+wdrip history wdrip-stack-027 --revision 5
+
+## Undo a bad edit by restoring the spec of revision 5
+wdrip rollback wdrip-stack-027 --revision 5
+`
+
+func NewCommandHistory() *cobra.Command {
+	flags := &v1.WdripOptions{}
+	cmdLine := &v1.CommandLineArgs{}
+	cmd := &cobra.Command{
+		Use:   "history [cluster]",
+		Short: "Kubernetes history clusterid",
+		Long:  HistoryLong,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			if err := clusterArg(flags, args); err != nil {
+				return err
+			}
+			return iaas.History(flags, cmdLine)
+		},
+	}
+	cmd.Flags().Int64Var(&cmdLine.Revision, "revision", 0, "show detail of revision")
+	cmd.Flags().StringVarP(&cmdLine.OutPutFormat, "output", "o", "", "output format [yaml|json]")
+	return cmd
+}
+
+func NewCommandRollback() *cobra.Command {
+	flags := &v1.WdripOptions{}
+	cmdLine := &v1.CommandLineArgs{}
+	cmd := &cobra.Command{
+		Use:   "rollback [cluster]",
+		Short: "Kubernetes rollback clusterid --revision N",
+		Long:  HistoryLong,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			if err := clusterArg(flags, args); err != nil {
+				return err
+			}
+			return iaas.Rollback(flags, cmdLine)
+		},
+	}
+	cmd.Flags().Int64Var(&cmdLine.Revision, "revision", 0, "revision to rollback to")
+	return cmd
+}
+
+func clusterArg(flags *v1.WdripOptions, args []string) error {
+	if len(args) != 1 {
+		return fmt.Errorf("exactly one cluster name expected, got %d", len(args))
+	}
+	flags.ClusterName = args[0]
+	return nil
+}
@@ -0,0 +1,41 @@
+package index
+
+import (
+	api "github.com/aoxn/wdrip/pkg/apis/alibabacloud.com/v1"
+	"github.com/aoxn/wdrip/pkg/iaas"
+	"github.com/spf13/cobra"
+)
+
+const mhelp = `
+## Show schema version of objects stored for all clusters
+wdrip index migrate --dry-run
+
+## Upgrade objects of one cluster to current schema version
+wdrip index migrate -c kubernetes-wdrip-64
+`
+
+func NewCommand() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "index",
+		Short: "manage objects stored in the cluster index bucket",
+		Long:  mhelp,
+	}
+	cmd.AddCommand(NewCommandMigrate())
+	return cmd
+}
+
+func NewCommandMigrate() *cobra.Command {
+	flags := &api.WdripOptions{}
+	cmdLine := &api.CommandLineArgs{}
+	cmd := &cobra.Command{
+		Use:   "migrate",
+		Short: "index migrate -c clusterid",
+		Long:  "upgrade stored ClusterId, NodePool, backup index and history to current schema version",
+		RunE: func(cmd *cobra.Command, args []string) error {
+			return iaas.MigrateIndex(flags, cmdLine)
+		},
+	}
+	cmd.Flags().StringVarP(&flags.ClusterName, "cluster", "c", "", "cluster to migrate, default all clusters")
+	cmd.Flags().BoolVar(&cmdLine.DryRun, "dry-run", false, "print schema version of stored objects only")
+	return cmd
+}
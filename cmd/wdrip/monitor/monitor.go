@@ -72,7 +72,10 @@ func runE(
 		Out:                             os.Stdout,
 		ErrOut:                          os.Stderr,
 	}
-	healet, err := heal.NewHealet(etcd.Cluster, cctl.GetClient(), ctx.Provider(), drainer)
+	healet, err := heal.NewHealet(
+		etcd.Cluster, cctl.GetClient(), ctx.Provider(),
+		drainer, cctl.GetEventRecorderFor("wdrip-monitor"),
+	)
 	if err != nil {
 		return errors.Wrapf(err, "construct healet")
 	}
@@ -15,6 +15,12 @@ const mhelp = `
 recover cluster from a remote backup
 wdrip --name kubernetes-wdrip-64 \
 	--recover-mode node
+
+## recover from a specified backup by identity or name, see [wdrip backup list]
+wdrip recover --name kubernetes-wdrip-64 --backup pre-upgrade
+
+## recover from the latest backup created before the point in time
+wdrip recover --name kubernetes-wdrip-64 --before 2021-10-19T12:00:00
 `
 
 func NewCommand() *cobra.Command {
@@ -31,6 +37,9 @@ func NewCommand() *cobra.Command {
 	cmd.Flags().StringVar(&flags.Bucket, "bucket", "host-wdrip", "download package from bucket")
 	cmd.Flags().StringVarP(&flags.RecoverFrom, "recover-from-cluster", "f", "", "recover from backups")
 	cmd.Flags().StringVar(&flags.RecoverMode, "recover-mode", "iaas", "the recover mode, [iaas|node], default iaas")
+	cmd.Flags().StringVar(&flags.RecoverBackup, "backup", "", "recover from backup of identity or name, default latest")
+	cmd.Flags().StringVar(&flags.RecoverBefore, "before", "", "recover from the latest backup before time in UTC, eg. 2021-10-19T12:00:00Z. "+
+		"backups taken before creation time was recorded are matched by identity read as UTC")
 	return cmd
 }
 
@@ -44,6 +53,9 @@ func runE(flags *api.WdripOptions, cmd *cobra.Command, args []string) error {
 	if flags.RecoverFrom == "" {
 		flags.RecoverFrom = flags.ClusterName
 	}
+	if flags.RecoverBackup != "" && flags.RecoverBefore != "" {
+		return fmt.Errorf("--backup and --before are mutually exclusive")
+	}
 	klog.Infof("recover mode[%s]", flags.RecoverMode)
 	switch flags.RecoverMode {
 	case "iaas":
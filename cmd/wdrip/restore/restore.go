@@ -0,0 +1,53 @@
+package restore
+
+import (
+	"fmt"
+	api "github.com/aoxn/wdrip/pkg/apis/alibabacloud.com/v1"
+	"github.com/aoxn/wdrip/pkg/iaas"
+	"github.com/spf13/cobra"
+)
+
+const mhelp = `
+## Restore namespace app from resource backup, see [wdrip backup create --resources]
+wdrip restore resources -c kubernetes-wdrip-64 --namespace app app-0918
+
+## Restore deployments and configmaps into another cluster
+wdrip restore resources -c kubernetes-wdrip-64 --kind Deployment,ConfigMap \
+	--to-cluster kubernetes-wdrip-65 app-0918
+
+## Print the selected api objects without applying them
+wdrip restore resources -c kubernetes-wdrip-64 --namespace app --dry-run app-0918
+`
+
+func NewCommand() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "restore",
+		Short: "restore from backups without rolling back the whole cluster",
+		Long:  mhelp,
+	}
+	cmd.AddCommand(NewCommandResources())
+	return cmd
+}
+
+func NewCommandResources() *cobra.Command {
+	flags := &api.WdripOptions{}
+	cmdLine := &api.CommandLineArgs{}
+	cmd := &cobra.Command{
+		Use:   "resources [identity|name]",
+		Short: "restore resources -c clusterid --namespace app app-0918",
+		Long:  "re-apply chosen namespaces or kinds from resource backup",
+		RunE: func(cmd *cobra.Command, args []string) error {
+			if len(args) != 1 {
+				return fmt.Errorf("exactly one backup identity or name expected, got %d", len(args))
+			}
+			cmdLine.BackupName = args[0]
+			return iaas.RestoreResources(flags, cmdLine)
+		},
+	}
+	cmd.Flags().StringVarP(&flags.ClusterName, "cluster", "c", "", "cluster which the backup belongs to")
+	cmd.Flags().StringVar(&cmdLine.ToCluster, "to-cluster", "", "cluster to restore into, default the backup cluster")
+	cmd.Flags().StringSliceVar(&cmdLine.Namespaces, "namespace", nil, "namespaces to restore, default all but system namespaces")
+	cmd.Flags().StringSliceVar(&cmdLine.Kinds, "kind", nil, "kinds to restore, eg. Deployment,ConfigMap")
+	cmd.Flags().BoolVar(&cmdLine.DryRun, "dry-run", false, "print selected api objects only")
+	return cmd
+}
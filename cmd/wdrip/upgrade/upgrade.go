@@ -0,0 +1,45 @@
+package upgrade
+
+import (
+	api "github.com/aoxn/wdrip/pkg/apis/alibabacloud.com/v1"
+	"github.com/aoxn/wdrip/pkg/iaas"
+	"github.com/spf13/cobra"
+)
+
+const mhelp = `
+## Rolling upgrade etcd one member at a time, a verified backup is taken first
+wdrip upgrade etcd -c kubernetes-wdrip-64 --version v3.4.16
+
+## Name the backup taken before upgrade
+wdrip upgrade etcd -c kubernetes-wdrip-64 --version v3.4.16 --name pre-etcd-3.4
+`
+
+func NewCommand() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "upgrade",
+		Short: "upgrade components of kubernetes cluster",
+		Long:  mhelp,
+	}
+	cmd.AddCommand(NewCommandEtcd())
+	return cmd
+}
+
+func NewCommandEtcd() *cobra.Command {
+	flags := &api.WdripOptions{}
+	cmdLine := &api.CommandLineArgs{}
+	cmd := &cobra.Command{
+		Use:   "etcd",
+		Short: "upgrade etcd -c clusterid --version v3.4.16",
+		Long:  "rolling upgrade etcd members one at a time, failed member is rolled back",
+		RunE: func(cmd *cobra.Command, args []string) error {
+			return iaas.UpgradeEtcd(flags, cmdLine)
+		},
+	}
+	cmd.Flags().StringVarP(&flags.ClusterName, "cluster", "c", "", "cluster name")
+	cmd.Flags().StringVar(&flags.Bucket, "bucket", "host-wdrip", "download etcd package from bucket")
+	cmd.Flags().StringVar(&cmdLine.Version, "version", "", "etcd version upgrade to, at most one minor version ahead")
+	cmd.Flags().StringVar(&cmdLine.BackupName, "name", "", "name of the backup taken before upgrade")
+	cmd.Flags().BoolVar(&cmdLine.Local, "local", false, "upgrade etcd member on current node, eg. master")
+	cmd.Flags().BoolVar(&cmdLine.Prepare, "prepare", false, "with --local, check cluster and take a verified backup only")
+	return cmd
+}
@@ -19,23 +19,29 @@ package wdrip
 
 import (
 	"fmt"
+	"github.com/aoxn/wdrip/cmd/wdrip/backup"
 	"github.com/aoxn/wdrip/cmd/wdrip/build"
 	"github.com/aoxn/wdrip/cmd/wdrip/cluster"
+	indexcmd "github.com/aoxn/wdrip/cmd/wdrip/index"
 	initpkg "github.com/aoxn/wdrip/cmd/wdrip/init"
 	"github.com/aoxn/wdrip/cmd/wdrip/monitor"
 	"github.com/aoxn/wdrip/cmd/wdrip/monkey"
 	"github.com/aoxn/wdrip/cmd/wdrip/vm"
+	"github.com/aoxn/wdrip/pkg/index"
 	log "github.com/sirupsen/logrus"
 	"github.com/spf13/cobra"
 	"k8s.io/klog/v2"
 	"k8s.io/klog/v2/klogr"
 	"os"
 	ctrl "sigs.k8s.io/controller-runtime"
+	"time"
 
 	"github.com/aoxn/wdrip/cmd/wdrip/bootstrap"
 	"github.com/aoxn/wdrip/cmd/wdrip/operator"
 	recv "github.com/aoxn/wdrip/cmd/wdrip/recover"
+	"github.com/aoxn/wdrip/cmd/wdrip/restore"
 	"github.com/aoxn/wdrip/cmd/wdrip/token"
+	"github.com/aoxn/wdrip/cmd/wdrip/upgrade"
 	"github.com/aoxn/wdrip/cmd/wdrip/version"
 )
 
@@ -79,11 +85,18 @@ func NewCommand() *cobra.Command {
 	cmd.AddCommand(cluster.NewCommandEdit())
 	cmd.AddCommand(cluster.NewCommandConfig())
 	cmd.AddCommand(cluster.NewCommandScale())
+	cmd.AddCommand(cluster.NewCommandHistory())
+	cmd.AddCommand(cluster.NewCommandRollback())
+	cmd.AddCommand(cluster.NewCommandDiagnose())
 	cmd.AddCommand(monitor.NewCommand())
 	cmd.AddCommand(recv.NewCommand())
 	cmd.AddCommand(monkey.NewCommand())
 	cmd.AddCommand(vm.NewCommand())
 	cmd.AddCommand(cluster.NewCommandDebug())
+	cmd.AddCommand(backup.NewCommand())
+	cmd.AddCommand(restore.NewCommand())
+	cmd.AddCommand(indexcmd.NewCommand())
+	cmd.AddCommand(upgrade.NewCommand())
 	return cmd
 }
 
@@ -101,6 +114,8 @@ func runE(flags *Flags, cmd *cobra.Command, args []string) error {
 
 // Run runs the `wdrip` root command
 func Run() error {
+	// give asynchronous index replication a chance to finish
+	defer index.WaitReplication(time.Minute)
 	return NewCommand().Execute()
 }
 
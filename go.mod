@@ -17,18 +17,27 @@ require (
 	github.com/google/goterm v0.0.0-20200907032337-555d40f16ae2
 	github.com/google/uuid v1.1.2
 	github.com/gorilla/mux v1.7.3
-	github.com/jonboulle/clockwork v0.1.0
+	github.com/jonboulle/clockwork v0.2.2
 	github.com/mdlayher/vsock v0.0.0-20210303205602-10d591861736
 	github.com/moby/hyperkit v0.0.0-20211015224120-09fe9202a29a
 	github.com/pkg/errors v0.9.1
+	github.com/prometheus/client_golang v1.11.0
 	github.com/rs/xid v1.3.0
 	github.com/sirupsen/logrus v1.8.1
 	github.com/spf13/cobra v1.1.3
+	github.com/stretchr/testify v1.7.0
 	github.com/verybluebot/tarinator-go v0.0.0-20190613183509-5ab4e1193986
+	go.etcd.io/etcd/api/v3 v3.5.0
+	go.etcd.io/etcd/client/pkg/v3 v3.5.0
+	go.etcd.io/etcd/client/v3 v3.5.0
+	go.etcd.io/etcd/etcdutl/v3 v3.5.0
+	go.etcd.io/etcd/server/v3 v3.5.0
+	go.uber.org/zap v1.18.1
 	golang.org/x/lint v0.0.0-20210508222113-6edffad5e616 // indirect
 	golang.org/x/net v0.0.0-20210428140749-89ef3d95e781
 	golang.org/x/sys v0.0.0-20211210111614-af8b64212486
 	golang.org/x/tools v0.1.3 // indirect
+	google.golang.org/grpc v1.38.0
 	gopkg.in/check.v1 v1.0.0-20201130134442-10cb98267c6c // indirect
 	k8s.io/api v0.21.3
 	k8s.io/apiextensions-apiserver v0.21.3
@@ -56,5 +65,5 @@ replace (
 	k8s.io/component-base => k8s.io/component-base v0.21.3
 	k8s.io/kubectl => k8s.io/kubectl v0.21.3
 	sigs.k8s.io/kustomize/api => sigs.k8s.io/kustomize/api v0.8.11
-	//sigs.k8s.io/kustomize/kustomize => sigs.k8s.io/kustomize/kustomize v4
+//sigs.k8s.io/kustomize/kustomize => sigs.k8s.io/kustomize/kustomize v4
 )
@@ -0,0 +1,211 @@
+//go:build linux || darwin
+// +build linux darwin
+
+package etcd
+
+import (
+	"context"
+	"crypto/tls"
+	"fmt"
+	"github.com/pkg/errors"
+	"go.etcd.io/etcd/api/v3/etcdserverpb"
+	"go.etcd.io/etcd/api/v3/v3rpc/rpctypes"
+	"go.etcd.io/etcd/client/pkg/v3/transport"
+	clientv3 "go.etcd.io/etcd/client/v3"
+	"go.uber.org/zap"
+	"math/big"
+	"net"
+	"net/url"
+	"strings"
+	"time"
+)
+
+const (
+	// DialTimeout timeout to connect an etcd endpoint
+	DialTimeout = 5 * time.Second
+
+	// RequestTimeout timeout of a single etcd request
+	RequestTimeout = 10 * time.Second
+
+	// SnapshotTimeout timeout to stream a snapshot from etcd
+	SnapshotTimeout = 10 * time.Minute
+
+	// DefragTimeout timeout to defragment a member, the member blocks
+	// reads and writes meanwhile
+	DefragTimeout = 5 * time.Minute
+)
+
+// Error etcd operation failure against an endpoint
+type Error struct {
+	Op       string
+	Endpoint string
+	Err      error
+}
+
+func (e *Error) Error() string {
+	return fmt.Sprintf("etcd %s [%s]: %s", e.Op, e.Endpoint, e.Err.Error())
+}
+
+func (e *Error) Unwrap() error { return e.Err }
+
+// Cause used by github.com/pkg/errors
+func (e *Error) Cause() error { return e.Err }
+
+// IsMemberNotFound returns true when the member to operate has been
+// removed from etcd cluster already.
+func IsMemberNotFound(err error) bool {
+	return errors.Is(err, rpctypes.ErrMemberNotFound) ||
+		(err != nil && strings.Contains(err.Error(), rpctypes.ErrMemberNotFound.Error()))
+}
+
+// TLSConfig client tls config from etcd certs under home
+func (m *Etcd) TLSConfig() (*tls.Config, error) {
+	if m.tls != nil {
+		return m.tls.Clone(), nil
+	}
+	info := transport.TLSInfo{
+		CertFile:      certHome(m.Home(), "client.crt"),
+		KeyFile:       certHome(m.Home(), "client.key"),
+		TrustedCAFile: certHome(m.Home(), "server-ca.crt"),
+	}
+	cfg, err := info.ClientConfig()
+	if err != nil {
+		return nil, errors.Wrapf(err, "etcd client tls config")
+	}
+	return cfg, nil
+}
+
+// ClientConfig config of etcd client to endpoints
+func (m *Etcd) ClientConfig(endpoints ...string) (clientv3.Config, error) {
+	cfg := clientv3.Config{
+		Endpoints:   endpoints,
+		DialTimeout: DialTimeout,
+		Logger:      zap.NewNop(),
+	}
+	if m.insecure {
+		return cfg, nil
+	}
+	tlsc, err := m.TLSConfig()
+	if err != nil {
+		return cfg, err
+	}
+	cfg.TLS = tlsc
+	return cfg, nil
+}
+
+// Client returns etcd client to endpoints, which must be closed.
+func (m *Etcd) Client(endpoints ...string) (*clientv3.Client, error) {
+	cfg, err := m.ClientConfig(endpoints...)
+	if err != nil {
+		return nil, err
+	}
+	cli, err := clientv3.New(cfg)
+	if err != nil {
+		return nil, errors.Wrapf(err, "new etcd client: %s", strings.Join(endpoints, ","))
+	}
+	return cli, nil
+}
+
+// do run op against endpoint with a request timeout
+func (m *Etcd) do(
+	op, endpoint string,
+	mfunc func(ctx context.Context, cli *clientv3.Client) error,
+) error {
+	return m.doTimeout(op, endpoint, RequestTimeout, mfunc)
+}
+
+// doTimeout run op against endpoint with timeout
+func (m *Etcd) doTimeout(
+	op, endpoint string,
+	timeout time.Duration,
+	mfunc func(ctx context.Context, cli *clientv3.Client) error,
+) error {
+	cli, err := m.Client(endpoint)
+	if err != nil {
+		return &Error{Op: op, Endpoint: endpoint, Err: err}
+	}
+	defer cli.Close()
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+	if err := mfunc(ctx, cli); err != nil {
+		return &Error{Op: op, Endpoint: endpoint, Err: err}
+	}
+	return nil
+}
+
+// endpoint client url of member ip. ip:port is accepted for members
+// listening on different ports of the same host, eg. in test.
+func (m *Etcd) endpoint(ip string) string {
+	host, port, err := net.SplitHostPort(ip)
+	if err != nil {
+		host, port = ip, m.clientPort()
+	}
+	if m.insecure {
+		return fmt.Sprintf("http://%s:%s", host, port)
+	}
+	return advertise(host, port)
+}
+
+func (m *Etcd) clientPort() string {
+	if m.port == "" {
+		return "2379"
+	}
+	return m.port
+}
+
+// health the same check as `etcdctl endpoint health`, a linearized
+// read which succeeds only when the member is part of a quorum.
+func health(ctx context.Context, cli *clientv3.Client) error {
+	_, err := cli.Get(ctx, "health")
+	if err == nil || err == rpctypes.ErrPermissionDenied {
+		return nil
+	}
+	return err
+}
+
+func newMember(pm *etcdserverpb.Member) Member {
+	mem := Member{
+		ID:         new(big.Int).SetUint64(pm.ID),
+		Name:       pm.Name,
+		PeerURLs:   pm.PeerURLs,
+		ClientURLs: pm.ClientURLs,
+		IsLearner:  pm.IsLearner,
+	}
+	if len(pm.PeerURLs) > 0 {
+		mem.IP = hostOf(pm.PeerURLs[0])
+	}
+	return mem
+}
+
+func newHeader(h *etcdserverpb.ResponseHeader) Header {
+	if h == nil {
+		return Header{}
+	}
+	return Header{
+		ClusterID: new(big.Int).SetUint64(h.ClusterId),
+		MemberID:  new(big.Int).SetUint64(h.MemberId),
+		Revision:  big.NewInt(h.Revision),
+		RaftTerm:  new(big.Int).SetUint64(h.RaftTerm),
+	}
+}
+
+func newStatus(s *clientv3.StatusResponse) Status {
+	return Status{
+		Header:      newHeader(s.Header),
+		Version:     s.Version,
+		DBsize:      big.NewInt(s.DbSize),
+		DBSizeInUse: big.NewInt(s.DbSizeInUse),
+		Leader:      new(big.Int).SetUint64(s.Leader),
+		RaftIndex:   new(big.Int).SetUint64(s.RaftIndex),
+		RaftTerm:    new(big.Int).SetUint64(s.RaftTerm),
+	}
+}
+
+// hostOf host of url, empty when url is malformed
+func hostOf(u string) string {
+	p, err := url.Parse(u)
+	if err != nil {
+		return ""
+	}
+	return p.Hostname()
+}
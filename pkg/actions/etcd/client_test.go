@@ -0,0 +1,142 @@
+//go:build linux || darwin
+// +build linux darwin
+
+package etcd
+
+import (
+	"context"
+	"crypto/x509"
+	"fmt"
+	api "github.com/aoxn/wdrip/pkg/apis/alibabacloud.com/v1"
+	"github.com/aoxn/wdrip/pkg/utils/sign"
+	"github.com/stretchr/testify/assert"
+	clientv3 "go.etcd.io/etcd/client/v3"
+	"math/big"
+	"path/filepath"
+	"strconv"
+	"testing"
+)
+
+// embedded start a single member etcd in test temp dir
+func embedded(t *testing.T) *Etcd {
+	ports, err := freePorts(2)
+	assert.Nil(t, err)
+	m := &Etcd{peer: []string{"127.0.0.1"}, port: strconv.Itoa(ports[0]), insecure: true}
+	server, err := startEmbed(
+		"test", filepath.Join(t.TempDir(), DataDir),
+		m.endpoint("127.0.0.1"), fmt.Sprintf("http://127.0.0.1:%d", ports[1]),
+	)
+	if err != nil {
+		t.Fatalf("start embedded etcd: %s", err.Error())
+	}
+	t.Cleanup(server.Close)
+	return m
+}
+
+func TestNewEtcdFromSpec(t *testing.T) {
+	key, crt, err := sign.SelfSignedPair()
+	assert.Nil(t, err)
+	spec := &api.Cluster{}
+	spec.Spec.Etcd.ServerCA = &api.KeyCert{Cert: crt, Key: key}
+	masters := []api.Master{
+		{Spec: api.MasterSpec{IP: "192.168.0.1"}},
+		{Spec: api.MasterSpec{IP: "192.168.0.2"}},
+	}
+	m, err := NewEtcdFromSpec(masters, spec)
+	assert.Nil(t, err)
+	assert.Equal(t, []string{"192.168.0.1", "192.168.0.2"}, m.peer)
+	assert.Equal(t, "", m.home)
+
+	tlsc, err := m.TLSConfig()
+	assert.Nil(t, err)
+	assert.Equal(t, 1, len(tlsc.Certificates))
+	client, err := x509.ParseCertificate(tlsc.Certificates[0].Certificate[0])
+	assert.Nil(t, err)
+	_, err = client.Verify(x509.VerifyOptions{
+		Roots:     tlsc.RootCAs,
+		KeyUsages: []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+	})
+	assert.Nil(t, err)
+
+	_, err = NewEtcdFromSpec(masters, &api.Cluster{})
+	assert.NotNil(t, err)
+}
+
+func TestClientOperations(t *testing.T) {
+	m := embedded(t)
+	assert.Nil(t, m.EndpointHealth("127.0.0.1"))
+	assert.Nil(t, m.WaitEndpoints(m.endpoint("127.0.0.1")))
+
+	mems, err := m.MemberList()
+	assert.Nil(t, err)
+	assert.Equal(t, 1, len(mems.Members))
+	assert.Equal(t, "127.0.0.1", mems.Members[0].IP)
+	assert.Equal(t, "test", mems.Members[0].Name)
+
+	endps, err := m.Endpoints()
+	assert.Nil(t, err)
+	assert.Equal(t, 1, len(endps))
+	assert.Equal(t, 0, endps[0].Status.Leader.Cmp(mems.Members[0].ID))
+
+	// removal of member not found is done
+	assert.Nil(t, m.RemoveMember(Member{ID: big.NewInt(1)}))
+	assert.Nil(t, m.RemoveMember(Member{}))
+
+	// structured error with endpoint
+	down := &Etcd{peer: []string{"127.0.0.1"}, port: "1", insecure: true}
+	err = down.EndpointHealth("127.0.0.1")
+	eerr, ok := err.(*Error)
+	assert.True(t, ok)
+	assert.Equal(t, "endpoint health", eerr.Op)
+}
+
+func TestSnapshotDrill(t *testing.T) {
+	m := embedded(t)
+	err := m.do(
+		"put", m.endpoint("127.0.0.1"),
+		func(ctx context.Context, cli *clientv3.Client) error {
+			for _, key := range Sentinels {
+				if _, err := cli.Put(ctx, key, "sentinel"); err != nil {
+					return err
+				}
+			}
+			return nil
+		},
+	)
+	assert.Nil(t, err)
+
+	snap := filepath.Join(t.TempDir(), "snapshot.db")
+	source, err := m.Snapshot(snap)
+	assert.Nil(t, err)
+	assert.True(t, source.Leader)
+	assert.True(t, source.Revision >= int64(len(Sentinels)))
+
+	result, err := Drill(snap, Sentinels)
+	assert.Nil(t, err)
+	assert.Equal(t, int64(len(Sentinels)), result.Keys)
+
+	result, err = Drill(snap, append(Sentinels, "/registry/namespaces/missing"))
+	assert.NotNil(t, err)
+	assert.Equal(t, []string{"/registry/namespaces/missing"}, result.Missing)
+}
+
+func TestRankEndpoints(t *testing.T) {
+	status := func(member, leader, revision int64) Status {
+		s := Status{Leader: big.NewInt(leader), RaftIndex: big.NewInt(revision)}
+		s.Header.MemberID = big.NewInt(member)
+		s.Header.Revision = big.NewInt(revision)
+		return s
+	}
+	endpoints := []string{"a", "b", "c", "d"}
+	statuses := map[string]Status{
+		"b": status(2, 3, 90),
+		"c": status(3, 3, 80),
+		"d": status(4, 3, 100),
+	}
+	// leader first even lagging, unknown status last
+	assert.Equal(t, []string{"c", "d", "b", "a"}, rankEndpoints(endpoints, statuses))
+
+	// no leader, eg. quorum lost
+	delete(statuses, "c")
+	assert.Equal(t, []string{"d", "b", "a", "c"}, rankEndpoints(endpoints, statuses))
+}
@@ -0,0 +1,184 @@
+//go:build linux || darwin
+// +build linux darwin
+
+package etcd
+
+import (
+	"context"
+	"fmt"
+	"github.com/pkg/errors"
+	clientv3 "go.etcd.io/etcd/client/v3"
+	"go.etcd.io/etcd/etcdutl/v3/snapshot"
+	"go.etcd.io/etcd/server/v3/embed"
+	"go.uber.org/zap"
+	"io/ioutil"
+	"k8s.io/klog/v2"
+	"net"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Sentinels keys every kubernetes cluster has, a restored
+// backup missing any of them is not usable.
+var Sentinels = []string{
+	"/registry/namespaces/default",
+	"/registry/namespaces/kube-system",
+	"/registry/services/specs/default/kubernetes",
+}
+
+// DrillResult what the throwaway etcd restored from snapshot
+type DrillResult struct {
+	Keys    int64
+	Missing []string
+}
+
+// Drill prove snapshot restorable. snapshot is restored into a scratch
+// data dir with its integrity hash checked, and served by a throwaway
+// embedded etcd on localhost, where keys are counted and sentinel keys
+// are checked. scratch dir is removed afterwards.
+func Drill(snap string, sentinels []string) (*DrillResult, error) {
+	scratch, err := ioutil.TempDir("", "wdrip-drill-")
+	if err != nil {
+		return nil, errors.Wrap(err, "make scratch dir")
+	}
+	defer os.RemoveAll(scratch)
+
+	ports, err := freePorts(2)
+	if err != nil {
+		return nil, errors.Wrap(err, "find free port")
+	}
+	m := &Etcd{peer: []string{"127.0.0.1"}, port: strconv.Itoa(ports[0]), insecure: true}
+	client := m.endpoint("127.0.0.1")
+	peer := fmt.Sprintf("http://127.0.0.1:%d", ports[1])
+	dataDir := filepath.Join(scratch, DataDir)
+	err = snapshot.NewV3(zap.NewNop()).Restore(
+		snapshot.RestoreConfig{
+			SnapshotPath:   snap,
+			Name:           "drill",
+			OutputDataDir:  dataDir,
+			PeerURLs:       []string{peer},
+			InitialCluster: fmt.Sprintf("drill=%s", peer),
+		},
+	)
+	if err != nil {
+		return nil, errors.Wrapf(err, "restore snapshot %s", snap)
+	}
+	server, err := startEmbed("drill", dataDir, client, peer)
+	if err != nil {
+		return nil, errors.Wrap(err, "start throwaway etcd")
+	}
+	defer server.Close()
+
+	result := &DrillResult{}
+	result.Keys, err = m.count("", true)
+	if err != nil {
+		return nil, errors.Wrap(err, "count keys")
+	}
+	for _, key := range sentinels {
+		cnt, err := m.count(key, false)
+		if err != nil {
+			return result, errors.Wrapf(err, "check sentinel %s", key)
+		}
+		if cnt == 0 {
+			result.Missing = append(result.Missing, key)
+		}
+	}
+	klog.Infof("drill: %d keys restored from %s, missing sentinels %v", result.Keys, snap, result.Missing)
+	if result.Keys == 0 {
+		return result, fmt.Errorf("no key restored from snapshot")
+	}
+	if len(result.Missing) != 0 {
+		return result, fmt.Errorf("sentinel keys missing: %s", strings.Join(result.Missing, ","))
+	}
+	return result, nil
+}
+
+// startEmbed start single member embedded etcd on dataDir
+func startEmbed(name, dataDir, client, peer string) (*embed.Etcd, error) {
+	cfg, err := embedConfig(name, dataDir, client, peer)
+	if err != nil {
+		return nil, err
+	}
+	server, err := embed.StartEtcd(cfg)
+	if err != nil {
+		return nil, err
+	}
+	if err := waitEmbed(server); err != nil {
+		return nil, err
+	}
+	return server, nil
+}
+
+// embedConfig config of embedded etcd member with a single member
+// initial cluster
+func embedConfig(name, dataDir, client, peer string) (*embed.Config, error) {
+	curl, err := url.Parse(client)
+	if err != nil {
+		return nil, errors.Wrapf(err, "parse client url %s", client)
+	}
+	purl, err := url.Parse(peer)
+	if err != nil {
+		return nil, errors.Wrapf(err, "parse peer url %s", peer)
+	}
+	cfg := embed.NewConfig()
+	cfg.Name = name
+	cfg.Dir = dataDir
+	cfg.LogLevel = "error"
+	cfg.LCUrls, cfg.ACUrls = []url.URL{*curl}, []url.URL{*curl}
+	cfg.LPUrls, cfg.APUrls = []url.URL{*purl}, []url.URL{*purl}
+	cfg.InitialCluster = cfg.InitialClusterFromName(name)
+	return cfg, nil
+}
+
+// waitEmbed wait embedded etcd ready to serve, closed on failure
+func waitEmbed(server *embed.Etcd) error {
+	var err error
+	select {
+	case <-server.Server.ReadyNotify():
+		return nil
+	case err = <-server.Err():
+	case <-time.After(1 * time.Minute):
+		err = fmt.Errorf("wait ready timeout")
+	}
+	server.Close()
+	return err
+}
+
+// count keys equal to key, or with prefix key
+func (m *Etcd) count(key string, prefix bool) (int64, error) {
+	var count int64
+	err := m.do(
+		"count", m.endpoint(m.peer[0]),
+		func(ctx context.Context, cli *clientv3.Client) error {
+			opts := []clientv3.OpOption{clientv3.WithCountOnly()}
+			if prefix {
+				opts = append(opts, clientv3.WithPrefix())
+			}
+			resp, err := cli.Get(ctx, key, opts...)
+			if err != nil {
+				return err
+			}
+			count = resp.Count
+			return nil
+		},
+	)
+	return count, err
+}
+
+// freePorts pick n free ports on localhost
+func freePorts(n int) ([]int, error) {
+	var ports []int
+	for i := 0; i < n; i++ {
+		l, err := net.Listen("tcp", "127.0.0.1:0")
+		if err != nil {
+			return nil, err
+		}
+		defer l.Close()
+		ports = append(ports, l.Addr().(*net.TCPAddr).Port)
+	}
+	return ports, nil
+}
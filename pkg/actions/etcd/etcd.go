@@ -4,6 +4,9 @@
 package etcd
 
 import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
 	"fmt"
 	"github.com/aoxn/wdrip/pkg/actions"
 	api "github.com/aoxn/wdrip/pkg/apis/alibabacloud.com/v1"
@@ -13,6 +16,9 @@ import (
 	"github.com/aoxn/wdrip/pkg/utils/cmd"
 	"github.com/aoxn/wdrip/pkg/utils/sign"
 	"github.com/pkg/errors"
+	clientv3 "go.etcd.io/etcd/client/v3"
+	"go.etcd.io/etcd/etcdutl/v3/snapshot"
+	"go.uber.org/zap"
 	"io/ioutil"
 	"k8s.io/apimachinery/pkg/util/wait"
 	"k8s.io/klog/v2"
@@ -89,17 +95,30 @@ func (a *action) Execute(ctx *actions.ActionContext) error {
 	if err != nil {
 		return fmt.Errorf("systecmctl enable etcd error,%s ", err.Error())
 	}
-
+	if ctx.WdripFlags().BootType == utils.BootTypeOperator {
+		// joined as learner, counts for quorum once promoted
+		err = etcd.PromoteMe()
+		if err != nil {
+			return errors.Wrapf(err, "promote etcd learner %s", node.Spec.IP)
+		}
+	}
 	return etcd.WaitEndpoints(advertise(node.Spec.IP, "2379"))
 }
 
-// Etcd todo: use etcd API to reimplement etcd operations
+// Etcd etcd operations through etcd clientv3 with the certs under home
 type Etcd struct {
 	home string
 	// my IP addr
 	me string
 	// peer IP addrs
 	peer []string
+	// port client port of peers, 2379 by default
+	port string
+	// insecure talk to peers over plain http, eg. the throwaway
+	// etcd of restore drill
+	insecure bool
+	// tls client config in memory, certs under home are used when nil
+	tls *tls.Config
 	//node *api.Master
 }
 
@@ -137,6 +156,40 @@ func NewEtcdFromCRD(
 	return &Etcd{me: ip, peer: peer, home: home}, nil
 }
 
+// NewEtcdFromSpec etcd of masters talking to peers with a client cert
+// signed in memory by etcd server ca of spec. nothing is written to
+// disk, for use off the masters.
+func NewEtcdFromSpec(nodes []api.Master, spec *api.Cluster) (*Etcd, error) {
+	if len(nodes) == 0 {
+		return nil, fmt.Errorf("no master found")
+	}
+	ca := spec.Spec.Etcd.ServerCA
+	if ca == nil {
+		return nil, fmt.Errorf("etcd server ca not found in cluster spec")
+	}
+	key, crt, err := sign.SignEtcdClient(ca.Cert, ca.Key, []string{}, nodes[0].Spec.IP)
+	if err != nil {
+		return nil, errors.Wrapf(err, "sign etcd client cert")
+	}
+	pair, err := tls.X509KeyPair(crt, key)
+	if err != nil {
+		return nil, errors.Wrapf(err, "load etcd client cert")
+	}
+	pool := x509.NewCertPool()
+	if !pool.AppendCertsFromPEM(ca.Cert) {
+		return nil, fmt.Errorf("parse etcd server ca")
+	}
+	var peer []string
+	for _, n := range nodes {
+		peer = append(peer, n.Spec.IP)
+	}
+	return &Etcd{
+		me:   nodes[len(nodes)-1].Spec.IP,
+		peer: peer,
+		tls:  &tls.Config{Certificates: []tls.Certificate{pair}, RootCAs: pool},
+	}, nil
+}
+
 func NewEtcd(node *api.Master) *Etcd {
 	var peer []string
 	for _, n := range node.Status.Peer {
@@ -194,49 +247,26 @@ func (m *Etcd) MemberList() (Members, error) {
 
 	mems := Members{}
 	fortry := func(ip string) error {
-		cm := cmd.NewCmd(
-			"etcdctl",
-			"--endpoints",
-			advertise(ip, "2379"),
-			"--cacert",
-			certHome(m.Home(), "server-ca.crt"),
-			"--cert",
-			certHome(m.Home(), "client.crt"),
-			"--key",
-			certHome(m.Home(), "client.key"),
-			"-w", "json",
-			"member", "list",
-			//"--peer-urls", PeerURLs(peer[0].IP,"2379"),
+		return m.do(
+			"member list", m.endpoint(ip),
+			func(ctx context.Context, cli *clientv3.Client) error {
+				resp, err := cli.MemberList(ctx)
+				if err != nil {
+					return err
+				}
+				mems = Members{Header: newHeader(resp.Header)}
+				for _, pm := range resp.Members {
+					mem := newMember(pm)
+					if mem.IP == "" {
+						return fmt.Errorf("unknown peer url format of member %x: %v", pm.ID, pm.PeerURLs)
+					}
+					klog.Infof("debug etcd member: %+v", mem)
+					mems.Members = append(mems.Members, mem)
+				}
+				return nil
+			},
 		)
-		cm.Env = []string{"ETCDCTL_API=3"}
-		result := <-cm.Start()
-		err := cmd.CmdError(result)
-		if err != nil {
-			return fmt.Errorf("peer list error: %s", err.Error())
-		}
-		err = Load(result.Stdout, &mems)
-		if err != nil {
-			return fmt.Errorf("unmarshal member: %s", err.Error())
-		}
-
-		for i, p := range mems.Members {
-			if len(p.PeerURLs) < 1 {
-				return fmt.Errorf("empty peer url: %+v", mems)
-			}
-			ips := strings.Split(p.PeerURLs[0], "//")
-			if len(ips) < 2 {
-				return fmt.Errorf("member list: unknown Advertise addr format, %s. skip", p.ClientURLs)
-			}
-			addr := strings.Split(ips[1], ":")
-			if len(addr) < 2 {
-				return fmt.Errorf("member list: unkown addr format, %s", addr)
-			}
-			mems.Members[i].IP = addr[0]
-			klog.Infof("debug etcd member: %+v", mems.Members[i])
-		}
-		return nil
 	}
-	// TODO: try each peer on error.
 	err := TryEachPeer(m.peer, 2*time.Second, fortry)
 	return mems, err
 }
@@ -261,77 +291,50 @@ func (m *Etcd) Restore(node *api.Master, dir string) error {
 	if err != nil {
 		klog.Errorf("remove backup dir: %s", err.Error())
 	}
-	cm := cmd.NewCmd(
-		"etcdctl", "snapshot", "restore", dir,
-		"--data-dir", dataDir,
-		"--skip-hash-check=true",
-		"--name", memberName(node.Spec.IP),
-		"--initial-cluster", InitialEtcdCluster(node, NewEmptyMembers()),
-		"--initial-cluster-token", node.Status.BootCFG.Spec.Etcd.InitToken,
-		"--initial-advertise-peer-urls", advertise(node.Spec.IP, "2380"),
-		"--cacert", certHome(m.Home(), "server-ca.crt"),
-		"--cert", certHome(m.Home(), "server.crt"),
+	err = snapshot.NewV3(zap.NewNop()).Restore(
+		snapshot.RestoreConfig{
+			SnapshotPath:        dir,
+			Name:                memberName(node.Spec.IP),
+			OutputDataDir:       dataDir,
+			PeerURLs:            []string{advertise(node.Spec.IP, "2380")},
+			InitialCluster:      InitialEtcdCluster(node, NewEmptyMembers()),
+			InitialClusterToken: node.Status.BootCFG.Spec.Etcd.InitToken,
+			SkipHashCheck:       true,
+		},
 	)
-	cm.Env = []string{"ETCDCTL_API=3"}
-	result := <-cm.Start()
-	return cmd.CmdError(result)
-}
-
-func (m *Etcd) Snapshot(dir string) error {
-	if dir == "" {
-		return fmt.Errorf("empty snapshot target path")
-	}
-	if len(m.peer) <= 0 {
-		return fmt.Errorf("empty peer endpoint, can not snapshot")
-	}
-
-	err := os.MkdirAll(filepath.Dir(dir), 0755)
 	if err != nil {
-		return errors.Wrapf(err, "make dir: %s", filepath.Dir(dir))
-	}
-	// todo:
-	// 		should use local ip to backup
-	first := []string{m.peer[0]}
-	cm := cmd.NewCmd(
-		"etcdctl",
-		"--endpoints",
-		advertises(first, "2379"),
-		"--cacert",
-		certHome(m.Home(), "server-ca.crt"),
-		"--cert",
-		certHome(m.Home(), "client.crt"),
-		"--key",
-		certHome(m.Home(), "client.key"),
-		"snapshot", "save", dir,
-	)
-	cm.Env = []string{"ETCDCTL_API=3"}
-	result := <-cm.Start()
-	return cmd.CmdError(result)
+		return errors.Wrapf(err, "restore snapshot %s to %s", dir, dataDir)
+	}
+	return nil
 }
 
 func (m *Etcd) Endpoints() ([]EndpointStatus, error) {
 	var endpoints []EndpointStatus
-	cm := cmd.NewCmd(
-		"etcdctl",
-		"--endpoints",
-		advertises(m.peer, "2379"),
-		"--cacert",
-		certHome(m.Home(), "server-ca.crt"),
-		"--cert",
-		certHome(m.Home(), "client.crt"),
-		"--key",
-		certHome(m.Home(), "client.key"),
-		"-w", "json",
-		"endpoint", "status",
-	)
-	cm.Env = []string{"ETCDCTL_API=3"}
-	result := <-cm.Start()
-	err := cmd.CmdError(result)
-	if err != nil {
-		return endpoints, fmt.Errorf("endpoint status error: %s", err.Error())
+	for _, ip := range m.peer {
+		status, err := m.status(m.endpoint(ip))
+		if err != nil {
+			return endpoints, err
+		}
+		endpoints = append(endpoints, status)
 	}
-	err = Load(result.Stdout, &endpoints)
-	return endpoints, err
+	return endpoints, nil
+}
+
+// status of the member serving endpoint
+func (m *Etcd) status(endpoint string) (EndpointStatus, error) {
+	status := EndpointStatus{Endpoint: endpoint}
+	err := m.do(
+		"endpoint status", endpoint,
+		func(ctx context.Context, cli *clientv3.Client) error {
+			resp, err := cli.Status(ctx, endpoint)
+			if err != nil {
+				return err
+			}
+			status.Status = newStatus(resp)
+			return nil
+		},
+	)
+	return status, err
 }
 
 // Join is the entry of peer join method
@@ -374,22 +377,17 @@ func (m *Etcd) RemoveMember(mem Member) error {
 		return nil
 	}
 	remove := func(ip string) error {
-		cm := cmd.NewCmd(
-			"etcdctl",
-			"--endpoints",
-			advertise(ip, "2379"),
-			"--cacert",
-			certHome(m.Home(), "server-ca.crt"),
-			"--cert",
-			certHome(m.Home(), "client.crt"),
-			"--key",
-			certHome(m.Home(), "client.key"),
-			"member", "remove", fmt.Sprintf("%x", mem.ID),
-			//"--peer-urls", PeerURLs(peer[0].IP,"2379"),
+		return m.do(
+			"member remove", m.endpoint(ip),
+			func(ctx context.Context, cli *clientv3.Client) error {
+				_, err := cli.MemberRemove(ctx, mem.ID.Uint64())
+				if IsMemberNotFound(err) {
+					klog.Infof("etcd member %x already removed", mem.ID)
+					return nil
+				}
+				return err
+			},
 		)
-		cm.Env = []string{"ETCDCTL_API=3"}
-		result := <-cm.Start()
-		return cmd.CmdError(result)
 	}
 	return TryEachPeer(
 		m.peer, 2*time.Second, remove,
@@ -436,24 +434,8 @@ func (m *Etcd) JoinMe() error {
 					return nil
 				}
 			}
-			// start to join me with backoff
-			cm := cmd.NewCmd(
-				"etcdctl",
-				"--endpoints",
-				advertise(ip, "2379"),
-				"--cacert",
-				certHome(m.Home(), "server-ca.crt"),
-				"--cert",
-				certHome(m.Home(), "client.crt"),
-				"--key",
-				certHome(m.Home(), "client.key"),
-				"member", "add", memberName(m.me),
-				"--peer-urls", advertise(m.me, "2380"),
-				//"--peer-urls", PeerURLs(peer[0].IP,"2379"),
-			)
-			cm.Env = []string{"ETCDCTL_API=3"}
-			result := <-cm.Start()
-			return cmd.CmdError(result)
+			// start to join me as learner with backoff
+			return m.addMember(m.endpoint(ip), advertise(m.me, "2380"))
 		},
 	)
 }
@@ -509,11 +491,17 @@ type Member struct {
 	Name       string   `json:"name,omitempty" protobuf:"bytes,4,opt,name=name"`
 	PeerURLs   []string `json:"peerURLs,omitempty" protobuf:"bytes,5,opt,name=peerURLs"`
 	ClientURLs []string `json:"clientURLs,omitempty" protobuf:"bytes,6,opt,name=clientURLs"`
+	// IsLearner non-voting member which is catching up with the leader
+	IsLearner bool `json:"isLearner,omitempty" protobuf:"varint,7,opt,name=isLearner"`
 }
 
 func memAdvertise(mems []Member) string {
 	var addrs []string
 	for _, mem := range mems {
+		if len(mem.ClientURLs) == 0 {
+			// added but not started yet
+			continue
+		}
 		addrs = append(addrs, mem.ClientURLs[0])
 	}
 	return strings.Join(addrs, ",")
@@ -532,20 +520,8 @@ func (m *Etcd) WaitEndpoints(endpints string) error {
 			return fmt.Errorf("wait for etcd ready timeout, %v", err)
 		default:
 			time.Sleep(2 * time.Second)
-			cm := cmd.NewCmd(
-				"etcdctl",
-				"--endpoints", endpints,
-				"--cacert",
-				certHome(m.Home(), "server-ca.crt"),
-				"--cert",
-				certHome(m.Home(), "client.crt"),
-				"--key",
-				certHome(m.Home(), "client.key"),
-				"endpoint", "health",
-			)
-			cm.Env = []string{"ETCDCTL_API=3"}
-			result := <-cm.Start()
-			if err := cmd.CmdError(result); err != nil {
+			err = m.healthy(strings.Split(endpints, ","))
+			if err != nil {
 				klog.Infof("waiting for etcd ready...%s", err.Error())
 				continue
 			}
@@ -559,21 +535,17 @@ func (m *Etcd) WaitEndpoints(endpints string) error {
 }
 
 func (m *Etcd) EndpointHealth(ip string) error {
-	endpoint := advertise(ip, "2379")
-	cm := cmd.NewCmd(
-		"etcdctl",
-		"--endpoints", endpoint,
-		"--cacert",
-		certHome(m.Home(), "server-ca.crt"),
-		"--cert",
-		certHome(m.Home(), "client.crt"),
-		"--key",
-		certHome(m.Home(), "client.key"),
-		"endpoint", "health",
-	)
-	cm.Env = []string{"ETCDCTL_API=3"}
-	result := <-cm.Start()
-	return cmd.CmdError(result)
+	return m.do("endpoint health", m.endpoint(ip), health)
+}
+
+// healthy returns error when any of endpoints is unhealthy
+func (m *Etcd) healthy(endpoints []string) error {
+	for _, endpoint := range endpoints {
+		if err := m.do("endpoint health", endpoint, health); err != nil {
+			return err
+		}
+	}
+	return nil
 }
 
 func certHome(home, name string) string {
@@ -722,11 +694,3 @@ func InitialEtcdCluster(node *api.Master, mem []Member) string {
 func advertise(ip, port string) string {
 	return fmt.Sprintf("https://%s:%s", ip, port)
 }
-
-func advertises(ips []string, port string) string {
-	var hosts []string
-	for _, ip := range ips {
-		hosts = append(hosts, advertise(ip, port))
-	}
-	return strings.Join(hosts, ",")
-}
@@ -0,0 +1,193 @@
+//go:build linux || darwin
+// +build linux darwin
+
+package etcd
+
+import (
+	"context"
+	"fmt"
+	"github.com/pkg/errors"
+	pb "go.etcd.io/etcd/api/v3/etcdserverpb"
+	"go.etcd.io/etcd/api/v3/v3rpc/rpctypes"
+	clientv3 "go.etcd.io/etcd/client/v3"
+	"k8s.io/klog/v2"
+	"sort"
+	"strings"
+)
+
+// AlarmMember alarm raised by a member
+type AlarmMember = pb.AlarmMember
+
+// IsCompacted returns true when revision has been compacted already
+func IsCompacted(err error) bool {
+	return errors.Is(err, rpctypes.ErrCompacted) ||
+		(err != nil && strings.Contains(err.Error(), rpctypes.ErrCompacted.Error()))
+}
+
+// Usage backend db usage of a member
+type Usage struct {
+	Endpoint string
+	// MemberID in hex
+	MemberID string
+	Leader   bool
+	DBSize   int64
+	InUse    int64
+	Revision int64
+}
+
+// Fragmentation ratio of db size reclaimable by defragmentation
+func (u Usage) Fragmentation() float64 {
+	if u.DBSize <= 0 || u.InUse <= 0 {
+		return 0
+	}
+	return 1 - float64(u.InUse)/float64(u.DBSize)
+}
+
+// Reclaimable bytes reclaimable by defragmentation
+func (u Usage) Reclaimable() int64 {
+	if u.InUse <= 0 || u.DBSize < u.InUse {
+		return 0
+	}
+	return u.DBSize - u.InUse
+}
+
+// Usages db usage of every peer. members whose status is unknown
+// are left out with a warning.
+func (m *Etcd) Usages() ([]Usage, error) {
+	var (
+		usages []Usage
+		err    error
+	)
+	for _, ip := range m.peer {
+		status, serr := m.status(m.endpoint(ip))
+		if serr != nil {
+			klog.Warningf("usage: %s", serr.Error())
+			err = serr
+			continue
+		}
+		usages = append(usages, newUsage(status))
+	}
+	if len(usages) == 0 {
+		return nil, fmt.Errorf("usage of all %d members unknown: %v", len(m.peer), err)
+	}
+	return usages, nil
+}
+
+func newUsage(s EndpointStatus) Usage {
+	usage := Usage{
+		Endpoint: s.Endpoint,
+		Leader:   isLeader(s.Status),
+	}
+	if s.Status.Header.MemberID != nil {
+		usage.MemberID = fmt.Sprintf("%x", s.Status.Header.MemberID)
+	}
+	if s.Status.Header.Revision != nil {
+		usage.Revision = s.Status.Header.Revision.Int64()
+	}
+	if s.Status.DBsize != nil {
+		usage.DBSize = s.Status.DBsize.Int64()
+	}
+	if s.Status.DBSizeInUse != nil {
+		usage.InUse = s.Status.DBSizeInUse.Int64()
+	}
+	return usage
+}
+
+// DefragOrder members to defragment one at a time: members whose
+// fragmentation reaches threshold with at least minReclaim bytes to
+// reclaim, followers first and the leader last. all members are
+// returned when threshold is 0.
+func DefragOrder(usages []Usage, threshold float64, minReclaim int64) []Usage {
+	var order []Usage
+	for _, u := range usages {
+		if threshold > 0 &&
+			(u.Fragmentation() < threshold || u.Reclaimable() < minReclaim) {
+			continue
+		}
+		order = append(order, u)
+	}
+	sort.SliceStable(
+		order,
+		func(i, j int) bool { return !order[i].Leader && order[j].Leader },
+	)
+	return order
+}
+
+// Defragment defragment the member serving endpoint
+func (m *Etcd) Defragment(endpoint string) error {
+	return m.doTimeout(
+		"defragment", endpoint, DefragTimeout,
+		func(ctx context.Context, cli *clientv3.Client) error {
+			_, err := cli.Defragment(ctx, endpoint)
+			return err
+		},
+	)
+}
+
+// Compact compact key space at revision physically, history before
+// revision is dropped.
+func (m *Etcd) Compact(revision int64) error {
+	return TryEachPeer(
+		m.peer, 0,
+		func(ip string) error {
+			return m.doTimeout(
+				"compact", m.endpoint(ip), DefragTimeout,
+				func(ctx context.Context, cli *clientv3.Client) error {
+					_, err := cli.Compact(ctx, revision, clientv3.WithCompactPhysical())
+					return err
+				},
+			)
+		},
+	)
+}
+
+// Alarms alarms raised by members
+func (m *Etcd) Alarms() ([]*AlarmMember, error) {
+	var alarms []*AlarmMember
+	err := TryEachPeer(
+		m.peer, 0,
+		func(ip string) error {
+			return m.do(
+				"alarm list", m.endpoint(ip),
+				func(ctx context.Context, cli *clientv3.Client) error {
+					resp, err := cli.AlarmList(ctx)
+					if err != nil {
+						return err
+					}
+					alarms = resp.Alarms
+					return nil
+				},
+			)
+		},
+	)
+	return alarms, err
+}
+
+// NoSpace NOSPACE alarms among alarms
+func NoSpace(alarms []*AlarmMember) []*AlarmMember {
+	var nospace []*AlarmMember
+	for _, alarm := range alarms {
+		if alarm.Alarm == pb.AlarmType_NOSPACE {
+			nospace = append(nospace, alarm)
+		}
+	}
+	return nospace
+}
+
+// Disarm disarm alarm
+func (m *Etcd) Disarm(alarm *AlarmMember) error {
+	return TryEachPeer(
+		m.peer, 0,
+		func(ip string) error {
+			return m.do(
+				"alarm disarm", m.endpoint(ip),
+				func(ctx context.Context, cli *clientv3.Client) error {
+					_, err := cli.AlarmDisarm(
+						ctx, &clientv3.AlarmMember{MemberID: alarm.MemberID, Alarm: alarm.Alarm},
+					)
+					return err
+				},
+			)
+		},
+	)
+}
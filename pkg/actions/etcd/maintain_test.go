@@ -0,0 +1,77 @@
+//go:build linux || darwin
+// +build linux darwin
+
+package etcd
+
+import (
+	"context"
+	"fmt"
+	"github.com/stretchr/testify/assert"
+	clientv3 "go.etcd.io/etcd/client/v3"
+	"strings"
+	"testing"
+)
+
+func TestDefragOrder(t *testing.T) {
+	usages := []Usage{
+		{MemberID: "a", Leader: true, DBSize: 1000, InUse: 100},
+		{MemberID: "b", DBSize: 1000, InUse: 900},
+		{MemberID: "c", DBSize: 1000, InUse: 200},
+		{MemberID: "d", DBSize: 1000},
+	}
+	ids := func(order []Usage) string {
+		var ids []string
+		for _, u := range order {
+			ids = append(ids, u.MemberID)
+		}
+		return strings.Join(ids, ",")
+	}
+	cases := []struct {
+		name       string
+		threshold  float64
+		minReclaim int64
+		want       string
+	}{
+		{"fragmented, leader last", 0.5, 0, "c,a"},
+		{"not worth to reclaim", 0.5, 850, "a"},
+		{"all members, leader last", 0, 0, "b,c,d,a"},
+	}
+	for _, c := range cases {
+		assert.Equal(t, c.want, ids(DefragOrder(usages, c.threshold, c.minReclaim)), c.name)
+	}
+}
+
+func TestMaintain(t *testing.T) {
+	m := embedded(t)
+	err := m.do(
+		"put", m.endpoint("127.0.0.1"),
+		func(ctx context.Context, cli *clientv3.Client) error {
+			value := strings.Repeat("v", 4096)
+			for i := 0; i < 256; i++ {
+				if _, err := cli.Put(ctx, fmt.Sprintf("/key/%d", i), value); err != nil {
+					return err
+				}
+			}
+			_, err := cli.Delete(ctx, "/key/", clientv3.WithPrefix())
+			return err
+		},
+	)
+	assert.Nil(t, err)
+
+	usages, err := m.Usages()
+	assert.Nil(t, err)
+	assert.Equal(t, 1, len(usages))
+	assert.True(t, usages[0].Leader)
+
+	assert.Nil(t, m.Compact(usages[0].Revision))
+	assert.True(t, IsCompacted(m.Compact(usages[0].Revision)))
+	assert.Nil(t, m.Defragment(usages[0].Endpoint))
+
+	defraged, err := m.Usages()
+	assert.Nil(t, err)
+	assert.True(t, defraged[0].DBSize < usages[0].DBSize)
+
+	alarms, err := m.Alarms()
+	assert.Nil(t, err)
+	assert.Equal(t, 0, len(NoSpace(alarms)))
+}
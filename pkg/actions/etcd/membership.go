@@ -0,0 +1,286 @@
+//go:build linux || darwin
+// +build linux darwin
+
+package etcd
+
+import (
+	"context"
+	"fmt"
+	"github.com/pkg/errors"
+	"go.etcd.io/etcd/api/v3/v3rpc/rpctypes"
+	clientv3 "go.etcd.io/etcd/client/v3"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"k8s.io/apimachinery/pkg/util/wait"
+	"k8s.io/klog/v2"
+	"math/big"
+	"strings"
+	"time"
+)
+
+// Membership changes are made one member at a time for any cluster
+// size. a member is removed only when the healthy voting members left
+// behind still form a quorum, and a new member joins as a learner which
+// is promoted to voting member after it has caught up with the leader,
+// so that a joining member never counts against quorum.
+
+// Quorum number of voting members needed for a cluster of size n
+func Quorum(n int) int { return n/2 + 1 }
+
+// Voters voting members, learners excluded
+func Voters(mems []Member) []Member {
+	var voters []Member
+	for _, mem := range mems {
+		if !mem.IsLearner {
+			voters = append(voters, mem)
+		}
+	}
+	return voters
+}
+
+// MemberHealth health of each voting member keyed by member id in hex.
+// members not started yet are unhealthy.
+func (m *Etcd) MemberHealth(mems []Member) map[string]bool {
+	healthy := map[string]bool{}
+	for _, mem := range Voters(mems) {
+		id := memberID(mem)
+		if len(mem.ClientURLs) == 0 {
+			healthy[id] = false
+			continue
+		}
+		err := m.do("endpoint health", mem.ClientURLs[0], health)
+		if err != nil {
+			klog.Warningf("member %s unhealthy: %s", id, err.Error())
+		}
+		healthy[id] = err == nil
+	}
+	return healthy
+}
+
+// CheckQuorum returns error when removing target would leave less healthy
+// voting members than the quorum of the shrunk cluster. removing a
+// learner never affects quorum.
+func CheckQuorum(mems []Member, target Member, healthy map[string]bool) error {
+	if target.IsLearner {
+		return nil
+	}
+	voters, alive := 0, 0
+	for _, mem := range Voters(mems) {
+		if memberID(mem) == memberID(target) {
+			continue
+		}
+		voters++
+		if healthy[memberID(mem)] {
+			alive++
+		}
+	}
+	if voters == 0 {
+		return fmt.Errorf("member %s is the last voting member", memberID(target))
+	}
+	if alive < Quorum(voters) {
+		return fmt.Errorf(
+			"removing member %s loses quorum: %d healthy of %d voting members left, %d needed",
+			memberID(target), alive, voters, Quorum(voters),
+		)
+	}
+	return nil
+}
+
+// PickRemoval member to remove when shrinking cluster by one. learners
+// go first, then unhealthy followers, then healthy followers. the leader
+// is never picked.
+func PickRemoval(mems []Member, leader *big.Int, healthy map[string]bool) (Member, error) {
+	var candidates [3][]Member
+	for _, mem := range mems {
+		switch {
+		case leader != nil && mem.ID != nil && mem.ID.Cmp(leader) == 0:
+			continue
+		case mem.IsLearner:
+			candidates[0] = append(candidates[0], mem)
+		case !healthy[memberID(mem)]:
+			candidates[1] = append(candidates[1], mem)
+		default:
+			candidates[2] = append(candidates[2], mem)
+		}
+	}
+	for _, c := range candidates {
+		if len(c) > 0 {
+			return c[0], nil
+		}
+	}
+	return Member{}, fmt.Errorf("no follower to remove in %d members", len(mems))
+}
+
+// RemoveMemberSafely remove mem after quorum check. member removed
+// already is treated as success.
+func (m *Etcd) RemoveMemberSafely(mem Member) error {
+	if mem.ID == nil || mem.ID.Sign() == 0 {
+		klog.Infof("empty member id: skip remove member")
+		return nil
+	}
+	mems, err := m.MemberList()
+	if err != nil {
+		return errors.Wrapf(err, "list member")
+	}
+	target, found := findMember(mems.Members, mem.ID)
+	if !found {
+		klog.Infof("etcd member %s already removed", memberID(mem))
+		return nil
+	}
+	err = CheckQuorum(mems.Members, target, m.MemberHealth(mems.Members))
+	if err != nil {
+		return err
+	}
+	return m.RemoveMember(target)
+}
+
+// RemoveFollower shrink cluster by exactly one non-leader member picked
+// by PickRemoval, and returns the removed member. only members on ips
+// are candidates when ips is given.
+func (m *Etcd) RemoveFollower(ips ...string) (Member, error) {
+	mems, err := m.MemberList()
+	if err != nil {
+		return Member{}, errors.Wrapf(err, "list member")
+	}
+	if len(mems.Members) <= 1 {
+		return Member{}, fmt.Errorf("can not remove the only member")
+	}
+	leader, err := m.Leader()
+	if err != nil {
+		return Member{}, err
+	}
+	candidates := mems.Members
+	if len(ips) > 0 {
+		candidates = nil
+		for _, ip := range ips {
+			if mem := FindMemberByIP(mems.Members, ip); mem.ID != nil {
+				candidates = append(candidates, mem)
+			}
+		}
+	}
+	healthy := m.MemberHealth(mems.Members)
+	target, err := PickRemoval(candidates, leader, healthy)
+	if err != nil {
+		return Member{}, err
+	}
+	err = CheckQuorum(mems.Members, target, healthy)
+	if err != nil {
+		return Member{}, err
+	}
+	klog.Infof("remove etcd follower %s[%s], %d members left",
+		memberID(target), target.IP, len(mems.Members)-1)
+	return target, m.RemoveMember(target)
+}
+
+// Leader id of the current leader, known to any member of quorum
+func (m *Etcd) Leader() (*big.Int, error) {
+	var leader *big.Int
+	err := TryEachPeer(
+		m.peer, 0,
+		func(ip string) error {
+			st, err := m.status(m.endpoint(ip))
+			if err != nil {
+				return err
+			}
+			if st.Status.Leader == nil || st.Status.Leader.Sign() == 0 {
+				return fmt.Errorf("no leader seen by %s", ip)
+			}
+			leader = st.Status.Leader
+			return nil
+		},
+	)
+	return leader, err
+}
+
+// addMember add a member with peerURL as learner. etcd before 3.4 knows
+// nothing about learner, a voting member is added instead.
+func (m *Etcd) addMember(endpoint, peerURL string) error {
+	return m.do(
+		"member add", endpoint,
+		func(ctx context.Context, cli *clientv3.Client) error {
+			_, err := cli.MemberAddAsLearner(ctx, []string{peerURL})
+			if status.Code(err) != codes.Unimplemented {
+				return err
+			}
+			klog.Warningf("learner not supported by %s, add as voting member", endpoint)
+			_, err = cli.MemberAdd(ctx, []string{peerURL})
+			return err
+		},
+	)
+}
+
+// promote learner id to voting member. etcd refuses until the
+// learner has caught up with the leader.
+func (m *Etcd) promote(id *big.Int) error {
+	return TryEachPeer(
+		m.peer, 0,
+		func(ip string) error {
+			return m.do(
+				"member promote", m.endpoint(ip),
+				func(ctx context.Context, cli *clientv3.Client) error {
+					_, err := cli.MemberPromote(ctx, id.Uint64())
+					return err
+				},
+			)
+		},
+	)
+}
+
+// PromoteMe wait for me to catch up with the leader and promote me to
+// voting member. nothing to do when me is a voting member already.
+func (m *Etcd) PromoteMe() error {
+	return m.waitPromote(func(mems []Member) Member { return FindMemberByIP(mems, m.me) })
+}
+
+func (m *Etcd) waitPromote(find func([]Member) Member) error {
+	return wait.PollImmediate(
+		3*time.Second,
+		5*time.Minute,
+		func() (done bool, err error) {
+			mems, err := m.MemberList()
+			if err != nil {
+				klog.Warningf("wait promote: %s", err.Error())
+				return false, nil
+			}
+			mem := find(mems.Members)
+			if mem.ID == nil {
+				return false, fmt.Errorf("member not found in %d members", len(mems.Members))
+			}
+			if !mem.IsLearner {
+				return true, nil
+			}
+			err = m.promote(mem.ID)
+			if err != nil {
+				if isLearnerNotReady(err) {
+					klog.Infof("learner %s is catching up with leader", memberID(mem))
+				} else {
+					klog.Warningf("promote learner %s: %s", memberID(mem), err.Error())
+				}
+				return false, nil
+			}
+			klog.Infof("learner %s[%s] promoted", memberID(mem), mem.IP)
+			return true, nil
+		},
+	)
+}
+
+func isLearnerNotReady(err error) bool {
+	return err != nil && strings.Contains(err.Error(), rpctypes.ErrMemberLearnerNotReady.Error())
+}
+
+func findMember(mems []Member, id *big.Int) (Member, bool) {
+	for _, mem := range mems {
+		if mem.ID != nil && id != nil && mem.ID.Cmp(id) == 0 {
+			return mem, true
+		}
+	}
+	return Member{}, false
+}
+
+// memberID member id in hex as etcdctl prints
+func memberID(mem Member) string {
+	if mem.ID == nil {
+		return ""
+	}
+	return fmt.Sprintf("%x", mem.ID)
+}
@@ -0,0 +1,265 @@
+//go:build linux || darwin
+// +build linux darwin
+
+package etcd
+
+import (
+	"fmt"
+	"github.com/stretchr/testify/assert"
+	"go.etcd.io/etcd/server/v3/embed"
+	"math/big"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// embeddedCluster start n members embedded etcd cluster on localhost.
+// peers of the returned Etcd are in the same order as servers.
+func embeddedCluster(t *testing.T, n int) (*Etcd, []*embed.Etcd) {
+	ports, err := freePorts(2 * n)
+	assert.Nil(t, err)
+	m := &Etcd{insecure: true}
+	var (
+		cfgs    []*embed.Config
+		initial []string
+	)
+	for i := 0; i < n; i++ {
+		name := fmt.Sprintf("etcd-%d", i)
+		m.peer = append(m.peer, fmt.Sprintf("127.0.0.1:%d", ports[2*i]))
+		cfg, err := embedConfig(
+			name, filepath.Join(t.TempDir(), DataDir),
+			m.endpoint(m.peer[i]), fmt.Sprintf("http://127.0.0.1:%d", ports[2*i+1]),
+		)
+		assert.Nil(t, err)
+		cfgs = append(cfgs, cfg)
+		initial = append(initial, fmt.Sprintf("%s=http://127.0.0.1:%d", name, ports[2*i+1]))
+	}
+	var servers []*embed.Etcd
+	for _, cfg := range cfgs {
+		cfg.InitialCluster = strings.Join(initial, ",")
+		server, err := embed.StartEtcd(cfg)
+		if err != nil {
+			t.Fatalf("start embedded etcd %s: %s", cfg.Name, err.Error())
+		}
+		t.Cleanup(func() {
+			select {
+			case <-server.Server.StopNotify():
+				// stopped by test case
+			default:
+				server.Close()
+			}
+		})
+		servers = append(servers, server)
+	}
+	for _, server := range servers {
+		if err := waitEmbed(server); err != nil {
+			t.Fatalf("wait embedded etcd: %s", err.Error())
+		}
+	}
+	return m, servers
+}
+
+// follower index of a non-leader server
+func follower(t *testing.T, m *Etcd, servers []*embed.Etcd) int {
+	leader, err := m.Leader()
+	assert.Nil(t, err)
+	for i, server := range servers {
+		if uint64(server.Server.ID()) != leader.Uint64() {
+			return i
+		}
+	}
+	t.Fatalf("no follower in %d members", len(servers))
+	return -1
+}
+
+func memberOf(server *embed.Etcd) Member {
+	return Member{ID: new(big.Int).SetUint64(uint64(server.Server.ID()))}
+}
+
+func TestMembership(t *testing.T) {
+	cases := []struct {
+		name    string
+		members int
+		// stop a follower before action
+		stop bool
+		// action with the index of stopped follower, or any follower
+		action  func(m *Etcd, servers []*embed.Etcd, follower int) error
+		wantErr bool
+		left    int
+	}{
+		{
+			name:    "remove follower of 3",
+			members: 3,
+			action: func(m *Etcd, servers []*embed.Etcd, follower int) error {
+				_, err := m.RemoveFollower()
+				return err
+			},
+			left: 2,
+		},
+		{
+			name:    "remove stopped member of 3",
+			members: 3,
+			stop:    true,
+			action: func(m *Etcd, servers []*embed.Etcd, follower int) error {
+				mem, err := m.RemoveFollower()
+				if err == nil && mem.ID.Cmp(memberOf(servers[follower]).ID) != 0 {
+					return fmt.Errorf("healthy member %x removed", mem.ID)
+				}
+				return err
+			},
+			left: 2,
+		},
+		{
+			name:    "refuse to remove healthy member when quorum lost",
+			members: 3,
+			stop:    true,
+			action: func(m *Etcd, servers []*embed.Etcd, follower int) error {
+				for i, server := range servers {
+					if i == follower {
+						continue
+					}
+					return m.RemoveMemberSafely(memberOf(server))
+				}
+				return nil
+			},
+			wantErr: true,
+			left:    3,
+		},
+		{
+			name:    "refuse to remove the only member",
+			members: 1,
+			action: func(m *Etcd, servers []*embed.Etcd, follower int) error {
+				return m.RemoveMemberSafely(memberOf(servers[0]))
+			},
+			wantErr: true,
+			left:    1,
+		},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			m, servers := embeddedCluster(t, c.members)
+			idx := 0
+			if c.members > 1 {
+				idx = follower(t, m, servers)
+			}
+			if c.stop {
+				servers[idx].Close()
+				// stopped member is tried last
+				m.peer = append(append(m.peer[:idx:idx], m.peer[idx+1:]...), m.peer[idx])
+			}
+			err := c.action(m, servers, idx)
+			if c.wantErr {
+				assert.NotNil(t, err)
+			} else {
+				assert.Nil(t, err)
+			}
+			mems, err := m.MemberList()
+			assert.Nil(t, err)
+			assert.Equal(t, c.left, len(mems.Members))
+		})
+	}
+}
+
+func TestLearnerJoin(t *testing.T) {
+	m, _ := embeddedCluster(t, 1)
+	ports, err := freePorts(2)
+	assert.Nil(t, err)
+	peer := fmt.Sprintf("http://127.0.0.1:%d", ports[1])
+	assert.Nil(t, m.addMember(m.endpoint(m.peer[0]), peer))
+
+	mems, err := m.MemberList()
+	assert.Nil(t, err)
+	assert.Equal(t, 2, len(mems.Members))
+	assert.Equal(t, 1, len(Voters(mems.Members)))
+
+	// learner does not count for quorum
+	learner := findByPeer(mems.Members, peer)
+	assert.True(t, learner.IsLearner)
+	assert.Nil(t, CheckQuorum(mems.Members, learner, nil))
+
+	cfg, err := embedConfig(
+		"learner", filepath.Join(t.TempDir(), DataDir),
+		fmt.Sprintf("http://127.0.0.1:%d", ports[0]), peer,
+	)
+	assert.Nil(t, err)
+	cfg.ClusterState = embed.ClusterStateFlagExisting
+	for _, mem := range mems.Members {
+		if !mem.IsLearner {
+			cfg.InitialCluster = fmt.Sprintf("%s=%s,learner=%s", mem.Name, mem.PeerURLs[0], peer)
+		}
+	}
+	server, err := embed.StartEtcd(cfg)
+	assert.Nil(t, err)
+	t.Cleanup(server.Close)
+
+	assert.Nil(t, m.waitPromote(func(mems []Member) Member { return findByPeer(mems, peer) }))
+	mems, err = m.MemberList()
+	assert.Nil(t, err)
+	assert.Equal(t, 2, len(Voters(mems.Members)))
+}
+
+func findByPeer(mems []Member, peer string) Member {
+	for _, mem := range mems {
+		if len(mem.PeerURLs) > 0 && mem.PeerURLs[0] == peer {
+			return mem
+		}
+	}
+	return Member{}
+}
+
+func TestCheckQuorum(t *testing.T) {
+	member := func(id int64, learner bool) Member {
+		return Member{ID: big.NewInt(id), IsLearner: learner}
+	}
+	three := []Member{member(1, false), member(2, false), member(3, false)}
+	five := append([]Member{member(4, false), member(5, false)}, three...)
+	cases := []struct {
+		name    string
+		mems    []Member
+		target  Member
+		healthy map[string]bool
+		wantErr bool
+	}{
+		{"all healthy of 3", three, member(3, false), map[string]bool{"1": true, "2": true, "3": true}, false},
+		{"remove the unhealthy of 3", three, member(3, false), map[string]bool{"1": true, "2": true}, false},
+		{"remove healthy with one down of 3", three, member(2, false), map[string]bool{"1": true, "2": true}, true},
+		{"5 to 4 with one down", five, member(5, false), map[string]bool{"1": true, "2": true, "3": true, "5": true}, false},
+		{"5 to 4 with two down", five, member(5, false), map[string]bool{"1": true, "2": true, "5": true}, true},
+		{"last voter", []Member{member(1, false)}, member(1, false), map[string]bool{"1": true}, true},
+		{"learner", append(three, member(6, true)), member(6, true), map[string]bool{}, false},
+	}
+	for _, c := range cases {
+		err := CheckQuorum(c.mems, c.target, c.healthy)
+		assert.Equal(t, c.wantErr, err != nil, c.name)
+	}
+}
+
+func TestPickRemoval(t *testing.T) {
+	mems := []Member{
+		{ID: big.NewInt(1)},
+		{ID: big.NewInt(2)},
+		{ID: big.NewInt(3)},
+		{ID: big.NewInt(4), IsLearner: true},
+	}
+	cases := []struct {
+		name    string
+		mems    []Member
+		leader  int64
+		healthy map[string]bool
+		want    int64
+	}{
+		{"learner first", mems, 1, map[string]bool{"1": true, "2": true, "3": true}, 4},
+		{"unhealthy follower", mems[:3], 1, map[string]bool{"1": true, "2": true}, 3},
+		{"never the leader", mems[:3], 1, map[string]bool{"2": true, "3": true}, 2},
+		{"no follower", mems[:1], 1, map[string]bool{"1": true}, 0},
+	}
+	for _, c := range cases {
+		mem, err := PickRemoval(c.mems, big.NewInt(c.leader), c.healthy)
+		if c.want == 0 {
+			assert.NotNil(t, err, c.name)
+			continue
+		}
+		assert.Nil(t, err, c.name)
+		assert.Equal(t, c.want, mem.ID.Int64(), c.name)
+	}
+}
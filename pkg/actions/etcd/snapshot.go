@@ -0,0 +1,145 @@
+//go:build linux || darwin
+// +build linux darwin
+
+package etcd
+
+import (
+	"context"
+	"fmt"
+	"github.com/pkg/errors"
+	csnapshot "go.etcd.io/etcd/client/v3/snapshot"
+	"go.etcd.io/etcd/etcdutl/v3/snapshot"
+	"go.uber.org/zap"
+	"k8s.io/klog/v2"
+	"math/big"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// SnapshotSource etcd member a snapshot was taken from
+type SnapshotSource struct {
+	Endpoint string
+	// MemberID in hex, empty when member status is unknown
+	MemberID string
+	Leader   bool
+	// Revision of the store captured by the snapshot
+	Revision int64
+}
+
+// Snapshot save snapshot to dir from the healthiest member. status of
+// every member is queried, the leader is preferred, then the member
+// with the highest revision. the next member is tried on failure,
+// members whose status is unknown are tried last.
+func (m *Etcd) Snapshot(dir string) (*SnapshotSource, error) {
+	if dir == "" {
+		return nil, fmt.Errorf("empty snapshot target path")
+	}
+	if len(m.peer) <= 0 {
+		return nil, fmt.Errorf("empty peer endpoint, can not snapshot")
+	}
+
+	err := os.MkdirAll(filepath.Dir(dir), 0755)
+	if err != nil {
+		return nil, errors.Wrapf(err, "make dir: %s", filepath.Dir(dir))
+	}
+	var endpoints []string
+	statuses := map[string]Status{}
+	for _, ip := range m.peer {
+		endpoint := m.endpoint(ip)
+		endpoints = append(endpoints, endpoint)
+		status, err := m.status(endpoint)
+		if err != nil {
+			klog.Warningf("snapshot: %s", err.Error())
+			continue
+		}
+		statuses[endpoint] = status.Status
+	}
+	var errs []string
+	for _, endpoint := range rankEndpoints(endpoints, statuses) {
+		err := m.snapshotFrom(endpoint, dir)
+		if err != nil {
+			klog.Warningf("snapshot: %s, try next member", err.Error())
+			errs = append(errs, err.Error())
+			continue
+		}
+		source := &SnapshotSource{Endpoint: endpoint}
+		if status, ok := statuses[endpoint]; ok {
+			source.MemberID = fmt.Sprintf("%x", status.Header.MemberID)
+			source.Leader = isLeader(status)
+		}
+		sstatus, err := snapshot.NewV3(zap.NewNop()).Status(dir)
+		if err != nil {
+			return source, errors.Wrapf(err, "read snapshot status: %s", dir)
+		}
+		source.Revision = sstatus.Revision
+		klog.Infof("snapshot taken from member %s [%s], leader=%t, revision=%d",
+			source.MemberID, endpoint, source.Leader, source.Revision)
+		return source, nil
+	}
+	return nil, fmt.Errorf("snapshot from all %d members failed: %s", len(endpoints), strings.Join(errs, "; "))
+}
+
+func (m *Etcd) snapshotFrom(endpoint, dir string) error {
+	cfg, err := m.ClientConfig(endpoint)
+	if err != nil {
+		return err
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), SnapshotTimeout)
+	defer cancel()
+	// written to dir.part and renamed on success
+	err = csnapshot.Save(ctx, zap.NewNop(), cfg, dir)
+	if err != nil {
+		return &Error{Op: "snapshot save", Endpoint: endpoint, Err: err}
+	}
+	return nil
+}
+
+// rankEndpoints order endpoints to take snapshot from: the leader, then
+// members by revision and raft index descending, then endpoints without
+// status in their original order.
+func rankEndpoints(endpoints []string, statuses map[string]Status) []string {
+	var known, unknown []string
+	for _, endpoint := range endpoints {
+		if _, ok := statuses[endpoint]; ok {
+			known = append(known, endpoint)
+			continue
+		}
+		unknown = append(unknown, endpoint)
+	}
+	sort.SliceStable(
+		known,
+		func(i, j int) bool {
+			si, sj := statuses[known[i]], statuses[known[j]]
+			if li, lj := isLeader(si), isLeader(sj); li != lj {
+				return li
+			}
+			if c := cmpInt(si.Header.Revision, sj.Header.Revision); c != 0 {
+				return c > 0
+			}
+			return cmpInt(si.RaftIndex, sj.RaftIndex) > 0
+		},
+	)
+	return append(known, unknown...)
+}
+
+func isLeader(s Status) bool {
+	return s.Leader != nil &&
+		s.Header.MemberID != nil &&
+		s.Leader.Sign() != 0 &&
+		s.Leader.Cmp(s.Header.MemberID) == 0
+}
+
+// cmpInt compare big int, nil is the smallest
+func cmpInt(a, b *big.Int) int {
+	switch {
+	case a == nil && b == nil:
+		return 0
+	case a == nil:
+		return -1
+	case b == nil:
+		return 1
+	}
+	return a.Cmp(b)
+}
@@ -20,6 +20,9 @@ type Status struct {
 	Leader    *big.Int `json:"leader,omitempty" protobuf:"bytes,4,opt,name=leader"`
 	RaftIndex *big.Int `json:"raftIndex,omitempty" protobuf:"bytes,5,opt,name=raftIndex"`
 	RaftTerm  *big.Int `json:"raftTerm,omitempty" protobuf:"bytes,6,opt,name=raftTerm"`
+	// DBSizeInUse logically in use size of backend db, the rest of
+	// DBsize is reclaimable by defragmentation
+	DBSizeInUse *big.Int `json:"dbSizeInUse,omitempty" protobuf:"bytes,7,opt,name=dbSizeInUse"`
 }
 
 type Header struct {
@@ -0,0 +1,274 @@
+//go:build linux || darwin
+// +build linux darwin
+
+package etcd
+
+import (
+	"fmt"
+	"github.com/aoxn/wdrip/pkg/utils/cmd"
+	"github.com/pkg/errors"
+	"io"
+	"k8s.io/apimachinery/pkg/util/version"
+	"k8s.io/apimachinery/pkg/util/wait"
+	"k8s.io/klog/v2"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// Upgrade replaces the etcd binary of one member at a time. the running
+// binary is kept as <name>.rollback beside the new one, and is put back
+// when the upgraded member does not come back healthy, caught up with
+// the revision of the cluster and reporting the new version.
+
+const (
+	// EtcdBinDir where etcd binaries are installed
+	EtcdBinDir = "/usr/bin"
+
+	// UpgradeStage where binaries of the new version are staged
+	UpgradeStage = "/var/tmp/etcd-upgrade"
+
+	// UpgradeTimeout wait for the upgraded member to be ready
+	UpgradeTimeout = 3 * time.Minute
+
+	rollbackSuffix = ".rollback"
+)
+
+// EtcdBinaries binaries replaced on upgrade
+var EtcdBinaries = []string{"etcd", "etcdctl"}
+
+// CompatibleUpgrade returns error unless from can be upgraded to to in
+// place: same major version, no downgrade, and at most one minor
+// version ahead as etcd supports.
+func CompatibleUpgrade(from, to string) error {
+	fv, err := version.ParseGeneric(from)
+	if err != nil {
+		return errors.Wrapf(err, "parse version %q", from)
+	}
+	tv, err := version.ParseGeneric(to)
+	if err != nil {
+		return errors.Wrapf(err, "parse version %q", to)
+	}
+	switch {
+	case fv.Major() != tv.Major():
+		return fmt.Errorf("upgrade across major version is not supported: %s to %s", from, to)
+	case tv.LessThan(fv):
+		return fmt.Errorf("downgrade is not supported: %s to %s", from, to)
+	case tv.Minor() > fv.Minor()+1:
+		return fmt.Errorf("upgrade one minor version at a time: %s to %s", from, to)
+	}
+	return nil
+}
+
+// SameVersion whether a and b are the same version, with or without v
+func SameVersion(a, b string) bool {
+	return strings.TrimPrefix(a, "v") == strings.TrimPrefix(b, "v")
+}
+
+// Versions server version of each peer keyed by peer ip
+func (m *Etcd) Versions() (map[string]string, error) {
+	versions := map[string]string{}
+	for _, ip := range m.peer {
+		status, err := m.status(m.endpoint(ip))
+		if err != nil {
+			return versions, errors.Wrapf(err, "version of %s", ip)
+		}
+		versions[ip] = status.Status.Version
+	}
+	return versions, nil
+}
+
+// CheckUpgrade returns error unless every voting member is healthy and
+// every peer can be upgraded to version.
+func (m *Etcd) CheckUpgrade(to string) error {
+	mems, err := m.MemberList()
+	if err != nil {
+		return errors.Wrapf(err, "list member")
+	}
+	for id, ok := range m.MemberHealth(mems.Members) {
+		if !ok {
+			return fmt.Errorf("member %s unhealthy, abort upgrade", id)
+		}
+	}
+	versions, err := m.Versions()
+	if err != nil {
+		return err
+	}
+	for ip, v := range versions {
+		if err := CompatibleUpgrade(v, to); err != nil {
+			return errors.Wrapf(err, "member %s", ip)
+		}
+	}
+	return nil
+}
+
+// revision the highest revision among peers
+func (m *Etcd) revision() (int64, error) {
+	var revision int64
+	for _, ip := range m.peer {
+		status, err := m.status(m.endpoint(ip))
+		if err != nil {
+			return 0, errors.Wrapf(err, "revision of %s", ip)
+		}
+		if r := status.Status.Header.Revision; r != nil && r.Int64() > revision {
+			revision = r.Int64()
+		}
+	}
+	return revision, nil
+}
+
+// WaitCaughtUp wait for the member on ip to be healthy, report version
+// and reach revision.
+func (m *Etcd) WaitCaughtUp(ip, version string, revision int64) error {
+	endpoint := m.endpoint(ip)
+	var last error
+	err := wait.PollImmediate(
+		3*time.Second,
+		UpgradeTimeout,
+		func() (bool, error) {
+			if last = m.do("endpoint health", endpoint, health); last != nil {
+				return false, nil
+			}
+			status, err := m.status(endpoint)
+			if err != nil {
+				last = err
+				return false, nil
+			}
+			if !SameVersion(status.Status.Version, version) {
+				last = fmt.Errorf("version %s, expect %s", status.Status.Version, version)
+				return false, nil
+			}
+			r := status.Status.Header.Revision
+			if r == nil || r.Int64() < revision {
+				last = fmt.Errorf("revision %v behind %d", r, revision)
+				return false, nil
+			}
+			return true, nil
+		},
+	)
+	if err != nil {
+		return fmt.Errorf("wait member %s caught up: %v", ip, last)
+	}
+	return nil
+}
+
+// UpgradeMember upgrade member on ip, which must be the current node, to
+// version with binaries staged in stage. member at version already is
+// skipped. the old binaries are restored and the member restarted when
+// the upgraded member fails to catch up.
+func (m *Etcd) UpgradeMember(ip, stage, version string) error {
+	status, err := m.status(m.endpoint(ip))
+	if err != nil {
+		return errors.Wrapf(err, "status of member %s", ip)
+	}
+	if SameVersion(status.Status.Version, version) {
+		klog.Infof("member %s is running %s already, skip upgrade", ip, version)
+		return nil
+	}
+	err = CompatibleUpgrade(status.Status.Version, version)
+	if err != nil {
+		return err
+	}
+	err = m.CheckUpgrade(version)
+	if err != nil {
+		return err
+	}
+	revision, err := m.revision()
+	if err != nil {
+		return err
+	}
+	klog.Infof("upgrade member %s from %s to %s at revision %d",
+		ip, status.Status.Version, version, revision)
+	err = SwapBinary(stage, EtcdBinDir)
+	if err == nil {
+		err = restart()
+	}
+	if err == nil {
+		err = m.WaitCaughtUp(ip, version, revision)
+	}
+	if err == nil {
+		klog.Infof("member %s upgraded to %s", ip, version)
+		return nil
+	}
+	klog.Errorf("upgrade member %s: %s, rolling back to %s", ip, err.Error(), status.Status.Version)
+	rerr := RollbackBinary(EtcdBinDir)
+	if rerr == nil {
+		rerr = restart()
+	}
+	if rerr == nil {
+		rerr = m.WaitCaughtUp(ip, status.Status.Version, revision)
+	}
+	if rerr != nil {
+		return fmt.Errorf("upgrade member %s: %s, rollback failed: %s", ip, err.Error(), rerr.Error())
+	}
+	return errors.Wrapf(err, "upgrade member %s, rolled back to %s", ip, status.Status.Version)
+}
+
+// SwapBinary install EtcdBinaries in stage into dir, the replaced
+// binaries are kept for RollbackBinary.
+func SwapBinary(stage, dir string) error {
+	for _, name := range EtcdBinaries {
+		src := filepath.Join(stage, name)
+		if _, err := os.Stat(src); err != nil {
+			return errors.Wrapf(err, "staged binary %s", name)
+		}
+		dst := filepath.Join(dir, name)
+		if _, err := os.Stat(dst); err == nil {
+			if err := install(dst, dst+rollbackSuffix); err != nil {
+				return errors.Wrapf(err, "keep %s for rollback", dst)
+			}
+		}
+		if err := install(src, dst); err != nil {
+			return errors.Wrapf(err, "install %s", dst)
+		}
+	}
+	return nil
+}
+
+// RollbackBinary restore binaries replaced by SwapBinary
+func RollbackBinary(dir string) error {
+	for _, name := range EtcdBinaries {
+		dst := filepath.Join(dir, name)
+		if _, err := os.Stat(dst + rollbackSuffix); err != nil {
+			klog.Warningf("no rollback binary for %s: %s", dst, err.Error())
+			continue
+		}
+		if err := install(dst+rollbackSuffix, dst); err != nil {
+			return errors.Wrapf(err, "restore %s", dst)
+		}
+	}
+	return nil
+}
+
+// install copy src to dst through a temp file, so that a running dst is
+// replaced rather than written in place.
+func install(src, dst string) error {
+	in, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+	tmp := dst + ".tmp"
+	out, err := os.OpenFile(tmp, os.O_CREATE|os.O_TRUNC|os.O_WRONLY, 0755)
+	if err != nil {
+		return err
+	}
+	_, err = io.Copy(out, in)
+	if cerr := out.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		os.Remove(tmp)
+		return err
+	}
+	return os.Rename(tmp, dst)
+}
+
+func restart() error {
+	err := cmd.Systemctl([]string{"restart", "etcd"})
+	if err != nil {
+		return errors.Wrapf(err, "restart etcd")
+	}
+	return nil
+}
@@ -0,0 +1,58 @@
+//go:build linux || darwin
+// +build linux darwin
+
+package etcd
+
+import (
+	"github.com/stretchr/testify/assert"
+	"io/ioutil"
+	"path/filepath"
+	"testing"
+)
+
+func TestCompatibleUpgrade(t *testing.T) {
+	cases := []struct {
+		from, to string
+		wantErr  bool
+	}{
+		{"3.4.13", "3.4.13", false},
+		{"3.4.13", "v3.4.16", false},
+		{"v3.4.13", "3.5.0", false},
+		{"3.3.8", "3.5.0", true},
+		{"3.5.0", "3.4.13", true},
+		{"2.3.8", "3.0.0", true},
+		{"3.5.0", "latest", true},
+	}
+	for _, c := range cases {
+		err := CompatibleUpgrade(c.from, c.to)
+		assert.Equal(t, c.wantErr, err != nil, "%s to %s", c.from, c.to)
+	}
+}
+
+func TestSwapBinary(t *testing.T) {
+	stage, dir := t.TempDir(), t.TempDir()
+	for _, name := range EtcdBinaries {
+		assert.Nil(t, ioutil.WriteFile(filepath.Join(dir, name), []byte("old"), 0755))
+		assert.Nil(t, ioutil.WriteFile(filepath.Join(stage, name), []byte("new"), 0755))
+	}
+	content := func(name string) string {
+		data, err := ioutil.ReadFile(filepath.Join(dir, name))
+		assert.Nil(t, err)
+		return string(data)
+	}
+
+	assert.Nil(t, SwapBinary(stage, dir))
+	for _, name := range EtcdBinaries {
+		assert.Equal(t, "new", content(name))
+		assert.Equal(t, "old", content(name+rollbackSuffix))
+	}
+
+	assert.Nil(t, RollbackBinary(dir))
+	for _, name := range EtcdBinaries {
+		assert.Equal(t, "old", content(name))
+	}
+
+	// incomplete stage never touches installed binaries
+	assert.NotNil(t, SwapBinary(t.TempDir(), dir))
+	assert.Equal(t, "old", content("etcd"))
+}
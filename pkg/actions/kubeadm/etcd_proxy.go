@@ -0,0 +1,163 @@
+//go:build linux || darwin
+// +build linux darwin
+
+package kubeadm
+
+import (
+	"bytes"
+	"fmt"
+	"github.com/aoxn/wdrip/pkg/actions/kubeadm/tpl"
+	"github.com/aoxn/wdrip/pkg/apis/alibabacloud.com/v1"
+	"github.com/pkg/errors"
+	"html/template"
+	"io/ioutil"
+	"k8s.io/klog/v2"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+)
+
+const (
+	// EtcdProxyManifest static pod manifest of local etcd grpc proxy
+	EtcdProxyManifest = "/etc/kubernetes/manifests/etcd-proxy.yaml"
+
+	// EtcdProxyPort port the proxy listens on localhost
+	EtcdProxyPort = 23790
+
+	// ParaEtcdProxy etcd para to turn the proxy on with "enabled",
+	// apiserver is pinned to etcd endpoints directly otherwise.
+	ParaEtcdProxy = "proxy"
+
+	// ParaEtcdProxyImage etcd para to set the proxy image, any image
+	// with the etcd binary of the cluster version on PATH.
+	ParaEtcdProxyImage = "proxyImage"
+)
+
+// EtcdProxyEnabled whether apiserver talks to etcd through local proxy.
+// the proxy is opt-in, no proxy image is shipped with the cluster.
+func EtcdProxyEnabled(spec *v1.ClusterSpec) bool {
+	return spec.Etcd.Paras[ParaEtcdProxy] == "enabled"
+}
+
+// EtcdProxyImage image of etcd proxy, etcd image of the same version as
+// etcd members from cluster registry when ParaEtcdProxyImage is not set,
+// which must be pushed to the registry before the proxy is enabled.
+func EtcdProxyImage(spec *v1.ClusterSpec) string {
+	if image := spec.Etcd.Paras[ParaEtcdProxyImage]; image != "" {
+		return image
+	}
+	return fmt.Sprintf("%s/etcd:%s", spec.Registry, strings.TrimPrefix(spec.Etcd.Version, "v"))
+}
+
+// WithEtcdProxy point apiserver to local etcd proxy, and let the proxy
+// balance across configured etcd endpoints and peers of this master.
+// must be applied after WithEtcdEndpoints.
+func WithEtcdProxy(tpl *ConfigTpl) {
+	if !EtcdProxyEnabled(tpl.ClusterSpec) {
+		return
+	}
+	members := tpl.EtcdEndpoints
+	for _, peer := range tpl.Master.Status.Peer {
+		if peer.IP == "" {
+			continue
+		}
+		endpoint := fmt.Sprintf("https://%s:2379", peer.IP)
+		if !contains(members, endpoint) {
+			members = append(members, endpoint)
+		}
+	}
+	tpl.EtcdMembers = members
+	tpl.EtcdEndpoints = []string{fmt.Sprintf("https://127.0.0.1:%d", EtcdProxyPort)}
+}
+
+// EtcdProxy render static pod manifest of etcd proxy
+func EtcdProxy(node *v1.Master) (string, error) {
+	cfg := NewConfigTpl(node, WithEtcdEndpoints, WithEtcdProxy)
+	t, err := template.New("etcd-proxy").Parse(tpl.EtcdProxy)
+	if err != nil {
+		return "", errors.Wrap(err, "failed to parse etcd proxy template")
+	}
+	var buff bytes.Buffer
+	err = t.Execute(
+		&buff,
+		struct {
+			Image   string
+			Members string
+			Listen  string
+			Port    int
+		}{
+			Image:   EtcdProxyImage(cfg.ClusterSpec),
+			Members: strings.Join(cfg.EtcdMembers, ","),
+			Listen:  fmt.Sprintf("127.0.0.1:%d", EtcdProxyPort),
+			Port:    EtcdProxyPort,
+		},
+	)
+	if err != nil {
+		return "", errors.Wrap(err, "error executing etcd proxy template")
+	}
+	return buff.String(), nil
+}
+
+// WriteEtcdProxy write etcd proxy static pod to kubelet manifest dir,
+// kubelet keeps it running before apiserver comes up.
+func WriteEtcdProxy(node *v1.Master) error {
+	if !EtcdProxyEnabled(&node.Status.BootCFG.Spec) {
+		klog.Infof("etcd proxy disabled, apiserver talks to etcd directly")
+		return nil
+	}
+	manifest, err := EtcdProxy(node)
+	if err != nil {
+		return err
+	}
+	err = os.MkdirAll(filepath.Dir(EtcdProxyManifest), 0755)
+	if err != nil {
+		return errors.Wrapf(err, "mkdir %s", filepath.Dir(EtcdProxyManifest))
+	}
+	klog.Infof("write etcd proxy static pod: %s", EtcdProxyManifest)
+	return ioutil.WriteFile(EtcdProxyManifest, []byte(manifest), 0644)
+}
+
+// UpgradeEtcdProxy point image of local etcd proxy to the one of spec,
+// endpoints are kept. nothing is done when the proxy is not deployed.
+func UpgradeEtcdProxy(spec *v1.ClusterSpec) error {
+	data, err := ioutil.ReadFile(EtcdProxyManifest)
+	if err != nil {
+		if os.IsNotExist(err) {
+			klog.Infof("etcd proxy not deployed, skip upgrade")
+			return nil
+		}
+		return errors.Wrapf(err, "read %s", EtcdProxyManifest)
+	}
+	image := EtcdProxyImage(spec)
+	manifest := proxyImage.ReplaceAll(data, []byte("${1}"+image))
+	if bytes.Equal(manifest, data) {
+		return nil
+	}
+	klog.Infof("upgrade etcd proxy image to %s", image)
+	return ioutil.WriteFile(EtcdProxyManifest, manifest, 0644)
+}
+
+var proxyImage = regexp.MustCompile(`(?m)^(\s*image: ).*$`)
+
+// EtcdProxyEndpointsCommand shell command pointing the etcd proxy on a
+// master to members, grpc-proxy only knows the endpoints it is started
+// with and must be re-rendered on membership change. the manifest is
+// left untouched when endpoints are unchanged, so that kubelet does not
+// restart the proxy for nothing.
+func EtcdProxyEndpointsCommand(members []string) string {
+	line := fmt.Sprintf("- --endpoints=%s", strings.Join(members, ","))
+	return fmt.Sprintf(
+		"test ! -f %[1]s || grep -q -- '%[2]s$' %[1]s || sed -i 's#- --endpoints=.*#%[2]s#' %[1]s",
+		EtcdProxyManifest, line,
+	)
+}
+
+func contains(items []string, item string) bool {
+	for _, i := range items {
+		if i == item {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,76 @@
+//go:build linux || darwin
+// +build linux darwin
+
+package kubeadm
+
+import (
+	v12 "github.com/aoxn/wdrip/pkg/apis/alibabacloud.com/v1"
+	"github.com/ghodss/yaml"
+	"github.com/stretchr/testify/assert"
+	"io/ioutil"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestEtcdProxy(t *testing.T) {
+	cfg := &v12.Cluster{}
+	assert.Nil(t, yaml.Unmarshal([]byte(bootcfg), &cfg.Spec))
+	cfg.Spec.Etcd.Endpoints = "192.168.0.1,192.168.0.2"
+	cfg.Spec.Etcd.Paras = map[string]string{ParaEtcdProxy: "enabled"}
+	node := &v12.Master{
+		Spec: v12.MasterSpec{ID: "cn-hangzhou.i-xxx", IP: "192.168.0.1"},
+		Status: v12.MasterStatus{
+			BootCFG: cfg,
+			Peer:    []v12.Host{{IP: "192.168.0.2"}, {IP: "192.168.0.3"}},
+		},
+	}
+
+	manifest, err := EtcdProxy(node)
+	assert.Nil(t, err)
+	assert.True(t, strings.Contains(manifest,
+		"--endpoints=https://192.168.0.1:2379,https://192.168.0.2:2379,https://192.168.0.3:2379"))
+	assert.True(t, strings.Contains(manifest, "--listen-addr=127.0.0.1:23790"))
+	assert.True(t, strings.Contains(manifest, "image: registry-vpc.cn-hangzhou.aliyuncs.com/acs/etcd:3.3.8"))
+
+	tpl := NewConfigTpl(node, WithEtcdEndpoints, WithEtcdProxy)
+	assert.Equal(t, []string{"https://127.0.0.1:23790"}, tpl.EtcdEndpoints)
+
+	cfg.Spec.Etcd.Paras[ParaEtcdProxyImage] = "registry.example.com/etcd:3.4.13-0"
+	manifest, err = EtcdProxy(node)
+	assert.Nil(t, err)
+	assert.True(t, strings.Contains(manifest, "image: registry.example.com/etcd:3.4.13-0"))
+
+	// apiserver pinned to etcd directly unless proxy is enabled
+	cfg.Spec.Etcd.Paras = nil
+	assert.False(t, EtcdProxyEnabled(&cfg.Spec))
+	tpl = NewConfigTpl(node, WithEtcdEndpoints, WithEtcdProxy)
+	assert.Equal(t, []string{"https://192.168.0.1:2379", "https://192.168.0.2:2379"}, tpl.EtcdEndpoints)
+}
+
+func TestEtcdProxyEndpointsCommand(t *testing.T) {
+	cfg := &v12.Cluster{}
+	assert.Nil(t, yaml.Unmarshal([]byte(bootcfg), &cfg.Spec))
+	node := &v12.Master{
+		Spec:   v12.MasterSpec{ID: "cn-hangzhou.i-xxx", IP: "192.168.0.1"},
+		Status: v12.MasterStatus{BootCFG: cfg},
+	}
+	manifest, err := EtcdProxy(node)
+	assert.Nil(t, err)
+	file := filepath.Join(t.TempDir(), "etcd-proxy.yaml")
+	assert.Nil(t, ioutil.WriteFile(file, []byte(manifest), 0644))
+
+	members := []string{"https://192.168.0.2:2379", "https://192.168.0.3:2379"}
+	cmd := strings.ReplaceAll(EtcdProxyEndpointsCommand(members), EtcdProxyManifest, file)
+	for i := 0; i < 2; i++ {
+		out, err := exec.Command("sh", "-c", cmd).CombinedOutput()
+		assert.Nil(t, err, string(out))
+	}
+	data, err := ioutil.ReadFile(file)
+	assert.Nil(t, err)
+	assert.Equal(t, 1, strings.Count(string(data), "--endpoints="))
+	assert.Contains(t, string(data),
+		"    - --endpoints=https://192.168.0.2:2379,https://192.168.0.3:2379\n")
+	assert.Contains(t, string(data), "--listen-addr=127.0.0.1:23790")
+}
@@ -47,6 +47,8 @@ type ConfigTpl struct {
 	*v1.ClusterSpec
 	NodeName      string
 	EtcdEndpoints []string
+	// EtcdMembers etcd endpoints behind local etcd proxy
+	EtcdMembers []string
 }
 
 type Option func(tpl *ConfigTpl)
@@ -113,6 +115,10 @@ func (a *ActionInit) Execute(ctx *actions.ActionContext) error {
 	}
 
 	klog.Infof("Using kubeadm config:%v", utils.PrettyYaml(kubeadmConfig))
+	err = WriteEtcdProxy(ctx.NodeObject())
+	if err != nil {
+		return errors.Wrap(err, "write etcd proxy static pod")
+	}
 	err = os.MkdirAll(KUBEADM_CONFIG_DIR, 0755)
 	if err != nil {
 		return fmt.Errorf("mkdir %s error: %s", KUBEADM_CONFIG_DIR, err.Error())
@@ -235,6 +241,7 @@ func Config(node *v1.Master) (config string, err error) {
 		node,
 		WithNodeName,
 		WithEtcdEndpoints,
+		WithEtcdProxy,
 	)
 	t, err := template.New("kubeadm-config").Parse(tpl.Tplv1)
 	if err != nil {
@@ -0,0 +1,59 @@
+package tpl
+
+// EtcdProxy static pod of the local etcd grpc proxy. kube-apiserver
+// talks to the proxy on localhost, and the proxy balances across etcd
+// members and fails over when one of them goes down.
+var EtcdProxy = `
+apiVersion: v1
+kind: Pod
+metadata:
+  name: etcd-proxy
+  namespace: kube-system
+  labels:
+    component: etcd-proxy
+    tier: control-plane
+spec:
+  hostNetwork: true
+  priorityClassName: system-node-critical
+  containers:
+  - name: etcd-proxy
+    image: {{ .Image }}
+    command:
+    - etcd
+    - grpc-proxy
+    - start
+    - --endpoints={{ .Members }}
+    - --listen-addr={{ .Listen }}
+    - --cert=/var/lib/etcd/cert/client.crt
+    - --key=/var/lib/etcd/cert/client.key
+    - --cacert=/var/lib/etcd/cert/server-ca.crt
+    - --cert-file=/var/lib/etcd/cert/server.crt
+    - --key-file=/var/lib/etcd/cert/server.key
+    - --trusted-ca-file=/var/lib/etcd/cert/server-ca.crt
+    livenessProbe:
+      tcpSocket:
+        host: 127.0.0.1
+        port: {{ .Port }}
+      initialDelaySeconds: 15
+      periodSeconds: 10
+      failureThreshold: 8
+    resources:
+      requests:
+        cpu: 50m
+        memory: 64Mi
+    volumeMounts:
+    - name: etcd-cert
+      mountPath: /var/lib/etcd/cert
+      readOnly: true
+    - name: localtime
+      mountPath: /etc/localtime
+      readOnly: true
+  volumes:
+  - name: etcd-cert
+    hostPath:
+      path: /var/lib/etcd/cert
+      type: Directory
+  - name: localtime
+    hostPath:
+      path: /etc/localtime
+`
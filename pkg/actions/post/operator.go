@@ -303,5 +303,47 @@ spec:
             items:
               - key: bootcfg
                 path: boot.cfg
+---
+apiVersion: batch/v1beta1
+kind: CronJob
+metadata:
+  labels:
+    app: wdrip-drill
+  name: wdrip-drill
+  namespace: kube-system
+spec:
+  # restore the latest backup into a throwaway etcd weekly,
+  # result is shown in [wdrip get backup]
+  schedule: "30 3 * * 0"
+  concurrencyPolicy: Forbid
+  successfulJobsHistoryLimit: 1
+  failedJobsHistoryLimit: 3
+  jobTemplate:
+    spec:
+      backoffLimit: 1
+      activeDeadlineSeconds: 3600
+      template:
+        metadata:
+          labels:
+            app: wdrip-drill
+        spec:
+          hostNetwork: true
+          restartPolicy: Never
+          serviceAccount: admin
+          containers:
+            - image: {{ .Registry }}/wdrip:{{ .Version }}
+              imagePullPolicy: IfNotPresent
+              name: wdrip-drill
+              command:
+                - /wdrip
+                - backup
+                - drill
+                - --local
+          nodeSelector:
+            node-role.kubernetes.io/master: ""
+          tolerations:
+          - effect: NoSchedule
+            operator: Exists
+            key: node-role.kubernetes.io/master
 `
 )
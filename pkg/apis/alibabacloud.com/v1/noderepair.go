@@ -0,0 +1,116 @@
+package v1
+
+import (
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+const (
+	NodeRepairKind   = "NodeRepair"
+	NodeRepairName   = "noderepair"
+	NodeRepairPlural = "noderepairs"
+)
+
+// NodeRepair phases
+const (
+	NodeRepairRunning   = "Running"
+	NodeRepairSucceeded = "Succeeded"
+	NodeRepairFailed    = "Failed"
+)
+
+// RepairStep outcomes
+const (
+	RepairStepSucceeded = "Succeeded"
+	RepairStepFailed    = "Failed"
+	// RepairStepThrottled step not admitted yet, eg. instance created
+	// just now
+	RepairStepThrottled = "Throttled"
+	// RepairStepSkipped step skipped by annotation of the node
+	RepairStepSkipped = "Skipped"
+	// RepairStepDryRun step not taken in dry-run mode
+	RepairStepDryRun = "DryRun"
+)
+
+// +genclient
+// +genclient:nonNamespaced
+// +k8s:deepcopy-gen:interfaces=k8s.io/apimachinery/pkg/runtime.Object
+
+// NodeRepair record of one remediation of a node by the Healet, from the
+// first step taken until the node is ready again or all steps failed.
+// +kubebuilder:resource:path=noderepairs,scope=Cluster
+type NodeRepair struct {
+	metav1.TypeMeta   `json:",inline"`
+	metav1.ObjectMeta `json:"metadata,omitempty"`
+
+	Spec   NodeRepairSpec   `json:"spec,omitempty"`
+	Status NodeRepairStatus `json:"status,omitempty"`
+}
+
+// NodeRepairSpec the node repaired and why
+type NodeRepairSpec struct {
+	NodeName   string `json:"nodeName,omitempty" protobuf:"bytes,1,opt,name=nodeName"`
+	InstanceID string `json:"instanceID,omitempty" protobuf:"bytes,2,opt,name=instanceID"`
+	// Role etc. provider.WorkerUserdata
+	Role     string `json:"role,omitempty" protobuf:"bytes,3,opt,name=role"`
+	NodePool string `json:"nodePool,omitempty" protobuf:"bytes,4,opt,name=nodePool"`
+	// Policy name of the RemediationPolicy followed
+	Policy string `json:"policy,omitempty" protobuf:"bytes,5,opt,name=policy"`
+	// Symptom detected, eg. node NotReady
+	Symptom  string         `json:"symptom,omitempty" protobuf:"bytes,6,opt,name=symptom"`
+	Snapshot RepairSnapshot `json:"snapshot,omitempty" protobuf:"bytes,7,opt,name=snapshot"`
+	// Reason short form of Symptom, eg. NodeNotReady
+	Reason string `json:"reason,omitempty" protobuf:"bytes,8,opt,name=reason"`
+	// DryRun repair recorded in dry-run mode, no step is taken
+	DryRun bool `json:"dryRun,omitempty" protobuf:"bytes,9,opt,name=dryRun"`
+	// Signal health signal remediated, empty for node not ready
+	Signal string `json:"signal,omitempty" protobuf:"bytes,10,opt,name=signal"`
+}
+
+// RepairSnapshot state of instance, node and Master CR seen by the
+// Healet when the repair started
+type RepairSnapshot struct {
+	InstanceIP     string `json:"instanceIP,omitempty" protobuf:"bytes,1,opt,name=instanceIP"`
+	InstanceStatus string `json:"instanceStatus,omitempty" protobuf:"bytes,2,opt,name=instanceStatus"`
+	CreatedAt      string `json:"createdAt,omitempty" protobuf:"bytes,3,opt,name=createdAt"`
+
+	// NodeReady status of node Ready condition, empty when node
+	// object is missing
+	NodeReady     string       `json:"nodeReady,omitempty" protobuf:"bytes,4,opt,name=nodeReady"`
+	NodeReason    string       `json:"nodeReason,omitempty" protobuf:"bytes,5,opt,name=nodeReason"`
+	LastHeartbeat *metav1.Time `json:"lastHeartbeat,omitempty" protobuf:"bytes,6,opt,name=lastHeartbeat"`
+
+	// MasterCR name of the Master CR, masters only
+	MasterCR string `json:"masterCR,omitempty" protobuf:"bytes,7,opt,name=masterCR"`
+
+	// Instances Nodes MasterCRs counts of the Triple
+	Instances int `json:"instances,omitempty" protobuf:"bytes,8,opt,name=instances"`
+	Nodes     int `json:"nodes,omitempty" protobuf:"bytes,9,opt,name=nodes"`
+	MasterCRs int `json:"masterCRs,omitempty" protobuf:"bytes,10,opt,name=masterCRs"`
+}
+
+// NodeRepairStatus steps taken so far and the result
+type NodeRepairStatus struct {
+	Phase      string       `json:"phase,omitempty" protobuf:"bytes,1,opt,name=phase"`
+	Message    string       `json:"message,omitempty" protobuf:"bytes,2,opt,name=message"`
+	StartedAt  metav1.Time  `json:"startedAt,omitempty" protobuf:"bytes,3,opt,name=startedAt"`
+	FinishedAt *metav1.Time `json:"finishedAt,omitempty" protobuf:"bytes,4,opt,name=finishedAt"`
+	Steps      []RepairStep `json:"steps,omitempty" protobuf:"bytes,5,rep,name=steps"`
+}
+
+// RepairStep one attempt of a remediation step
+type RepairStep struct {
+	Action    string          `json:"action" protobuf:"bytes,1,opt,name=action"`
+	Attempt   int             `json:"attempt,omitempty" protobuf:"bytes,2,opt,name=attempt"`
+	StartedAt metav1.Time     `json:"startedAt,omitempty" protobuf:"bytes,3,opt,name=startedAt"`
+	Duration  metav1.Duration `json:"duration,omitempty" protobuf:"bytes,4,opt,name=duration"`
+	Outcome   string          `json:"outcome,omitempty" protobuf:"bytes,5,opt,name=outcome"`
+	Message   string          `json:"message,omitempty" protobuf:"bytes,6,opt,name=message"`
+}
+
+// +k8s:deepcopy-gen:interfaces=k8s.io/apimachinery/pkg/runtime.Object
+
+// NodeRepairList contains a list of NodeRepair
+type NodeRepairList struct {
+	metav1.TypeMeta `json:",inline"`
+	metav1.ListMeta `json:"metadata,omitempty"`
+	Items           []NodeRepair `json:"items"`
+}
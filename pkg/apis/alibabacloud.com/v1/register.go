@@ -71,6 +71,10 @@ func addKnownTypes(scheme *runtime.Scheme) error {
 		&ClusterList{},
 		&NodePool{},
 		&NodePoolList{},
+		&RemediationPolicy{},
+		&RemediationPolicyList{},
+		&NodeRepair{},
+		&NodeRepairList{},
 	)
 	metav1.AddToGroupVersion(scheme, SchemeGroupVersion)
 	return nil
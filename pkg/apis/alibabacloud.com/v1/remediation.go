@@ -0,0 +1,179 @@
+package v1
+
+import (
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+const (
+	RemediationPolicyKind   = "RemediationPolicy"
+	RemediationPolicyName   = "remediationpolicy"
+	RemediationPolicyPlural = "remediationpolicies"
+)
+
+// remediation actions
+const (
+	// RemediationRestartService restart a systemd service over RunCommand
+	RemediationRestartService = "RestartService"
+	// RemediationReboot restart the instance
+	RemediationReboot = "Reboot"
+	// RemediationReimage replace the system disk and re-join the node
+	RemediationReimage = "Reimage"
+	// RemediationReplace replace the instance with a new one
+	RemediationReplace = "Replace"
+)
+
+// built-in health signals, see RemediationSignal
+const (
+	// SignalKernelDeadlock SignalReadonlyFilesystem conditions reported
+	// by node-problem-detector
+	SignalKernelDeadlock     = "KernelDeadlock"
+	SignalReadonlyFilesystem = "ReadonlyFilesystem"
+	// SignalDiskPressure SignalPIDPressure conditions reported by kubelet
+	SignalDiskPressure = "DiskPressure"
+	SignalPIDPressure  = "PIDPressure"
+	// SignalContainerRuntimeUnhealthy condition reported by
+	// node-problem-detector, or node not ready for container runtime down
+	// or PLEG not healthy
+	SignalContainerRuntimeUnhealthy = "ContainerRuntimeUnhealthy"
+	// SignalKubeletCertificateExpiry kubelet serving certificate expires
+	// within For
+	SignalKubeletCertificateExpiry = "KubeletCertificateExpiry"
+)
+
+// roles selected by RemediationSelector
+const (
+	RemediationRoleMaster = "master"
+	RemediationRoleWorker = "worker"
+)
+
+const (
+	// ConditionRemediationCircuitOpen condition of Cluster which tells
+	// whether remediation of workers is stopped by the circuit breaker
+	ConditionRemediationCircuitOpen = "RemediationCircuitOpen"
+
+	// RemediationCircuitResetAnnotation annotate Cluster to reset the
+	// circuit breaker, the annotation is removed once reset
+	RemediationCircuitResetAnnotation = "alibabacloud.com/remediation-circuit-reset"
+)
+
+// maintenance annotations honored by every heal path of the Healet
+const (
+	// HealDryRunAnnotation annotate Cluster with "true" to have the Healet
+	// log and record what it would do without acting
+	HealDryRunAnnotation = "alibabacloud.com/heal-dry-run"
+
+	// HealPauseUntilAnnotation annotate Node or Master CR to pause heal of
+	// the node until the RFC3339 time, any other value pauses it until the
+	// annotation is removed
+	HealPauseUntilAnnotation = "alibabacloud.com/heal-pause-until"
+
+	// HealSkipReimageAnnotation annotate Node or Master CR with "true" to
+	// skip the Reimage and Replace steps of the node
+	HealSkipReimageAnnotation = "alibabacloud.com/heal-skip-reimage"
+)
+
+// CircuitBreaker stops disruptive remediation of workers while too many
+// nodes of a nodepool or zone are unhealthy at once, or while remediation
+// keeps failing. zero fields take defaults.
+type CircuitBreaker struct {
+	// Disabled never open the circuit
+	Disabled bool `json:"disabled,omitempty" protobuf:"bytes,1,opt,name=disabled"`
+	// MaxUnhealthy nodes of a nodepool or zone unhealthy at once
+	MaxUnhealthy int `json:"maxUnhealthy,omitempty" protobuf:"bytes,2,opt,name=maxUnhealthy"`
+	// MaxUnhealthyPercent of nodes of a nodepool or zone unhealthy at
+	// once, checked when at least two nodes are unhealthy
+	MaxUnhealthyPercent int `json:"maxUnhealthyPercent,omitempty" protobuf:"bytes,3,opt,name=maxUnhealthyPercent"`
+	// MaxFailures failed remediation steps within FailureWindow
+	MaxFailures   int             `json:"maxFailures,omitempty" protobuf:"bytes,4,opt,name=maxFailures"`
+	FailureWindow metav1.Duration `json:"failureWindow,omitempty" protobuf:"bytes,5,opt,name=failureWindow"`
+}
+
+// +genclient
+// +genclient:nonNamespaced
+// +k8s:deepcopy-gen:interfaces=k8s.io/apimachinery/pkg/runtime.Object
+
+// RemediationPolicy how the Healet repairs the unhealthy nodes it
+// selects. when more than one policy selects a node, the one of the
+// highest priority wins.
+// +kubebuilder:resource:path=remediationpolicies,scope=Cluster
+type RemediationPolicy struct {
+	metav1.TypeMeta   `json:",inline"`
+	metav1.ObjectMeta `json:"metadata,omitempty"`
+
+	Spec RemediationPolicySpec `json:"spec,omitempty"`
+}
+
+// RemediationPolicySpec defines the remediation ladder of selected nodes
+type RemediationPolicySpec struct {
+	Selector RemediationSelector `json:"selector,omitempty" protobuf:"bytes,1,opt,name=selector"`
+	Priority int                 `json:"priority,omitempty" protobuf:"bytes,2,opt,name=priority"`
+
+	// Steps tried in order. a step is retried up to its MaxAttempts
+	// before the next one is tried.
+	Steps    []RemediationStep   `json:"steps,omitempty" protobuf:"bytes,3,rep,name=steps"`
+	Throttle RemediationThrottle `json:"throttle,omitempty" protobuf:"bytes,4,opt,name=throttle"`
+
+	// Signals health signals of ready nodes remediated besides not ready,
+	// the first one firing wins
+	Signals []RemediationSignal `json:"signals,omitempty" protobuf:"bytes,5,rep,name=signals"`
+}
+
+// RemediationSelector selects nodes, empty field matches all
+type RemediationSelector struct {
+	// Role master or worker
+	Role string `json:"role,omitempty" protobuf:"bytes,1,opt,name=role"`
+	// NodePools names of nodepool, workers only
+	NodePools []string `json:"nodePools,omitempty" protobuf:"bytes,2,rep,name=nodePools"`
+	// Labels node label selector, node without node object matches
+	// only an empty selector
+	Labels *metav1.LabelSelector `json:"labels,omitempty" protobuf:"bytes,3,opt,name=labels"`
+}
+
+// RemediationSignal health signal of a node besides Ready, remediated with
+// Steps once it persists for For
+type RemediationSignal struct {
+	// Type one of the built-in signals, or any node condition type
+	Type string `json:"type" protobuf:"bytes,1,opt,name=type"`
+	// Status of the node condition, True by default
+	Status string `json:"status,omitempty" protobuf:"bytes,2,opt,name=status"`
+	// For the condition persists before remediation, 10m for disk and PID
+	// pressure. for KubeletCertificateExpiry the certificate expires
+	// within, 7 days by default
+	For metav1.Duration `json:"for,omitempty" protobuf:"bytes,3,opt,name=for"`
+	// Steps remediation of the signal, the steps of the policy by default
+	Steps []RemediationStep `json:"steps,omitempty" protobuf:"bytes,4,rep,name=steps"`
+}
+
+// RemediationStep one step of the remediation ladder
+type RemediationStep struct {
+	// Action RestartService, Reboot, Reimage or Replace
+	Action string `json:"action" protobuf:"bytes,1,opt,name=action"`
+	// Service restarted by RestartService, kubelet by default
+	Service string `json:"service,omitempty" protobuf:"bytes,2,opt,name=service"`
+	// Timeout wait for node ready after action
+	Timeout metav1.Duration `json:"timeout,omitempty" protobuf:"bytes,3,opt,name=timeout"`
+	// Backoff wait between two attempts of this step
+	Backoff metav1.Duration `json:"backoff,omitempty" protobuf:"bytes,4,opt,name=backoff"`
+	// MaxAttempts of this step, 0 means no limit
+	MaxAttempts int `json:"maxAttempts,omitempty" protobuf:"bytes,5,opt,name=maxAttempts"`
+}
+
+// RemediationThrottle admission of disruptive steps, Reimage and Replace
+type RemediationThrottle struct {
+	// CreateGrace instance created within is left to finish joining
+	CreateGrace metav1.Duration `json:"createGrace,omitempty" protobuf:"bytes,1,opt,name=createGrace"`
+	// InstanceInterval minimal interval between two disruptive steps
+	// on the same instance
+	InstanceInterval metav1.Duration `json:"instanceInterval,omitempty" protobuf:"bytes,2,opt,name=instanceInterval"`
+	// NotReadyFor node heartbeat must be missing this long
+	NotReadyFor metav1.Duration `json:"notReadyFor,omitempty" protobuf:"bytes,3,opt,name=notReadyFor"`
+}
+
+// +k8s:deepcopy-gen:interfaces=k8s.io/apimachinery/pkg/runtime.Object
+
+// RemediationPolicyList contains a list of RemediationPolicy
+type RemediationPolicyList struct {
+	metav1.TypeMeta `json:",inline"`
+	metav1.ListMeta `json:"metadata,omitempty"`
+	Items           []RemediationPolicy `json:"items"`
+}
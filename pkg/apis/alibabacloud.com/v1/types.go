@@ -196,6 +196,39 @@ type CommandLineArgs struct {
 	InstanceID string
 	Command    string
 	NodePoolID string
+
+	// BackupName identity or name of etcd backup
+	BackupName string
+	// Local take backup on current node
+	Local bool
+	Unpin bool
+
+	// Resources backup api objects instead of etcd snapshot
+	Resources bool
+	// Namespaces Kinds Selector select api objects to backup or restore
+	Namespaces []string
+	Kinds      []string
+	Selector   string
+	// ToCluster restore api objects into, default the backup source cluster
+	ToCluster string
+	DryRun    bool
+
+	// Revision of ClusterId history
+	Revision int64
+
+	// Limit max items of a listing page, 0 means all
+	Limit int
+	// Continue token returned by the last page to list next page
+	Continue string
+
+	// Version target version to upgrade to
+	Version string
+	// Prepare check and backup before upgrade instead of upgrading
+	Prepare bool
+
+	// Kubeconfig path of kubeconfig to access the cluster, default an
+	// admin kubeconfig signed with cluster root ca
+	Kubeconfig string
 }
 
 type WdripOptions struct {
@@ -213,7 +246,11 @@ type WdripOptions struct {
 	// TargetCount scale target nodes count
 	TargetCount int
 	RecoverFrom string
-	ClusterName string
+	// RecoverBackup backup identity or name to recover from
+	RecoverBackup string
+	// RecoverBefore recover from the latest backup created before this time
+	RecoverBefore string
+	ClusterName   string
 
 	// Default is an important data structure which contains Context config
 	Default *ContextCFG
@@ -255,6 +292,8 @@ type Cluster struct {
 
 type ClusterStatus struct {
 	Peers []Host `json:"peers,omitempty" protobuf:"bytes,1,opt,name=peers"`
+	// Conditions eg. IndexSynced
+	Conditions []metav1.Condition `json:"conditions,omitempty" protobuf:"bytes,2,rep,name=conditions"`
 }
 
 type ClusterSpec struct {
@@ -274,6 +313,9 @@ type ClusterSpec struct {
 	Registry         string   `json:"registry,omitempty" protobuf:"bytes,11,opt,name=registry"`
 	Endpoint         Endpoint `json:"endpoint,omitempty" protobuf:"bytes,12,opt,name=endpoint"`
 	SilentTime       int      `json:"silentTime,omitempty" protobuf:"bytes,12,opt,name=silentTime"`
+
+	// CircuitBreaker of automated remediation
+	CircuitBreaker CircuitBreaker `json:"circuitBreaker,omitempty" protobuf:"bytes,14,opt,name=circuitBreaker"`
 }
 
 type Kubernetes struct {
@@ -434,6 +476,12 @@ type NodePoolSpec struct {
 	NodePoolID string `json:"id,omitempty" protobuf:"bytes,1,opt,name=id"`
 	AutoHeal   bool   `json:"autoHeal,omitempty" protobuf:"bytes,2,opt,name=autoHeal"`
 	Infra      Infra  `json:"infra,omitempty" protobuf:"bytes,3,opt,name=infra"`
+
+	// RemediationMode how the Healet repairs broken workers of the
+	// nodepool once restarting kubelet does not help, Reimage the system
+	// disk or Replace the instance through the scaling group. Reimage by
+	// default, a RemediationPolicy selecting the nodepool takes precedence.
+	RemediationMode string `json:"remediationMode,omitempty" protobuf:"bytes,4,opt,name=remediationMode"`
 }
 
 type Config struct {
@@ -471,6 +519,9 @@ type NodePoolStatus struct {
 	// INSERT ADDITIONAL STATUS FIELD - define observed state of cluster
 	// Important: Run "operator-sdk generate k8s" to regenerate code after modifying this file
 	// Add custom validation using kubebuilder tags: https://book-v1.book.kubebuilder.io/beyond_basics/generating_crd.html
+
+	// Conditions eg. IndexSynced
+	Conditions []metav1.Condition `json:"conditions,omitempty" protobuf:"bytes,1,rep,name=conditions"`
 }
 
 // +k8s:deepcopy-gen:interfaces=k8s.io/apimachinery/pkg/runtime.Object
@@ -498,6 +549,16 @@ type NodePoolList struct {
 const (
 	NodePoolHashLabel = "alibabacloud.com/nodepool.hash"
 	NodePoolIDLabel   = "alibabacloud.com/nodepool-id"
+
+	// IndexSyncedHashAnnotation hash of the spec last synced between
+	// the in-cluster object and its copy in the index
+	IndexSyncedHashAnnotation = "alibabacloud.com/index-synced-hash"
+	// IndexGenerationAnnotation generation of the index copy last synced
+	IndexGenerationAnnotation = "alibabacloud.com/index-generation"
+
+	// ConditionIndexSynced condition of Cluster and NodePool which tells
+	// whether the object is in sync with its copy in the index
+	ConditionIndexSynced = "IndexSynced"
 )
 
 type ConfigTpl struct {
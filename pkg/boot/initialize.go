@@ -267,8 +267,12 @@ func InitFromRecover(ctx *context.NodeContext) error {
 		return errors.Wrapf(err, "no cluster found by name %s", opts.ClusterName)
 	}
 
+	before, err := index.ParseBackupTime(opts.RecoverBefore)
+	if err != nil {
+		return errors.Wrap(err, "parse recover point in time")
+	}
 	mindex := index.NewGenericIndexer(opts.RecoverFrom, pctx.Provider())
-	from, err := mindex.LatestBackup(index.SnapshotTMP)
+	from, err := mindex.DownloadBackup(index.SnapshotTMP, opts.RecoverBackup, before)
 	if err != nil {
 		return errors.Wrap(err, "download backup db file")
 	}
@@ -0,0 +1,305 @@
+package iaas
+
+import (
+	"fmt"
+	"github.com/aoxn/wdrip/pkg/actions/etcd"
+	"github.com/aoxn/wdrip/pkg/apis/alibabacloud.com/v1"
+	pd "github.com/aoxn/wdrip/pkg/iaas/provider"
+	"github.com/aoxn/wdrip/pkg/iaas/provider/alibaba"
+	"github.com/aoxn/wdrip/pkg/index"
+	"github.com/aoxn/wdrip/pkg/operator/controllers/backup"
+	h "github.com/aoxn/wdrip/pkg/operator/controllers/help"
+	"github.com/aoxn/wdrip/pkg/operator/monit"
+	"github.com/aoxn/wdrip/pkg/utils"
+	"github.com/aoxn/wdrip/pkg/utils/log"
+	"github.com/pkg/errors"
+	"io/ioutil"
+	"k8s.io/klog/v2"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// CreateBackup take an on-demand etcd backup.
+// with cmdLine.Local, the snapshot is taken from current node which
+// must be able to reach the apiserver and etcd, e.g. on master. otherwise
+// pick up a running master and run `wdrip backup create --local` on it.
+func CreateBackup(options *v1.WdripOptions, cmdLine *v1.CommandLineArgs) error {
+	if cmdLine.BackupName == "" {
+		return fmt.Errorf("backup name must be specified over [--name xxx]")
+	}
+	if err := index.ValidateBackupName(cmdLine.BackupName); err != nil {
+		return err
+	}
+	if cmdLine.Resources {
+		return createResourceBackup(options, cmdLine)
+	}
+	if cmdLine.Local {
+		return createLocalBackup(cmdLine.BackupName)
+	}
+	if options.ClusterName == "" {
+		return fmt.Errorf("cluster name must be specified over [-c xxx]")
+	}
+	ctx, err := pd.NewContext(options, nil)
+	if err != nil {
+		return errors.Wrapf(err, "initialize wdrip context")
+	}
+	idx := index.NewGenericIndexer(options.ClusterName, ctx.Provider())
+	backups, err := idx.Snapshot()
+	if err != nil {
+		return errors.Wrapf(err, "backup: get snapshot")
+	}
+	if backups.FindBackup(cmdLine.BackupName) != nil {
+		return fmt.Errorf("backup named [%s] already exists", cmdLine.BackupName)
+	}
+	id, err := idx.GetCluster(options.ClusterName)
+	if err != nil {
+		return errors.Wrapf(err, "get cluster: %s", options.ClusterName)
+	}
+	stack, err := h.LoadStackFromSpec(ctx.Provider(), ctx, &id.Spec.Cluster)
+	if err != nil {
+		return errors.Wrapf(err, "load stack")
+	}
+	ctx.WithStack(stack)
+	detail, err := ctx.Provider().ScalingGroupDetail(
+		ctx, "", pd.Option{Action: alibaba.ActionInstanceIDS},
+	)
+	if err != nil {
+		return errors.Wrapf(err, "find master instance")
+	}
+	command := fmt.Sprintf(
+		"KUBECONFIG=%s /usr/local/bin/wdrip backup create --local --name %s",
+		quote(utils.AUTH_FILE), quote(cmdLine.BackupName),
+	)
+	for _, ins := range detail.Instances {
+		if ins.Status != "Running" {
+			continue
+		}
+		klog.Infof("trying to backup etcd on master [%s]", ins.Id)
+		err = runOnMaster(ctx, ins.Id, command)
+		if err != nil {
+			klog.Warningf("backup on master [%s] failed, try next: %s", ins.Id, err.Error())
+			continue
+		}
+		klog.Infof("backup on master [%s] finished", ins.Id)
+		return nil
+	}
+	return fmt.Errorf("no running master available to take backup: %d", len(detail.Instances))
+}
+
+func createLocalBackup(name string) error {
+	restc, err := monit.NewClusterCtl()
+	if err != nil {
+		return errors.Wrapf(err, "new cluster client")
+	}
+	spec, masters, err := monit.GetSpec(restc.GetClient())
+	if err != nil {
+		return errors.Wrapf(err, "get cluster spec")
+	}
+	ctx, err := pd.NewContext(&v1.WdripOptions{}, &spec.Spec)
+	if err != nil {
+		return errors.Wrapf(err, "new provider context")
+	}
+	idx := index.NewGenericIndexer(spec.Spec.ClusterID, ctx.Provider())
+	err = backup.NewBareSnapshot(idx).BackupWithName(spec, masters, name)
+	if err != nil {
+		return errors.Wrapf(err, "backup etcd")
+	}
+	klog.Infof("backup [%s] finished", name)
+	return nil
+}
+
+func ListBackups(options *v1.WdripOptions, cmdLine *v1.CommandLineArgs) error {
+	return doGetBuckups(options, cmdLine)
+}
+
+func DescribeBackup(options *v1.WdripOptions, cmdLine *v1.CommandLineArgs) error {
+	idx, err := backupIndex(options, cmdLine)
+	if err != nil {
+		return err
+	}
+	backups, err := idx.Snapshot()
+	if err != nil {
+		return errors.Wrapf(err, "backup: get snapshot")
+	}
+	b, err := idx.GetBackup(cmdLine.BackupName)
+	if err != nil {
+		return err
+	}
+	switch cmdLine.OutPutFormat {
+	case "yaml":
+		fmt.Printf(utils.PrettyYaml(b))
+	case "json":
+		fmt.Printf(utils.PrettyJson(b))
+	default:
+		fmt.Printf("%-12s%s\n", "Cluster:", backups.Name)
+		fmt.Printf("%-12s%s\n", "Identity:", b.Identity)
+		fmt.Printf("%-12s%s\n", "Name:", b.Name)
+		fmt.Printf("%-12s%s\n", "Type:", backupType(b))
+		fmt.Printf("%-12s%t\n", "Pinned:", b.Pinned)
+		fmt.Printf("%-12s%s\n", "CreatedAt:", b.CreatedAt)
+		fmt.Printf("%-12s%s\n", "Path:", backups.Path(*b))
+		if b.Source != nil {
+			fmt.Printf("%-12smember %s [%s], leader=%t\n", "Source:", b.Source.Member, b.Source.Endpoint, b.Source.Leader)
+			fmt.Printf("%-12s%d\n", "Revision:", b.Source.Revision)
+		}
+		fmt.Printf("%-12s%s\n", "VerifiedAt:", verifiedAt(b))
+		if b.Drill != nil {
+			fmt.Printf("%-12s%d\n", "Keys:", b.Drill.Keys)
+			if b.Drill.Message != "" {
+				fmt.Printf("%-12s%s at %s\n", "DrillError:", b.Drill.Message, b.Drill.VerifiedAt)
+			}
+		}
+	}
+	return nil
+}
+
+func DeleteBackup(options *v1.WdripOptions, cmdLine *v1.CommandLineArgs) error {
+	idx, err := backupIndex(options, cmdLine)
+	if err != nil {
+		return err
+	}
+	err = idx.RemoveBackup(cmdLine.BackupName)
+	if err != nil {
+		return errors.Wrapf(err, "delete backup: %s", cmdLine.BackupName)
+	}
+	klog.Infof("backup [%s] deleted", cmdLine.BackupName)
+	return nil
+}
+
+func PinBackup(options *v1.WdripOptions, cmdLine *v1.CommandLineArgs) error {
+	idx, err := backupIndex(options, cmdLine)
+	if err != nil {
+		return err
+	}
+	err = idx.PinBackup(cmdLine.BackupName, !cmdLine.Unpin)
+	if err != nil {
+		return errors.Wrapf(err, "pin backup: %s", cmdLine.BackupName)
+	}
+	klog.Infof("backup [%s] pinned=%t", cmdLine.BackupName, !cmdLine.Unpin)
+	return nil
+}
+
+// VerifyReplicas repair cluster index and backups on replica buckets
+func VerifyReplicas(options *v1.WdripOptions, cmdLine *v1.CommandLineArgs) error {
+	if options.ClusterName == "" {
+		return fmt.Errorf("cluster name must be specified over [-c xxx]")
+	}
+	ctx, err := pd.NewContext(options, nil)
+	if err != nil {
+		return errors.Wrapf(err, "initialize wdrip context")
+	}
+	bar := log.NewPgmbar("", nil)
+	idx := index.NewGenericIndexer(options.ClusterName, ctx.Provider())
+	idx.WithProgress(func(key string) pd.Progress { return bar.Transfer(key) })
+	err = idx.VerifyReplicas()
+	if err != nil {
+		bar.Finish("FAILED")
+		return err
+	}
+	bar.Finish(log.SUCCESS)
+	klog.Infof("replicas of [%s] verified", options.ClusterName)
+	return nil
+}
+
+// DrillBackup restore the backup selected by cmdLine.BackupName, the
+// latest etcd backup by default, into a throwaway etcd and record the
+// result next to the backup. with cmdLine.Local, the cluster is found
+// from the in-cluster Cluster object, which is how the CronJob runs.
+func DrillBackup(options *v1.WdripOptions, cmdLine *v1.CommandLineArgs) error {
+	var (
+		name string
+		ctx  *pd.Context
+		err  error
+	)
+	if cmdLine.Local {
+		restc, err := monit.NewClusterCtl()
+		if err != nil {
+			return errors.Wrapf(err, "new cluster client")
+		}
+		spec, _, err := monit.GetSpec(restc.GetClient())
+		if err != nil {
+			return errors.Wrapf(err, "get cluster spec")
+		}
+		name = spec.Spec.ClusterID
+		ctx, err = pd.NewContext(&v1.WdripOptions{}, &spec.Spec)
+		if err != nil {
+			return errors.Wrapf(err, "new provider context")
+		}
+	} else {
+		if options.ClusterName == "" {
+			return fmt.Errorf("cluster name must be specified over [-c xxx]")
+		}
+		name = options.ClusterName
+		ctx, err = pd.NewContext(options, nil)
+		if err != nil {
+			return errors.Wrapf(err, "initialize wdrip context")
+		}
+	}
+	idx := index.NewGenericIndexer(name, ctx.Provider())
+	b, err := idx.SelectBackup(cmdLine.BackupName, time.Time{})
+	if err != nil {
+		return errors.Wrapf(err, "select backup")
+	}
+	dir, err := ioutil.TempDir("", "wdrip-drill-snapshot-")
+	if err != nil {
+		return errors.Wrapf(err, "make temp dir")
+	}
+	defer os.RemoveAll(dir)
+
+	klog.Infof("drill: restore backup [%s] of %s", b.Identity, name)
+	snapshot := filepath.Join(dir, "snapshot.db")
+	_, err = idx.DownloadBackup(snapshot, b.Identity, time.Time{})
+	if err != nil {
+		// unreachable backup is not a verdict on the backup itself
+		return errors.Wrapf(err, "download backup: %s", b.Identity)
+	}
+	var keys int64
+	result, derr := etcd.Drill(snapshot, etcd.Sentinels)
+	if result != nil {
+		keys = result.Keys
+	}
+	err = idx.RecordDrill(b.Identity, index.NewDrill(keys, derr))
+	if err != nil {
+		klog.Warningf("record drill result of [%s]: %s", b.Identity, err.Error())
+	}
+	if derr != nil {
+		return errors.Wrapf(derr, "drill backup [%s] FAILED", b.Identity)
+	}
+	klog.Infof("drill backup [%s] passed, %d keys restored", b.Identity, keys)
+	return nil
+}
+
+// verifiedAt drill status of backup for display
+func verifiedAt(b *index.Backup) string {
+	switch {
+	case b.Drill == nil:
+		return "-"
+	case !b.Drill.Passed:
+		return "FAILED"
+	}
+	return b.VerifiedAt()
+}
+
+func backupType(b *index.Backup) string {
+	if b.Type == "" {
+		return "etcd"
+	}
+	return b.Type
+}
+
+func backupIndex(
+	options *v1.WdripOptions, cmdLine *v1.CommandLineArgs,
+) (*index.GenericIndexer, error) {
+	if options.ClusterName == "" {
+		return nil, fmt.Errorf("cluster name must be specified over [-c xxx]")
+	}
+	if cmdLine.BackupName == "" {
+		return nil, fmt.Errorf("backup identity or name must be specified")
+	}
+	ctx, err := pd.NewContext(options, nil)
+	if err != nil {
+		return nil, errors.Wrapf(err, "initialize wdrip context")
+	}
+	return index.NewGenericIndexer(options.ClusterName, ctx.Provider()), nil
+}
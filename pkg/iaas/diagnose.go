@@ -0,0 +1,126 @@
+package iaas
+
+import (
+	"fmt"
+	"github.com/aoxn/wdrip/pkg/actions/etcd"
+	"github.com/aoxn/wdrip/pkg/apis/alibabacloud.com/v1"
+	pd "github.com/aoxn/wdrip/pkg/iaas/provider"
+	"github.com/aoxn/wdrip/pkg/index"
+	h "github.com/aoxn/wdrip/pkg/operator/controllers/help"
+	"github.com/aoxn/wdrip/pkg/operator/heal"
+	"github.com/aoxn/wdrip/pkg/utils"
+	"github.com/pkg/errors"
+	"k8s.io/apimachinery/pkg/runtime"
+	clientgoscheme "k8s.io/client-go/kubernetes/scheme"
+	"k8s.io/client-go/rest"
+	"k8s.io/client-go/tools/clientcmd"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"strings"
+)
+
+// Diagnose print the Healet view of every node of cluster: the cloud
+// instance, Node, Master CR and etcd member of it, the inconsistencies
+// found and the action the Healet would take. nothing is changed.
+func Diagnose(options *v1.WdripOptions, cmdLine *v1.CommandLineArgs) error {
+	if options.ClusterName == "" {
+		return fmt.Errorf("cluster name must be specified over [wdrip diagnose xxx]")
+	}
+	ctx, err := pd.NewContext(options, nil)
+	if err != nil {
+		return errors.Wrapf(err, "initialize wdrip context")
+	}
+	idx := index.NewGenericIndexer(options.ClusterName, ctx.Provider())
+	id, err := idx.GetCluster(options.ClusterName)
+	if err != nil {
+		return errors.Wrapf(err, "get cluster: %s", options.ClusterName)
+	}
+	stack, err := h.LoadStackFromSpec(ctx.Provider(), ctx, &id.Spec.Cluster)
+	if err != nil {
+		return errors.Wrapf(err, "load stack")
+	}
+	ctx.WithStack(stack)
+
+	cfg, err := diagnoseRestConfig(&id, cmdLine.Kubeconfig)
+	if err != nil {
+		return err
+	}
+	scheme := runtime.NewScheme()
+	if err := clientgoscheme.AddToScheme(scheme); err != nil {
+		return errors.Wrapf(err, "add client-go scheme")
+	}
+	if err := v1.AddToScheme(scheme); err != nil {
+		return errors.Wrapf(err, "add wdrip scheme")
+	}
+	mclient, err := client.New(cfg, client.Options{Scheme: scheme})
+	if err != nil {
+		return errors.Wrapf(err, "new kubernetes client")
+	}
+	infra, err := heal.NewInfraManager(&v1.Cluster{Spec: id.Spec.Cluster}, ctx.Provider())
+	if err != nil {
+		return errors.Wrapf(err, "new infra manager")
+	}
+	members := func(masters []v1.Master, cluster *v1.Cluster) ([]etcd.Member, error) {
+		metcd, err := etcd.NewEtcdFromSpec(masters, cluster)
+		if err != nil {
+			return nil, errors.Wrapf(err, "new etcd")
+		}
+		mems, err := metcd.MemberList()
+		return mems.Members, err
+	}
+	report, err := heal.NewDiagnoser(
+		heal.NewTripleGetter(infra, mclient), mclient, members,
+	).Diagnose()
+	if err != nil {
+		return errors.Wrapf(err, "diagnose cluster %s", options.ClusterName)
+	}
+	switch cmdLine.OutPutFormat {
+	case "yaml":
+		fmt.Printf(utils.PrettyYaml(report))
+	case "json":
+		fmt.Printf(utils.PrettyJson(report))
+	default:
+		printDiagnosis(report)
+	}
+	return nil
+}
+
+// diagnoseRestConfig load kubeconfig from path, or sign an admin
+// kubeconfig with cluster root ca in index when path is empty
+func diagnoseRestConfig(id *v1.ClusterId, path string) (*rest.Config, error) {
+	if path != "" {
+		cfg, err := clientcmd.BuildConfigFromFlags("", path)
+		return cfg, errors.Wrapf(err, "load kubeconfig %s", path)
+	}
+	data, err := adminKubeConfig(id)
+	if err != nil {
+		return nil, errors.Wrapf(err, "build kubeconfig for %s", id.Name)
+	}
+	cfg, err := clientcmd.RESTConfigFromKubeConfig([]byte(data))
+	return cfg, errors.Wrapf(err, "load kubeconfig for %s", id.Name)
+}
+
+func printDiagnosis(report *heal.Report) {
+	if report.DryRun {
+		fmt.Printf("Healet is in dry-run mode, no action is taken\n")
+	}
+	if report.Circuit != "" {
+		fmt.Printf("remediation circuit is open: %s\n", report.Circuit)
+	}
+	for _, e := range report.Errors {
+		fmt.Printf("WARNING: %s\n", e)
+	}
+	fmt.Printf("%-8s%-16s%-26s%-16s%-10s%-40s%-8s%-10s%-36s%s\n",
+		"ROLE", "POOL", "INSTANCE", "IP", "STATUS", "NODE", "READY", "ETCD", "PROBLEMS", "ACTION")
+	for _, n := range report.Nodes {
+		fmt.Printf("%-8s%-16s%-26s%-16s%-10s%-40s%-8s%-10s%-36s%s\n",
+			n.Role, column(n.NodePool), column(n.Instance), column(n.IP), column(n.Status),
+			column(n.Node), column(n.Ready), column(n.Etcd), column(strings.Join(n.Problems, ",")), n.Action)
+	}
+}
+
+func column(v string) string {
+	if v == "" {
+		return "-"
+	}
+	return v
+}
@@ -0,0 +1,80 @@
+package iaas
+
+import (
+	"fmt"
+	"github.com/aoxn/wdrip/pkg/apis/alibabacloud.com/v1"
+	pd "github.com/aoxn/wdrip/pkg/iaas/provider"
+	"github.com/aoxn/wdrip/pkg/index"
+	"github.com/aoxn/wdrip/pkg/utils"
+	"github.com/pkg/errors"
+	"k8s.io/klog/v2"
+)
+
+// History print revision history of ClusterId, or the detail
+// of one revision with cmdLine.Revision.
+func History(options *v1.WdripOptions, cmdLine *v1.CommandLineArgs) error {
+	if options.ClusterName == "" {
+		return fmt.Errorf("cluster name must be specified")
+	}
+	ctx, err := pd.NewContext(options, nil)
+	if err != nil {
+		return errors.Wrapf(err, "initialize wdrip context")
+	}
+	idx := index.NewGenericIndexer(options.ClusterName, ctx.Provider())
+	history, err := idx.History(options.ClusterName)
+	if err != nil {
+		return errors.Wrapf(err, "get history: %s", options.ClusterName)
+	}
+	if cmdLine.Revision != 0 {
+		rev := history.FindRevision(cmdLine.Revision)
+		if rev == nil {
+			return fmt.Errorf("revision %d not found in history of %s", cmdLine.Revision, options.ClusterName)
+		}
+		switch cmdLine.OutPutFormat {
+		case "yaml":
+			fmt.Printf(utils.PrettyYaml(rev))
+		case "json":
+			fmt.Printf(utils.PrettyJson(rev))
+		default:
+			fmt.Printf("%-12s%d\n", "Revision:", rev.Revision)
+			fmt.Printf("%-12s%s\n", "Author:", rev.Author)
+			fmt.Printf("%-12s%s\n", "Timestamp:", rev.Timestamp)
+			fmt.Printf("%-12s%s\n", "Command:", rev.Command)
+			fmt.Printf("Diff:\n%s\n", rev.Diff)
+		}
+		return nil
+	}
+	switch cmdLine.OutPutFormat {
+	case "yaml":
+		fmt.Printf(utils.PrettyYaml(history))
+	case "json":
+		fmt.Printf(utils.PrettyJson(history))
+	default:
+		fmt.Printf("%-10s%-30s%-24s%-80s\n", "REVISION", "AUTHOR", "TIMESTAMP", "COMMAND")
+		for _, rev := range history.Revisions {
+			fmt.Printf("%-10d%-30s%-24s%-80s\n", rev.Revision, rev.Author, rev.Timestamp, rev.Command)
+		}
+	}
+	return nil
+}
+
+// Rollback restore ClusterId spec to a previous revision
+func Rollback(options *v1.WdripOptions, cmdLine *v1.CommandLineArgs) error {
+	if options.ClusterName == "" {
+		return fmt.Errorf("cluster name must be specified")
+	}
+	if cmdLine.Revision <= 0 {
+		return fmt.Errorf("revision must be specified over [--revision N], see [wdrip history %s]", options.ClusterName)
+	}
+	ctx, err := pd.NewContext(options, nil)
+	if err != nil {
+		return errors.Wrapf(err, "initialize wdrip context")
+	}
+	idx := index.NewGenericIndexer(options.ClusterName, ctx.Provider())
+	err = idx.Rollback(options.ClusterName, cmdLine.Revision)
+	if err != nil {
+		return errors.Wrapf(err, "rollback %s", options.ClusterName)
+	}
+	klog.Infof("cluster [%s] rolled back to revision %d", options.ClusterName, cmdLine.Revision)
+	return nil
+}
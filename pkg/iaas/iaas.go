@@ -20,6 +20,7 @@ import (
 	"k8s.io/kubectl/pkg/cmd/util/editor"
 	"os"
 	"path/filepath"
+	"reflect"
 	"strings"
 	"time"
 )
@@ -50,6 +51,19 @@ func Recover(cfg *v1.WdripOptions) error {
 		// set back
 		id.Spec.Cluster = from.Spec.Cluster
 	}
+	before, err := index.ParseBackupTime(cfg.RecoverBefore)
+	if err != nil {
+		return errors.Wrapf(err, "parse recover point in time")
+	}
+	// validate backup before any infrastructure change, and resolve it
+	// to identity so that the recovering master restore the same copy.
+	backup, err := index.NewGenericIndexer(cfg.RecoverFrom, ctx.Provider()).SelectBackup(cfg.RecoverBackup, before)
+	if err != nil {
+		return errors.Wrapf(err, "select backup from cluster %s", cfg.RecoverFrom)
+	}
+	klog.Infof("recover [%s] from backup [%s] of cluster [%s]", cfg.ClusterName, backup.Identity, cfg.RecoverFrom)
+	cfg.RecoverBackup = backup.Identity
+	cfg.RecoverBefore = ""
 	ctx.SetKV("BootCFG", &id.Spec.Cluster)
 	ctx.SetKV("WdripOptions", cfg)
 	pvd := ctx.Provider()
@@ -100,7 +114,12 @@ func Create(cfg *v1.WdripOptions) error {
 	if !strings.Contains(err.Error(), "NoSuchKey") {
 		return errors.Wrapf(err, "create cluster")
 	}
+	// generation 0 makes the write create-only, a concurrent create of
+	// the same cluster surfaces as conflict instead of being overwritten.
 	err = indexer.SaveCluster(id)
+	if index.IsConflict(err) {
+		return errors.Wrapf(err, "cluster [%s] created concurrently", id.Name)
+	}
 	if err != nil {
 		return errors.Wrapf(err, "create cluster: %s", id.Name)
 	}
@@ -110,7 +129,15 @@ func Create(cfg *v1.WdripOptions) error {
 		return fmt.Errorf("call provider [%s] create: %s", bootcfg.Bind.Provider.Name, err.Error())
 	}
 	// set id for defer function.
-	err = indexer.SaveCluster(*nid)
+	err = indexer.UpdateCluster(
+		nid.Name,
+		func(mid *v1.ClusterId) error {
+			generation := mid.Generation
+			*mid = *nid
+			mid.Generation = generation
+			return nil
+		},
+	)
 	if err != nil {
 		klog.Errorf("save cluster cache after: %s", err.Error())
 	}
@@ -172,7 +199,7 @@ func Scale(options *v1.WdripOptions, name string, count int) error {
 		return errors.Wrapf(err, "initialize wdrip context")
 	}
 	idx := index.NewGenericIndexer(name, ctx.Provider())
-	id, err := idx.GetCluster(name)
+	_, err = idx.GetCluster(name)
 	if err != nil {
 		return errors.Wrapf(err, "scale cluster: %s", name)
 	}
@@ -184,8 +211,13 @@ func Scale(options *v1.WdripOptions, name string, count int) error {
 	if err != nil {
 		return fmt.Errorf("scale cluster: %s", err.Error())
 	}
-	id.Spec.UpdatedAt = time.Now().Format("2006-01-02T15:04:05")
-	return idx.SaveCluster(id)
+	return idx.UpdateCluster(
+		name,
+		func(mid *v1.ClusterId) error {
+			mid.Spec.UpdatedAt = time.Now().Format("2006-01-02T15:04:05")
+			return nil
+		},
+	)
 }
 
 func RunCommand(options *v1.WdripOptions, cmdline *v1.CommandLineArgs) error {
@@ -295,8 +327,19 @@ func Edit(options *v1.WdripOptions, cmdLine *v1.CommandLineArgs) error {
 	if err != nil {
 		return errors.Wrapf(err, "unrecognized field or value")
 	}
-	id.Spec.Cluster = *cspec
-	return idx.SaveCluster(id)
+	return idx.UpdateCluster(
+		options.ClusterName,
+		func(mid *v1.ClusterId) error {
+			// the edit was made against id, refuse to overwrite a spec
+			// that has been changed by someone else in the meantime.
+			if !reflect.DeepEqual(mid.Spec.Cluster, id.Spec.Cluster) {
+				return fmt.Errorf("cluster [%s] changed while editing, edit again", options.ClusterName)
+			}
+			mid.Spec.Cluster = *cspec
+			mid.Spec.UpdatedAt = time.Now().Format("2006-01-02T15:04:05")
+			return nil
+		},
+	)
 }
 
 func doGetCluster(options *v1.WdripOptions, cmdLine *v1.CommandLineArgs) error {
@@ -307,10 +350,13 @@ func doGetCluster(options *v1.WdripOptions, cmdLine *v1.CommandLineArgs) error {
 	index := index.NewGenericIndexer(options.ClusterName, ctx.Provider())
 
 	if options.ClusterName == "" {
-		ids, err := index.ListCluster(options.ClusterName)
+		ids, next, err := index.ListClusterPage(cmdLine.Limit, cmdLine.Continue)
 		if err != nil {
 			return errors.Wrapf(err, "ListCluster")
 		}
+		if next != "" {
+			defer fmt.Printf("\nmore clusters available, list next page with [--continue %s]\n", next)
+		}
 		switch cmdLine.OutPutFormat {
 		case "yaml":
 			for _, v := range ids {
@@ -379,9 +425,10 @@ func doGetBuckups(options *v1.WdripOptions, cmdLine *v1.CommandLineArgs) error {
 		fmt.Printf(utils.PrettyJson(backups))
 	default:
 		klog.Info()
-		fmt.Printf("%-20s%-20s%-20s%-80s\n", "NAME", "PREFIX", "DATE", "PATH")
-		for _, b := range backups.Copies {
-			fmt.Printf("%-20s%-20s%-20s%-80s\n", backups.Name, backups.Prefix, b.Identity, backups.Path(b))
+		fmt.Printf("%-20s%-40s%-20s%-12s%-10s%-24s%-80s\n", "NAME", "IDENTITY", "BACKUP", "TYPE", "PINNED", "VERIFIED AT", "PATH")
+		for i := range backups.Copies {
+			b := &backups.Copies[i]
+			fmt.Printf("%-20s%-40s%-20s%-12s%-10t%-24s%-80s\n", backups.Name, b.Identity, b.Name, backupType(b), b.Pinned, verifiedAt(b), backups.Path(*b))
 		}
 	}
 	return nil
@@ -400,14 +447,37 @@ func doGetKubeConfig(options *v1.WdripOptions, cmdLine *v1.CommandLineArgs) erro
 	if err != nil {
 		return errors.Wrapf(err, "scale cluster: %s", options.ClusterName)
 	}
+	cfg, err := adminKubeConfig(&id)
+	if err != nil {
+		return err
+	}
+
+	if cmdLine.WriteTo == "" {
+		fmt.Printf(cfg)
+		return nil
+	} else {
+		//mpath := filepath.Join(os.Getenv("HOME"), ".kube/config.wdrip")
+		mpath := cmdLine.WriteTo
+		err = ioutil.WriteFile(mpath, []byte(cfg), 0755)
+		if err == nil {
+			klog.Infof("write kubeconfig to file [%s]", mpath)
+		} else {
+			klog.Errorf("write kubeconfig to %s failed: %s", mpath, err.Error())
+		}
+	}
+	return nil
+}
+
+// adminKubeConfig sign an admin kubeconfig with cluster root ca
+func adminKubeConfig(id *v1.ClusterId) (string, error) {
 	if id.Spec.Cluster.Kubernetes.RootCA == nil {
-		return fmt.Errorf("root ca does not exist in spec.Kubernetes.RootCA in id cache")
+		return "", fmt.Errorf("root ca does not exist in spec.Kubernetes.RootCA in id cache")
 	}
 	key, crt, err := sign.SignKubernetes(
 		id.Spec.Cluster.Kubernetes.RootCA.Cert, id.Spec.Cluster.Kubernetes.RootCA.Key, []string{},
 	)
 	if err != nil {
-		return fmt.Errorf("sign kubernetes crt: %s", err.Error())
+		return "", fmt.Errorf("sign kubernetes crt: %s", err.Error())
 	}
 	cfg, err := utils.RenderConfig(
 		"admin.cfg",
@@ -425,23 +495,9 @@ func doGetKubeConfig(options *v1.WdripOptions, cmdLine *v1.CommandLineArgs) erro
 		},
 	)
 	if err != nil {
-		return fmt.Errorf("render admin.local config error: %s", err.Error())
+		return "", fmt.Errorf("render admin.local config error: %s", err.Error())
 	}
-
-	if cmdLine.WriteTo == "" {
-		fmt.Printf(cfg)
-		return nil
-	} else {
-		//mpath := filepath.Join(os.Getenv("HOME"), ".kube/config.wdrip")
-		mpath := cmdLine.WriteTo
-		err = ioutil.WriteFile(mpath, []byte(cfg), 0755)
-		if err == nil {
-			klog.Infof("write kubeconfig to file [%s]", mpath)
-		} else {
-			klog.Errorf("write kubeconfig to %s failed: %s", mpath, err.Error())
-		}
-	}
-	return nil
+	return cfg, nil
 }
 
 func WatchResult(options *v1.WdripOptions, name string) error {
@@ -463,5 +519,13 @@ func WatchResult(options *v1.WdripOptions, name string) error {
 	if err != nil {
 		return fmt.Errorf("watch error: %s", err.Error())
 	}
-	return idx.SaveCluster(id)
+	// watching takes minutes, only carry the endpoint discovered by the
+	// provider over to the latest copy.
+	return idx.UpdateCluster(
+		name,
+		func(mid *v1.ClusterId) error {
+			mid.Spec.Cluster.Endpoint = id.Spec.Cluster.Endpoint
+			return nil
+		},
+	)
 }
@@ -0,0 +1,46 @@
+package iaas
+
+import (
+	"fmt"
+	"github.com/aoxn/wdrip/pkg/apis/alibabacloud.com/v1"
+	pd "github.com/aoxn/wdrip/pkg/iaas/provider"
+	"github.com/aoxn/wdrip/pkg/index"
+	"github.com/pkg/errors"
+	"k8s.io/klog/v2"
+)
+
+// MigrateIndex upgrade objects stored in the index to current schema
+// version, objects of all clusters when no cluster name specified.
+// objects are upgraded on read anyway, bulk migration makes them
+// readable by tools which do not know the old shapes.
+func MigrateIndex(options *v1.WdripOptions, cmdLine *v1.CommandLineArgs) error {
+	ctx, err := pd.NewContext(options, nil)
+	if err != nil {
+		return errors.Wrapf(err, "initialize wdrip context")
+	}
+	names := []string{options.ClusterName}
+	if options.ClusterName == "" {
+		ids, err := index.NewClusterIndex("", ctx.Provider()).ListCluster("")
+		if err != nil {
+			return errors.Wrapf(err, "list clusters")
+		}
+		names = nil
+		for _, id := range ids {
+			names = append(names, id.Name)
+		}
+	}
+	fmt.Printf("%-70s%-16s%-8s%-8s\n", "KEY", "KIND", "FROM", "TO")
+	for _, name := range names {
+		results, err := index.NewGenericIndexer(name, ctx.Provider()).Migrate(cmdLine.DryRun)
+		for _, r := range results {
+			fmt.Printf("%-70s%-16s%-8d%-8d\n", r.Key, r.Kind, r.From, r.To)
+		}
+		if err != nil {
+			return errors.Wrapf(err, "migrate cluster %s", name)
+		}
+	}
+	if cmdLine.DryRun {
+		klog.Infof("dry run, nothing migrated")
+	}
+	return nil
+}
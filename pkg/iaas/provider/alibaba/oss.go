@@ -1,18 +1,23 @@
 package alibaba
 
 import (
-	"bufio"
+	"bytes"
 	"fmt"
+	"github.com/aoxn/wdrip/pkg/iaas/provider"
 	"github.com/denverdino/aliyungo/oss"
 	"github.com/pkg/errors"
 	"io"
 	"k8s.io/klog/v2"
-	"os"
+	"net/http"
 	"strings"
+	"time"
 )
 
 func (n *Devel) BucketName() string { return n.Cfg.BucketName }
 
+// Replicas secondary buckets configured by AlibabaDev.Replicas
+func (n *Devel) Replicas() []provider.ObjectStorage { return n.replicas }
+
 func (n *Devel) EnsureBucket(name string) error {
 	if name == "" {
 		return fmt.Errorf("empyt bucket name")
@@ -48,54 +53,130 @@ func (n *Devel) GetObject(src string) ([]byte, error) {
 	return data, nil
 }
 
+// GetFile download object to local file, resumable, see provider.DownloadFile
 func (n *Devel) GetFile(src, dst string) error {
-	bName, mpath := n.Cfg.BucketName, src
-	if strings.HasPrefix(src, "oss://") {
-		segs := strings.Split(src, "/")
-		if len(segs) < 4 {
-			return fmt.Errorf("invalid oss bucket: %s", src)
-		}
-		// override bucket name by user
-		bName = segs[2]
-		mpath = strings.Replace(src, fmt.Sprintf("oss://%s/", bName), "", -1)
+	return provider.DownloadFile(n, src, dst, provider.TransferOption{})
+}
+
+// PutFile upload local file in parts when it is large, see PutStream
+func (n *Devel) PutFile(src, dst string) error {
+	return provider.UploadFile(n, src, dst, provider.TransferOption{})
+}
+
+func (n *Devel) location(key string) (string, string, error) {
+	if !strings.HasPrefix(key, "oss://") {
+		return n.Cfg.BucketName, key, nil
 	}
-	klog.Infof("oss get file from [oss://%s/%s]", bName, mpath)
-	bucket := n.OSS.Bucket(bName)
-	reader, err := bucket.GetReader(mpath)
+	segs := strings.Split(key, "/")
+	if len(segs) < 4 {
+		return "", "", fmt.Errorf("invalid oss bucket: %s", key)
+	}
+	// override bucket name by user
+	return segs[2], strings.Replace(key, fmt.Sprintf("oss://%s/", segs[2]), "", -1), nil
+}
+
+func (n *Devel) PutStream(r io.Reader, size int64, dst string, opt provider.TransferOption) error {
+	bName, mpath, err := n.location(dst)
 	if err != nil {
-		return errors.Wrap(err, "get bucket reader")
+		return err
+	}
+	if err := opt.CheckSize(size); err != nil {
+		return errors.Wrapf(err, "put stream: %s", dst)
+	}
+	klog.Infof("oss put stream to [oss://%s/%s], size=%d", bName, mpath, size)
+	bucket := n.OSS.Bucket(bName)
+	reader := opt.Reader(r, 0, size)
+	psize := opt.PartSizeOrDefault()
+	if size >= 0 && size <= psize {
+		return bucket.PutReader(mpath, reader, size, oss.DefaultContentType, oss.Private, oss.Options{})
 	}
-	defer reader.Close()
-	desc, err := os.OpenFile(dst, os.O_RDWR|os.O_CREATE, 0777)
+	multi, err := bucket.InitMulti(mpath, oss.DefaultContentType, oss.Private, oss.Options{})
 	if err != nil {
-		return errors.Wrapf(err, "open dest file:%s", dst)
+		return errors.Wrapf(err, "init multipart upload: [oss://%s/%s]", bName, mpath)
+	}
+	var (
+		parts []oss.Part
+		buf   = make([]byte, psize)
+	)
+	for num := 1; ; num++ {
+		cnt, rerr := io.ReadFull(reader, buf)
+		if rerr == io.EOF {
+			break
+		}
+		if rerr != nil && rerr != io.ErrUnexpectedEOF {
+			_ = multi.Abort()
+			return errors.Wrapf(rerr, "read part %d", num)
+		}
+		part, err := putPart(multi, num, buf[:cnt])
+		if err != nil {
+			_ = multi.Abort()
+			return errors.Wrapf(err, "upload part %d: [oss://%s/%s]", num, bName, mpath)
+		}
+		parts = append(parts, part)
+		if rerr == io.ErrUnexpectedEOF {
+			break
+		}
+	}
+	if len(parts) == 0 {
+		_ = multi.Abort()
+		return bucket.Put(mpath, []byte{}, oss.DefaultContentType, oss.Private, oss.Options{})
 	}
-	defer desc.Close()
-	cnt, err := io.Copy(bufio.NewWriterSize(desc, 10*1024*1024), reader)
-	klog.Infof("get file[%s] from oss, read count[%d], to[%s]", src, cnt, dst)
-	return err
+	klog.Infof("oss complete multipart upload [oss://%s/%s] with %d parts", bName, mpath, len(parts))
+	return multi.Complete(parts)
 }
 
-func (n *Devel) PutFile(src, dst string) error {
-	bName, mpath := n.Cfg.BucketName, dst
-	if strings.HasPrefix(dst, "oss://") {
-		segs := strings.Split(dst, "/")
-		if len(segs) < 4 {
-			return fmt.Errorf("invalid oss bucket: %s", dst)
+// putPart upload a single part, failed part is retried on its own
+// instead of restarting the whole upload.
+func putPart(multi *oss.Multi, num int, data []byte) (oss.Part, error) {
+	var (
+		part oss.Part
+		err  error
+	)
+	for i := 0; i <= provider.DefaultTransferRetry; i++ {
+		part, err = multi.PutPart(num, bytes.NewReader(data))
+		if err == nil {
+			return part, nil
 		}
-		// override bucket name by user
-		bName = segs[2]
-		mpath = strings.Replace(dst, fmt.Sprintf("oss://%s/", bName), "", -1)
+		klog.Warningf("upload part %d of [%s] failed: %s, retry", num, multi.Key, err.Error())
+		time.Sleep(time.Duration(i+1) * time.Second)
 	}
-	klog.Infof("oss put file to [oss://%s/%s]", bName, mpath)
+	return part, err
+}
 
-	bucket := n.OSS.Bucket(bName)
-	desc, err := os.OpenFile(src, os.O_RDONLY, 0777)
+func (n *Devel) GetStream(
+	src string, w io.Writer, offset int64, opt provider.TransferOption,
+) (int64, error) {
+	bName, mpath, err := n.location(src)
+	if err != nil {
+		return 0, err
+	}
+	klog.Infof("oss get stream from [oss://%s/%s], offset=%d", bName, mpath, offset)
+	headers := http.Header{}
+	if offset > 0 {
+		headers.Set("Range", fmt.Sprintf("bytes=%d-", offset))
+	}
+	resp, err := n.OSS.Bucket(bName).GetResponseWithHeaders(mpath, headers)
 	if err != nil {
-		return errors.Wrapf(err, "open file: %s", src)
+		return 0, errors.Wrapf(err, "get oss object: path=[oss://%s/%s]", bName, mpath)
 	}
-	defer desc.Close()
-	return bucket.PutFile(mpath, desc, oss.Private, oss.Options{})
+	defer resp.Body.Close()
+	total := resp.ContentLength
+	if offset > 0 {
+		if resp.StatusCode != http.StatusPartialContent {
+			// oss ignores range beyond object size and
+			// returns the whole object instead.
+			if resp.ContentLength == offset {
+				return 0, nil
+			}
+			return 0, fmt.Errorf("InvalidRange: resume [oss://%s/%s] at %d, object size %d",
+				bName, mpath, offset, resp.ContentLength)
+		}
+		total = offset + resp.ContentLength
+	}
+	if err := opt.CheckSize(total); err != nil {
+		return 0, errors.Wrapf(err, "get stream: %s", src)
+	}
+	return io.Copy(opt.Writer(w, offset, total), resp.Body)
 }
 
 func (n *Devel) PutObject(b []byte, dst string) error {
@@ -136,18 +217,45 @@ func (n *Devel) ListObject(prefix string) ([][]byte, error) {
 	if err := n.EnsureBucket(bName); err != nil {
 		return nil, errors.Wrapf(err, "ensure bucket")
 	}
+	var (
+		result [][]byte
+		opt    = provider.ListOption{}
+	)
+	for {
+		page, err := n.ListPage(prefix, opt)
+		if err != nil {
+			return nil, err
+		}
+		for _, key := range page.Keys {
+			data, err := n.GetObject(key)
+			if err != nil {
+				return nil, errors.Wrapf(err, "get object by key: %s", key)
+			}
+			result = append(result, data)
+		}
+		if page.NextMarker == "" {
+			return result, nil
+		}
+		opt.Marker = page.NextMarker
+	}
+}
 
-	mlist, err := n.OSS.Bucket(bName).List(prefix, "", "", 1000)
+func (n *Devel) ListPage(prefix string, opt provider.ListOption) (*provider.ListResult, error) {
+	bName := n.Cfg.BucketName
+	max := opt.MaxKeys
+	if max <= 0 {
+		max = 1000
+	}
+	mlist, err := n.OSS.Bucket(bName).List(prefix, opt.Delimiter, opt.Marker, max)
 	if err != nil {
 		return nil, errors.Wrapf(err, "list object: %s", bName)
 	}
-	var result [][]byte
+	result := &provider.ListResult{CommonPrefixes: mlist.CommonPrefixes}
 	for _, v := range mlist.Contents {
-		data, err := n.GetObject(v.Key)
-		if err != nil {
-			return nil, errors.Wrapf(err, "get object by key: %s", v.Key)
-		}
-		result = append(result, data)
+		result.Keys = append(result.Keys, v.Key)
+	}
+	if mlist.IsTruncated {
+		result.NextMarker = mlist.NextMarker
 	}
 	return result, nil
 }
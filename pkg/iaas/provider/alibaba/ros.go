@@ -55,6 +55,14 @@ type AlibabaDev struct {
 	AccessKeySecret string `json:"accessKeySecret,omitempty" protobuf:"bytes,3,opt,name=accessKeySecret"`
 	BucketName      string `json:"bucketName,omitempty" protobuf:"bytes,4,opt,name=bucketName"`
 	TemplateFile    string `json:"template,omitempty" protobuf:"bytes,5,opt,name=template"`
+	// Replicas secondary buckets that cluster index and backups are replicated to
+	Replicas []BucketReplica `json:"replicas,omitempty" protobuf:"bytes,6,rep,name=replicas"`
+}
+
+type BucketReplica struct {
+	// Region of replica bucket, default the same region with primary bucket
+	Region     string `json:"region,omitempty" protobuf:"bytes,1,opt,name=region"`
+	BucketName string `json:"bucketName,omitempty" protobuf:"bytes,2,opt,name=bucketName"`
 }
 
 type Devel struct {
@@ -63,6 +71,8 @@ type Devel struct {
 	ESS *ess.Client
 	ECS *ecs.Client
 	OSS *oss.Client
+
+	replicas []provider.ObjectStorage
 }
 
 func (n *Devel) Initialize(ctx *provider.Context) error {
@@ -100,6 +110,29 @@ func (n *Devel) Initialize(ctx *provider.Context) error {
 	// the F** Word for the oss region
 	oregion := oss.Region(fmt.Sprintf("oss-%s", region))
 	n.OSS = oss.NewOSSClient(oregion, false, n.Cfg.AccessKeyId, n.Cfg.AccessKeySecret, false)
+	n.replicas = nil
+	for _, r := range n.Cfg.Replicas {
+		if r.BucketName == "" {
+			return fmt.Errorf("bucket name of replica must be specified")
+		}
+		rregion := r.Region
+		if rregion == "" {
+			rregion = region
+		}
+		klog.V(5).Infof("object storage replica: oss://%s, region=%s", r.BucketName, rregion)
+		n.replicas = append(n.replicas, &Devel{
+			Cfg: &AlibabaDev{
+				Region:          rregion,
+				BucketName:      r.BucketName,
+				AccessKeyId:     n.Cfg.AccessKeyId,
+				AccessKeySecret: n.Cfg.AccessKeySecret,
+			},
+			OSS: oss.NewOSSClient(
+				oss.Region(fmt.Sprintf("oss-%s", rregion)),
+				false, n.Cfg.AccessKeyId, n.Cfg.AccessKeySecret, false,
+			),
+		})
+	}
 	return nil
 }
 
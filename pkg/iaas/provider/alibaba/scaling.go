@@ -279,12 +279,18 @@ func (n *Devel) ScaleNodeGroup(
 }
 
 func (n *Devel) RemoveScalingGroupECS(
-	ctx *provider.Context, gid string, ecs string,
+	ctx *provider.Context, gid string, ecs string, opt ...provider.Option,
 ) error {
 	klog.Infof("[RemoveScalingGroupECS] trying to remove scaling group ecs: %s", ecs)
-	// load scaling group id from stack
-	stack := ctx.Stack()
-	sgid := stack["k8s_master_sg"].Val.(string)
+	sgid := gid
+	if sgid == "" {
+		// load master scaling group id from stack
+		stack := ctx.Stack()
+		if stack == nil {
+			return fmt.Errorf("stack context must be exist")
+		}
+		sgid = stack["k8s_master_sg"].Val.(string)
+	}
 	//srid := stack["k8s_master_srule"].Val.(string)
 	waitActivity := func(id string) error {
 		return wait.PollImmediate(
@@ -319,6 +325,11 @@ func (n *Devel) RemoveScalingGroupECS(
 	req := ess.CreateRemoveInstancesRequest()
 	req.InstanceId = &[]string{ecs}
 	req.ScalingGroupId = sgid
+	for _, o := range opt {
+		if o.Action == provider.ActionKeepCapacity {
+			req.DecreaseDesiredCapacity = requests.NewBoolean(false)
+		}
+	}
 	_, err = n.ESS.RemoveInstances(req)
 	if err != nil {
 		return fmt.Errorf("remove sg instance: %s %s", ecs, err.Error())
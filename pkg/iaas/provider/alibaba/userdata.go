@@ -144,18 +144,20 @@ func NewRecoverUserData(ctx *provider.Context) (string, error) {
 	ctxCfg := provider.BuildContexCFG(boot)
 	me := struct {
 		ConfigTpl
-		RecoverFrom string
-		ClusterName string
-		WdripConfig string
-		Bucket      string
+		RecoverFrom   string
+		RecoverBackup string
+		ClusterName   string
+		WdripConfig   string
+		Bucket        string
 	}{
-		ConfigTpl:   *cfg,
-		Bucket:      opts.Bucket,
-		WdripConfig: utils.PrettyYaml(ctxCfg),
-		ClusterName: opts.ClusterName,
-		RecoverFrom: opts.RecoverFrom,
+		ConfigTpl:     *cfg,
+		Bucket:        opts.Bucket,
+		WdripConfig:   utils.PrettyYaml(ctxCfg),
+		ClusterName:   opts.ClusterName,
+		RecoverFrom:   opts.RecoverFrom,
+		RecoverBackup: opts.RecoverBackup,
 	}
-	klog.Infof("recover [%s] from [%s], bucket [%s]", me.ClusterName, me.RecoverFrom, me.Bucket)
+	klog.Infof("recover [%s] from [%s], backup [%s], bucket [%s]", me.ClusterName, me.RecoverFrom, me.RecoverBackup, me.Bucket)
 	tpl, err := template.New("restore userdata").Parse(RecoverUserData)
 	if err != nil {
 		return "", errors.Wrap(err, "build recover userdata")
@@ -191,7 +193,7 @@ chmod +x /tmp/wdrip.${ARCH} ; mv /tmp/wdrip.${ARCH} /usr/local/bin/wdrip; mkdir
 cat > ~/.wdrip/config << EOF
 {{ .WdripConfig }}
 EOF
-/usr/local/bin/wdrip recover --recover-mode node --name "{{ .ClusterName }}" --recover-from-cluster "{{ .RecoverFrom}}" {{ if .Bucket }} --bucket "{{.Bucket}}" {{ end }}
+/usr/local/bin/wdrip recover --recover-mode node --name "{{ .ClusterName }}" --recover-from-cluster "{{ .RecoverFrom}}" {{ if .RecoverBackup }} --backup "{{.RecoverBackup}}" {{ end }}{{ if .Bucket }} --bucket "{{.Bucket}}" {{ end }}
 `
 
 var USER_DATA_JOIN_MASTER = `#!/bin/sh
@@ -0,0 +1,149 @@
+package fake
+
+import (
+	"fmt"
+	pd "github.com/aoxn/wdrip/pkg/iaas/provider"
+	"io"
+	"io/ioutil"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// NewObjectStorage in memory object storage of bucket, for test
+func NewObjectStorage(bucket string) *ObjectStorage {
+	return &ObjectStorage{bucket: bucket, objects: map[string][]byte{}}
+}
+
+var _ pd.ObjectStorage = &ObjectStorage{}
+
+type ObjectStorage struct {
+	bucket  string
+	lock    sync.Mutex
+	objects map[string][]byte
+}
+
+// key strip oss://bucket/ of f
+func key(f string) string {
+	if strings.HasPrefix(f, "oss://") {
+		segs := strings.SplitN(strings.TrimPrefix(f, "oss://"), "/", 2)
+		if len(segs) == 2 {
+			return segs[1]
+		}
+	}
+	return f
+}
+
+// Keys of objects stored, sorted
+func (m *ObjectStorage) Keys() []string {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	var keys []string
+	for k := range m.objects {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+func (m *ObjectStorage) BucketName() string { return m.bucket }
+
+func (m *ObjectStorage) EnsureBucket(name string) error { return nil }
+
+func (m *ObjectStorage) GetFile(src, dst string) error {
+	data, err := m.GetObject(src)
+	if err != nil {
+		return err
+	}
+	return ioutil.WriteFile(dst, data, 0644)
+}
+
+func (m *ObjectStorage) PutFile(src, dst string) error {
+	data, err := ioutil.ReadFile(src)
+	if err != nil {
+		return err
+	}
+	return m.PutObject(data, dst)
+}
+
+func (m *ObjectStorage) DeleteObject(f string) error {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	delete(m.objects, key(f))
+	return nil
+}
+
+func (m *ObjectStorage) GetObject(src string) ([]byte, error) {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	data, ok := m.objects[key(src)]
+	if !ok {
+		return nil, fmt.Errorf("NoSuchKey: %s", src)
+	}
+	return data, nil
+}
+
+func (m *ObjectStorage) PutObject(b []byte, dst string) error {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	m.objects[key(dst)] = b
+	return nil
+}
+
+func (m *ObjectStorage) ListObject(prefix string) ([][]byte, error) {
+	var result [][]byte
+	for _, k := range m.Keys() {
+		if strings.HasPrefix(k, prefix) {
+			data, err := m.GetObject(k)
+			if err != nil {
+				return nil, err
+			}
+			result = append(result, data)
+		}
+	}
+	return result, nil
+}
+
+func (m *ObjectStorage) ListPage(prefix string, opt pd.ListOption) (*pd.ListResult, error) {
+	result := &pd.ListResult{}
+	prefixes := map[string]bool{}
+	for _, k := range m.Keys() {
+		if !strings.HasPrefix(k, prefix) || k <= opt.Marker {
+			continue
+		}
+		if opt.MaxKeys > 0 && len(result.Keys) == opt.MaxKeys {
+			result.NextMarker = result.Keys[len(result.Keys)-1]
+			break
+		}
+		rest := strings.TrimPrefix(k, prefix)
+		if i := strings.Index(rest, opt.Delimiter); opt.Delimiter != "" && i >= 0 {
+			if p := prefix + rest[:i+1]; !prefixes[p] {
+				prefixes[p] = true
+				result.CommonPrefixes = append(result.CommonPrefixes, p)
+			}
+			continue
+		}
+		result.Keys = append(result.Keys, k)
+	}
+	return result, nil
+}
+
+func (m *ObjectStorage) PutStream(r io.Reader, size int64, dst string, opt pd.TransferOption) error {
+	data, err := ioutil.ReadAll(opt.Reader(r, 0, size))
+	if err != nil {
+		return err
+	}
+	return m.PutObject(data, dst)
+}
+
+func (m *ObjectStorage) GetStream(src string, w io.Writer, offset int64, opt pd.TransferOption) (int64, error) {
+	data, err := m.GetObject(src)
+	if err != nil {
+		return 0, err
+	}
+	if err := opt.CheckSize(int64(len(data))); err != nil {
+		return 0, err
+	}
+	n, err := opt.Writer(w, offset, int64(len(data))).Write(data[offset:])
+	return int64(n), err
+}
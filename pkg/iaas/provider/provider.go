@@ -7,6 +7,7 @@ import (
 	"github.com/aoxn/wdrip/pkg/utils/cmd"
 	"github.com/ghodss/yaml"
 	"github.com/pkg/errors"
+	"io"
 	"io/ioutil"
 	"k8s.io/klog/v2"
 	"os"
@@ -176,6 +177,52 @@ type ObjectStorage interface {
 	GetObject(src string) ([]byte, error)
 	PutObject(b []byte, dst string) error
 	ListObject(prefix string) ([][]byte, error)
+	// ListPage list one page of keys under prefix, see ListOption
+	ListPage(prefix string, opt ListOption) (*ListResult, error)
+
+	// PutStream upload size bytes read from r to dst. object larger than
+	// the part size is uploaded in parts, size -1 means unknown.
+	PutStream(r io.Reader, size int64, dst string, opt TransferOption) error
+	// GetStream write object src to w starting from offset, which is used
+	// to resume an interrupted download. returns bytes written to w.
+	GetStream(src string, w io.Writer, offset int64, opt TransferOption) (int64, error)
+}
+
+// ListOption options of paginated listing
+type ListOption struct {
+	// Delimiter keys containing Delimiter after prefix are grouped
+	// into CommonPrefixes instead of returned one by one
+	Delimiter string
+	// Marker list keys after Marker, the NextMarker of last page
+	Marker string
+	// MaxKeys max keys of one page, 0 means storage default
+	MaxKeys int
+}
+
+// ListResult one page of listing, keys are in lexical order
+type ListResult struct {
+	Keys           []string
+	CommonPrefixes []string
+	// NextMarker marker of next page, empty for the last page
+	NextMarker string
+}
+
+// ReplicatedStorage is implemented by object storage which has
+// secondary replicas configured for disaster recovery.
+type ReplicatedStorage interface {
+	Replicas() []ObjectStorage
+}
+
+// ConditionalStorage is implemented by object storage which supports
+// preconditioned writes, eg. ETag based If-Match. index falls back to
+// a lock object for storage without it.
+type ConditionalStorage interface {
+	// GetObjectVersion returns object content together with its version
+	GetObjectVersion(src string) ([]byte, string, error)
+	// PutObjectIfMatch put object only if its version is still version,
+	// empty version means the object must not exist. error code
+	// PreconditionFailed is expected on mismatch.
+	PutObjectIfMatch(b []byte, dst, version string) error
 }
 
 type Resource interface {
@@ -195,9 +242,17 @@ type Scaling interface {
 
 	ScaleMasterGroup(ctx *Context, gid string, desired int) error
 
-	RemoveScalingGroupECS(ctx *Context, gid string, ecs string) error
+	// RemoveScalingGroupECS remove ecs from scaling group gid, master group
+	// when empty. the desired capacity decreases by one unless opt asks to
+	// keep it with ActionKeepCapacity, in which case the group creates a
+	// new instance instead.
+	RemoveScalingGroupECS(ctx *Context, gid string, ecs string, opt ...Option) error
 }
 
+// ActionKeepCapacity option of RemoveScalingGroupECS to remove instance
+// from scaling group without decreasing the desired capacity
+const ActionKeepCapacity = "KeepCapacity"
+
 type NodeGroup interface {
 	CreateNodeGroup(ctx *Context, np *v1.NodePool) (*v1.BindID, error)
 
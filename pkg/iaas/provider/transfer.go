@@ -0,0 +1,174 @@
+package provider
+
+import (
+	"fmt"
+	"github.com/pkg/errors"
+	"hash/fnv"
+	"io"
+	"k8s.io/klog/v2"
+	"os"
+	"strings"
+	"time"
+)
+
+const (
+	// DefaultPartSize objects larger than DefaultPartSize are
+	// uploaded in parts, a failed part is retried on its own.
+	DefaultPartSize = 16 * 1024 * 1024
+
+	// DefaultTransferRetry retries of an interrupted download, each
+	// retry resumes from the bytes already written.
+	DefaultTransferRetry = 5
+)
+
+// Progress is called with transferred bytes and total bytes of the
+// object as the transfer goes on. total is -1 when unknown.
+type Progress func(transferred, total int64)
+
+// TransferOption options of streaming transfer
+type TransferOption struct {
+	// PartSize size of each part of multipart upload,
+	// 0 means DefaultPartSize
+	PartSize int64
+
+	// MaxSize refuse to transfer object larger than MaxSize,
+	// 0 means no limit
+	MaxSize int64
+
+	// Progress optional progress callback
+	Progress Progress
+}
+
+// PartSizeOrDefault part size of multipart upload
+func (o TransferOption) PartSizeOrDefault() int64 {
+	if o.PartSize <= 0 {
+		return DefaultPartSize
+	}
+	return o.PartSize
+}
+
+// CheckSize returns error when size exceeds MaxSize
+func (o TransferOption) CheckSize(size int64) error {
+	if o.MaxSize > 0 && size > o.MaxSize {
+		return fmt.Errorf("SizeLimitExceeded: object size %d exceeds limit %d", size, o.MaxSize)
+	}
+	return nil
+}
+
+// Reader wrap r with size limit and progress report. offset is the
+// bytes transferred before, total is the object size or -1.
+func (o TransferOption) Reader(r io.Reader, offset, total int64) io.Reader {
+	return &progressReader{reader: r, option: o, done: offset, total: total}
+}
+
+// Writer wrap w with size limit and progress report, see Reader.
+func (o TransferOption) Writer(w io.Writer, offset, total int64) io.Writer {
+	return &progressWriter{writer: w, option: o, done: offset, total: total}
+}
+
+type progressReader struct {
+	reader io.Reader
+	option TransferOption
+	done   int64
+	total  int64
+}
+
+func (p *progressReader) Read(b []byte) (int, error) {
+	n, err := p.reader.Read(b)
+	p.done += int64(n)
+	if serr := p.option.CheckSize(p.done); serr != nil {
+		return n, serr
+	}
+	if p.option.Progress != nil && n > 0 {
+		p.option.Progress(p.done, p.total)
+	}
+	return n, err
+}
+
+type progressWriter struct {
+	writer io.Writer
+	option TransferOption
+	done   int64
+	total  int64
+}
+
+func (p *progressWriter) Write(b []byte) (int, error) {
+	if err := p.option.CheckSize(p.done + int64(len(b))); err != nil {
+		return 0, err
+	}
+	n, err := p.writer.Write(b)
+	p.done += int64(n)
+	if p.option.Progress != nil && n > 0 {
+		p.option.Progress(p.done, p.total)
+	}
+	return n, err
+}
+
+// UploadFile upload local file src to dst through PutStream
+func UploadFile(store ObjectStorage, src, dst string, opt TransferOption) error {
+	desc, err := os.Open(src)
+	if err != nil {
+		return errors.Wrapf(err, "open file: %s", src)
+	}
+	defer desc.Close()
+	info, err := desc.Stat()
+	if err != nil {
+		return errors.Wrapf(err, "stat file: %s", src)
+	}
+	if err := opt.CheckSize(info.Size()); err != nil {
+		return errors.Wrapf(err, "upload %s", src)
+	}
+	return store.PutStream(desc, info.Size(), dst, opt)
+}
+
+// DownloadFile download src to local file dst. content is written to a
+// part file named after src first and moved to dst when finished. an
+// interrupted download is resumed from the end of the part file, either
+// by retry or by the next call with the same src and dst.
+func DownloadFile(store ObjectStorage, src, dst string, opt TransferOption) error {
+	h := fnv.New32a()
+	_, _ = h.Write([]byte(src))
+	part := fmt.Sprintf("%s.%x.part", dst, h.Sum32())
+	desc, err := os.OpenFile(part, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0644)
+	if err != nil {
+		return errors.Wrapf(err, "open file: %s", part)
+	}
+	info, err := desc.Stat()
+	if err != nil {
+		_ = desc.Close()
+		return errors.Wrapf(err, "stat file: %s", part)
+	}
+	offset := info.Size()
+	for i := 0; ; i++ {
+		if offset > 0 {
+			klog.Infof("resume download [%s] from offset %d", src, offset)
+		}
+		var n int64
+		n, err = store.GetStream(src, desc, offset, opt)
+		offset += n
+		if err == nil || i >= DefaultTransferRetry || !retryable(err) {
+			break
+		}
+		klog.Warningf("download [%s] interrupted at %d: %s, retry", src, offset, err.Error())
+		time.Sleep(time.Duration(i+1) * time.Second)
+	}
+	if cerr := desc.Close(); err == nil && cerr != nil {
+		err = cerr
+	}
+	if err != nil {
+		if !retryable(err) {
+			_ = os.Remove(part)
+		}
+		return errors.Wrapf(err, "download %s", src)
+	}
+	return os.Rename(part, dst)
+}
+
+func retryable(err error) bool {
+	for _, code := range []string{"NoSuchKey", "NoSuchBucket", "SizeLimitExceeded", "InvalidRange", "AccessDenied"} {
+		if strings.Contains(err.Error(), code) {
+			return false
+		}
+	}
+	return true
+}
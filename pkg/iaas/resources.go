@@ -0,0 +1,107 @@
+package iaas
+
+import (
+	"fmt"
+	"github.com/aoxn/wdrip/pkg/apis/alibabacloud.com/v1"
+	pd "github.com/aoxn/wdrip/pkg/iaas/provider"
+	"github.com/aoxn/wdrip/pkg/index"
+	"github.com/aoxn/wdrip/pkg/utils/kubeclient"
+	"github.com/pkg/errors"
+	"k8s.io/cli-runtime/pkg/genericclioptions"
+	"k8s.io/client-go/tools/clientcmd"
+	"k8s.io/klog/v2"
+)
+
+// createResourceBackup export api objects selected by namespace, kind and
+// label selector, and save them as a named backup next to etcd snapshots.
+func createResourceBackup(options *v1.WdripOptions, cmdLine *v1.CommandLineArgs) error {
+	if options.ClusterName == "" {
+		return fmt.Errorf("cluster name must be specified over [-c xxx]")
+	}
+	ctx, err := pd.NewContext(options, nil)
+	if err != nil {
+		return errors.Wrapf(err, "initialize wdrip context")
+	}
+	getter, err := clusterClientGetter(ctx.Provider(), options.ClusterName)
+	if err != nil {
+		return err
+	}
+	data, err := kubeclient.ExportResources(getter, exportOption(cmdLine))
+	if err != nil {
+		return errors.Wrapf(err, "export resources")
+	}
+	idx := index.NewGenericIndexer(options.ClusterName, ctx.Provider())
+	err = idx.BackupResourcesWithName(data, cmdLine.BackupName)
+	if err != nil {
+		return errors.Wrapf(err, "backup resources")
+	}
+	klog.Infof("resource backup [%s] finished", cmdLine.BackupName)
+	return nil
+}
+
+// RestoreResources re-apply api objects from resource backup of
+// cluster options.ClusterName into cmdLine.ToCluster.
+func RestoreResources(options *v1.WdripOptions, cmdLine *v1.CommandLineArgs) error {
+	idx, err := backupIndex(options, cmdLine)
+	if err != nil {
+		return err
+	}
+	data, err := idx.GetResources(cmdLine.BackupName)
+	if err != nil {
+		return errors.Wrapf(err, "get resource backup: %s", cmdLine.BackupName)
+	}
+	data, err = kubeclient.FilterResources(data, exportOption(cmdLine))
+	if err != nil {
+		return errors.Wrapf(err, "filter resources")
+	}
+	if len(data) == 0 {
+		return fmt.Errorf("no resources matched in backup %s", cmdLine.BackupName)
+	}
+	if cmdLine.DryRun {
+		fmt.Printf("%s", data)
+		return nil
+	}
+	target := cmdLine.ToCluster
+	if target == "" {
+		target = options.ClusterName
+	}
+	ctx, err := pd.NewContext(options, nil)
+	if err != nil {
+		return errors.Wrapf(err, "initialize wdrip context")
+	}
+	getter, err := clusterClientGetter(ctx.Provider(), target)
+	if err != nil {
+		return err
+	}
+	klog.Infof("restore resources from backup [%s] of [%s] into cluster [%s]",
+		cmdLine.BackupName, options.ClusterName, target)
+	return kubeclient.ApplyWithGetter(string(data), getter)
+}
+
+func exportOption(cmdLine *v1.CommandLineArgs) kubeclient.ExportOption {
+	return kubeclient.ExportOption{
+		Namespaces: cmdLine.Namespaces,
+		Kinds:      cmdLine.Kinds,
+		Selector:   cmdLine.Selector,
+	}
+}
+
+// clusterClientGetter build client getter with admin kubeconfig
+// signed from cluster root ca in index
+func clusterClientGetter(
+	store pd.ObjectStorage, name string,
+) (genericclioptions.RESTClientGetter, error) {
+	id, err := index.NewGenericIndexer(name, store).GetCluster(name)
+	if err != nil {
+		return nil, errors.Wrapf(err, "no cluster found by name %s", name)
+	}
+	cfg, err := adminKubeConfig(&id)
+	if err != nil {
+		return nil, errors.Wrapf(err, "build kubeconfig for %s", name)
+	}
+	apicfg, err := clientcmd.Load([]byte(cfg))
+	if err != nil {
+		return nil, errors.Wrapf(err, "load kubeconfig for %s", name)
+	}
+	return kubeclient.NewClientGetter(apicfg), nil
+}
@@ -0,0 +1,258 @@
+package iaas
+
+import (
+	"fmt"
+	"github.com/aoxn/wdrip/pkg/actions/etcd"
+	"github.com/aoxn/wdrip/pkg/actions/file"
+	"github.com/aoxn/wdrip/pkg/actions/kubeadm"
+	"github.com/aoxn/wdrip/pkg/apis/alibabacloud.com/v1"
+	pd "github.com/aoxn/wdrip/pkg/iaas/provider"
+	"github.com/aoxn/wdrip/pkg/iaas/provider/alibaba"
+	"github.com/aoxn/wdrip/pkg/index"
+	meta "github.com/aoxn/wdrip/pkg/node/meta/alibaba"
+	"github.com/aoxn/wdrip/pkg/operator/controllers/backup"
+	h "github.com/aoxn/wdrip/pkg/operator/controllers/help"
+	"github.com/aoxn/wdrip/pkg/operator/monit"
+	"github.com/aoxn/wdrip/pkg/utils"
+	"github.com/pkg/errors"
+	kversion "k8s.io/apimachinery/pkg/util/version"
+	"k8s.io/klog/v2"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+// UpgradeEtcd rolling upgrade etcd of cluster to cmdLine.Version.
+// health and version compatibility are checked and a verified backup is
+// taken on one master first, then masters are upgraded one at a time
+// with `wdrip upgrade etcd --local`, stopping at the first failure. the
+// failed member is rolled back by itself.
+func UpgradeEtcd(options *v1.WdripOptions, cmdLine *v1.CommandLineArgs) error {
+	if cmdLine.Version == "" {
+		return fmt.Errorf("target version must be specified over [--version xxx]")
+	}
+	err := validateVersion(cmdLine.Version)
+	if err != nil {
+		return err
+	}
+	if cmdLine.Local {
+		return upgradeLocalEtcd(options, cmdLine)
+	}
+	if options.ClusterName == "" {
+		return fmt.Errorf("cluster name must be specified over [-c xxx]")
+	}
+	ctx, err := pd.NewContext(options, nil)
+	if err != nil {
+		return errors.Wrapf(err, "initialize wdrip context")
+	}
+	idx := index.NewGenericIndexer(options.ClusterName, ctx.Provider())
+	id, err := idx.GetCluster(options.ClusterName)
+	if err != nil {
+		return errors.Wrapf(err, "get cluster: %s", options.ClusterName)
+	}
+	err = etcd.CompatibleUpgrade(id.Spec.Cluster.Etcd.Version, cmdLine.Version)
+	if err != nil {
+		return errors.Wrapf(err, "upgrade etcd of %s", options.ClusterName)
+	}
+	name := upgradeBackupName(cmdLine)
+	err = index.ValidateBackupName(name)
+	if err != nil {
+		return err
+	}
+	stack, err := h.LoadStackFromSpec(ctx.Provider(), ctx, &id.Spec.Cluster)
+	if err != nil {
+		return errors.Wrapf(err, "load stack")
+	}
+	ctx.WithStack(stack)
+	detail, err := ctx.Provider().ScalingGroupDetail(
+		ctx, "", pd.Option{Action: alibaba.ActionInstanceIDS},
+	)
+	if err != nil {
+		return errors.Wrapf(err, "find master instance")
+	}
+	var masters []string
+	for _, ins := range detail.Instances {
+		if ins.Status != "Running" {
+			return fmt.Errorf("master [%s] is %s, abort upgrade", ins.Id, ins.Status)
+		}
+		masters = append(masters, ins.Id)
+	}
+	if len(masters) == 0 {
+		return fmt.Errorf("no master found to upgrade")
+	}
+	sort.Strings(masters)
+
+	command := fmt.Sprintf(
+		"KUBECONFIG=%s /usr/local/bin/wdrip upgrade etcd --local --version %s --bucket %s",
+		quote(utils.AUTH_FILE), quote(cmdLine.Version), quote(options.Bucket),
+	)
+	klog.Infof("check and backup etcd on master [%s] before upgrade", masters[0])
+	err = runOnMaster(ctx, masters[0], fmt.Sprintf("%s --prepare --name %s", command, quote(name)))
+	if err != nil {
+		return errors.Wrapf(err, "prepare etcd upgrade")
+	}
+	for i, ins := range masters {
+		klog.Infof("upgrade etcd on master [%s], %d of %d", ins, i+1, len(masters))
+		err = runOnMaster(ctx, ins, command)
+		if err != nil {
+			return errors.Wrapf(
+				err, "upgrade etcd on master [%s] failed, %d of %d upgraded, recover with backup [%s] if needed",
+				ins, i, len(masters), name,
+			)
+		}
+	}
+	err = idx.UpdateCluster(
+		options.ClusterName,
+		func(mid *v1.ClusterId) error {
+			mid.Spec.Cluster.Etcd.Version = cmdLine.Version
+			mid.Spec.UpdatedAt = time.Now().Format("2006-01-02T15:04:05")
+			return nil
+		},
+	)
+	if err != nil {
+		return errors.Wrapf(err, "etcd upgraded, but save version to cluster index")
+	}
+	klog.Infof("etcd of %s upgraded to %s", options.ClusterName, cmdLine.Version)
+	return nil
+}
+
+// upgradeBackupName name of the backup taken before upgrade
+func upgradeBackupName(cmdLine *v1.CommandLineArgs) string {
+	if cmdLine.BackupName != "" {
+		return cmdLine.BackupName
+	}
+	version := strings.ReplaceAll(cmdLine.Version, ".", "-")
+	return fmt.Sprintf("pre-etcd-%s-%s", version, time.Now().Format("20060102-1504"))
+}
+
+// validateVersion returns error unless version is a plain etcd version,
+// eg. v3.4.3 or 3.4.3. it is passed to a shell on masters.
+func validateVersion(version string) error {
+	v, err := kversion.ParseGeneric(version)
+	if err != nil {
+		return errors.Wrapf(err, "parse version %q", version)
+	}
+	if strings.TrimPrefix(version, "v") != v.String() {
+		return fmt.Errorf("unexpected version %q, eg. v3.4.3", version)
+	}
+	return nil
+}
+
+// quote single quote s for shell
+func quote(s string) string {
+	return "'" + strings.ReplaceAll(s, "'", `'\''`) + "'"
+}
+
+func runOnMaster(ctx *pd.Context, id, command string) error {
+	result, err := ctx.Provider().RunCommand(ctx, id, command)
+	if err != nil {
+		return err
+	}
+	klog.Infof("\n\n%s", result.OutPut)
+	if result.Status != "Success" {
+		return fmt.Errorf("command on [%s] status=%s", id, result.Status)
+	}
+	return nil
+}
+
+// upgradeChecker check etcd cluster before upgrade
+type upgradeChecker interface {
+	CheckUpgrade(to string) error
+}
+
+// prepareUpgrade check etcd can be upgraded to cmdLine.Version, then take
+// a named backup with snap and verify it with drill.
+func prepareUpgrade(
+	checker upgradeChecker,
+	snap *backup.Snapshot,
+	spec *v1.Cluster,
+	masters []v1.Master,
+	cmdLine *v1.CommandLineArgs,
+	drill func(name string) error,
+) error {
+	err := checker.CheckUpgrade(cmdLine.Version)
+	if err != nil {
+		return errors.Wrapf(err, "check upgrade")
+	}
+	name := upgradeBackupName(cmdLine)
+	err = snap.BackupWithName(spec, masters, name)
+	if err != nil {
+		return errors.Wrapf(err, "backup etcd before upgrade")
+	}
+	klog.Infof("backup [%s] finished", name)
+	return drill(name)
+}
+
+// upgradeLocalEtcd upgrade etcd member on current node, or check the
+// cluster and take a verified backup with cmdLine.Prepare.
+func upgradeLocalEtcd(options *v1.WdripOptions, cmdLine *v1.CommandLineArgs) error {
+	restc, err := monit.NewClusterCtl()
+	if err != nil {
+		return errors.Wrapf(err, "new cluster client")
+	}
+	spec, masters, err := monit.GetSpec(restc.GetClient())
+	if err != nil {
+		return errors.Wrapf(err, "get cluster spec")
+	}
+	metcd, err := etcd.NewEtcdFromCRD(masters, spec, etcd.ETCD_TMP)
+	if err != nil {
+		return errors.Wrapf(err, "new etcd")
+	}
+	if cmdLine.Prepare {
+		ctx, err := pd.NewContext(&v1.WdripOptions{}, &spec.Spec)
+		if err != nil {
+			return errors.Wrapf(err, "new provider context")
+		}
+		idx := index.NewGenericIndexer(spec.Spec.ClusterID, ctx.Provider())
+		drill := func(name string) error {
+			return DrillBackup(options, &v1.CommandLineArgs{Local: true, BackupName: name})
+		}
+		return prepareUpgrade(metcd, backup.NewBareSnapshot(idx), spec, masters, cmdLine, drill)
+	}
+	node := meta.NewMetaDataAlibaba(nil)
+	ip, err := node.PrivateIPv4()
+	if err != nil {
+		return errors.Wrapf(err, "node ip")
+	}
+	region, err := node.Region()
+	if err != nil {
+		return errors.Wrapf(err, "node region")
+	}
+	stage := filepath.Join(etcd.UpgradeStage, cmdLine.Version)
+	f := file.File{
+		Bucket:   options.Bucket,
+		Region:   region,
+		CacheDir: etcd.UpgradeStage,
+		VersionedPath: file.Path{
+			Namespace:   spec.Spec.Namespace,
+			Pkg:         file.PKG_ETCD,
+			CType:       spec.Spec.CloudType,
+			Ftype:       file.FILE_BINARY,
+			Project:     "wdrip",
+			OS:          "centos",
+			Arch:        "amd64",
+			Version:     cmdLine.Version,
+			Destination: stage,
+		},
+	}
+	// a stale stage would mix binaries of different versions
+	err = os.RemoveAll(etcd.UpgradeStage)
+	if err != nil {
+		return errors.Wrapf(err, "clean stage")
+	}
+	for _, step := range []func() error{f.Download, f.Untar, f.Install} {
+		if err := step(); err != nil {
+			return errors.Wrapf(err, "stage etcd %s", cmdLine.Version)
+		}
+	}
+	err = metcd.UpgradeMember(ip, stage, cmdLine.Version)
+	if err != nil {
+		return err
+	}
+	// etcd proxy runs the etcd image of the member version
+	upgraded := spec.Spec
+	upgraded.Etcd.Version = cmdLine.Version
+	return kubeadm.UpgradeEtcdProxy(&upgraded)
+}
@@ -0,0 +1,56 @@
+package iaas
+
+import (
+	"fmt"
+	"github.com/aoxn/wdrip/pkg/actions/etcd"
+	"github.com/aoxn/wdrip/pkg/apis/alibabacloud.com/v1"
+	"github.com/aoxn/wdrip/pkg/iaas/provider/fake"
+	"github.com/aoxn/wdrip/pkg/index"
+	"github.com/aoxn/wdrip/pkg/operator/controllers/backup"
+	"github.com/stretchr/testify/assert"
+	"io/ioutil"
+	"path/filepath"
+	"testing"
+)
+
+type fakeChecker struct{ err error }
+
+func (c *fakeChecker) CheckUpgrade(to string) error { return c.err }
+
+func TestPrepareUpgrade(t *testing.T) {
+	idx := index.NewGenericIndexer("kubernetes-wdrip-64", fake.NewObjectStorage("wdrip-index"))
+	idx.WithSnapshotFile(filepath.Join(t.TempDir(), "snapshot.db"))
+	snap := backup.NewBareSnapshot(idx).WithSnapshotter(
+		func(masters []v1.Master, spec *v1.Cluster, dst string) (*etcd.SnapshotSource, error) {
+			return &etcd.SnapshotSource{}, ioutil.WriteFile(dst, []byte("etcd snapshot"), 0644)
+		},
+	)
+	spec := &v1.Cluster{}
+	masters := []v1.Master{{Spec: v1.MasterSpec{IP: "192.168.0.1"}}}
+	cmdLine := &v1.CommandLineArgs{Version: "v3.5.0", BackupName: "pre-etcd-v3-5-0"}
+
+	var drilled []string
+	drill := func(name string) error {
+		_, err := idx.GetBackup(name)
+		drilled = append(drilled, name)
+		return err
+	}
+	assert.Nil(t, prepareUpgrade(&fakeChecker{}, snap, spec, masters, cmdLine, drill))
+	assert.Equal(t, []string{"pre-etcd-v3-5-0"}, drilled)
+
+	// no backup is taken when etcd can not be upgraded
+	cmdLine.BackupName = "pre-etcd-v3-6-0"
+	checker := &fakeChecker{err: fmt.Errorf("member unhealthy")}
+	assert.NotNil(t, prepareUpgrade(checker, snap, spec, masters, cmdLine, drill))
+	_, err := idx.GetBackup("pre-etcd-v3-6-0")
+	assert.NotNil(t, err)
+	assert.Equal(t, 1, len(drilled))
+}
+
+func TestValidateVersion(t *testing.T) {
+	assert.Nil(t, validateVersion("v3.5.0"))
+	assert.Nil(t, validateVersion("3.4.13"))
+	assert.NotNil(t, validateVersion("3.5.0;reboot"))
+	assert.NotNil(t, validateVersion("latest"))
+	assert.Equal(t, `'it'\''s'`, quote("it's"))
+}
@@ -1,12 +1,11 @@
 package index
 
 import (
-	"encoding/json"
 	"fmt"
 	api "github.com/aoxn/wdrip/pkg/apis/alibabacloud.com/v1"
 	pd "github.com/aoxn/wdrip/pkg/iaas/provider"
-	"github.com/aoxn/wdrip/pkg/utils"
 	"github.com/pkg/errors"
+	"k8s.io/client-go/util/retry"
 	"k8s.io/klog/v2"
 	"strings"
 )
@@ -18,7 +17,7 @@ func path(bucket, name string) string {
 func NewClusterIndex(
 	id string, store pd.ObjectStorage,
 ) *ClusterIndex {
-	return &ClusterIndex{id: id, store: store}
+	return &ClusterIndex{id: id, store: withReplicas(store)}
 }
 
 type ClusterIndex struct {
@@ -26,28 +25,89 @@ type ClusterIndex struct {
 	store pd.ObjectStorage
 }
 
+// SaveCluster write ClusterId with generation increased. id must carry
+// the generation it was read with, ConflictError is returned when the
+// stored ClusterId has been modified since then.
 func (n *ClusterIndex) SaveCluster(id api.ClusterId) error {
 	bName := n.store.BucketName()
 	if bName == "" {
 		return fmt.Errorf("oss bucket name should be provided in wdrip config")
 	}
 
-	data := utils.PrettyJson(id)
 	klog.Infof("trying to save ClusterIndex id to remote bucket: %s", id.Name)
-	err := n.store.PutObject([]byte(data), path(bName, id.Name))
-	if err == nil {
-		return nil
+	key := path(bName, id.Name)
+	var (
+		prev  *api.ClusterIdSpec
+		saved api.ClusterId
+	)
+	save := func(data []byte) ([]byte, error) {
+		prev = nil
+		if data == nil {
+			if id.Generation != 0 {
+				return nil, &ConflictError{Key: key, Reason: "cluster has been removed"}
+			}
+		} else {
+			cur := api.ClusterId{}
+			err := decode(KindClusterId, data, &cur)
+			if err != nil {
+				return nil, errors.Wrapf(err, "unmarshal ClusterIndex: %s", id.Name)
+			}
+			if cur.Generation != id.Generation {
+				return nil, &ConflictError{
+					Key: key,
+					Reason: fmt.Sprintf(
+						"cluster modified concurrently, generation %d, expected %d",
+						cur.Generation, id.Generation,
+					),
+				}
+			}
+			prev = &cur.Spec
+		}
+		saved = id
+		saved.Generation++
+		return encode(KindClusterId, saved)
 	}
-	klog.Errorf("put object: %s", err.Error())
-	if strings.Contains(err.Error(), "NoSuchBucket") {
+	err := update(n.store, key, save)
+	if err != nil && strings.Contains(err.Error(), "NoSuchBucket") {
+		klog.Errorf("put object: %s", err.Error())
 		err = n.store.EnsureBucket(bName)
 		if err != nil {
 			return errors.Wrapf(err, "create bucket fail: %s", bName)
 		}
-		return n.store.PutObject([]byte(data), path(bName, id.Name))
+		err = update(n.store, key, save)
+	}
+	if err != nil {
+		klog.Errorf("put object: %s", err.Error())
+		return err
+	}
+	// audit trail is best effort, the change has been committed
+	err = n.record(prev, saved)
+	if err != nil {
+		klog.Warningf("record revision %d of %s: %s", saved.Generation, id.Name, err.Error())
 	}
-	return err
+	return nil
+}
+
+// UpdateCluster apply mutate on the latest ClusterId and save it,
+// retry with a fresh copy on conflict.
+func (n *ClusterIndex) UpdateCluster(
+	name string, mutate func(id *api.ClusterId) error,
+) error {
+	return retry.OnError(
+		retry.DefaultBackoff, IsConflict,
+		func() error {
+			id, err := n.GetCluster(name)
+			if err != nil {
+				return err
+			}
+			if err := mutate(&id); err != nil {
+				return err
+			}
+			return n.SaveCluster(id)
+		},
+	)
 }
+
 func (n *ClusterIndex) GetCluster(id string) (api.ClusterId, error) {
 	cid := api.ClusterId{}
 	bName := n.store.BucketName()
@@ -58,7 +118,7 @@ func (n *ClusterIndex) GetCluster(id string) (api.ClusterId, error) {
 	if err != nil {
 		return cid, errors.Wrapf(err, "get ClusterIndex: %s", n.id)
 	}
-	err = json.Unmarshal(data, &cid)
+	err = decode(KindClusterId, data, &cid)
 	if err != nil {
 		return cid, errors.Wrapf(err, "unmarshal ClusterIndex: %s", n.id)
 	}
@@ -74,22 +134,49 @@ func (n *ClusterIndex) RemoveCluster(id string) error {
 }
 
 func (n *ClusterIndex) ListCluster(selector string) ([]api.ClusterId, error) {
+	cids, _, err := n.ListClusterPage(0, "")
+	return cids, err
+}
+
+// ListClusterPage list at most limit clusters after the cluster named by
+// token, limit 0 means all. clusters are fetched lazily page by page, the
+// token of next page is returned, which is empty for the last page.
+func (n *ClusterIndex) ListClusterPage(limit int, token string) ([]api.ClusterId, string, error) {
 	var cids []api.ClusterId
 	bName := n.store.BucketName()
 	if bName == "" {
-		return cids, fmt.Errorf("oss bucket name should be provided in wdrip config")
+		return cids, "", fmt.Errorf("oss bucket name should be provided in wdrip config")
 	}
-	mlist, err := n.store.ListObject("wdrip/clusters")
-	if err != nil {
-		return cids, errors.Wrapf(err, "list clusters: %s", bName)
+	prefix, marker := "wdrip/clusters/", ""
+	if token != "" {
+		marker = fmt.Sprintf("%s%s.json", prefix, token)
 	}
-	for _, v := range mlist {
+	it := NewIterator(n.store, prefix, ".json", marker)
+	for {
+		key, ok := it.Next()
+		if !ok {
+			break
+		}
+		if limit > 0 && len(cids) == limit {
+			return cids, cids[len(cids)-1].Name, nil
+		}
+		data, err := n.store.GetObject(key)
+		if err != nil {
+			if isNotFound(err) {
+				// removed after listed
+				continue
+			}
+			return cids, "", errors.Wrapf(err, "get cluster: %s", key)
+		}
 		cid := api.ClusterId{}
-		err = json.Unmarshal(v, &cid)
+		err = decode(KindClusterId, data, &cid)
 		if err != nil {
-			return cids, errors.Wrapf(err, "unmarshal ClusterIndex")
+			return cids, "", errors.Wrapf(err, "unmarshal ClusterIndex")
 		}
 		cids = append(cids, cid)
 	}
-	return cids, nil
+	if err := it.Err(); err != nil {
+		return cids, "", errors.Wrapf(err, "list clusters: %s", bName)
+	}
+	return cids, "", nil
 }
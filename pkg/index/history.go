@@ -0,0 +1,181 @@
+package index
+
+import (
+	"fmt"
+	api "github.com/aoxn/wdrip/pkg/apis/alibabacloud.com/v1"
+	"github.com/aoxn/wdrip/pkg/utils"
+	"github.com/pkg/errors"
+	"os"
+	"os/user"
+	"reflect"
+	"strings"
+	"time"
+)
+
+// MaxRevisions revisions kept in cluster history
+const MaxRevisions = 20
+
+func hPath(bucket, name string) string {
+	return fmt.Sprintf("oss://%s/wdrip/history/%s.json", bucket, name)
+}
+
+// ClusterHistory bounded revision history of ClusterId
+type ClusterHistory struct {
+	Name      string     `json:"name,omitempty" protobuf:"bytes,1,opt,name=name"`
+	Revisions []Revision `json:"revisions,omitempty" protobuf:"bytes,2,rep,name=revisions"`
+}
+
+// Revision records who changed ClusterId with which command.
+// Spec is the ClusterId spec after the change, used for rollback.
+type Revision struct {
+	Revision  int64             `json:"revision" protobuf:"varint,1,opt,name=revision"`
+	Author    string            `json:"author,omitempty" protobuf:"bytes,2,opt,name=author"`
+	Timestamp string            `json:"timestamp,omitempty" protobuf:"bytes,3,opt,name=timestamp"`
+	Command   string            `json:"command,omitempty" protobuf:"bytes,4,opt,name=command"`
+	Diff      string            `json:"diff,omitempty" protobuf:"bytes,5,opt,name=diff"`
+	Spec      api.ClusterIdSpec `json:"spec" protobuf:"bytes,6,opt,name=spec"`
+}
+
+// FindRevision returns nil if revision has been trimmed or never exists
+func (h *ClusterHistory) FindRevision(revision int64) *Revision {
+	for k := range h.Revisions {
+		if h.Revisions[k].Revision == revision {
+			return &h.Revisions[k]
+		}
+	}
+	return nil
+}
+
+// History returns revision history of cluster, oldest first
+func (n *ClusterIndex) History(name string) (*ClusterHistory, error) {
+	bName := n.store.BucketName()
+	if bName == "" {
+		return nil, fmt.Errorf("oss bucket name should be provided in wdrip config")
+	}
+	history := &ClusterHistory{Name: name}
+	data, err := n.store.GetObject(hPath(bName, name))
+	if err != nil {
+		if isNotFound(err) {
+			return history, nil
+		}
+		return nil, errors.Wrapf(err, "get history: %s", name)
+	}
+	err = decode(KindHistory, data, history)
+	if err != nil {
+		return nil, errors.Wrapf(err, "unmarshal history: %s", name)
+	}
+	return history, nil
+}
+
+// Rollback restore ClusterId spec to the given revision, which is
+// recorded as a new revision.
+func (n *ClusterIndex) Rollback(name string, revision int64) error {
+	history, err := n.History(name)
+	if err != nil {
+		return err
+	}
+	rev := history.FindRevision(revision)
+	if rev == nil {
+		return fmt.Errorf("revision %d not found in history of %s", revision, name)
+	}
+	return n.UpdateCluster(
+		name,
+		func(id *api.ClusterId) error {
+			created := id.Spec.CreatedAt
+			id.Spec = rev.Spec
+			id.Spec.CreatedAt = created
+			id.Spec.UpdatedAt = time.Now().Format("2006-01-02T15:04:05")
+			return nil
+		},
+	)
+}
+
+// record append revision of saved ClusterId to history, a save that
+// does not change the spec is not recorded.
+func (n *ClusterIndex) record(prev *api.ClusterIdSpec, saved api.ClusterId) error {
+	if prev != nil && reflect.DeepEqual(*prev, saved.Spec) {
+		return nil
+	}
+	bName := n.store.BucketName()
+	before := ""
+	if prev != nil {
+		before = utils.PrettyYaml(prev)
+	}
+	diff := lineDiff(before, utils.PrettyYaml(saved.Spec))
+	if diff == "" {
+		return nil
+	}
+	rev := Revision{
+		Revision:  saved.Generation,
+		Author:    author(),
+		Timestamp: time.Now().Format(timeFormat),
+		Command:   strings.Join(os.Args, " "),
+		Diff:      diff,
+		Spec:      saved.Spec,
+	}
+	return update(
+		n.store, hPath(bName, saved.Name),
+		func(data []byte) ([]byte, error) {
+			history := &ClusterHistory{Name: saved.Name}
+			if data != nil {
+				if err := decode(KindHistory, data, history); err != nil {
+					return nil, errors.Wrapf(err, "unmarshal history")
+				}
+			}
+			history.Revisions = append(history.Revisions, rev)
+			if len(history.Revisions) > MaxRevisions {
+				history.Revisions = history.Revisions[len(history.Revisions)-MaxRevisions:]
+			}
+			return encode(KindHistory, history)
+		},
+	)
+}
+
+func author() string {
+	host, _ := os.Hostname()
+	u, err := user.Current()
+	if err != nil {
+		return host
+	}
+	return fmt.Sprintf("%s@%s", u.Username, host)
+}
+
+// lineDiff returns lines removed from a with "-" and lines added in b
+// with "+", based on longest common subsequence of lines.
+func lineDiff(a, b string) string {
+	x, y := strings.Split(a, "\n"), strings.Split(b, "\n")
+	if a == "" {
+		x = nil
+	}
+	lcs := make([][]int, len(x)+1)
+	for i := range lcs {
+		lcs[i] = make([]int, len(y)+1)
+	}
+	for i := len(x) - 1; i >= 0; i-- {
+		for j := len(y) - 1; j >= 0; j-- {
+			if x[i] == y[j] {
+				lcs[i][j] = lcs[i+1][j+1] + 1
+			} else if lcs[i+1][j] >= lcs[i][j+1] {
+				lcs[i][j] = lcs[i+1][j]
+			} else {
+				lcs[i][j] = lcs[i][j+1]
+			}
+		}
+	}
+	var out []string
+	i, j := 0, 0
+	for i < len(x) || j < len(y) {
+		switch {
+		case i < len(x) && j < len(y) && x[i] == y[j]:
+			i++
+			j++
+		case i < len(x) && (j == len(y) || lcs[i+1][j] >= lcs[i][j+1]):
+			out = append(out, "- "+x[i])
+			i++
+		default:
+			out = append(out, "+ "+y[j])
+			j++
+		}
+	}
+	return strings.Join(out, "\n")
+}
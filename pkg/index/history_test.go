@@ -0,0 +1,70 @@
+package index
+
+import (
+	"fmt"
+	api "github.com/aoxn/wdrip/pkg/apis/alibabacloud.com/v1"
+	"github.com/stretchr/testify/assert"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"testing"
+)
+
+func TestLineDiff(t *testing.T) {
+	assert.Equal(t, "- b: 2\n+ b: 3\n+ c: 4", lineDiff("a: 1\nb: 2", "a: 1\nb: 3\nc: 4"))
+	assert.Equal(t, "+ a: 1", lineDiff("", "a: 1"))
+}
+
+func TestHistoryRollback(t *testing.T) {
+	idx := NewClusterIndex("kubernetes-wdrip-64", newVersionedStore())
+	id := api.ClusterId{ObjectMeta: metav1.ObjectMeta{Name: "kubernetes-wdrip-64"}}
+	id.Spec.Cluster.Etcd.Version = "v3.4.3"
+	assert.Nil(t, idx.SaveCluster(id))
+	for i := 0; i < MaxRevisions; i++ {
+		assert.Nil(t, idx.UpdateCluster(
+			"kubernetes-wdrip-64",
+			func(id *api.ClusterId) error {
+				id.Spec.Cluster.Etcd.Version = fmt.Sprintf("v3.5.%d", i)
+				return nil
+			},
+		))
+	}
+	history, err := idx.History("kubernetes-wdrip-64")
+	assert.Nil(t, err)
+	assert.Equal(t, MaxRevisions, len(history.Revisions))
+	assert.Nil(t, history.FindRevision(1))
+	assert.Contains(t, history.FindRevision(2).Diff, "version: v3.5.0")
+
+	assert.NotNil(t, idx.Rollback("kubernetes-wdrip-64", 1))
+	// rollback to v3.5.0 then edit back by hand, rollback again
+	mid, _ := idx.GetCluster("kubernetes-wdrip-64")
+	mid.Spec.Cluster.Etcd.Version = "v3.4.3"
+	assert.Nil(t, idx.SaveCluster(mid))
+	assert.Nil(t, idx.Rollback("kubernetes-wdrip-64", 3))
+	mid, _ = idx.GetCluster("kubernetes-wdrip-64")
+	assert.Equal(t, "v3.5.1", mid.Spec.Cluster.Etcd.Version)
+}
+
+func TestHistorySkipNoop(t *testing.T) {
+	idx := NewClusterIndex("kubernetes-wdrip-64", newVersionedStore())
+	id := api.ClusterId{ObjectMeta: metav1.ObjectMeta{Name: "kubernetes-wdrip-64"}}
+	id.Spec.Cluster.Etcd.Version = "v3.4.3"
+	assert.Nil(t, idx.SaveCluster(id))
+	assert.Nil(t, idx.UpdateCluster(
+		"kubernetes-wdrip-64",
+		func(id *api.ClusterId) error { return nil },
+	))
+	history, err := idx.History("kubernetes-wdrip-64")
+	assert.Nil(t, err)
+	assert.Equal(t, 1, len(history.Revisions))
+
+	assert.Nil(t, idx.UpdateCluster(
+		"kubernetes-wdrip-64",
+		func(id *api.ClusterId) error {
+			id.Spec.Cluster.Etcd.Version = "v3.5.0"
+			return nil
+		},
+	))
+	history, err = idx.History("kubernetes-wdrip-64")
+	assert.Nil(t, err)
+	assert.Equal(t, 2, len(history.Revisions))
+	assert.Equal(t, int64(3), history.Revisions[1].Revision)
+}
@@ -1,14 +1,18 @@
 package index
 
 import (
+	"fmt"
 	pd "github.com/aoxn/wdrip/pkg/iaas/provider"
+	"strings"
 )
 
 func NewGenericIndexer(
 	id string, store pd.ObjectStorage,
 ) *GenericIndexer {
-
+	// writes are replicated to secondary storages if configured
+	store = withReplicas(store)
 	return &GenericIndexer{
+		store:         store,
 		SnapshotIndex: NewSnapshotIndex(id, store),
 		ClusterIndex:  NewClusterIndex(id, store),
 		NodePoolIndex: NewNodePoolIndex(id, store),
@@ -16,7 +20,44 @@ func NewGenericIndexer(
 }
 
 type GenericIndexer struct {
+	store pd.ObjectStorage
 	*SnapshotIndex
 	*ClusterIndex
 	*NodePoolIndex
 }
+
+// VerifyReplicas repair cluster index, nodepool index and backups on
+// replicas which asynchronous replication failed to deliver.
+func (g *GenericIndexer) VerifyReplicas() error {
+	rs, ok := g.store.(*replicaStore)
+	if !ok {
+		return nil
+	}
+	var errs []string
+	// backups go first, because index.json records replicated backups
+	if err := g.SnapshotIndex.verifyReplicas(rs); err != nil {
+		errs = append(errs, err.Error())
+	}
+	bName := g.store.BucketName()
+	keys := []string{
+		path(bName, g.ClusterIndex.id),
+		hPath(bName, g.ClusterIndex.id),
+		g.SnapshotIndex.snapshot.IndexLocation(),
+	}
+	pools, err := g.ListNodePools("")
+	if err != nil {
+		errs = append(errs, err.Error())
+	}
+	for _, np := range pools {
+		keys = append(keys, nPath(bName, g.NodePoolIndex.cid, np.Name))
+	}
+	for _, key := range keys {
+		if err := rs.syncObject(key); err != nil {
+			errs = append(errs, err.Error())
+		}
+	}
+	if len(errs) != 0 {
+		return fmt.Errorf("verify replicas: %s", strings.Join(errs, "; "))
+	}
+	return nil
+}
@@ -0,0 +1,68 @@
+package index
+
+import (
+	pd "github.com/aoxn/wdrip/pkg/iaas/provider"
+	"strings"
+)
+
+// pageSize keys fetched per listing request
+const pageSize = 100
+
+// Iterator iterate keys under prefix lazily, keys are listed page
+// by page on demand. only keys with the given suffix are returned,
+// which skips lock objects and other sidecar objects.
+type Iterator struct {
+	store  pd.ObjectStorage
+	prefix string
+	suffix string
+	option pd.ListOption
+	keys   []string
+	last   string
+	done   bool
+	err    error
+}
+
+// NewIterator iterate keys under prefix after marker. keys under
+// sub-directories of prefix are skipped.
+func NewIterator(store pd.ObjectStorage, prefix, suffix, marker string) *Iterator {
+	return &Iterator{
+		store:  store,
+		prefix: prefix,
+		suffix: suffix,
+		last:   marker,
+		option: pd.ListOption{Delimiter: "/", Marker: marker, MaxKeys: pageSize},
+	}
+}
+
+// Next returns the next key. false is returned when there are no more
+// keys or listing failed, see Err.
+func (it *Iterator) Next() (string, bool) {
+	for len(it.keys) == 0 {
+		if it.done || it.err != nil {
+			return "", false
+		}
+		page, err := it.store.ListPage(it.prefix, it.option)
+		if err != nil {
+			it.err = err
+			return "", false
+		}
+		for _, key := range page.Keys {
+			if strings.HasSuffix(key, it.suffix) {
+				it.keys = append(it.keys, key)
+			}
+		}
+		it.option.Marker = page.NextMarker
+		it.done = page.NextMarker == ""
+	}
+	key := it.keys[0]
+	it.keys = it.keys[1:]
+	it.last = key
+	return key, true
+}
+
+// Err returns the error which stopped the iteration
+func (it *Iterator) Err() error { return it.err }
+
+// Continue returns the token to resume iteration after the last
+// returned key, see NewIterator.
+func (it *Iterator) Continue() string { return it.last }
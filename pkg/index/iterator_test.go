@@ -0,0 +1,45 @@
+package index
+
+import (
+	"fmt"
+	api "github.com/aoxn/wdrip/pkg/apis/alibabacloud.com/v1"
+	"github.com/stretchr/testify/assert"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"testing"
+)
+
+func TestListClusterPage(t *testing.T) {
+	store := newMemStore()
+	for i := 0; i < 2*pageSize+5; i++ {
+		name := fmt.Sprintf("kubernetes-wdrip-%03d", i)
+		id := api.ClusterId{ObjectMeta: metav1.ObjectMeta{Name: name}}
+		assert.Nil(t, NewClusterIndex(name, store).SaveCluster(id))
+	}
+	// left over lock object and history are not clusters
+	assert.Nil(t, store.PutObject([]byte("lock"), "wdrip/clusters/kubernetes-wdrip-000.json.lock"))
+
+	idx := NewClusterIndex("", store)
+	all, err := idx.ListCluster("")
+	assert.Nil(t, err)
+	assert.Equal(t, 2*pageSize+5, len(all))
+
+	var (
+		names []string
+		token string
+	)
+	for {
+		page, next, err := idx.ListClusterPage(30, token)
+		assert.Nil(t, err)
+		assert.True(t, len(page) <= 30)
+		for _, id := range page {
+			names = append(names, id.Name)
+		}
+		if next == "" {
+			break
+		}
+		token = next
+	}
+	assert.Equal(t, 2*pageSize+5, len(names))
+	assert.Equal(t, "kubernetes-wdrip-000", names[0])
+	assert.Equal(t, fmt.Sprintf("kubernetes-wdrip-%03d", 2*pageSize+4), names[len(names)-1])
+}
@@ -1,11 +1,9 @@
 package index
 
 import (
-	"encoding/json"
 	"fmt"
 	api "github.com/aoxn/wdrip/pkg/apis/alibabacloud.com/v1"
 	pd "github.com/aoxn/wdrip/pkg/iaas/provider"
-	"github.com/aoxn/wdrip/pkg/utils"
 	"github.com/pkg/errors"
 	"k8s.io/klog/v2"
 	"strings"
@@ -18,7 +16,7 @@ func nPath(bucket, id, name string) string {
 func NewNodePoolIndex(
 	cid string, store pd.ObjectStorage,
 ) *NodePoolIndex {
-	return &NodePoolIndex{cid: cid, store: store}
+	return &NodePoolIndex{cid: cid, store: withReplicas(store)}
 }
 
 type NodePoolIndex struct {
@@ -32,9 +30,12 @@ func (n *NodePoolIndex) SaveNodePool(id api.NodePool) error {
 		return fmt.Errorf("oss bucket name should be provided in wdrip config")
 	}
 
-	data := utils.PrettyJson(id)
+	data, err := encode(KindNodePool, id)
+	if err != nil {
+		return err
+	}
 	klog.Infof("trying to save NodePoolIndex id to remote bucket: %s", id.Name)
-	err := n.store.PutObject([]byte(data), nPath(bName, n.cid, id.Name))
+	err = n.store.PutObject(data, nPath(bName, n.cid, id.Name))
 	if err == nil {
 		return nil
 	}
@@ -44,7 +45,7 @@ func (n *NodePoolIndex) SaveNodePool(id api.NodePool) error {
 		if err != nil {
 			return errors.Wrapf(err, "create bucket fail: %s", bName)
 		}
-		return n.store.PutObject([]byte(data), nPath(bName, n.cid, id.Name))
+		return n.store.PutObject(data, nPath(bName, n.cid, id.Name))
 	}
 	return err
 }
@@ -58,7 +59,7 @@ func (n *NodePoolIndex) GetNodePool(id string) (api.NodePool, error) {
 	if err != nil {
 		return cid, errors.Wrapf(err, "get NodePoolIndex: %s", id)
 	}
-	err = json.Unmarshal(data, &cid)
+	err = decode(KindNodePool, data, &cid)
 	if err != nil {
 		return cid, errors.Wrapf(err, "unmarshal NodePoolIndex: %s", id)
 	}
@@ -79,17 +80,28 @@ func (n *NodePoolIndex) ListNodePools(selector string) ([]api.NodePool, error) {
 	if bName == "" {
 		return cids, fmt.Errorf("oss bucket name should be provided in wdrip config")
 	}
-	mlist, err := n.store.ListObject(fmt.Sprintf("wdrip/nodepools/%s", n.cid))
-	if err != nil {
-		return cids, errors.Wrapf(err, "list clusters: %s", bName)
-	}
-	for _, v := range mlist {
+	it := NewIterator(n.store, fmt.Sprintf("wdrip/nodepools/%s/", n.cid), ".json", "")
+	for {
+		key, ok := it.Next()
+		if !ok {
+			break
+		}
+		data, err := n.store.GetObject(key)
+		if err != nil {
+			if isNotFound(err) {
+				continue
+			}
+			return cids, errors.Wrapf(err, "get nodepool: %s", key)
+		}
 		cid := api.NodePool{}
-		err = json.Unmarshal(v, &cid)
+		err = decode(KindNodePool, data, &cid)
 		if err != nil {
 			return cids, errors.Wrapf(err, "unmarshal NodePoolIndex")
 		}
 		cids = append(cids, cid)
 	}
+	if err := it.Err(); err != nil {
+		return cids, errors.Wrapf(err, "list nodepools: %s", bName)
+	}
 	return cids, nil
 }
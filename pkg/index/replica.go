@@ -0,0 +1,282 @@
+package index
+
+import (
+	"bytes"
+	"fmt"
+	pd "github.com/aoxn/wdrip/pkg/iaas/provider"
+	"github.com/pkg/errors"
+	"io"
+	"k8s.io/klog/v2"
+	"strings"
+	"sync"
+	"time"
+)
+
+// replicating tracks in flight replication, see WaitReplication
+var replicating sync.WaitGroup
+
+// WaitReplication wait for in flight replication to finish before
+// process exit. replication left behind is repaired by the verifier.
+func WaitReplication(timeout time.Duration) {
+	done := make(chan struct{})
+	go func() {
+		replicating.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(timeout):
+		klog.Warningf("wait replication timeout after %s, left for verifier", timeout)
+	}
+}
+
+// withReplicas wrap store with its secondary replicas if any
+func withReplicas(store pd.ObjectStorage) pd.ObjectStorage {
+	if _, ok := store.(*replicaStore); ok {
+		return store
+	}
+	r, ok := store.(pd.ReplicatedStorage)
+	if !ok || len(r.Replicas()) == 0 {
+		return store
+	}
+	return &replicaStore{ObjectStorage: store, replicas: r.Replicas()}
+}
+
+// replicaStore write to primary storage synchronously and replicate
+// the write to secondary storages asynchronously. read fall back to
+// replicas in order when primary storage is unavailable.
+type replicaStore struct {
+	pd.ObjectStorage
+	replicas []pd.ObjectStorage
+}
+
+// relative strip oss://bucket/ from key, replica use its own bucket
+func relative(key string) string {
+	if !strings.HasPrefix(key, "oss://") {
+		return key
+	}
+	segs := strings.SplitN(strings.TrimPrefix(key, "oss://"), "/", 2)
+	if len(segs) < 2 {
+		return key
+	}
+	return segs[1]
+}
+
+func isNotFound(err error) bool {
+	return err != nil && strings.Contains(err.Error(), "NoSuchKey")
+}
+
+func (r *replicaStore) replicate(key string, write func(replica pd.ObjectStorage) error) {
+	for _, replica := range r.replicas {
+		replicating.Add(1)
+		go func(replica pd.ObjectStorage) {
+			defer replicating.Done()
+			err := write(replica)
+			if err != nil && strings.Contains(err.Error(), "NoSuchBucket") {
+				err = replica.EnsureBucket(replica.BucketName())
+				if err == nil {
+					err = write(replica)
+				}
+			}
+			if err != nil {
+				klog.Warningf("replicate [%s] to [%s]: %s", key, replica.BucketName(), err.Error())
+			}
+		}(replica)
+	}
+}
+
+func (r *replicaStore) PutObject(b []byte, dst string) error {
+	if err := r.ObjectStorage.PutObject(b, dst); err != nil {
+		return err
+	}
+	r.replicate(dst, func(replica pd.ObjectStorage) error {
+		return replica.PutObject(b, relative(dst))
+	})
+	return nil
+}
+
+func (r *replicaStore) PutFile(src, dst string) error {
+	if err := r.ObjectStorage.PutFile(src, dst); err != nil {
+		return err
+	}
+	r.replicate(dst, func(replica pd.ObjectStorage) error {
+		return replica.PutFile(src, relative(dst))
+	})
+	return nil
+}
+
+func (r *replicaStore) DeleteObject(f string) error {
+	if err := r.ObjectStorage.DeleteObject(f); err != nil {
+		return err
+	}
+	r.replicate(f, func(replica pd.ObjectStorage) error {
+		return replica.DeleteObject(relative(f))
+	})
+	return nil
+}
+
+func (r *replicaStore) GetObject(src string) ([]byte, error) {
+	data, err := r.ObjectStorage.GetObject(src)
+	if err == nil || isNotFound(err) {
+		return data, err
+	}
+	for _, replica := range r.replicas {
+		klog.Warningf("get [%s] from primary storage: %s, fall back to replica [%s]",
+			src, err.Error(), replica.BucketName())
+		data, rerr := replica.GetObject(relative(src))
+		if rerr == nil {
+			return data, nil
+		}
+		klog.Warningf("get [%s] from replica [%s]: %s", src, replica.BucketName(), rerr.Error())
+	}
+	return nil, err
+}
+
+func (r *replicaStore) GetFile(src, dst string) error {
+	err := r.ObjectStorage.GetFile(src, dst)
+	if err == nil || isNotFound(err) {
+		return err
+	}
+	for _, replica := range r.replicas {
+		klog.Warningf("get file [%s] from primary storage: %s, fall back to replica [%s]",
+			src, err.Error(), replica.BucketName())
+		rerr := replica.GetFile(relative(src), dst)
+		if rerr == nil {
+			return nil
+		}
+		klog.Warningf("get file [%s] from replica [%s]: %s", src, replica.BucketName(), rerr.Error())
+	}
+	return err
+}
+
+func (r *replicaStore) ListObject(prefix string) ([][]byte, error) {
+	data, err := r.ObjectStorage.ListObject(prefix)
+	if err == nil {
+		return data, nil
+	}
+	for _, replica := range r.replicas {
+		klog.Warningf("list [%s] from primary storage: %s, fall back to replica [%s]",
+			prefix, err.Error(), replica.BucketName())
+		data, rerr := replica.ListObject(prefix)
+		if rerr == nil {
+			return data, nil
+		}
+		klog.Warningf("list [%s] from replica [%s]: %s", prefix, replica.BucketName(), rerr.Error())
+	}
+	return nil, err
+}
+
+func (r *replicaStore) ListPage(prefix string, opt pd.ListOption) (*pd.ListResult, error) {
+	page, err := r.ObjectStorage.ListPage(prefix, opt)
+	if err == nil {
+		return page, nil
+	}
+	for _, replica := range r.replicas {
+		klog.Warningf("list page [%s] from primary storage: %s, fall back to replica [%s]",
+			prefix, err.Error(), replica.BucketName())
+		page, rerr := replica.ListPage(prefix, opt)
+		if rerr == nil {
+			return page, nil
+		}
+		klog.Warningf("list page [%s] from replica [%s]: %s", prefix, replica.BucketName(), rerr.Error())
+	}
+	return nil, err
+}
+
+// syncObject copy small object from primary to replicas when it is
+// missing or different on replica.
+func (r *replicaStore) syncObject(key string) error {
+	data, err := r.ObjectStorage.GetObject(key)
+	if err != nil {
+		if isNotFound(err) {
+			return nil
+		}
+		return errors.Wrapf(err, "get primary object: %s", key)
+	}
+	var errs []string
+	for _, replica := range r.replicas {
+		rdata, err := replica.GetObject(relative(key))
+		if err == nil && bytes.Equal(data, rdata) {
+			continue
+		}
+		klog.Infof("verifier: replica [%s] out of date, sync %s", replica.BucketName(), key)
+		err = replica.PutObject(data, relative(key))
+		if err != nil {
+			errs = append(errs, fmt.Sprintf("%s: %s", replica.BucketName(), err.Error()))
+		}
+	}
+	if len(errs) != 0 {
+		return fmt.Errorf("sync %s: %s", key, strings.Join(errs, "; "))
+	}
+	return nil
+}
+
+// copyTo stream object from primary to the given replica, which is
+// the way backups are transferred. nothing is buffered on local disk.
+func (r *replicaStore) copyTo(key string, replica pd.ObjectStorage, opt pd.TransferOption) error {
+	pr, pw := io.Pipe()
+	go func() {
+		_, err := r.ObjectStorage.GetStream(key, pw, 0, pd.TransferOption{MaxSize: opt.MaxSize})
+		_ = pw.CloseWithError(err)
+	}()
+	err := replica.PutStream(pr, -1, relative(key), opt)
+	// unblock the reading side when upload failed halfway
+	_ = pr.CloseWithError(err)
+	if err != nil {
+		return errors.Wrapf(err, "copy %s to %s", key, replica.BucketName())
+	}
+	return nil
+}
+
+func (r *replicaStore) PutStream(rd io.Reader, size int64, dst string, opt pd.TransferOption) error {
+	if err := r.ObjectStorage.PutStream(rd, size, dst, opt); err != nil {
+		return err
+	}
+	// reader is consumed, replicas copy from primary instead
+	r.replicate(dst, func(replica pd.ObjectStorage) error {
+		return r.copyTo(dst, replica, pd.TransferOption{MaxSize: opt.MaxSize})
+	})
+	return nil
+}
+
+func (r *replicaStore) GetStream(
+	src string, w io.Writer, offset int64, opt pd.TransferOption,
+) (int64, error) {
+	n, err := r.ObjectStorage.GetStream(src, w, offset, opt)
+	if err == nil || isNotFound(err) || n > 0 {
+		// partial content is resumed by the caller from primary
+		return n, err
+	}
+	for _, replica := range r.replicas {
+		klog.Warningf("get stream [%s] from primary storage: %s, fall back to replica [%s]",
+			src, err.Error(), replica.BucketName())
+		n, rerr := replica.GetStream(relative(src), w, offset, opt)
+		if rerr == nil || n > 0 {
+			return n, rerr
+		}
+		klog.Warningf("get stream [%s] from replica [%s]: %s", src, replica.BucketName(), rerr.Error())
+	}
+	return 0, err
+}
+
+func (r *replicaStore) GetObjectVersion(src string) ([]byte, string, error) {
+	cs, ok := r.ObjectStorage.(pd.ConditionalStorage)
+	if !ok {
+		return nil, "", fmt.Errorf("conditional write not supported by primary storage")
+	}
+	return cs.GetObjectVersion(src)
+}
+
+func (r *replicaStore) PutObjectIfMatch(b []byte, dst, version string) error {
+	cs, ok := r.ObjectStorage.(pd.ConditionalStorage)
+	if !ok {
+		return fmt.Errorf("conditional write not supported by primary storage")
+	}
+	if err := cs.PutObjectIfMatch(b, dst, version); err != nil {
+		return err
+	}
+	r.replicate(dst, func(replica pd.ObjectStorage) error {
+		return replica.PutObject(b, relative(dst))
+	})
+	return nil
+}
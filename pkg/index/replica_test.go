@@ -0,0 +1,67 @@
+package index
+
+import (
+	"fmt"
+	api "github.com/aoxn/wdrip/pkg/apis/alibabacloud.com/v1"
+	pd "github.com/aoxn/wdrip/pkg/iaas/provider"
+	"github.com/stretchr/testify/assert"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"testing"
+	"time"
+)
+
+type replicatedStore struct {
+	*memStore
+	down     bool
+	replicas []pd.ObjectStorage
+}
+
+func (m *replicatedStore) Replicas() []pd.ObjectStorage { return m.replicas }
+
+func (m *replicatedStore) GetObject(src string) ([]byte, error) {
+	if m.down {
+		return nil, fmt.Errorf("RequestTimeout: %s", src)
+	}
+	return m.memStore.GetObject(src)
+}
+
+func TestReplicaFallback(t *testing.T) {
+	replica := newMemStore()
+	primary := &replicatedStore{memStore: newMemStore(), replicas: []pd.ObjectStorage{replica}}
+	idx := NewGenericIndexer("kubernetes-wdrip-64", primary)
+
+	id := api.ClusterId{ObjectMeta: metav1.ObjectMeta{Name: "kubernetes-wdrip-64"}}
+	assert.Nil(t, idx.SaveCluster(id))
+	WaitReplication(time.Second)
+	_, err := replica.GetObject("wdrip/clusters/kubernetes-wdrip-64.json")
+	assert.Nil(t, err)
+
+	primary.down = true
+	mid, err := idx.GetCluster("kubernetes-wdrip-64")
+	assert.Nil(t, err)
+	assert.Equal(t, "kubernetes-wdrip-64", mid.Name)
+}
+
+func TestVerifyReplicas(t *testing.T) {
+	file := seedSnapshot(t)
+	primary := &replicatedStore{memStore: newMemStore()}
+	// replica configured after backups were taken
+	idx := NewGenericIndexer("kubernetes-wdrip-64", primary.memStore)
+	idx.WithSnapshotFile(file)
+	assert.Nil(t, idx.BackupWithName(api.ClusterSpec{}, "pre-upgrade"))
+
+	replica := newMemStore()
+	primary.replicas = []pd.ObjectStorage{replica}
+	idx = NewGenericIndexer("kubernetes-wdrip-64", primary)
+	assert.Nil(t, idx.VerifyReplicas())
+	WaitReplication(time.Second)
+
+	b, err := idx.GetBackup("pre-upgrade")
+	assert.Nil(t, err)
+	assert.True(t, b.ReplicatedTo(replica.BucketName()))
+	snapshot, _ := idx.Snapshot()
+	_, err = replica.GetObject(snapshot.Path(*b))
+	assert.Nil(t, err)
+	_, err = replica.GetObject(snapshot.IndexLocation())
+	assert.Nil(t, err)
+}
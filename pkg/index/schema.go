@@ -0,0 +1,224 @@
+package index
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	pd "github.com/aoxn/wdrip/pkg/iaas/provider"
+	"github.com/aoxn/wdrip/pkg/utils"
+	"github.com/pkg/errors"
+	"k8s.io/klog/v2"
+	"sort"
+	"strconv"
+)
+
+// Kinds of object stored in the index
+const (
+	KindClusterId = "ClusterId"
+	KindNodePool  = "NodePool"
+	KindSnapshot  = "Snapshot"
+	KindHistory   = "ClusterHistory"
+
+	// schemaVersionKey top level field of stored object which records
+	// the schema version it is written in. object without it was
+	// written before versioning and is in version 1.
+	schemaVersionKey = "schemaVersion"
+)
+
+// Migration upgrade stored object of Kind from schema version From to
+// From+1. Migrate works on the decoded json object, so that fields
+// removed or renamed in types.go can still be read.
+type Migration struct {
+	Kind        string
+	From        int
+	Description string
+	Migrate     func(object map[string]interface{}) error
+}
+
+// migrations registered migrations of each kind in version order
+var migrations = map[string][]Migration{}
+
+// RegisterMigration register the migration of kind to the next schema
+// version, migrations of a kind must be registered in version order.
+// a change to the stored types which older wdrip can not read as is,
+// eg. field renamed, needs a migration and a golden file in testdata.
+func RegisterMigration(m Migration) {
+	if m.From != SchemaVersion(m.Kind) {
+		panic(fmt.Sprintf("migration of %s from version %d registered out of order, expect %d",
+			m.Kind, m.From, SchemaVersion(m.Kind)))
+	}
+	migrations[m.Kind] = append(migrations[m.Kind], m)
+}
+
+// SchemaVersion current schema version of kind
+func SchemaVersion(kind string) int { return len(migrations[kind]) + 1 }
+
+func init() {
+	RegisterMigration(
+		Migration{
+			Kind:        KindSnapshot,
+			From:        1,
+			Description: "backups record createdAt in UTC",
+			// identities of older backups were written in the local time
+			// of whoever took them, which is not known. no createdAt is
+			// derived from them, Backup.Time reads them as UTC instead.
+			Migrate: func(object map[string]interface{}) error { return nil },
+		},
+	)
+}
+
+// encode marshal object of kind with current schema version
+func encode(kind string, obj interface{}) ([]byte, error) {
+	data, err := json.Marshal(obj)
+	if err != nil {
+		return nil, errors.Wrapf(err, "marshal %s", kind)
+	}
+	object, err := unmarshal(data)
+	if err != nil {
+		return nil, err
+	}
+	object[schemaVersionKey] = SchemaVersion(kind)
+	return []byte(utils.PrettyJson(object)), nil
+}
+
+// decode upgrade stored object to current schema version and
+// unmarshal it into obj
+func decode(kind string, data []byte, obj interface{}) error {
+	object, _, err := migrate(kind, data)
+	if err != nil {
+		return err
+	}
+	data, err = json.Marshal(object)
+	if err != nil {
+		return errors.Wrapf(err, "marshal %s", kind)
+	}
+	return json.Unmarshal(data, obj)
+}
+
+func unmarshal(data []byte) (map[string]interface{}, error) {
+	object := map[string]interface{}{}
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	// keep int64 like generation precise
+	decoder.UseNumber()
+	if err := decoder.Decode(&object); err != nil {
+		return nil, errors.Wrapf(err, "decode object")
+	}
+	return object, nil
+}
+
+// migrate upgrade stored object of kind to current schema version,
+// returns the upgraded object and the version it was stored in. object
+// written by newer wdrip is refused, rewriting it with an older schema
+// would lose data.
+func migrate(kind string, data []byte) (map[string]interface{}, int, error) {
+	object, err := unmarshal(data)
+	if err != nil {
+		return nil, 0, errors.Wrapf(err, "decode %s", kind)
+	}
+	version := 1
+	if v, ok := object[schemaVersionKey]; ok {
+		version, err = strconv.Atoi(fmt.Sprintf("%v", v))
+		if err != nil || version < 1 {
+			return nil, 0, fmt.Errorf("invalid schema version of %s: %v", kind, v)
+		}
+	}
+	if version > SchemaVersion(kind) {
+		return nil, version, fmt.Errorf(
+			"%s written in schema version %d, newer than %d supported, upgrade wdrip",
+			kind, version, SchemaVersion(kind),
+		)
+	}
+	for _, m := range migrations[kind][version-1:] {
+		if err := m.Migrate(object); err != nil {
+			return nil, version, errors.Wrapf(err, "migrate %s from version %d", kind, m.From)
+		}
+	}
+	object[schemaVersionKey] = SchemaVersion(kind)
+	return object, version, nil
+}
+
+// MigrateResult stored object upgraded by Migrate
+type MigrateResult struct {
+	Key  string
+	Kind string
+	From int
+	To   int
+}
+
+// migrateObject upgrade stored object at key to current schema version
+// in place. nothing is written in dry run or when it is up to date.
+func migrateObject(store pd.ObjectStorage, kind, key string, dryRun bool) (*MigrateResult, error) {
+	result := &MigrateResult{Key: key, Kind: kind, To: SchemaVersion(kind)}
+	if dryRun {
+		data, err := store.GetObject(key)
+		if err != nil {
+			if isNotFound(err) {
+				return nil, nil
+			}
+			return nil, errors.Wrapf(err, "get %s", key)
+		}
+		_, result.From, err = migrate(kind, data)
+		return result, err
+	}
+	found := false
+	err := update(
+		store, key,
+		func(data []byte) ([]byte, error) {
+			found = data != nil
+			if !found {
+				return nil, nil
+			}
+			object, from, err := migrate(kind, data)
+			result.From = from
+			if err != nil || from == result.To {
+				return nil, err
+			}
+			return []byte(utils.PrettyJson(object)), nil
+		},
+	)
+	if err != nil || !found {
+		return nil, err
+	}
+	return result, nil
+}
+
+// Migrate upgrade all objects of the cluster to current schema version,
+// which are upgraded on read anyway. the results are sorted by key.
+func (g *GenericIndexer) Migrate(dryRun bool) ([]MigrateResult, error) {
+	bName := g.store.BucketName()
+	if bName == "" {
+		return nil, fmt.Errorf("oss bucket name should be provided in wdrip config")
+	}
+	objects := map[string]string{
+		path(bName, g.ClusterIndex.id):           KindClusterId,
+		hPath(bName, g.ClusterIndex.id):          KindHistory,
+		g.SnapshotIndex.snapshot.IndexLocation(): KindSnapshot,
+	}
+	it := NewIterator(g.store, fmt.Sprintf("wdrip/nodepools/%s/", g.NodePoolIndex.cid), ".json", "")
+	for {
+		key, ok := it.Next()
+		if !ok {
+			break
+		}
+		objects[key] = KindNodePool
+	}
+	if err := it.Err(); err != nil {
+		return nil, errors.Wrapf(err, "list nodepools")
+	}
+	var results []MigrateResult
+	for key, kind := range objects {
+		result, err := migrateObject(g.store, kind, key, dryRun)
+		if err != nil {
+			return results, errors.Wrapf(err, "migrate %s", key)
+		}
+		if result == nil {
+			continue
+		}
+		if !dryRun && result.From != result.To {
+			klog.Infof("migrated %s [%s] from schema version %d to %d", kind, key, result.From, result.To)
+		}
+		results = append(results, *result)
+	}
+	sort.Slice(results, func(i, j int) bool { return results[i].Key < results[j].Key })
+	return results, nil
+}
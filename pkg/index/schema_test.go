@@ -0,0 +1,79 @@
+package index
+
+import (
+	"flag"
+	"fmt"
+	api "github.com/aoxn/wdrip/pkg/apis/alibabacloud.com/v1"
+	"github.com/aoxn/wdrip/pkg/utils"
+	"github.com/stretchr/testify/assert"
+	"io/ioutil"
+	"path/filepath"
+	"testing"
+)
+
+var updateGolden = flag.Bool("update", false, "update golden files of schema migration")
+
+// TestMigrateGolden upgrade each historical shape in testdata/schema,
+// named <Kind>-v<version>.json, and compare with its golden file.
+func TestMigrateGolden(t *testing.T) {
+	kinds := map[string]interface{}{
+		KindClusterId: &api.ClusterId{},
+		KindNodePool:  &api.NodePool{},
+		KindSnapshot:  &Snapshot{},
+		KindHistory:   &ClusterHistory{},
+	}
+	for kind, obj := range kinds {
+		for version := 1; version <= SchemaVersion(kind); version++ {
+			name := filepath.Join("testdata", "schema", fmt.Sprintf("%s-v%d", kind, version))
+			data, err := ioutil.ReadFile(name + ".json")
+			if !assert.Nil(t, err, "historical shape of %s in version %d", kind, version) {
+				continue
+			}
+			object, from, err := migrate(kind, data)
+			assert.Nil(t, err)
+			assert.Equal(t, version, from)
+			got := utils.PrettyJson(object)
+			if *updateGolden {
+				assert.Nil(t, ioutil.WriteFile(name+".golden", []byte(got), 0644))
+			}
+			want, err := ioutil.ReadFile(name + ".golden")
+			assert.Nil(t, err)
+			assert.Equal(t, string(want), got, "golden of %s", name)
+			assert.Nil(t, decode(kind, data, obj))
+		}
+	}
+}
+
+func TestMigrateObject(t *testing.T) {
+	store := newMemStore()
+	data, err := ioutil.ReadFile("testdata/schema/Snapshot-v1.json")
+	assert.Nil(t, err)
+	idx := NewGenericIndexer("kubernetes-wdrip-64", store)
+	assert.Nil(t, store.PutObject(data, idx.SnapshotIndex.snapshot.IndexLocation()))
+
+	results, err := idx.Migrate(true)
+	assert.Nil(t, err)
+	assert.Equal(t, 1, len(results))
+	assert.Equal(t, 1, results[0].From)
+	stored, _ := store.GetObject(idx.SnapshotIndex.snapshot.IndexLocation())
+	assert.Equal(t, data, stored)
+
+	results, err = idx.Migrate(false)
+	assert.Nil(t, err)
+	assert.Equal(t, SchemaVersion(KindSnapshot), results[0].To)
+	results, err = idx.Migrate(true)
+	assert.Nil(t, err)
+	assert.Equal(t, results[0].To, results[0].From)
+
+	b, err := idx.GetBackup("20211019-1200")
+	assert.Nil(t, err)
+	// no createdAt is derived from identity of unknown time zone
+	assert.Equal(t, "", b.CreatedAt)
+	created, err := b.Time()
+	assert.Nil(t, err)
+	assert.Equal(t, "2021-10-19T12:00:00Z", created.Format(timeFormat))
+
+	// written by newer wdrip
+	_, err = NewSnapshotFrom([]byte(`{"schemaVersion": 99}`))
+	assert.NotNil(t, err)
+}
@@ -1,13 +1,13 @@
 package index
 
 import (
-	"encoding/json"
 	"fmt"
 	api "github.com/aoxn/wdrip/pkg/apis/alibabacloud.com/v1"
 	pd "github.com/aoxn/wdrip/pkg/iaas/provider"
 	"github.com/aoxn/wdrip/pkg/utils"
 	"github.com/pkg/errors"
 	"k8s.io/klog/v2"
+	"regexp"
 	"sort"
 	"strings"
 	"sync"
@@ -17,6 +17,17 @@ import (
 const (
 	KEEP_COPIES_CNT = 4
 	SnapshotTMP     = "/tmp/snapshot.db"
+
+	// BackupResources backup of api objects in yaml, empty
+	// backup type stands for etcd snapshot.
+	BackupResources = "resources"
+
+	// MaxBackupSize refuse to transfer backups larger than it, etcd
+	// backend quota is recommended to be no more than 8G.
+	MaxBackupSize = 10 * 1024 * 1024 * 1024
+
+	timeFormat     = "2006-01-02T15:04:05Z"
+	identityFormat = "20060102-1504"
 )
 
 func NewSnapshotIndex(
@@ -24,7 +35,8 @@ func NewSnapshotIndex(
 ) *SnapshotIndex {
 	return &SnapshotIndex{
 		load:     false,
-		store:    store,
+		file:     SnapshotTMP,
+		store:    withReplicas(store),
 		snapshot: newSnapshot(id),
 	}
 }
@@ -34,6 +46,49 @@ type SnapshotIndex struct {
 	lock     sync.RWMutex
 	snapshot *Snapshot
 	store    pd.ObjectStorage
+	progress func(key string) pd.Progress
+	// file local etcd snapshot uploaded as backup, SnapshotTMP by default
+	file string
+}
+
+// WithSnapshotFile upload etcd snapshot from file instead of SnapshotTMP
+func (i *SnapshotIndex) WithSnapshotFile(file string) *SnapshotIndex {
+	i.file = file
+	return i
+}
+
+// SnapshotFile local etcd snapshot uploaded as backup
+func (i *SnapshotIndex) SnapshotFile() string { return i.file }
+
+// WithProgress report backup transfer progress with the callback made
+// by progress for each object, progress is logged by default.
+func (i *SnapshotIndex) WithProgress(progress func(key string) pd.Progress) *SnapshotIndex {
+	i.progress = progress
+	return i
+}
+
+func (i *SnapshotIndex) transfer(key string) pd.TransferOption {
+	progress := logProgress
+	if i.progress != nil {
+		progress = i.progress
+	}
+	return pd.TransferOption{MaxSize: MaxBackupSize, Progress: progress(key)}
+}
+
+// logProgress log transfer progress of key every 10 percent
+func logProgress(key string) pd.Progress {
+	last := int64(-1)
+	return func(transferred, total int64) {
+		if total <= 0 {
+			return
+		}
+		step := transferred * 10 / total
+		if step == last {
+			return
+		}
+		last = step
+		klog.Infof("transfer [%s]: %d%%, %d/%d bytes", key, step*10, transferred, total)
+	}
 }
 
 func (i *SnapshotIndex) LazyLoad() error {
@@ -62,8 +117,17 @@ func (i *SnapshotIndex) Snapshot() (*Snapshot, error) { return i.snapshot, i.Laz
 func (i *SnapshotIndex) BootSpec() (*api.ClusterSpec, error) { return i.snapshot.Spec, i.LazyLoad() }
 
 func (i *SnapshotIndex) LatestBackup(dir string) (*api.ClusterSpec, error) {
+	return i.DownloadBackup(dir, "", time.Time{})
+}
+
+// DownloadBackup download the selected backup to dir. backup is selected
+// by identity or name with key, or the latest one created before the given
+// time. both empty means the latest backup.
+func (i *SnapshotIndex) DownloadBackup(
+	dir, key string, before time.Time,
+) (*api.ClusterSpec, error) {
 	if err := i.LazyLoad(); err != nil {
-		return nil, errors.Wrapf(err, "load latest backup")
+		return nil, errors.Wrapf(err, "load backup index")
 	}
 	i.lock.Lock()
 	defer i.lock.Unlock()
@@ -71,34 +135,71 @@ func (i *SnapshotIndex) LatestBackup(dir string) (*api.ClusterSpec, error) {
 	if dir == "" {
 		dir = SnapshotTMP
 	}
-	backup := i.snapshot.LatestBackup()
-	if backup == nil {
-		return i.snapshot.Spec, fmt.Errorf("BackupNotFound")
+	backup, err := i.snapshot.SelectBackup(key, before)
+	if err != nil {
+		return i.snapshot.Spec, err
 	}
-	err := i.store.GetFile(i.snapshot.Path(*backup), dir)
+	klog.Infof("restore from backup: [%s], identity=%s", i.snapshot.Name, backup.Identity)
+	location := i.snapshot.Path(*backup)
+	err = pd.DownloadFile(i.store, location, dir, i.transfer(location))
 	if err != nil {
-		return nil, errors.Wrapf(err, "download latest backup")
+		return nil, errors.Wrapf(err, "download backup: %s", backup.Identity)
 	}
 
 	return i.snapshot.Spec, nil
 }
 
-func (i *SnapshotIndex) Backup(id api.ClusterSpec) error {
+// SelectBackup returns the backup to restore from without downloading it.
+func (i *SnapshotIndex) SelectBackup(key string, before time.Time) (*Backup, error) {
+	if err := i.LazyLoad(); err != nil {
+		return nil, errors.Wrapf(err, "load backup index")
+	}
+	i.lock.Lock()
+	defer i.lock.Unlock()
+	return i.snapshot.SelectBackup(key, before)
+}
+
+func (i *SnapshotIndex) Backup(id api.ClusterSpec) error { return i.BackupWithName(id, "") }
+
+// BackupWithName upload /tmp/snapshot.db as a new backup copy.
+// named backup is excluded from automatic gc, name must be unique.
+func (i *SnapshotIndex) BackupWithName(id api.ClusterSpec, name string) error {
+	return i.BackupFrom(id, name, nil)
+}
+
+// BackupFrom upload /tmp/snapshot.db as a new backup copy, recording
+// the etcd member it was taken from if known.
+func (i *SnapshotIndex) BackupFrom(id api.ClusterSpec, name string, source *Source) error {
 	if err := i.LazyLoad(); err != nil {
 		return errors.Wrapf(err, "load latest backup")
 	}
 	i.lock.Lock()
 	defer i.lock.Unlock()
 
-	backup := Backup{Identity: HourNow()}
-	klog.Infof("trying to backup etcd to oss: [%s]", i.snapshot.Path(backup))
-	err := i.store.PutFile(SnapshotTMP, i.snapshot.Path(backup))
+	if name != "" && i.snapshot.FindBackup(name) != nil {
+		return fmt.Errorf("backup named [%s] already exists", name)
+	}
+	backup, err := NewBackup(name)
 	if err != nil {
-		return errors.Wrapf(err, "put file %s: %s", SnapshotTMP, i.snapshot.Path(backup))
+		return err
 	}
-	i.snapshot.Copies = append(i.snapshot.Copies, backup)
-	i.snapshot.Spec = &id
-	err = i.store.PutObject(i.snapshot.Bytes(), i.snapshot.IndexLocation())
+	backup.Source = source
+	location := i.snapshot.Path(backup)
+	klog.Infof("trying to backup etcd to oss: [%s]", location)
+	err = pd.UploadFile(i.store, i.file, location, i.transfer(location))
+	if err != nil {
+		return errors.Wrapf(err, "put file %s: %s", i.file, i.snapshot.Path(backup))
+	}
+	err = i.save(
+		func(s *Snapshot) error {
+			if name != "" && s.FindBackup(name) != nil {
+				return fmt.Errorf("backup named [%s] already exists", name)
+			}
+			s.Copies = append(s.Copies, backup)
+			s.Spec = &id
+			return nil
+		},
+	)
 	if err != nil {
 		return errors.Wrapf(err, "put snapshot object: %s", i.snapshot.IndexLocation())
 	}
@@ -106,6 +207,206 @@ func (i *SnapshotIndex) Backup(id api.ClusterSpec) error {
 	return nil
 }
 
+// BackupResourcesWithName upload exported api objects as a new named backup.
+func (i *SnapshotIndex) BackupResourcesWithName(data []byte, name string) error {
+	if err := i.LazyLoad(); err != nil {
+		return errors.Wrapf(err, "load backups")
+	}
+	i.lock.Lock()
+	defer i.lock.Unlock()
+
+	if name != "" && i.snapshot.FindBackup(name) != nil {
+		return fmt.Errorf("backup named [%s] already exists", name)
+	}
+	backup, err := NewBackup(name)
+	if err != nil {
+		return err
+	}
+	backup.Type = BackupResources
+	err = i.store.PutObject(data, i.snapshot.Path(backup))
+	if err != nil {
+		return errors.Wrapf(err, "put resources: %s", i.snapshot.Path(backup))
+	}
+	err = i.save(
+		func(s *Snapshot) error {
+			if name != "" && s.FindBackup(name) != nil {
+				return fmt.Errorf("backup named [%s] already exists", name)
+			}
+			s.Copies = append(s.Copies, backup)
+			return nil
+		},
+	)
+	if err != nil {
+		return errors.Wrapf(err, "put snapshot object: %s", i.snapshot.IndexLocation())
+	}
+	klog.Infof("backup resources finished: %s", i.snapshot.Path(backup))
+	return nil
+}
+
+// GetResources download api objects of resource backup
+func (i *SnapshotIndex) GetResources(key string) ([]byte, error) {
+	backup, err := i.GetBackup(key)
+	if err != nil {
+		return nil, err
+	}
+	if backup.Type != BackupResources {
+		return nil, fmt.Errorf("backup [%s] is an etcd snapshot, not resource backup", key)
+	}
+	return i.store.GetObject(i.snapshot.Path(*backup))
+}
+
+// verifyReplicas copy backups to replicas which do not have them yet,
+// and record the replicated bucket in index.
+func (i *SnapshotIndex) verifyReplicas(rs *replicaStore) error {
+	if err := i.LazyLoad(); err != nil {
+		return errors.Wrapf(err, "load backups")
+	}
+	i.lock.Lock()
+	defer i.lock.Unlock()
+
+	var errs []string
+	replicated := map[string][]string{}
+	for _, backup := range i.snapshot.Copies {
+		for _, replica := range rs.replicas {
+			if backup.ReplicatedTo(replica.BucketName()) {
+				continue
+			}
+			klog.Infof("verifier: replicate backup [%s] to [%s]", backup.Identity, replica.BucketName())
+			location := i.snapshot.Path(backup)
+			err := rs.copyTo(location, replica, i.transfer(location))
+			if err != nil {
+				errs = append(errs, fmt.Sprintf("%s: %s", backup.Identity, err.Error()))
+				continue
+			}
+			replicated[backup.Identity] = append(replicated[backup.Identity], replica.BucketName())
+		}
+	}
+	if len(replicated) != 0 {
+		err := i.save(
+			func(s *Snapshot) error {
+				for k := range s.Copies {
+					backup := &s.Copies[k]
+					for _, bucket := range replicated[backup.Identity] {
+						if !backup.ReplicatedTo(bucket) {
+							backup.Replicas = append(backup.Replicas, bucket)
+						}
+					}
+				}
+				return nil
+			},
+		)
+		if err != nil {
+			errs = append(errs, err.Error())
+		}
+	}
+	if len(errs) != 0 {
+		return fmt.Errorf("replicate backups: %s", strings.Join(errs, "; "))
+	}
+	return nil
+}
+
+// RecordDrill record drill result on backup, the result of last
+// drill is kept only.
+func (i *SnapshotIndex) RecordDrill(key string, drill Drill) error {
+	if err := i.LazyLoad(); err != nil {
+		return errors.Wrapf(err, "load backups")
+	}
+	i.lock.Lock()
+	defer i.lock.Unlock()
+
+	return i.save(
+		func(s *Snapshot) error {
+			backup := s.FindBackup(key)
+			if backup == nil {
+				return fmt.Errorf("BackupNotFound: %s", key)
+			}
+			backup.Drill = &drill
+			return nil
+		},
+	)
+}
+
+// GetBackup find backup by identity or by name
+func (i *SnapshotIndex) GetBackup(key string) (*Backup, error) {
+	if err := i.LazyLoad(); err != nil {
+		return nil, errors.Wrapf(err, "load backups")
+	}
+	i.lock.RLock()
+	defer i.lock.RUnlock()
+
+	backup := i.snapshot.FindBackup(key)
+	if backup == nil {
+		return nil, fmt.Errorf("BackupNotFound: %s", key)
+	}
+	copied := *backup
+	return &copied, nil
+}
+
+// RemoveBackup delete backup index entry and its copy.
+// pinned backup must be unpinned before removal.
+func (i *SnapshotIndex) RemoveBackup(key string) error {
+	if err := i.LazyLoad(); err != nil {
+		return errors.Wrapf(err, "load backups")
+	}
+	i.lock.Lock()
+	defer i.lock.Unlock()
+
+	var removed Backup
+	err := i.save(
+		func(s *Snapshot) error {
+			backup := s.FindBackup(key)
+			if backup == nil {
+				return fmt.Errorf("BackupNotFound: %s", key)
+			}
+			if backup.Pinned {
+				return fmt.Errorf("backup [%s] is pinned, unpin it first", key)
+			}
+			removed = *backup
+			var bck []Backup
+			for _, b := range s.Copies {
+				if b.Identity == removed.Identity {
+					continue
+				}
+				bck = append(bck, b)
+			}
+			s.Copies = bck
+			return nil
+		},
+	)
+	if err != nil {
+		return err
+	}
+	err = i.store.DeleteObject(i.snapshot.Path(removed))
+	if err != nil {
+		return errors.Wrapf(err, "delete backup object: %s", i.snapshot.Path(removed))
+	}
+	return nil
+}
+
+// PinBackup mark backup as pinned or not, pinned
+// backup would never be removed by gc.
+func (i *SnapshotIndex) PinBackup(key string, pin bool) error {
+	if err := i.LazyLoad(); err != nil {
+		return errors.Wrapf(err, "load backups")
+	}
+	i.lock.Lock()
+	defer i.lock.Unlock()
+
+	return i.save(
+		func(s *Snapshot) error {
+			backup := s.FindBackup(key)
+			if backup == nil {
+				return fmt.Errorf("BackupNotFound: %s", key)
+			}
+			if backup.Pinned == pin {
+				return errNoChange
+			}
+			backup.Pinned = pin
+			return nil
+		},
+	)
+}
+
 func (i *SnapshotIndex) BackupGC() error {
 	if err := i.LazyLoad(); err != nil {
 		return errors.Wrapf(err, "load latest backup")
@@ -113,32 +414,81 @@ func (i *SnapshotIndex) BackupGC() error {
 	i.lock.Lock()
 	defer i.lock.Unlock()
 
-	if len(i.snapshot.Copies) <= KEEP_COPIES_CNT {
+	var deleted []Backup
+	err := i.save(
+		func(s *Snapshot) error {
+			deleted = nil
+			if len(s.Copies) <= KEEP_COPIES_CNT {
+				return errNoChange
+			}
+			s.SortBackups()
+			cnt := 0
+			var bck []Backup
+			for _, backup := range s.Copies {
+				if backup.Retained() {
+					bck = append(bck, backup)
+					continue
+				}
+				if cnt < KEEP_COPIES_CNT {
+					cnt++
+					bck = append(bck, backup)
+					continue
+				}
+				deleted = append(deleted, backup)
+			}
+			if len(deleted) == 0 {
+				return errNoChange
+			}
+			s.Copies = bck
+			return nil
+		},
+	)
+	if err != nil {
+		klog.Errorf("clean up, put snapshot object fail: %s", err.Error())
 		return nil
 	}
-	i.snapshot.SortBackups()
-	deleted := false
-	var bck []Backup
-	for k, backup := range i.snapshot.Copies {
-		if k < KEEP_COPIES_CNT {
-			bck = append(bck, backup)
-			continue
-		}
-		deleted = true
+	for _, backup := range deleted {
 		err := i.store.DeleteObject(i.snapshot.Path(backup))
 		klog.Infof("remove etcd backup copies: %s, %v", i.snapshot.Path(backup), err)
 	}
-	if deleted {
-		i.snapshot.Copies = bck
-		err := i.store.PutObject(i.snapshot.Bytes(), i.snapshot.IndexLocation())
-		if err != nil {
-			klog.Errorf("clean up, put snapshot object fail: %i", err.Error())
-		}
-	}
 	klog.Infof("clean up backups: %d", len(i.snapshot.Copies))
 	return nil
 }
 
+// errNoChange returned by snapshot mutation which has nothing to write
+var errNoChange = fmt.Errorf("NoChange")
+
+// save apply mutate on the latest index.json and write it back with
+// generation increased. mutate might be called more than once on
+// conflict, in-memory snapshot is replaced by the result.
+func (i *SnapshotIndex) save(mutate func(s *Snapshot) error) error {
+	var latest *Snapshot
+	err := update(
+		i.store, i.snapshot.IndexLocation(),
+		func(data []byte) ([]byte, error) {
+			latest = newSnapshot(i.snapshot.Name)
+			if err := latest.Load(data); err != nil {
+				return nil, errors.Wrapf(err, "load snapshot index")
+			}
+			err := mutate(latest)
+			if err == errNoChange {
+				return nil, nil
+			}
+			if err != nil {
+				return nil, err
+			}
+			latest.Generation++
+			return latest.Bytes(), nil
+		},
+	)
+	if err != nil {
+		return err
+	}
+	i.snapshot = latest
+	i.load = true
+	return nil
+}
+
 func NewSnapshotFrom(data []byte) (Snapshot, error) {
 	i := Snapshot{}
 	return i, i.Load(data)
@@ -156,12 +506,114 @@ type Snapshot struct {
 	Name   string           `json:"name,omitempty" protobuf:"bytes,2,opt,name=name"`
 	Copies []Backup         `json:"copies,omitempty" protobuf:"bytes,3,opt,name=copies"`
 	Spec   *api.ClusterSpec `json:"spec,omitempty" protobuf:"bytes,4,opt,name=spec"`
+	// Generation increased on every write of index.json
+	Generation int64 `json:"generation,omitempty" protobuf:"varint,5,opt,name=generation"`
 }
 
 type Backup struct {
 	Identity string `json:"identity,omitempty" protobuf:"bytes,1,opt,name=identity"`
+	// Name user specified name for on-demand backup
+	Name string `json:"name,omitempty" protobuf:"bytes,2,opt,name=name"`
+	// Pinned backup is excluded from automatic gc
+	Pinned    bool   `json:"pinned,omitempty" protobuf:"bytes,3,opt,name=pinned"`
+	CreatedAt string `json:"createdAt,omitempty" protobuf:"bytes,4,opt,name=createdAt"`
+	// Type of backup, empty for etcd snapshot
+	Type string `json:"type,omitempty" protobuf:"bytes,5,opt,name=type"`
+	// Replicas buckets this backup is verified to be replicated to
+	Replicas []string `json:"replicas,omitempty" protobuf:"bytes,6,rep,name=replicas"`
+	// Drill result of the last restore drill, see `wdrip backup drill`
+	Drill *Drill `json:"drill,omitempty" protobuf:"bytes,7,opt,name=drill"`
+	// Source etcd member the snapshot was taken from
+	Source *Source `json:"source,omitempty" protobuf:"bytes,8,opt,name=source"`
+}
+
+// Source etcd member a snapshot was taken from
+type Source struct {
+	Endpoint string `json:"endpoint,omitempty" protobuf:"bytes,1,opt,name=endpoint"`
+	// Member id in hex
+	Member string `json:"member,omitempty" protobuf:"bytes,2,opt,name=member"`
+	Leader bool   `json:"leader,omitempty" protobuf:"bytes,3,opt,name=leader"`
+	// Revision of etcd store captured by the snapshot
+	Revision int64 `json:"revision,omitempty" protobuf:"varint,4,opt,name=revision"`
+}
+
+// Drill result of restoring a backup into a throwaway etcd
+type Drill struct {
+	// VerifiedAt time the drill finished
+	VerifiedAt string `json:"verifiedAt,omitempty" protobuf:"bytes,1,opt,name=verifiedAt"`
+	Passed     bool   `json:"passed" protobuf:"bytes,2,opt,name=passed"`
+	// Keys restored from backup
+	Keys int64 `json:"keys,omitempty" protobuf:"varint,3,opt,name=keys"`
+	// Message reason of failure
+	Message string `json:"message,omitempty" protobuf:"bytes,4,opt,name=message"`
+}
+
+// NewDrill returns drill result finished now
+func NewDrill(keys int64, err error) Drill {
+	drill := Drill{
+		VerifiedAt: time.Now().UTC().Format(timeFormat),
+		Passed:     err == nil,
+		Keys:       keys,
+	}
+	if err != nil {
+		drill.Message = err.Error()
+	}
+	return drill
+}
+
+// VerifiedAt returns the time backup was last proved restorable
+// by drill, empty when never drilled or the last drill failed.
+func (b *Backup) VerifiedAt() string {
+	if b.Drill == nil || !b.Drill.Passed {
+		return ""
+	}
+	return b.Drill.VerifiedAt
+}
+
+// backupName format of backup name, a DNS label
+var backupName = regexp.MustCompile(`^[a-z0-9]([-a-z0-9]*[a-z0-9])?$`)
+
+// ValidateBackupName returns error unless name is a DNS label. the name
+// ends up in shell commands run on masters, userdata and object keys.
+func ValidateBackupName(name string) error {
+	if len(name) > 63 || !backupName.MatchString(name) {
+		return fmt.Errorf("invalid backup name [%s]: lower case "+
+			"alphanumeric characters or '-' expected, at most 63", name)
+	}
+	return nil
 }
 
+// NewBackup returns a backup identified by current time.
+// name is appended to identity for named backup to avoid
+// collision with periodic backups in the same minute.
+func NewBackup(name string) (Backup, error) {
+	identity := HourNow()
+	if name != "" {
+		if err := ValidateBackupName(name); err != nil {
+			return Backup{}, err
+		}
+		identity = fmt.Sprintf("%s-%s", identity, name)
+	}
+	return Backup{
+		Identity:  identity,
+		Name:      name,
+		CreatedAt: time.Now().UTC().Format(timeFormat),
+	}, nil
+}
+
+// ReplicatedTo returns true if backup is verified in replica bucket
+func (b *Backup) ReplicatedTo(bucket string) bool {
+	for _, r := range b.Replicas {
+		if r == bucket {
+			return true
+		}
+	}
+	return false
+}
+
+// Retained named or pinned backup should not be garbage collected
+func (b *Backup) Retained() bool { return b.Pinned || b.Name != "" }
+
 func (i *Snapshot) base() string {
 	return fmt.Sprintf("%s/%s", i.Prefix, i.Name)
 }
@@ -174,6 +626,9 @@ func (i *Snapshot) URI(b Backup, bName string) string {
 }
 
 func (i *Snapshot) Path(b Backup) string {
+	if b.Type == BackupResources {
+		return fmt.Sprintf("%s/%s/resources.yaml", i.base(), b.Identity)
+	}
 	return fmt.Sprintf("%s/%s/snapshot.db", i.base(), b.Identity)
 }
 
@@ -181,12 +636,20 @@ func (i *Snapshot) Load(b []byte) error {
 	if len(b) == 0 {
 		return nil
 	}
-	return json.Unmarshal(b, i)
+	return decode(KindSnapshot, b, i)
 }
 
 func (i *Snapshot) String() string { return utils.PrettyJson(i) }
 
-func (i *Snapshot) Bytes() []byte { return []byte(utils.PrettyJson(i)) }
+// Bytes encode snapshot index with current schema version
+func (i *Snapshot) Bytes() []byte {
+	data, err := encode(KindSnapshot, i)
+	if err != nil {
+		klog.Errorf("encode snapshot index: %s", err.Error())
+		return []byte(utils.PrettyJson(i))
+	}
+	return data
+}
 
 func (i *Snapshot) SortBackups() {
 	cmp := func(m, n int) bool {
@@ -203,6 +666,92 @@ func (i *Snapshot) LatestBackup() *Backup {
 	return &i.Copies[0]
 }
 
+// FindBackup find backup by identity or name
+func (i *Snapshot) FindBackup(key string) *Backup {
+	if key == "" {
+		return nil
+	}
+	for k := range i.Copies {
+		if i.Copies[k].Identity == key ||
+			i.Copies[k].Name == key {
+			return &i.Copies[k]
+		}
+	}
+	return nil
+}
+
+// SelectBackup select backup by identity or name when key is provided,
+// otherwise the latest backup created before the given time. zero time
+// means the latest backup.
+func (i *Snapshot) SelectBackup(key string, before time.Time) (*Backup, error) {
+	if i.Spec != nil &&
+		i.Spec.ClusterID != "" &&
+		i.Spec.ClusterID != i.Name {
+		return nil, fmt.Errorf("backup index [%s] belongs to cluster [%s]", i.Name, i.Spec.ClusterID)
+	}
+	if key != "" {
+		backup := i.FindBackup(key)
+		if backup == nil {
+			return nil, fmt.Errorf("BackupNotFound: %s in cluster %s", key, i.Name)
+		}
+		if backup.Type == BackupResources {
+			return nil, fmt.Errorf("backup [%s] is a resource backup, use [wdrip restore resources]", key)
+		}
+		return backup, nil
+	}
+	i.SortBackups()
+	for k := range i.Copies {
+		if i.Copies[k].Type == BackupResources {
+			continue
+		}
+		if before.IsZero() {
+			return &i.Copies[k], nil
+		}
+		created, err := i.Copies[k].Time()
+		if err != nil {
+			klog.Warningf("skip backup %s: %s", i.Copies[k].Identity, err.Error())
+			continue
+		}
+		if !created.After(before) {
+			return &i.Copies[k], nil
+		}
+	}
+	if before.IsZero() {
+		return nil, fmt.Errorf("BackupNotFound")
+	}
+	return nil, fmt.Errorf("BackupNotFound: no backup before %s in cluster %s", before.Format(timeFormat), i.Name)
+}
+
+// Time returns the creation time of backup. identity is used for
+// backups created before CreatedAt was recorded. all times are UTC so
+// that the operator and the cli in other time zones agree. identities
+// of those older backups were written in local time of the operator or
+// cli taking them, they are read as UTC and may be off by its offset.
+func (b *Backup) Time() (time.Time, error) {
+	if b.CreatedAt != "" {
+		return time.ParseInLocation(timeFormat, b.CreatedAt, time.UTC)
+	}
+	if len(b.Identity) < len(identityFormat) {
+		return time.Time{}, fmt.Errorf("unexpected backup identity: %s", b.Identity)
+	}
+	return time.ParseInLocation(identityFormat, b.Identity[:len(identityFormat)], time.UTC)
+}
+
+// ParseBackupTime parse point in time for restore in UTC, either
+// in "2006-01-02T15:04:05Z" or backup identity format "20060102-1504"
+func ParseBackupTime(t string) (time.Time, error) {
+	if t == "" {
+		return time.Time{}, nil
+	}
+	for _, format := range []string{timeFormat, "2006-01-02T15:04:05", identityFormat} {
+		v, err := time.ParseInLocation(format, t, time.UTC)
+		if err == nil {
+			return v, nil
+		}
+	}
+	return time.Time{}, fmt.Errorf("unrecognized time %q, expect format %s or %s", t, timeFormat, identityFormat)
+}
+
 func HourNow() string {
-	return time.Now().Format("20060102-1504")
+	return time.Now().UTC().Format(identityFormat)
 }
@@ -0,0 +1,316 @@
+package index
+
+import (
+	"fmt"
+	api "github.com/aoxn/wdrip/pkg/apis/alibabacloud.com/v1"
+	pd "github.com/aoxn/wdrip/pkg/iaas/provider"
+	"github.com/stretchr/testify/assert"
+	"io"
+	"io/ioutil"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+type memStore struct {
+	lock    sync.Mutex
+	objects map[string][]byte
+}
+
+func newMemStore() *memStore { return &memStore{objects: map[string][]byte{}} }
+
+func key(f string) string {
+	if strings.HasPrefix(f, "oss://") {
+		segs := strings.SplitN(strings.TrimPrefix(f, "oss://"), "/", 2)
+		return segs[1]
+	}
+	return f
+}
+
+func (m *memStore) BucketName() string { return "wdrip-index" }
+
+func (m *memStore) EnsureBucket(name string) error { return nil }
+
+func (m *memStore) GetFile(src, dst string) error {
+	_, err := m.GetObject(src)
+	return err
+}
+
+func (m *memStore) PutFile(src, dst string) error { return m.PutObject([]byte(src), dst) }
+
+func (m *memStore) DeleteObject(f string) error {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	delete(m.objects, key(f))
+	return nil
+}
+
+func (m *memStore) GetObject(src string) ([]byte, error) {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	data, ok := m.objects[key(src)]
+	if !ok {
+		return nil, fmt.Errorf("NoSuchKey: %s", src)
+	}
+	return data, nil
+}
+
+func (m *memStore) PutObject(b []byte, dst string) error {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	m.objects[key(dst)] = b
+	return nil
+}
+
+func (m *memStore) PutStream(r io.Reader, size int64, dst string, opt pd.TransferOption) error {
+	data, err := ioutil.ReadAll(opt.Reader(r, 0, size))
+	if err != nil {
+		return err
+	}
+	return m.PutObject(data, dst)
+}
+
+func (m *memStore) GetStream(src string, w io.Writer, offset int64, opt pd.TransferOption) (int64, error) {
+	data, err := m.GetObject(src)
+	if err != nil {
+		return 0, err
+	}
+	if err := opt.CheckSize(int64(len(data))); err != nil {
+		return 0, err
+	}
+	n, err := opt.Writer(w, offset, int64(len(data))).Write(data[offset:])
+	return int64(n), err
+}
+
+func (m *memStore) ListObject(prefix string) ([][]byte, error) {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	var result [][]byte
+	for k, v := range m.objects {
+		if strings.HasPrefix(k, prefix) {
+			result = append(result, v)
+		}
+	}
+	return result, nil
+}
+
+func (m *memStore) ListPage(prefix string, opt pd.ListOption) (*pd.ListResult, error) {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	var keys []string
+	for k := range m.objects {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	result := &pd.ListResult{}
+	prefixes := map[string]bool{}
+	for _, k := range keys {
+		if !strings.HasPrefix(k, prefix) || k <= opt.Marker {
+			continue
+		}
+		if opt.MaxKeys > 0 && len(result.Keys) == opt.MaxKeys {
+			result.NextMarker = result.Keys[len(result.Keys)-1]
+			break
+		}
+		rest := strings.TrimPrefix(k, prefix)
+		if i := strings.Index(rest, opt.Delimiter); opt.Delimiter != "" && i >= 0 {
+			if p := prefix + rest[:i+1]; !prefixes[p] {
+				prefixes[p] = true
+				result.CommonPrefixes = append(result.CommonPrefixes, p)
+			}
+			continue
+		}
+		result.Keys = append(result.Keys, k)
+	}
+	return result, nil
+}
+
+// seedSnapshot prepare the etcd snapshot file to be uploaded
+func seedSnapshot(t *testing.T) string {
+	file := filepath.Join(t.TempDir(), "snapshot.db")
+	assert.Nil(t, ioutil.WriteFile(file, []byte("etcd snapshot"), 0644))
+	return file
+}
+
+func TestBackupGCRetained(t *testing.T) {
+	file := seedSnapshot(t)
+	store := newMemStore()
+	snapshot := newSnapshot("kubernetes-wdrip-64")
+	for i := 0; i < KEEP_COPIES_CNT+3; i++ {
+		snapshot.Copies = append(
+			snapshot.Copies, Backup{Identity: fmt.Sprintf("20211019-120%d", i)},
+		)
+	}
+	// oldest backup pinned
+	snapshot.Copies[0].Pinned = true
+	assert.Nil(t, store.PutObject(snapshot.Bytes(), snapshot.IndexLocation()))
+	idx := NewSnapshotIndex("kubernetes-wdrip-64", store).WithSnapshotFile(file)
+	assert.Nil(t, idx.BackupWithName(api.ClusterSpec{}, "pre-upgrade"))
+	assert.NotNil(t, idx.BackupWithName(api.ClusterSpec{}, "pre-upgrade"))
+
+	assert.Nil(t, idx.BackupGC())
+	assert.Equal(t, KEEP_COPIES_CNT+2, len(idx.snapshot.Copies))
+	assert.NotNil(t, idx.snapshot.FindBackup("20211019-1200"))
+	assert.NotNil(t, idx.snapshot.FindBackup("pre-upgrade"))
+
+	assert.NotNil(t, idx.RemoveBackup("20211019-1200"))
+	assert.Nil(t, idx.PinBackup("20211019-1200", false))
+	assert.Nil(t, idx.RemoveBackup("20211019-1200"))
+	_, err := idx.GetBackup("20211019-1200")
+	assert.NotNil(t, err)
+}
+
+func TestSelectBackup(t *testing.T) {
+	snapshot := newSnapshot("kubernetes-wdrip-64")
+	snapshot.Copies = []Backup{
+		{Identity: "20211019-1100"},
+		{Identity: "20211019-1200-pre-upgrade", Name: "pre-upgrade", CreatedAt: "2021-10-19T12:00:30Z"},
+		{Identity: "20211019-1300"},
+	}
+	b, err := snapshot.SelectBackup("", time.Time{})
+	assert.Nil(t, err)
+	assert.Equal(t, "20211019-1300", b.Identity)
+
+	b, err = snapshot.SelectBackup("pre-upgrade", time.Time{})
+	assert.Nil(t, err)
+	assert.Equal(t, "20211019-1200-pre-upgrade", b.Identity)
+
+	before, err := ParseBackupTime("2021-10-19T12:59:00")
+	assert.Nil(t, err)
+	b, err = snapshot.SelectBackup("", before)
+	assert.Nil(t, err)
+	assert.Equal(t, "20211019-1200-pre-upgrade", b.Identity)
+
+	before, _ = ParseBackupTime("20211019-1000")
+	_, err = snapshot.SelectBackup("", before)
+	assert.NotNil(t, err)
+	_, err = snapshot.SelectBackup("not-exist", time.Time{})
+	assert.NotNil(t, err)
+
+	snapshot.Spec = &api.ClusterSpec{}
+	snapshot.Spec.ClusterID = "kubernetes-wdrip-65"
+	_, err = snapshot.SelectBackup("", time.Time{})
+	assert.NotNil(t, err)
+}
+
+func TestBackupTimeUTC(t *testing.T) {
+	before, err := ParseBackupTime("2021-10-19T12:59:00Z")
+	assert.Nil(t, err)
+	assert.Equal(t, time.Date(2021, 10, 19, 12, 59, 0, 0, time.UTC), before)
+
+	backup, err := NewBackup("")
+	assert.Nil(t, err)
+	created, err := backup.Time()
+	assert.Nil(t, err)
+	assert.WithinDuration(t, time.Now(), created, time.Minute)
+	byIdentity, err := (&Backup{Identity: backup.Identity}).Time()
+	assert.Nil(t, err)
+	assert.WithinDuration(t, time.Now(), byIdentity, 2*time.Minute)
+}
+
+// flakyStore breaks the first download halfway
+type flakyStore struct {
+	*memStore
+	broken bool
+}
+
+func (m *flakyStore) GetStream(src string, w io.Writer, offset int64, opt pd.TransferOption) (int64, error) {
+	if m.broken {
+		return m.memStore.GetStream(src, w, offset, opt)
+	}
+	m.broken = true
+	data, err := m.GetObject(src)
+	if err != nil {
+		return 0, err
+	}
+	n, _ := w.Write(data[offset : len(data)/2])
+	return int64(n), fmt.Errorf("connection reset by peer")
+}
+
+func TestDownloadBackupResume(t *testing.T) {
+	file := seedSnapshot(t)
+	store := &flakyStore{memStore: newMemStore()}
+	idx := NewSnapshotIndex("kubernetes-wdrip-64", store).WithSnapshotFile(file)
+	assert.Nil(t, idx.BackupWithName(api.ClusterSpec{}, "pre-upgrade"))
+
+	var transferred int64
+	idx.WithProgress(
+		func(key string) pd.Progress {
+			return func(n, total int64) { transferred = n }
+		},
+	)
+	dst := filepath.Join(t.TempDir(), "snapshot.db")
+	_, err := idx.DownloadBackup(dst, "pre-upgrade", time.Time{})
+	assert.Nil(t, err)
+	data, err := ioutil.ReadFile(dst)
+	assert.Nil(t, err)
+	assert.Equal(t, "etcd snapshot", string(data))
+	assert.Equal(t, int64(len(data)), transferred)
+}
+
+func TestTransferSizeLimit(t *testing.T) {
+	store := newMemStore()
+	opt := pd.TransferOption{MaxSize: 4}
+	err := store.PutStream(strings.NewReader("etcd snapshot"), -1, "snapshot.db", opt)
+	assert.True(t, strings.Contains(err.Error(), "SizeLimitExceeded"))
+
+	assert.Nil(t, store.PutObject([]byte("etcd snapshot"), "snapshot.db"))
+	dst := filepath.Join(t.TempDir(), "snapshot.db")
+	err = pd.DownloadFile(store, "snapshot.db", dst, opt)
+	assert.True(t, strings.Contains(err.Error(), "SizeLimitExceeded"))
+	matches, _ := filepath.Glob(dst + "*")
+	assert.Equal(t, 0, len(matches))
+}
+
+func TestRecordDrill(t *testing.T) {
+	file := seedSnapshot(t)
+	store := newMemStore()
+	idx := NewSnapshotIndex("kubernetes-wdrip-64", store).WithSnapshotFile(file)
+	assert.Nil(t, idx.BackupWithName(api.ClusterSpec{}, "pre-upgrade"))
+
+	assert.Nil(t, idx.RecordDrill("pre-upgrade", NewDrill(120, nil)))
+	assert.NotNil(t, idx.RecordDrill("not-exist", NewDrill(0, nil)))
+
+	// drill result is persisted in index.json
+	reload := NewSnapshotIndex("kubernetes-wdrip-64", store)
+	b, err := reload.GetBackup("pre-upgrade")
+	assert.Nil(t, err)
+	assert.Equal(t, int64(120), b.Drill.Keys)
+	assert.NotEqual(t, "", b.VerifiedAt())
+
+	// failed drill clears verified at
+	assert.Nil(t, idx.RecordDrill("pre-upgrade", NewDrill(0, fmt.Errorf("sentinel missing"))))
+	b, err = idx.GetBackup("pre-upgrade")
+	assert.Nil(t, err)
+	assert.Equal(t, "", b.VerifiedAt())
+	assert.Equal(t, "sentinel missing", b.Drill.Message)
+}
+
+func TestBackupSource(t *testing.T) {
+	file := seedSnapshot(t)
+	store := newMemStore()
+	idx := NewSnapshotIndex("kubernetes-wdrip-64", store).WithSnapshotFile(file)
+	source := &Source{Endpoint: "https://192.168.0.31:2379", Member: "dc2a", Leader: true, Revision: 296665}
+	assert.Nil(t, idx.BackupFrom(api.ClusterSpec{}, "pre-upgrade", source))
+
+	reload := NewSnapshotIndex("kubernetes-wdrip-64", store)
+	b, err := reload.GetBackup("pre-upgrade")
+	assert.Nil(t, err)
+	assert.Equal(t, source, b.Source)
+}
+
+func TestValidateBackupName(t *testing.T) {
+	for _, name := range []string{"pre-upgrade", "a", "pre-etcd-v3-4-16-20211019-1200"} {
+		assert.Nil(t, ValidateBackupName(name), name)
+	}
+	for _, name := range []string{"", "x; rm -rf /", "Pre", "-a", "a-", "a.b", "a/b", `a"b`, strings.Repeat("a", 64)} {
+		assert.NotNil(t, ValidateBackupName(name), name)
+	}
+	file := seedSnapshot(t)
+	idx := NewSnapshotIndex("kubernetes-wdrip-64", newMemStore()).WithSnapshotFile(file)
+	assert.NotNil(t, idx.BackupWithName(api.ClusterSpec{}, "$(reboot)"))
+	assert.NotNil(t, idx.BackupResourcesWithName([]byte("kind: List"), "a b"))
+}
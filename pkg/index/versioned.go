@@ -0,0 +1,203 @@
+package index
+
+import (
+	"encoding/json"
+	"fmt"
+	pd "github.com/aoxn/wdrip/pkg/iaas/provider"
+	"github.com/pkg/errors"
+	"k8s.io/apimachinery/pkg/util/wait"
+	"k8s.io/klog/v2"
+	"math/rand"
+	"os"
+	"strings"
+	"time"
+)
+
+var (
+	// lockTTL lock object expires after lockTTL in case holder crashed
+	lockTTL = 30 * time.Second
+	// lockSettle wait before read lock object back, so that a racing
+	// writer who also saw the lock free has written its own record.
+	lockSettle = 300 * time.Millisecond
+
+	conflictBackoff = wait.Backoff{
+		Steps:    10,
+		Duration: 50 * time.Millisecond,
+		Factor:   2,
+		Jitter:   1,
+		Cap:      2 * time.Second,
+	}
+)
+
+// ConflictError is returned when an index object is modified concurrently
+type ConflictError struct {
+	Key    string
+	Reason string
+
+	// retryable conflict is caused by racing writers, and
+	// is retried in update with fresh content.
+	retryable bool
+}
+
+func (e *ConflictError) Error() string {
+	return fmt.Sprintf("Conflict: %s, %s", e.Key, e.Reason)
+}
+
+// IsConflict returns true if err is caused by concurrent modification
+func IsConflict(err error) bool {
+	_, ok := errors.Cause(err).(*ConflictError)
+	return ok
+}
+
+func isRetryable(err error) bool {
+	conflict, ok := errors.Cause(err).(*ConflictError)
+	return ok && conflict.retryable
+}
+
+// update read-modify-write object at key. racing writers are detected by
+// conditional put if the storage supports it, otherwise serialized by a
+// lock object next to key. mutate receives nil when object does not exist,
+// and returns nil content for nothing to write.
+func update(
+	store pd.ObjectStorage, key string, mutate func(data []byte) ([]byte, error),
+) error {
+	var err error
+	waitErr := wait.ExponentialBackoff(
+		conflictBackoff,
+		func() (bool, error) {
+			err = tryUpdate(store, key, mutate)
+			if isRetryable(err) {
+				klog.Warningf("update [%s] conflict, retry: %s", key, err.Error())
+				return false, nil
+			}
+			return true, err
+		},
+	)
+	if waitErr == wait.ErrWaitTimeout {
+		return err
+	}
+	return waitErr
+}
+
+func tryUpdate(
+	store pd.ObjectStorage, key string, mutate func(data []byte) ([]byte, error),
+) error {
+	if cs, ok := conditional(store); ok {
+		data, version, err := cs.GetObjectVersion(key)
+		if err != nil {
+			if !isNotFound(err) {
+				return errors.Wrapf(err, "get object: %s", key)
+			}
+			data, version = nil, ""
+		}
+		ndata, err := mutate(data)
+		if err != nil || ndata == nil {
+			return err
+		}
+		err = cs.PutObjectIfMatch(ndata, key, version)
+		if err != nil && strings.Contains(err.Error(), "PreconditionFailed") {
+			return &ConflictError{Key: key, Reason: err.Error(), retryable: true}
+		}
+		return err
+	}
+
+	release, err := acquire(store, key)
+	if err != nil {
+		return err
+	}
+	defer release()
+	data, err := store.GetObject(key)
+	if err != nil {
+		if !isNotFound(err) {
+			return errors.Wrapf(err, "get object: %s", key)
+		}
+		data = nil
+	}
+	ndata, err := mutate(data)
+	if err != nil || ndata == nil {
+		return err
+	}
+	return store.PutObject(ndata, key)
+}
+
+func conditional(store pd.ObjectStorage) (pd.ConditionalStorage, bool) {
+	if rs, ok := store.(*replicaStore); ok {
+		if _, ok := rs.ObjectStorage.(pd.ConditionalStorage); !ok {
+			return nil, false
+		}
+		return rs, true
+	}
+	cs, ok := store.(pd.ConditionalStorage)
+	return cs, ok
+}
+
+type lockRecord struct {
+	Holder string    `json:"holder"`
+	Expire time.Time `json:"expire"`
+}
+
+// acquire take the lock object of key. it is a best effort lock for
+// storage without precondition support: write own record, wait for
+// lockSettle and read back to see whether it is still the holder.
+func acquire(store pd.ObjectStorage, key string) (func(), error) {
+	if rs, ok := store.(*replicaStore); ok {
+		// lock object is never replicated
+		store = rs.ObjectStorage
+	}
+	lkey := fmt.Sprintf("%s.lock", key)
+	held, err := readLock(store, lkey)
+	if err != nil {
+		return nil, err
+	}
+	if held != nil && time.Now().Before(held.Expire) {
+		return nil, &ConflictError{Key: key, Reason: fmt.Sprintf("locked by %s", held.Holder), retryable: true}
+	}
+	host, _ := os.Hostname()
+	me := lockRecord{
+		Holder: fmt.Sprintf("%s-%d-%d", host, os.Getpid(), rand.Int63()),
+		Expire: time.Now().Add(lockTTL),
+	}
+	data, err := json.Marshal(me)
+	if err != nil {
+		return nil, errors.Wrapf(err, "marshal lock record")
+	}
+	err = store.PutObject(data, lkey)
+	if err != nil {
+		return nil, errors.Wrapf(err, "put lock object: %s", lkey)
+	}
+	time.Sleep(lockSettle)
+	held, err = readLock(store, lkey)
+	if err != nil {
+		return nil, err
+	}
+	if held == nil || held.Holder != me.Holder {
+		return nil, &ConflictError{Key: key, Reason: "lock taken by another writer", retryable: true}
+	}
+	release := func() {
+		held, err := readLock(store, lkey)
+		if err != nil || held == nil || held.Holder != me.Holder {
+			klog.Warningf("lock [%s] lost before release: %v", lkey, err)
+			return
+		}
+		if err := store.DeleteObject(lkey); err != nil {
+			klog.Warningf("release lock [%s]: %s", lkey, err.Error())
+		}
+	}
+	return release, nil
+}
+
+func readLock(store pd.ObjectStorage, lkey string) (*lockRecord, error) {
+	data, err := store.GetObject(lkey)
+	if err != nil {
+		if isNotFound(err) {
+			return nil, nil
+		}
+		return nil, errors.Wrapf(err, "get lock object: %s", lkey)
+	}
+	held := &lockRecord{}
+	if err := json.Unmarshal(data, held); err != nil {
+		klog.Warningf("broken lock object [%s], treat as free: %s", lkey, err.Error())
+		return nil, nil
+	}
+	return held, nil
+}
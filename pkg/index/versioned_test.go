@@ -0,0 +1,117 @@
+package index
+
+import (
+	"fmt"
+	api "github.com/aoxn/wdrip/pkg/apis/alibabacloud.com/v1"
+	pd "github.com/aoxn/wdrip/pkg/iaas/provider"
+	"github.com/stretchr/testify/assert"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"sync"
+	"testing"
+	"time"
+)
+
+func init() {
+	// in-memory store is linearizable, no need to wait long
+	lockSettle = 10 * time.Millisecond
+}
+
+// versionedStore is an in-memory storage with ETag like versions
+type versionedStore struct {
+	*memStore
+	versions map[string]int
+}
+
+func newVersionedStore() *versionedStore {
+	return &versionedStore{memStore: newMemStore(), versions: map[string]int{}}
+}
+
+func (m *versionedStore) GetObjectVersion(src string) ([]byte, string, error) {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	data, ok := m.objects[key(src)]
+	if !ok {
+		return nil, "", fmt.Errorf("NoSuchKey: %s", src)
+	}
+	return data, fmt.Sprintf("%d", m.versions[key(src)]), nil
+}
+
+func (m *versionedStore) PutObjectIfMatch(b []byte, dst, version string) error {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	cur := ""
+	if _, ok := m.objects[key(dst)]; ok {
+		cur = fmt.Sprintf("%d", m.versions[key(dst)])
+	}
+	if cur != version {
+		return fmt.Errorf("PreconditionFailed: %s, version %s, expected %s", dst, cur, version)
+	}
+	m.objects[key(dst)] = b
+	m.versions[key(dst)]++
+	return nil
+}
+
+func concurrentBackups(t *testing.T, store pd.ObjectStorage, writers int) {
+	file := seedSnapshot(t)
+	var wg sync.WaitGroup
+	for i := 0; i < writers; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			// each writer stands for a separate process
+			idx := NewSnapshotIndex("kubernetes-wdrip-64", store).WithSnapshotFile(file)
+			err := idx.BackupWithName(api.ClusterSpec{}, fmt.Sprintf("writer-%d", i))
+			assert.Nil(t, err)
+		}(i)
+	}
+	wg.Wait()
+
+	idx := NewSnapshotIndex("kubernetes-wdrip-64", store)
+	snapshot, err := idx.Snapshot()
+	assert.Nil(t, err)
+	assert.Equal(t, writers, len(snapshot.Copies))
+	assert.Equal(t, int64(writers), snapshot.Generation)
+}
+
+func TestConcurrentBackupConditional(t *testing.T) {
+	concurrentBackups(t, newVersionedStore(), 8)
+}
+
+func TestConcurrentBackupLock(t *testing.T) {
+	concurrentBackups(t, newMemStore(), 4)
+}
+
+func TestSaveClusterConflict(t *testing.T) {
+	store := newVersionedStore()
+	idx := NewClusterIndex("kubernetes-wdrip-64", store)
+	id := api.ClusterId{ObjectMeta: metav1.ObjectMeta{Name: "kubernetes-wdrip-64"}}
+	assert.Nil(t, idx.SaveCluster(id))
+
+	stale, err := idx.GetCluster("kubernetes-wdrip-64")
+	assert.Nil(t, err)
+	assert.Equal(t, int64(1), stale.Generation)
+	assert.Nil(t, idx.SaveCluster(stale))
+
+	err = idx.SaveCluster(stale)
+	assert.True(t, IsConflict(err))
+
+	var wg sync.WaitGroup
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			err := NewClusterIndex("kubernetes-wdrip-64", store).UpdateCluster(
+				"kubernetes-wdrip-64",
+				func(id *api.ClusterId) error {
+					id.Spec.UpdatedAt = time.Now().String()
+					return nil
+				},
+			)
+			assert.Nil(t, err)
+		}()
+	}
+	wg.Wait()
+	mid, err := idx.GetCluster("kubernetes-wdrip-64")
+	assert.Nil(t, err)
+	assert.Equal(t, int64(10), mid.Generation)
+}
@@ -3,6 +3,7 @@ package operator
 import (
 	"github.com/aoxn/wdrip/pkg/context/shared"
 	"github.com/aoxn/wdrip/pkg/operator/controllers/addon"
+	"github.com/aoxn/wdrip/pkg/operator/controllers/indexsync"
 	"github.com/aoxn/wdrip/pkg/operator/controllers/master"
 	"github.com/aoxn/wdrip/pkg/operator/controllers/nodepool"
 	"github.com/aoxn/wdrip/pkg/operator/controllers/noderepair"
@@ -20,6 +21,9 @@ func init() {
 
 	Funcs = append(Funcs, nodepool.AddNodePoolController)
 	Funcs = append(Funcs, noderepair.AddNodeRepair)
+
+	Funcs = append(Funcs, indexsync.AddClusterSync)
+	Funcs = append(Funcs, indexsync.AddNodePoolSync)
 }
 
 func AddControllers(
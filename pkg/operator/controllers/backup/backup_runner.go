@@ -9,10 +9,10 @@ import (
 	"github.com/aoxn/wdrip/pkg/index"
 	h "github.com/aoxn/wdrip/pkg/operator/controllers/help"
 	"github.com/pkg/errors"
+	v1 "k8s.io/api/core/v1"
 	"k8s.io/apimachinery/pkg/util/wait"
 	"k8s.io/client-go/tools/record"
 	"k8s.io/klog/v2"
-	"path/filepath"
 	"sigs.k8s.io/controller-runtime/pkg/cache"
 	"sync"
 	"time"
@@ -21,16 +21,43 @@ import (
 	"sigs.k8s.io/controller-runtime/pkg/manager"
 )
 
-func NewSnapshot() *Snapshot {
-	recon := &Snapshot{lock: &sync.RWMutex{}}
+// event reasons
+const (
+	ReasonBackup       = "EtcdBackup"
+	ReasonBackupFailed = "EtcdBackupFailed"
+)
+
+func NewSnapshot(record record.EventRecorder) *Snapshot {
+	recon := &Snapshot{lock: &sync.RWMutex{}, record: record, snapshotter: EtcdSnapshot}
 	return recon
 }
 
+// NewBareSnapshot snapshot runner out of the operator, backups are
+// uploaded to index and the cluster spec is given on each backup.
 func NewBareSnapshot(index *index.GenericIndexer) *Snapshot {
 	return &Snapshot{
-		index: index,
-		lock:  &sync.RWMutex{},
+		index:       index,
+		lock:        &sync.RWMutex{},
+		snapshotter: EtcdSnapshot,
+	}
+}
+
+// Snapshotter take etcd snapshot of masters into file dst
+type Snapshotter func(masters []api.Master, spec *api.Cluster, dst string) (*etcd.SnapshotSource, error)
+
+// EtcdSnapshot take etcd snapshot with client certs signed under ETCD_TMP
+func EtcdSnapshot(masters []api.Master, spec *api.Cluster, dst string) (*etcd.SnapshotSource, error) {
+	metcd, err := etcd.NewEtcdFromCRD(masters, spec, etcd.ETCD_TMP)
+	if err != nil {
+		return nil, fmt.Errorf("new etcd: %s", err.Error())
 	}
+	return metcd.Snapshot(dst)
+}
+
+// WithSnapshotter take etcd snapshot with snapshotter
+func (s *Snapshot) WithSnapshotter(snapshotter Snapshotter) *Snapshot {
+	s.snapshotter = snapshotter
+	return s
 }
 
 var _ manager.Runnable = &Snapshot{}
@@ -41,6 +68,8 @@ type Snapshot struct {
 	cache  cache.Cache
 	client client.Client
 	index  *index.GenericIndexer
+	// snapshotter take etcd snapshot, EtcdSnapshot by default
+	snapshotter Snapshotter
 	//record event recorder
 	record record.EventRecorder
 }
@@ -69,9 +98,14 @@ func (s *Snapshot) Start(ctx context.Context) error {
 
 	backup := func() {
 		err := s.doBackup()
+		backups.WithLabelValues(result(err)).Inc()
 		if err != nil {
 			klog.Errorf("backup etcd: %s", err.Error())
+			s.record.Eventf(s.spec, v1.EventTypeWarning, ReasonBackupFailed, "backup etcd: %s", err.Error())
+			return
 		}
+		latest.set(time.Now())
+		s.record.Event(s.spec, v1.EventTypeNormal, ReasonBackup, "etcd backup uploaded")
 	}
 	wait.Forever(backup, 10*time.Minute)
 	return nil
@@ -100,6 +134,21 @@ func (s *Snapshot) CleanUp() {
 	if err != nil {
 		klog.Errorf("gc backup fail: %s", err.Error())
 	}
+	err = s.index.VerifyReplicas()
+	if err != nil {
+		klog.Errorf("verify backup replicas: %s", err.Error())
+	}
+	backup, err := s.index.SelectBackup("", time.Time{})
+	if err != nil {
+		klog.Warningf("find latest backup: %s", err.Error())
+		return
+	}
+	created, err := backup.Time()
+	if err != nil {
+		klog.Warningf("latest backup time: %s", err.Error())
+		return
+	}
+	latest.set(created)
 }
 
 func (s *Snapshot) doBackup() error {
@@ -124,27 +173,34 @@ func (s *Snapshot) Backup(
 	spec *api.Cluster,
 	masters []api.Master,
 ) error {
-	if err := s.initialize(); err != nil {
-		return errors.Wrapf(err, "initialize snapshot backup failed")
-	}
-	metcd, err := etcd.NewEtcdFromCRD(masters, spec, etcd.ETCD_TMP)
-	if err != nil {
-		return fmt.Errorf("new etcd: %s", err.Error())
-	}
+	return s.BackupWithName(spec, masters, "")
+}
 
-	src := filepath.Join(index.SnapshotTMP)
-	err = metcd.Snapshot(src)
-	if err != nil {
-		return errors.Wrap(err, "snapshot etcd")
-	}
-	mid, err := s.index.GetCluster(spec.Spec.ClusterID)
-	if err != nil {
-		return errors.Wrap(err, "load cluster id")
+// BackupWithName take an etcd snapshot and upload it as a named backup.
+// empty name means a periodic backup which is subject to gc.
+func (s *Snapshot) BackupWithName(
+	spec *api.Cluster,
+	masters []api.Master,
+	name string,
+) error {
+	// index is injected by NewBareSnapshot, or initialized on start
+	if s.index == nil {
+		if err := s.initialize(); err != nil {
+			return errors.Wrapf(err, "initialize snapshot backup failed")
+		}
 	}
-	mid.Spec.Cluster = spec.Spec
-	err = s.index.SaveCluster(mid)
+	source, err := s.snapshotter(masters, spec, s.index.SnapshotFile())
 	if err != nil {
-		return errors.Wrapf(err, "save cluster spec")
+		return errors.Wrap(err, "snapshot etcd")
 	}
-	return s.index.Backup(spec.Spec)
+	// cluster spec in index is kept in sync with the CR by indexsync
+	return s.index.BackupFrom(
+		spec.Spec, name,
+		&index.Source{
+			Endpoint: source.Endpoint,
+			Member:   source.MemberID,
+			Leader:   source.Leader,
+			Revision: source.Revision,
+		},
+	)
 }
@@ -0,0 +1,43 @@
+package backup
+
+import (
+	"github.com/aoxn/wdrip/pkg/actions/etcd"
+	api "github.com/aoxn/wdrip/pkg/apis/alibabacloud.com/v1"
+	"github.com/aoxn/wdrip/pkg/iaas/provider/fake"
+	"github.com/aoxn/wdrip/pkg/index"
+	"github.com/stretchr/testify/assert"
+	"io/ioutil"
+	"path/filepath"
+	"testing"
+)
+
+func TestBareSnapshotBackupWithName(t *testing.T) {
+	store := fake.NewObjectStorage("wdrip-index")
+	idx := index.NewGenericIndexer("kubernetes-wdrip-64", store)
+	idx.WithSnapshotFile(filepath.Join(t.TempDir(), "snapshot.db"))
+
+	spec := &api.Cluster{}
+	spec.Spec.ClusterID = "kubernetes-wdrip-64"
+	masters := []api.Master{{Spec: api.MasterSpec{IP: "192.168.0.1"}}}
+	snapshotter := func(ms []api.Master, cluster *api.Cluster, dst string) (*etcd.SnapshotSource, error) {
+		assert.Equal(t, masters, ms)
+		assert.Equal(t, spec, cluster)
+		err := ioutil.WriteFile(dst, []byte("etcd snapshot"), 0644)
+		return &etcd.SnapshotSource{Endpoint: "https://192.168.0.1:2379", Leader: true, Revision: 7}, err
+	}
+
+	snap := NewBareSnapshot(idx).WithSnapshotter(snapshotter)
+	assert.Nil(t, snap.BackupWithName(spec, masters, "before-upgrade"))
+
+	backup, err := idx.GetBackup("before-upgrade")
+	assert.Nil(t, err)
+	assert.Equal(t, int64(7), backup.Source.Revision)
+	snapshot, err := idx.Snapshot()
+	assert.Nil(t, err)
+	data, err := store.GetObject(snapshot.Path(*backup))
+	assert.Nil(t, err)
+	assert.Equal(t, "etcd snapshot", string(data))
+
+	// backup name is unique
+	assert.NotNil(t, snap.BackupWithName(spec, masters, "before-upgrade"))
+}
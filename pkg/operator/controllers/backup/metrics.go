@@ -0,0 +1,61 @@
+package backup
+
+import (
+	"github.com/prometheus/client_golang/prometheus"
+	"sigs.k8s.io/controller-runtime/pkg/metrics"
+	"sync"
+	"time"
+)
+
+var (
+	backups = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Namespace: "wdrip",
+			Subsystem: "backup",
+			Name:      "total",
+			Help:      "Periodic etcd backups taken per result.",
+		}, []string{"result"},
+	)
+	backupAge = prometheus.NewGaugeFunc(
+		prometheus.GaugeOpts{
+			Namespace: "wdrip",
+			Subsystem: "backup",
+			Name:      "age_seconds",
+			Help:      "Age of the latest etcd backup, 0 until the backup index is loaded.",
+		}, latest.age,
+	)
+)
+
+// latest time of the latest etcd backup
+var latest = &latestBackup{}
+
+type latestBackup struct {
+	lock sync.RWMutex
+	at   time.Time
+}
+
+func (l *latestBackup) set(at time.Time) {
+	l.lock.Lock()
+	defer l.lock.Unlock()
+	if at.After(l.at) {
+		l.at = at
+	}
+}
+
+func (l *latestBackup) age() float64 {
+	l.lock.RLock()
+	defer l.lock.RUnlock()
+	if l.at.IsZero() {
+		return 0
+	}
+	return time.Since(l.at).Seconds()
+}
+
+func init() { metrics.Registry.MustRegister(backups, backupAge) }
+
+func result(err error) string {
+	if err != nil {
+		return "failure"
+	}
+	return "success"
+}
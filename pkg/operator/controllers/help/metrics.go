@@ -0,0 +1,24 @@
+package help
+
+import (
+	"github.com/prometheus/client_golang/prometheus"
+	"sigs.k8s.io/controller-runtime/pkg/metrics"
+)
+
+var apiErrors = prometheus.NewCounterVec(
+	prometheus.CounterOpts{
+		Namespace: "wdrip",
+		Subsystem: "provider",
+		Name:      "api_errors_total",
+		Help:      "Failed cloud provider API calls per API.",
+	}, []string{"api"},
+)
+
+func init() { metrics.Registry.MustRegister(apiErrors) }
+
+// CountAPIError count err returned by cloud provider api, nil is ignored
+func CountAPIError(api string, err error) {
+	if err != nil {
+		apiErrors.WithLabelValues(api).Inc()
+	}
+}
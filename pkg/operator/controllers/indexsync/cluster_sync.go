@@ -0,0 +1,159 @@
+package indexsync
+
+import (
+	"context"
+	"fmt"
+	api "github.com/aoxn/wdrip/pkg/apis/alibabacloud.com/v1"
+	"github.com/aoxn/wdrip/pkg/context/shared"
+	"github.com/aoxn/wdrip/pkg/iaas/provider"
+	"github.com/aoxn/wdrip/pkg/index"
+	"github.com/aoxn/wdrip/pkg/operator/controllers/help"
+	gerr "github.com/pkg/errors"
+	v1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/tools/record"
+	"k8s.io/klog/v2"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/controller"
+	"sigs.k8s.io/controller-runtime/pkg/handler"
+	"sigs.k8s.io/controller-runtime/pkg/manager"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+	"sigs.k8s.io/controller-runtime/pkg/source"
+	"strings"
+	"time"
+)
+
+// AddClusterSync sync Cluster with ClusterId in the index
+func AddClusterSync(
+	mgr manager.Manager,
+	ctx *shared.SharedOperatorContext,
+) error {
+	r := &ReconcileClusterSync{
+		client: mgr.GetClient(),
+		prvd:   ctx.ProvdIAAS(),
+		recd:   mgr.GetEventRecorderFor("index-sync"),
+	}
+	c, err := controller.New(
+		"cluster-index-sync", mgr,
+		controller.Options{
+			Reconciler:              r,
+			MaxConcurrentReconciles: 1,
+		},
+	)
+	if err != nil {
+		return fmt.Errorf("create cluster index sync controller: %s", err.Error())
+	}
+	return c.Watch(
+		&source.Kind{
+			Type: &api.Cluster{},
+		},
+		&handler.EnqueueRequestForObject{},
+	)
+}
+
+var _ reconcile.Reconciler = &ReconcileClusterSync{}
+
+type ReconcileClusterSync struct {
+	prvd   provider.Interface
+	client client.Client
+	recd   record.EventRecorder
+}
+
+func (r *ReconcileClusterSync) Reconcile(
+	ctx context.Context, request reconcile.Request,
+) (reconcile.Result, error) {
+	cluster := &api.Cluster{}
+	err := r.client.Get(ctx, request.NamespacedName, cluster)
+	if err != nil {
+		if errors.IsNotFound(err) {
+			return reconcile.Result{}, nil
+		}
+		return help.NewDelay(3), err
+	}
+	if !cluster.DeletionTimestamp.IsZero() ||
+		cluster.Spec.ClusterID == "" {
+		return reconcile.Result{}, nil
+	}
+	name := cluster.Spec.ClusterID
+	idx := index.NewClusterIndex(name, r.prvd)
+	id, err := idx.GetCluster(name)
+	if err != nil {
+		// ClusterId is created by wdrip create, never recreated here.
+		reason := ReasonSyncFailed
+		if strings.Contains(err.Error(), "NoSuchKey") {
+			reason = ReasonIndexNotFound
+		}
+		return reconcile.Result{RequeueAfter: SyncPeriod}, r.fail(cluster, reason, err)
+	}
+
+	objHash, err := specHash(cluster.Spec)
+	if err != nil {
+		return reconcile.Result{}, gerr.Wrapf(err, "hash cluster %s", cluster.Name)
+	}
+	idxHash, err := specHash(id.Spec.Cluster)
+	if err != nil {
+		return reconcile.Result{}, gerr.Wrapf(err, "hash ClusterId %s", name)
+	}
+	dir, conflict := decide(
+		cluster.Annotations[api.IndexSyncedHashAnnotation], objHash, idxHash,
+	)
+	synced, generation := objHash, id.Generation
+	switch dir {
+	case toIndex:
+		id.Spec.Cluster = cluster.Spec
+		id.Spec.UpdatedAt = time.Now().Format("2006-01-02T15:04:05")
+		err = idx.SaveCluster(id)
+		if err != nil {
+			if index.IsConflict(err) {
+				klog.Infof("ClusterId %s modified concurrently, retry sync: %s", name, err.Error())
+				return help.NewDelay(1), nil
+			}
+			return help.NewDelay(10), r.fail(cluster, ReasonSyncFailed, err)
+		}
+		generation = id.Generation + 1
+	case toCluster:
+		synced = idxHash
+	}
+	reason, message := result(dir, conflict, "Cluster", cluster.Name, generation)
+	if dir != inSync {
+		klog.Infof("index sync: %s", message)
+	}
+	if conflict {
+		r.recd.Event(cluster, v1.EventTypeWarning, reason, message)
+	}
+	diff := func(copy runtime.Object) (client.Object, error) {
+		mc := copy.(*api.Cluster)
+		if dir == toCluster {
+			if h, _ := specHash(mc.Spec); h != objHash {
+				return nil, fmt.Errorf("cluster %s modified during sync, retry", mc.Name)
+			}
+			mc.Spec = id.Spec.Cluster
+		}
+		markSynced(mc, synced, generation)
+		setCondition(&mc.Status.Conditions, mc.Generation, reason, message)
+		return mc, nil
+	}
+	err = help.Patch(r.client, cluster, diff, help.PatchSpec)
+	if err != nil {
+		return help.NewDelay(3), gerr.Wrapf(err, "patch cluster %s sync status", cluster.Name)
+	}
+	return reconcile.Result{RequeueAfter: SyncPeriod}, nil
+}
+
+// fail report sync failure in condition, err is returned for requeue
+func (r *ReconcileClusterSync) fail(cluster *api.Cluster, reason string, err error) error {
+	diff := func(copy runtime.Object) (client.Object, error) {
+		mc := copy.(*api.Cluster)
+		setCondition(&mc.Status.Conditions, mc.Generation, reason, err.Error())
+		return mc, nil
+	}
+	if perr := help.Patch(r.client, cluster, diff, help.PatchSpec); perr != nil {
+		klog.Warningf("patch cluster %s sync condition: %s", cluster.Name, perr.Error())
+	}
+	if reason == ReasonIndexNotFound {
+		klog.Warningf("ClusterId of %s not found in index, skip sync", cluster.Name)
+		return nil
+	}
+	return gerr.Wrapf(err, "sync cluster %s", cluster.Name)
+}
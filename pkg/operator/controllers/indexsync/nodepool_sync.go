@@ -0,0 +1,154 @@
+package indexsync
+
+import (
+	"context"
+	"fmt"
+	api "github.com/aoxn/wdrip/pkg/apis/alibabacloud.com/v1"
+	"github.com/aoxn/wdrip/pkg/context/shared"
+	"github.com/aoxn/wdrip/pkg/iaas/provider"
+	"github.com/aoxn/wdrip/pkg/index"
+	"github.com/aoxn/wdrip/pkg/operator/controllers/help"
+	gerr "github.com/pkg/errors"
+	v1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/tools/record"
+	"k8s.io/klog/v2"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/controller"
+	"sigs.k8s.io/controller-runtime/pkg/handler"
+	"sigs.k8s.io/controller-runtime/pkg/manager"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+	"sigs.k8s.io/controller-runtime/pkg/source"
+	"strings"
+)
+
+// AddNodePoolSync sync NodePool with its copy in the index. removal of
+// the index copy on NodePool deletion is left to nodepool controller.
+func AddNodePoolSync(
+	mgr manager.Manager,
+	ctx *shared.SharedOperatorContext,
+) error {
+	r := &ReconcileNodePoolSync{
+		client: mgr.GetClient(),
+		prvd:   ctx.ProvdIAAS(),
+		recd:   mgr.GetEventRecorderFor("index-sync"),
+	}
+	c, err := controller.New(
+		"nodepool-index-sync", mgr,
+		controller.Options{
+			Reconciler:              r,
+			MaxConcurrentReconciles: 1,
+		},
+	)
+	if err != nil {
+		return fmt.Errorf("create nodepool index sync controller: %s", err.Error())
+	}
+	return c.Watch(
+		&source.Kind{
+			Type: &api.NodePool{},
+		},
+		&handler.EnqueueRequestForObject{},
+	)
+}
+
+var _ reconcile.Reconciler = &ReconcileNodePoolSync{}
+
+type ReconcileNodePoolSync struct {
+	prvd   provider.Interface
+	client client.Client
+	recd   record.EventRecorder
+}
+
+func (r *ReconcileNodePoolSync) Reconcile(
+	ctx context.Context, request reconcile.Request,
+) (reconcile.Result, error) {
+	np := &api.NodePool{}
+	err := r.client.Get(ctx, request.NamespacedName, np)
+	if err != nil {
+		if errors.IsNotFound(err) {
+			return reconcile.Result{}, nil
+		}
+		return help.NewDelay(3), err
+	}
+	if !np.DeletionTimestamp.IsZero() {
+		return reconcile.Result{}, nil
+	}
+	spec, err := help.Cluster(r.client, "kubernetes-cluster")
+	if err != nil {
+		return help.NewDelay(10), gerr.Wrapf(err, "get cluster id")
+	}
+	idx := index.NewNodePoolIndex(spec.Spec.ClusterID, r.prvd)
+
+	objHash, err := specHash(np.Spec)
+	if err != nil {
+		return reconcile.Result{}, gerr.Wrapf(err, "hash nodepool %s", np.Name)
+	}
+	idxHash, generation := "", int64(0)
+	inp, err := idx.GetNodePool(np.Name)
+	switch {
+	case err == nil:
+		idxHash, err = specHash(inp.Spec)
+		if err != nil {
+			return reconcile.Result{}, gerr.Wrapf(err, "hash index nodepool %s", np.Name)
+		}
+		generation = syncedGeneration(&inp)
+	case !strings.Contains(err.Error(), "NoSuchKey"):
+		return help.NewDelay(10), r.fail(np, err)
+	}
+	dir, conflict := decide(
+		np.Annotations[api.IndexSyncedHashAnnotation], objHash, idxHash,
+	)
+	synced := objHash
+	switch dir {
+	case toIndex:
+		generation++
+		mp := np.DeepCopy()
+		mp.Status = api.NodePoolStatus{}
+		mp.ResourceVersion = ""
+		markSynced(mp, objHash, generation)
+		err = idx.SaveNodePool(*mp)
+		if err != nil {
+			return help.NewDelay(10), r.fail(np, err)
+		}
+	case toCluster:
+		synced = idxHash
+	}
+	reason, message := result(dir, conflict, "NodePool", np.Name, generation)
+	if dir != inSync {
+		klog.Infof("index sync: %s", message)
+	}
+	if conflict {
+		r.recd.Event(np, v1.EventTypeWarning, reason, message)
+	}
+	diff := func(copy runtime.Object) (client.Object, error) {
+		mp := copy.(*api.NodePool)
+		if dir == toCluster {
+			if h, _ := specHash(mp.Spec); h != objHash {
+				return nil, fmt.Errorf("nodepool %s modified during sync, retry", mp.Name)
+			}
+			mp.Spec = inp.Spec
+		}
+		markSynced(mp, synced, generation)
+		setCondition(&mp.Status.Conditions, mp.Generation, reason, message)
+		return mp, nil
+	}
+	err = help.Patch(r.client, np, diff, help.PatchSpec)
+	if err != nil {
+		return help.NewDelay(3), gerr.Wrapf(err, "patch nodepool %s sync status", np.Name)
+	}
+	return reconcile.Result{RequeueAfter: SyncPeriod}, nil
+}
+
+// fail report sync failure in condition, err is returned for requeue
+func (r *ReconcileNodePoolSync) fail(np *api.NodePool, err error) error {
+	diff := func(copy runtime.Object) (client.Object, error) {
+		mp := copy.(*api.NodePool)
+		setCondition(&mp.Status.Conditions, mp.Generation, ReasonSyncFailed, err.Error())
+		return mp, nil
+	}
+	if perr := help.Patch(r.client, np, diff, help.PatchSpec); perr != nil {
+		klog.Warningf("patch nodepool %s sync condition: %s", np.Name, perr.Error())
+	}
+	return gerr.Wrapf(err, "sync nodepool %s, last synced generation %d", np.Name, syncedGeneration(np))
+}
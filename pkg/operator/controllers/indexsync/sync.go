@@ -0,0 +1,139 @@
+// Package indexsync keeps in-cluster Cluster and NodePool objects in sync
+// with their copies in the object storage index, in both directions.
+//
+// Each object records the hash of the spec last synced and the generation
+// of the index copy in annotations. On every reconcile, and every SyncPeriod
+// to pick up bucket side edits, the spec of both sides is compared with the
+// recorded hash:
+//
+//   - neither side changed, nothing to do.
+//   - only the in-cluster object changed, it is written to the index.
+//   - only the index copy changed, it is applied to the in-cluster object.
+//   - both changed, the in-cluster object wins. it is what controllers act
+//     on. the overwritten ClusterId is kept in its revision history, see
+//     `wdrip history`. the condition reports Conflict in this case.
+//
+// index copy missing, or object never synced before, is treated as changed
+// in-cluster, the index copy is (re)written.
+// condition IndexSynced is True once both sides are in sync, its reason
+// tells the last sync action. it is False when sync failed.
+package indexsync
+
+import (
+	"fmt"
+	api "github.com/aoxn/wdrip/pkg/apis/alibabacloud.com/v1"
+	"github.com/aoxn/wdrip/pkg/utils/hash"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"strconv"
+	"time"
+)
+
+// SyncPeriod interval to poll the index for bucket side edits
+var SyncPeriod = 1 * time.Minute
+
+type direction string
+
+const (
+	inSync    direction = "InSync"
+	toIndex   direction = "ToIndex"
+	toCluster direction = "ToCluster"
+)
+
+// Condition reasons of api.ConditionIndexSynced
+const (
+	ReasonSynced          = "Synced"
+	ReasonPushed          = "PushedToIndex"
+	ReasonPulled          = "PulledFromIndex"
+	ReasonConflict        = "Conflict"
+	ReasonIndexNotFound   = "IndexNotFound"
+	ReasonSyncFailed      = "SyncFailed"
+	conditionMessageLimit = 256
+)
+
+// decide which way to sync by the spec hash of the in-cluster object,
+// of the index copy and the hash last synced. empty index hash means
+// the index copy does not exist, empty last hash means never synced.
+// conflict is true when both changed.
+func decide(last, object, idx string) (direction, bool) {
+	if object == idx {
+		return inSync, false
+	}
+	if idx == "" || last == "" {
+		return toIndex, false
+	}
+	objectChanged, indexChanged := object != last, idx != last
+	switch {
+	case objectChanged && indexChanged:
+		return toIndex, true
+	case indexChanged:
+		return toCluster, false
+	}
+	return toIndex, false
+}
+
+func specHash(spec interface{}) (string, error) {
+	if spec == nil {
+		return "", nil
+	}
+	return hash.HashObject(spec)
+}
+
+// syncedGeneration generation of the index copy last synced
+func syncedGeneration(o metav1.Object) int64 {
+	g, _ := strconv.ParseInt(o.GetAnnotations()[api.IndexGenerationAnnotation], 10, 64)
+	return g
+}
+
+// markSynced record the synced hash and index generation on object
+func markSynced(o metav1.Object, hash string, generation int64) {
+	annotations := o.GetAnnotations()
+	if annotations == nil {
+		annotations = map[string]string{}
+	}
+	annotations[api.IndexSyncedHashAnnotation] = hash
+	annotations[api.IndexGenerationAnnotation] = strconv.FormatInt(generation, 10)
+	o.SetAnnotations(annotations)
+}
+
+// setCondition set IndexSynced condition. synced condition is left
+// as is, so that the last sync action is kept in reason.
+func setCondition(conditions *[]metav1.Condition, generation int64, reason, message string) {
+	status := metav1.ConditionTrue
+	switch reason {
+	case ReasonIndexNotFound, ReasonSyncFailed:
+		status = metav1.ConditionFalse
+	case ReasonSynced:
+		if meta.IsStatusConditionTrue(*conditions, api.ConditionIndexSynced) {
+			return
+		}
+	}
+	if len(message) > conditionMessageLimit {
+		message = message[:conditionMessageLimit]
+	}
+	meta.SetStatusCondition(
+		conditions,
+		metav1.Condition{
+			Type:               api.ConditionIndexSynced,
+			Status:             status,
+			ObservedGeneration: generation,
+			Reason:             reason,
+			Message:            message,
+		},
+	)
+}
+
+// result condition reason and message of a sync in the given direction
+func result(dir direction, conflict bool, kind, name string, generation int64) (string, string) {
+	switch {
+	case conflict:
+		return ReasonConflict, fmt.Sprintf(
+			"%s %s and its index copy were both modified, index overwritten "+
+				"with in-cluster object at generation %d", kind, name, generation)
+	case dir == toIndex:
+		return ReasonPushed, fmt.Sprintf("index copy of %s %s updated to generation %d", kind, name, generation)
+	case dir == toCluster:
+		return ReasonPulled, fmt.Sprintf("%s %s updated from index generation %d", kind, name, generation)
+	}
+	return ReasonSynced, fmt.Sprintf("%s %s in sync with index generation %d", kind, name, generation)
+}
@@ -0,0 +1,60 @@
+package indexsync
+
+import (
+	api "github.com/aoxn/wdrip/pkg/apis/alibabacloud.com/v1"
+	"github.com/stretchr/testify/assert"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"testing"
+)
+
+func TestDecide(t *testing.T) {
+	cases := []struct {
+		name     string
+		last     string
+		object   string
+		idx      string
+		dir      direction
+		conflict bool
+	}{
+		{name: "in sync", last: "a", object: "a", idx: "a", dir: inSync},
+		{name: "in sync, never recorded", object: "a", idx: "a", dir: inSync},
+		{name: "object changed", last: "a", object: "b", idx: "a", dir: toIndex},
+		{name: "index changed", last: "a", object: "a", idx: "b", dir: toCluster},
+		{name: "both changed", last: "a", object: "b", idx: "c", dir: toIndex, conflict: true},
+		{name: "index missing", last: "a", object: "a", dir: toIndex},
+		{name: "never synced", object: "a", idx: "b", dir: toIndex},
+	}
+	for _, c := range cases {
+		dir, conflict := decide(c.last, c.object, c.idx)
+		assert.Equal(t, c.dir, dir, c.name)
+		assert.Equal(t, c.conflict, conflict, c.name)
+	}
+}
+
+func TestSetCondition(t *testing.T) {
+	var conditions []metav1.Condition
+	setCondition(&conditions, 1, ReasonPushed, "pushed")
+	assert.True(t, meta.IsStatusConditionTrue(conditions, api.ConditionIndexSynced))
+
+	// last sync action is kept while in sync
+	setCondition(&conditions, 2, ReasonSynced, "in sync")
+	cond := meta.FindStatusCondition(conditions, api.ConditionIndexSynced)
+	assert.Equal(t, ReasonPushed, cond.Reason)
+
+	setCondition(&conditions, 2, ReasonSyncFailed, "access denied")
+	assert.True(t, meta.IsStatusConditionFalse(conditions, api.ConditionIndexSynced))
+
+	setCondition(&conditions, 2, ReasonSynced, "in sync")
+	cond = meta.FindStatusCondition(conditions, api.ConditionIndexSynced)
+	assert.Equal(t, metav1.ConditionTrue, cond.Status)
+	assert.Equal(t, ReasonSynced, cond.Reason)
+	assert.Len(t, conditions, 1)
+}
+
+func TestMarkSynced(t *testing.T) {
+	np := &api.NodePool{}
+	markSynced(np, "hash", 3)
+	assert.Equal(t, "hash", np.Annotations[api.IndexSyncedHashAnnotation])
+	assert.Equal(t, int64(3), syncedGeneration(np))
+}
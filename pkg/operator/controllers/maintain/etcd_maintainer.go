@@ -0,0 +1,200 @@
+package maintain
+
+import (
+	"context"
+	"fmt"
+	"github.com/aoxn/wdrip/pkg/actions/etcd"
+	api "github.com/aoxn/wdrip/pkg/apis/alibabacloud.com/v1"
+	h "github.com/aoxn/wdrip/pkg/operator/controllers/help"
+	"github.com/pkg/errors"
+	v1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/util/wait"
+	"k8s.io/client-go/tools/record"
+	"k8s.io/klog/v2"
+	"sigs.k8s.io/controller-runtime/pkg/cache"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/manager"
+	"time"
+)
+
+const (
+	// MaintainPeriod period to check etcd usage and alarms
+	MaintainPeriod = 5 * time.Minute
+
+	// DefragThreshold fragmentation ratio to defragment a member
+	DefragThreshold = 0.5
+
+	// DefragMinReclaim defragment only when at least this much disk
+	// would be reclaimed, small db is not worth the blocking.
+	DefragMinReclaim = 64 << 20
+)
+
+// event reasons
+const (
+	ReasonDefragment     = "EtcdDefragment"
+	ReasonDefragFailed   = "EtcdDefragmentFailed"
+	ReasonNoSpace        = "EtcdNoSpace"
+	ReasonCompact        = "EtcdCompact"
+	ReasonCompactFailed  = "EtcdCompactFailed"
+	ReasonDisarm         = "EtcdAlarmDisarm"
+	ReasonDisarmFailed   = "EtcdAlarmDisarmFailed"
+	ReasonNoSpaceRecover = "EtcdNoSpaceRecovered"
+)
+
+func NewEtcdMaintainer(record record.EventRecorder) *EtcdMaintainer {
+	return &EtcdMaintainer{record: record}
+}
+
+var _ manager.Runnable = &EtcdMaintainer{}
+
+// EtcdMaintainer keeps etcd under its quota. db usage of every member is
+// tracked, members over DefragThreshold are defragmented one at a time
+// with the leader last, and a NOSPACE alarm is recovered by compaction,
+// defragmentation of all members and disarming the alarm.
+type EtcdMaintainer struct {
+	cache  cache.Cache
+	client client.Client
+	//record event recorder
+	record record.EventRecorder
+}
+
+func (s *EtcdMaintainer) InjectCache(cache cache.Cache) error {
+	s.cache = cache
+	return nil
+}
+
+func (s *EtcdMaintainer) InjectClient(me client.Client) error {
+	s.client = me
+	return nil
+}
+
+func (s *EtcdMaintainer) Start(ctx context.Context) error {
+	klog.Infof("trying to start etcd maintainer")
+	if !s.cache.WaitForCacheSync(ctx) {
+		return fmt.Errorf("wait for cache sync failed")
+	}
+	maintain := func() {
+		err := s.Maintain()
+		if err != nil {
+			klog.Errorf("maintain etcd: %s", err.Error())
+		}
+	}
+	wait.Until(maintain, MaintainPeriod, ctx.Done())
+	return nil
+}
+
+// Maintain one round of etcd maintenance
+func (s *EtcdMaintainer) Maintain() error {
+	masters, err := h.MasterCRDS(s.client)
+	if err != nil {
+		return fmt.Errorf("member master: %s", err.Error())
+	}
+	if len(masters) <= 0 {
+		return fmt.Errorf("master crd not found %d, abort maintain", len(masters))
+	}
+	spec, err := h.Cluster(s.client, api.KUBERNETES_CLUSTER)
+	if err != nil {
+		return fmt.Errorf("member: spec not found,%s", err.Error())
+	}
+	metcd, err := etcd.NewEtcdFromCRD(masters, spec, etcd.ETCD_TMP)
+	if err != nil {
+		return fmt.Errorf("new etcd: %s", err.Error())
+	}
+
+	alarms, err := metcd.Alarms()
+	if err != nil {
+		return errors.Wrap(err, "list alarm")
+	}
+	nospaces := etcd.NoSpace(alarms)
+	nospace.Set(float64(len(nospaces)))
+
+	usages, err := metcd.Usages()
+	if err != nil {
+		return errors.Wrap(err, "db usage")
+	}
+	for _, u := range usages {
+		dbSize.WithLabelValues(u.MemberID).Set(float64(u.DBSize))
+		dbInUse.WithLabelValues(u.MemberID).Set(float64(u.InUse))
+		fragmentation.WithLabelValues(u.MemberID).Set(u.Fragmentation())
+	}
+	if len(nospaces) > 0 {
+		return s.recover(spec, metcd, usages, nospaces)
+	}
+	return s.defragment(spec, metcd, etcd.DefragOrder(usages, DefragThreshold, DefragMinReclaim))
+}
+
+// recover NOSPACE alarm: compact at the latest revision, defragment
+// every member and disarm the alarms. alarms are kept on any failure.
+func (s *EtcdMaintainer) recover(
+	spec *api.Cluster,
+	metcd *etcd.Etcd,
+	usages []etcd.Usage,
+	alarms []*etcd.AlarmMember,
+) error {
+	s.record.Eventf(
+		spec, v1.EventTypeWarning, ReasonNoSpace,
+		"etcd NOSPACE alarm raised on %d members, cluster is read only", len(alarms),
+	)
+	var revision int64
+	for _, u := range usages {
+		if u.Revision > revision {
+			revision = u.Revision
+		}
+	}
+	err := metcd.Compact(revision)
+	if etcd.IsCompacted(err) {
+		err = nil
+	}
+	compactions.WithLabelValues(result(err)).Inc()
+	if err != nil {
+		s.record.Eventf(spec, v1.EventTypeWarning, ReasonCompactFailed, "compact at revision %d: %s", revision, err.Error())
+		return errors.Wrapf(err, "compact at revision %d", revision)
+	}
+	s.record.Eventf(spec, v1.EventTypeNormal, ReasonCompact, "compacted at revision %d", revision)
+
+	err = s.defragment(spec, metcd, etcd.DefragOrder(usages, 0, 0))
+	if err != nil {
+		return err
+	}
+	for _, alarm := range alarms {
+		err := metcd.Disarm(alarm)
+		disarms.WithLabelValues(result(err)).Inc()
+		if err != nil {
+			s.record.Eventf(spec, v1.EventTypeWarning, ReasonDisarmFailed,
+				"disarm NOSPACE alarm of member %x: %s", alarm.MemberID, err.Error())
+			return errors.Wrapf(err, "disarm alarm of member %x", alarm.MemberID)
+		}
+		s.record.Eventf(spec, v1.EventTypeNormal, ReasonDisarm, "NOSPACE alarm of member %x disarmed", alarm.MemberID)
+	}
+	nospace.Set(0)
+	s.record.Eventf(spec, v1.EventTypeNormal, ReasonNoSpaceRecover, "etcd recovered from NOSPACE alarm")
+	return nil
+}
+
+// defragment members in order, one at a time. next member is started
+// only after the previous one is healthy again, and the rest is
+// abandoned on failure.
+func (s *EtcdMaintainer) defragment(
+	spec *api.Cluster,
+	metcd *etcd.Etcd,
+	order []etcd.Usage,
+) error {
+	for _, u := range order {
+		klog.Infof("defragment etcd member %s[%s]: db=%d, inuse=%d, leader=%t",
+			u.MemberID, u.Endpoint, u.DBSize, u.InUse, u.Leader)
+		err := metcd.Defragment(u.Endpoint)
+		defrags.WithLabelValues(u.MemberID, result(err)).Inc()
+		if err != nil {
+			s.record.Eventf(spec, v1.EventTypeWarning, ReasonDefragFailed,
+				"defragment member %s: %s", u.MemberID, err.Error())
+			return errors.Wrapf(err, "defragment member %s", u.MemberID)
+		}
+		s.record.Eventf(spec, v1.EventTypeNormal, ReasonDefragment,
+			"member %s defragmented, %d of %d bytes reclaimable", u.MemberID, u.Reclaimable(), u.DBSize)
+		err = metcd.WaitEndpoints(u.Endpoint)
+		if err != nil {
+			return errors.Wrapf(err, "wait member %s healthy after defragment", u.MemberID)
+		}
+	}
+	return nil
+}
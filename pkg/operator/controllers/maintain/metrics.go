@@ -0,0 +1,78 @@
+package maintain
+
+import (
+	"github.com/prometheus/client_golang/prometheus"
+	"sigs.k8s.io/controller-runtime/pkg/metrics"
+)
+
+var (
+	dbSize = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Namespace: "wdrip",
+			Subsystem: "etcd",
+			Name:      "db_size_bytes",
+			Help:      "Size of etcd backend db allocated on disk.",
+		}, []string{"member"},
+	)
+	dbInUse = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Namespace: "wdrip",
+			Subsystem: "etcd",
+			Name:      "db_size_in_use_bytes",
+			Help:      "Size of etcd backend db logically in use.",
+		}, []string{"member"},
+	)
+	fragmentation = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Namespace: "wdrip",
+			Subsystem: "etcd",
+			Name:      "db_fragmentation_ratio",
+			Help:      "Ratio of etcd backend db reclaimable by defragmentation.",
+		}, []string{"member"},
+	)
+	nospace = prometheus.NewGauge(
+		prometheus.GaugeOpts{
+			Namespace: "wdrip",
+			Subsystem: "etcd",
+			Name:      "nospace_alarms",
+			Help:      "Number of active etcd NOSPACE alarms.",
+		},
+	)
+	defrags = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Namespace: "wdrip",
+			Subsystem: "etcd",
+			Name:      "defrag_total",
+			Help:      "Defragmentations run by wdrip per member and result.",
+		}, []string{"member", "result"},
+	)
+	compactions = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Namespace: "wdrip",
+			Subsystem: "etcd",
+			Name:      "compaction_total",
+			Help:      "Compactions run by wdrip on NOSPACE recovery per result.",
+		}, []string{"result"},
+	)
+	disarms = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Namespace: "wdrip",
+			Subsystem: "etcd",
+			Name:      "alarm_disarm_total",
+			Help:      "NOSPACE alarms disarmed by wdrip per result.",
+		}, []string{"result"},
+	)
+)
+
+func init() {
+	metrics.Registry.MustRegister(
+		dbSize, dbInUse, fragmentation, nospace, defrags, compactions, disarms,
+	)
+}
+
+func result(err error) string {
+	if err != nil {
+		return "failure"
+	}
+	return "success"
+}
@@ -27,6 +27,7 @@ import (
 	"github.com/pkg/errors"
 	gerr "github.com/pkg/errors"
 	log "github.com/sirupsen/logrus"
+	v1 "k8s.io/api/core/v1"
 	"k8s.io/client-go/kubernetes"
 	"k8s.io/client-go/tools/record"
 	"k8s.io/klog/v2"
@@ -36,6 +37,7 @@ import (
 	"sigs.k8s.io/controller-runtime/pkg/manager"
 	"sigs.k8s.io/controller-runtime/pkg/reconcile"
 	"sigs.k8s.io/controller-runtime/pkg/source"
+	"sort"
 	"time"
 
 	"k8s.io/apimachinery/pkg/runtime"
@@ -45,6 +47,13 @@ import (
 	//nodepoolv1 "gitlab.alibaba-inc.com/cos/wdrip/api/v1"
 )
 
+// event reasons
+const (
+	ReasonScaleOut    = "MasterScaleOut"
+	ReasonScaleIn     = "MasterScaleIn"
+	ReasonScaleFailed = "MasterScaleFailed"
+)
+
 func AddMasterSet(
 	mgr manager.Manager,
 	ctx *shared.SharedOperatorContext,
@@ -164,6 +173,7 @@ func (m *MasterSetReconciler) Reconcile(ctx context.Context, req ctrl.Request) (
 	detail, err := m.prvd.ScalingGroupDetail(
 		cctx, "", provider.Option{Action: "InstanceIDS"},
 	)
+	help.CountAPIError("ScalingGroupDetail", err)
 	if err != nil {
 		return ctrl.Result{Requeue: true, RequeueAfter: 15 * time.Second}, nil
 	}
@@ -186,6 +196,7 @@ func (m *MasterSetReconciler) Reconcile(ctx context.Context, req ctrl.Request) (
 			Value:  provider.Value{Val: ud},
 		},
 	)
+	help.CountAPIError("ModifyScalingConfig", err)
 	if err != nil {
 		klog.Errorf("modify userdata: %s", err.Error())
 		return ctrl.Result{Requeue: true, RequeueAfter: 30 * time.Second}, nil
@@ -200,10 +211,14 @@ func (m *MasterSetReconciler) Reconcile(ctx context.Context, req ctrl.Request) (
 
 		klog.Infof("[QuorumScale] do scale[expect=%d]...........................", expect)
 		// check for etcd member, make sure len(etcd)==len(ecs)
-		if expect == 1 {
-			klog.Infof("[QuorumScale] do remove ecs to 1")
-			// replica changes from 2 to 1.
-			ip, err := m.healet.RemoveFollower()
+		if expect < len(detail.Instances) {
+			klog.Infof("[QuorumScale] remove one etcd follower and its ecs")
+			var ips []string
+			for _, i := range detail.Instances {
+				ips = append(ips, i.Ip)
+			}
+			sort.Strings(ips)
+			ip, err := m.healet.RemoveFollower(ips)
 			if err != nil {
 				return fmt.Errorf("quorum remove etcd member: %s", err.Error())
 			}
@@ -212,25 +227,34 @@ func (m *MasterSetReconciler) Reconcile(ctx context.Context, req ctrl.Request) (
 				return fmt.Errorf("ecs not found by ip: %s", ip)
 			}
 			err = m.prvd.RemoveScalingGroupECS(cctx, "", id)
+			help.CountAPIError("RemoveScalingGroupECS", err)
 			if err != nil {
 				return fmt.Errorf("remove ecs from scaling group: %s", err.Error())
 			}
+			m.recd.Eventf(ms, v1.EventTypeNormal, ReasonScaleIn,
+				"etcd member %s and its ecs %s removed, target %d", ip, id, expect)
 		} else {
 			klog.Infof("[QuorumScale] do scale ecs to %d", expect)
-			// replicas changes from n to min(replica,2)
 			err = m.prvd.ScaleMasterGroup(cctx, "", expect)
+			help.CountAPIError("ScaleMasterGroup", err)
 			if err != nil {
 				klog.Infof("[QuorumScale] sleep 30s for scale master error: %s", err.Error())
 				time.Sleep(30 * time.Second)
 				return fmt.Errorf("scale master group: %s", err.Error())
 			}
+			m.recd.Eventf(ms, v1.EventTypeNormal, ReasonScaleOut, "scale master ecs to %d", expect)
 		}
 
 		klog.Infof("[QuorumScale] member center finished")
 		return nil
 	}
 	// 4. do scale and wait
-	return ctrl.Result{}, QuorumScale(scale, len(detail.Instances), ms.Spec.Replicas)
+	err = QuorumScale(scale, len(detail.Instances), ms.Spec.Replicas)
+	if err != nil {
+		m.recd.Eventf(ms, v1.EventTypeWarning, ReasonScaleFailed,
+			"scale masters from %d to %d: %s", len(detail.Instances), ms.Spec.Replicas, err.Error())
+	}
+	return ctrl.Result{}, err
 }
 
 func QuorumScale(
@@ -247,10 +271,9 @@ func QuorumScale(
 		// scale out should not be controlled.
 		return mfunc(expect)
 	}
-	// max scale in
-	max := help.Max((current-1)/2, 1) // max scale num per operate
-	klog.Infof("do quorum scale in: target=%d", help.Max(current-max, expect))
-	err := mfunc(help.Max(current-max, expect))
+	// etcd member is removed one at a time
+	klog.Infof("do quorum scale in: target=%d", current-1)
+	err := mfunc(current - 1)
 	if err != nil {
 		return err
 	}
@@ -263,12 +286,8 @@ func QuorumScale(
 func findId(
 	ecs map[string]provider.Instance, ip string,
 ) string {
-	key := func(ip string) string {
-		return fmt.Sprintf("https://%s:2379", ip)
-	}
 	for _, i := range ecs {
-		p := key(i.Ip)
-		if ip == p {
+		if ip == i.Ip {
 			return i.Id
 		}
 	}
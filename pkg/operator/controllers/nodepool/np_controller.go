@@ -11,6 +11,7 @@ import (
 	"github.com/aoxn/wdrip/pkg/operator/heal"
 	"github.com/aoxn/wdrip/pkg/utils/hash"
 	gerr "github.com/pkg/errors"
+	v1 "k8s.io/api/core/v1"
 	"k8s.io/apimachinery/pkg/api/errors"
 	"k8s.io/apimachinery/pkg/runtime"
 	"k8s.io/client-go/kubernetes"
@@ -55,6 +56,17 @@ func newReconciler(
 
 const NODE_POOL_FINALIZER = "nodepool"
 
+// event reasons
+const (
+	ReasonCreate       = "NodeGroupCreated"
+	ReasonCreateFailed = "NodeGroupCreateFailed"
+	ReasonModify       = "NodeGroupModified"
+	ReasonModifyFailed = "NodeGroupModifyFailed"
+	ReasonDelete       = "NodeGroupDeleted"
+	ReasonDeleteFailed = "NodeGroupDeleteFailed"
+	ReasonHealFailed   = "NodePoolHealFailed"
+)
+
 // add adds a new Controller to mgr with r as the reconcile.Reconciler
 func add(mgr manager.Manager, r reconcile.Reconciler) error {
 	// Create a new controller
@@ -106,9 +118,12 @@ func (r *ReconcileNodePool) Reconcile(ctx context.Context, request reconcile.Req
 	if !np.DeletionTimestamp.IsZero() {
 		klog.Infof("nodepool has been deleted, [%s], %v", np.Name, np.Spec.Infra.Bind)
 		err := r.prvd.DeleteNodeGroup(mctx, np)
+		help.CountAPIError("DeleteNodeGroup", err)
 		if err != nil {
+			r.recd.Eventf(np, v1.EventTypeWarning, ReasonDeleteFailed, "delete node group: %s", err.Error())
 			return help.NewDelay(10), gerr.Wrapf(err, "clean up nodepool %s infra", np.Name)
 		}
+		r.recd.Event(np, v1.EventTypeNormal, ReasonDelete, "node group deleted")
 		if err = r.DeleteNodePoolBackup(*np); err != nil {
 			return reconcile.Result{}, gerr.Wrapf(err, "remove oss nodepool backup failed")
 		}
@@ -135,6 +150,7 @@ func (r *ReconcileNodePool) Reconcile(ctx context.Context, request reconcile.Req
 				"this might happen when recover from another infrastructure", np.Name)
 		}
 		klog.Warningf("warning trying to fix nodepool: %s", err.Error())
+		r.recd.Eventf(np, v1.EventTypeWarning, ReasonHealFailed, "fix nodepool: %s", err.Error())
 		klog.Warningf("warning will trying to create a new scaling group for nodepool: %s", np.Name)
 	}
 
@@ -146,9 +162,12 @@ func (r *ReconcileNodePool) Reconcile(ctx context.Context, request reconcile.Req
 	if np.Spec.Infra.Bind == nil {
 		klog.Infof("trying to create node pool: %s", np.Name)
 		bind, err := r.prvd.CreateNodeGroup(mctx, np)
+		help.CountAPIError("CreateNodeGroup", err)
 		if err != nil {
+			r.recd.Eventf(np, v1.EventTypeWarning, ReasonCreateFailed, "create node group: %s", err.Error())
 			return reconcile.Result{}, gerr.Wrapf(err, "create node group: %v", np.Name)
 		}
+		r.recd.Event(np, v1.EventTypeNormal, ReasonCreate, "node group created")
 		diff := func(copy runtime.Object) (client.Object, error) {
 			mp := copy.(*acv1.NodePool)
 			mp.Spec.Infra.Bind = bind
@@ -175,9 +194,13 @@ func (r *ReconcileNodePool) Reconcile(ctx context.Context, request reconcile.Req
 
 		klog.Infof("node group modified, %s", np.Name)
 		err := r.prvd.ModifyNodeGroup(mctx, np)
+		help.CountAPIError("ModifyNodeGroup", err)
 		if err != nil {
+			r.recd.Eventf(np, v1.EventTypeWarning, ReasonModifyFailed, "modify node group: %s", err.Error())
 			return help.NewDelay(3), gerr.Wrapf(err, "modify node group: %v", np)
 		}
+		r.recd.Eventf(np, v1.EventTypeNormal, ReasonModify,
+			"node group modified, desired capacity %d", np.Spec.Infra.DesiredCapacity)
 		if np.Spec.Infra.Bind.ScalingGroupId != "" {
 			if err := r.EnsureNodePoolBackup(*np); err != nil {
 				klog.Warningf("modify nodepool, ensure nodepool oss backup: %s", err.Error())
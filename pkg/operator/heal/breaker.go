@@ -0,0 +1,307 @@
+package heal
+
+import (
+	"fmt"
+	api "github.com/aoxn/wdrip/pkg/apis/alibabacloud.com/v1"
+	pd "github.com/aoxn/wdrip/pkg/iaas/provider"
+	h "github.com/aoxn/wdrip/pkg/operator/controllers/help"
+	v1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/klog/v2"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sort"
+	"sync"
+	"time"
+)
+
+// default thresholds of the circuit breaker
+const (
+	DefaultMaxUnhealthy        = 5
+	DefaultMaxUnhealthyPercent = 40
+	DefaultMaxFailures         = 10
+	DefaultFailureWindow       = 30 * time.Minute
+
+	// BreakerStaleAfter health of a nodepool not seen within is ignored,
+	// eg. the nodepool has been deleted
+	BreakerStaleAfter = 10 * time.Minute
+)
+
+// circuit breaker condition reasons
+const (
+	ReasonCircuitClosed    = "CircuitClosed"
+	ReasonCircuitReset     = "CircuitReset"
+	ReasonTooManyUnhealthy = "TooManyUnhealthy"
+	ReasonTooManyFailures  = "TooManyFailures"
+)
+
+// zone labels of node, the beta label for old kubelet
+var zoneLabels = []string{v1.LabelTopologyZone, v1.LabelFailureDomainBetaZone}
+
+// SetBreakerDefaults fill in the defaults of unset fields
+func SetBreakerDefaults(cfg *api.CircuitBreaker) {
+	if cfg.MaxUnhealthy == 0 {
+		cfg.MaxUnhealthy = DefaultMaxUnhealthy
+	}
+	if cfg.MaxUnhealthyPercent == 0 {
+		cfg.MaxUnhealthyPercent = DefaultMaxUnhealthyPercent
+	}
+	if cfg.MaxFailures == 0 {
+		cfg.MaxFailures = DefaultMaxFailures
+	}
+	if cfg.FailureWindow.Duration == 0 {
+		cfg.FailureWindow.Duration = DefaultFailureWindow
+	}
+}
+
+// CircuitOpen error of a step stopped by the open circuit
+type CircuitOpen struct {
+	Message string
+}
+
+func (c *CircuitOpen) Error() string {
+	return fmt.Sprintf("remediation circuit is open: %s", c.Message)
+}
+
+// health unhealthy and total nodes of a nodepool or zone
+type health struct {
+	Unhealthy int
+	Total     int
+}
+
+func (n health) exceeds(cfg api.CircuitBreaker) bool {
+	if n.Unhealthy > cfg.MaxUnhealthy {
+		return true
+	}
+	return n.Unhealthy > 1 && n.Unhealthy*100 > cfg.MaxUnhealthyPercent*n.Total
+}
+
+// poolHealth health of a nodepool and its zones seen at At
+type poolHealth struct {
+	At    time.Time
+	Pool  health
+	Zones map[string]health
+}
+
+func NewBreaker() *Breaker { return &Breaker{pools: map[string]poolHealth{}} }
+
+// Breaker circuit breaker of automated remediation. the health of every
+// nodepool is observed on each check, and failed steps are counted. the
+// circuit opens when a nodepool or zone has too many unhealthy nodes or
+// too many steps failed within the window, and closes once the situation
+// clears or it is reset. masters are critical and never stopped.
+type Breaker struct {
+	mutex    sync.Mutex
+	pools    map[string]poolHealth
+	failures []time.Time
+
+	open    bool
+	reason  string
+	message string
+	// held reason of the trip the circuit is held closed for by reset
+	held string
+}
+
+// Observe health of the nodepool of trip, instances created within grace
+// are still joining and count as healthy
+func (b *Breaker) Observe(trip *Triple, grace time.Duration) {
+	if trip.role == pd.JoinMasterUserdata {
+		return
+	}
+	seen := poolHealth{At: time.Now(), Zones: map[string]health{}}
+	for _, info := range trip.nodeInfo {
+		if info.Instance == nil {
+			continue
+		}
+		unhealthy := false
+		if info.Node == nil {
+			unhealthy = h.After(info.Instance.CreatedAt, grace)
+		} else {
+			unhealthy = !h.NodeReady(info.Node)
+		}
+		seen.Pool.add(unhealthy)
+		if zone := nodeZone(info.Node); zone != "" {
+			n := seen.Zones[zone]
+			n.add(unhealthy)
+			seen.Zones[zone] = n
+		}
+	}
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	b.pools[trip.pool] = seen
+}
+
+func (n *health) add(unhealthy bool) {
+	n.Total++
+	if unhealthy {
+		n.Unhealthy++
+	}
+}
+
+func nodeZone(node *v1.Node) string {
+	if node == nil {
+		return ""
+	}
+	for _, lbl := range zoneLabels {
+		if zone := node.Labels[lbl]; zone != "" {
+			return zone
+		}
+	}
+	return ""
+}
+
+// Failure count a failed remediation step
+func (b *Breaker) Failure() {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	b.failures = append(b.failures, time.Now())
+}
+
+// Reset forget failures counted so far, and hold the circuit closed
+// until the trip it is open for clears. a trip for another reason opens
+// it again.
+func (b *Breaker) Reset() {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	b.failures = nil
+	b.held = ""
+	if b.open {
+		b.held = b.reason
+	}
+}
+
+// Sync evaluate the circuit with cfg, returns whether the circuit is
+// open, the reason and message. changed tells whether the circuit has
+// just opened or closed.
+func (b *Breaker) Sync(cfg api.CircuitBreaker) (open, changed bool, reason, message string) {
+	SetBreakerDefaults(&cfg)
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+
+	now := time.Now()
+	open, reason, message = false, ReasonCircuitClosed, "remediation is running"
+	if !cfg.Disabled {
+		open, reason, message = b.evaluate(cfg, now)
+	}
+	if open && reason == b.held {
+		open, reason, message = false, ReasonCircuitReset, fmt.Sprintf("reset while %s", message)
+	} else {
+		b.held = ""
+	}
+	changed = open != b.open
+	b.open, b.reason, b.message = open, reason, message
+	return open, changed, reason, message
+}
+
+func (b *Breaker) evaluate(cfg api.CircuitBreaker, now time.Time) (bool, string, string) {
+	var (
+		cutoff = now.Add(-cfg.FailureWindow.Duration)
+		recent []time.Time
+	)
+	for _, t := range b.failures {
+		if t.After(cutoff) {
+			recent = append(recent, t)
+		}
+	}
+	b.failures = recent
+
+	var names []string
+	for name := range b.pools {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	zones := map[string]health{}
+	for _, name := range names {
+		seen := b.pools[name]
+		if now.Sub(seen.At) > BreakerStaleAfter {
+			delete(b.pools, name)
+			continue
+		}
+		if seen.Pool.exceeds(cfg) {
+			return true, ReasonTooManyUnhealthy, fmt.Sprintf(
+				"%d of %d nodes of nodepool %s are unhealthy", seen.Pool.Unhealthy, seen.Pool.Total, name)
+		}
+		for zone, n := range seen.Zones {
+			z := zones[zone]
+			z.Unhealthy += n.Unhealthy
+			z.Total += n.Total
+			zones[zone] = z
+		}
+	}
+	for zone, n := range zones {
+		if n.exceeds(cfg) {
+			return true, ReasonTooManyUnhealthy, fmt.Sprintf(
+				"%d of %d nodes of zone %s are unhealthy", n.Unhealthy, n.Total, zone)
+		}
+	}
+	if len(recent) >= cfg.MaxFailures {
+		return true, ReasonTooManyFailures, fmt.Sprintf(
+			"%d remediation steps failed within %s", len(recent), cfg.FailureWindow.Duration)
+	}
+	return false, ReasonCircuitClosed, "remediation is running"
+}
+
+// Admit step on info, returns CircuitOpen when the circuit is open. only
+// RestartService is admitted on workers then, masters are always admitted.
+func (b *Breaker) Admit(info *NodeInfo, step api.RemediationStep) error {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	if !b.open ||
+		info.Role == pd.JoinMasterUserdata ||
+		step.Action == api.RemediationRestartService {
+		return nil
+	}
+	return &CircuitOpen{Message: b.message}
+}
+
+// syncBreaker evaluate the circuit breaker and report it as a condition
+// of cluster, a reset requested by annotation is done first
+func (m *Healet) syncBreaker(cluster *api.Cluster) {
+	reset := cluster.Annotations[api.RemediationCircuitResetAnnotation] != ""
+	if reset {
+		klog.Infof("reset remediation circuit breaker on request")
+		m.breaker.Reset()
+	}
+	open, changed, reason, message := m.breaker.Sync(cluster.Spec.CircuitBreaker)
+	status := metav1.ConditionFalse
+	if open {
+		status = metav1.ConditionTrue
+		circuitOpen.Set(1)
+	} else {
+		circuitOpen.Set(0)
+	}
+	if reset && !open {
+		reason = ReasonCircuitReset
+	}
+	if changed || reset {
+		etype := v1.EventTypeNormal
+		if open {
+			etype = v1.EventTypeWarning
+		}
+		m.recd.Eventf(cluster, etype, reason, "remediation circuit open=%t: %s", open, message)
+	}
+	cond := meta.FindStatusCondition(cluster.Status.Conditions, api.ConditionRemediationCircuitOpen)
+	if !reset && cond != nil && cond.Status == status && cond.Reason == reason {
+		return
+	}
+	diff := func(copy runtime.Object) (client.Object, error) {
+		mc := copy.(*api.Cluster)
+		delete(mc.Annotations, api.RemediationCircuitResetAnnotation)
+		meta.SetStatusCondition(
+			&mc.Status.Conditions,
+			metav1.Condition{
+				Type:               api.ConditionRemediationCircuitOpen,
+				Status:             status,
+				ObservedGeneration: mc.Generation,
+				Reason:             reason,
+				Message:            message,
+			},
+		)
+		return mc, nil
+	}
+	err := h.Patch(m.client, cluster, diff, h.PatchSpec)
+	if err != nil {
+		klog.Warningf("patch remediation circuit condition: %s", err.Error())
+	}
+}
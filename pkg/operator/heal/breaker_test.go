@@ -0,0 +1,189 @@
+package heal
+
+import (
+	"fmt"
+	api "github.com/aoxn/wdrip/pkg/apis/alibabacloud.com/v1"
+	"github.com/aoxn/wdrip/pkg/iaas/provider"
+	"github.com/stretchr/testify/assert"
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"testing"
+	"time"
+)
+
+// poolTriple nodepool pool with ready and notReady nodes in zone
+func poolTriple(pool, zone string, ready, notReady int) *Triple {
+	trip := &Triple{role: provider.WorkerUserdata, pool: pool}
+	add := func(status v1.ConditionStatus) {
+		id := fmt.Sprintf("%s-%d", pool, len(trip.nodeInfo))
+		node := &v1.Node{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:   id,
+				Labels: map[string]string{v1.LabelTopologyZone: zone},
+			},
+			Status: v1.NodeStatus{
+				Conditions: []v1.NodeCondition{{Type: v1.NodeReady, Status: status}},
+			},
+		}
+		trip.nodeInfo = append(trip.nodeInfo,
+			NodeInfo{Role: provider.WorkerUserdata, Instance: &provider.Instance{Id: id}, Node: node})
+	}
+	for i := 0; i < ready; i++ {
+		add(v1.ConditionTrue)
+	}
+	for i := 0; i < notReady; i++ {
+		add(v1.ConditionFalse)
+	}
+	return trip
+}
+
+func TestBreakerUnhealthy(t *testing.T) {
+	cfg := api.CircuitBreaker{MaxUnhealthy: 3, MaxUnhealthyPercent: 40}
+	cases := []struct {
+		name  string
+		trips []*Triple
+		open  bool
+	}{
+		{"healthy", []*Triple{poolTriple("a", "z1", 10, 0)}, false},
+		{"single node of small pool", []*Triple{poolTriple("a", "z1", 1, 1)}, false},
+		{"below threshold", []*Triple{poolTriple("a", "z1", 7, 3)}, false},
+		{"over count", []*Triple{poolTriple("a", "z1", 20, 4)}, true},
+		{"over percent", []*Triple{poolTriple("a", "z1", 2, 2)}, true},
+		{
+			"over percent of zone",
+			[]*Triple{poolTriple("a", "z1", 1, 1), poolTriple("b", "z1", 1, 1), poolTriple("c", "z2", 10, 0)},
+			true,
+		},
+	}
+	for _, c := range cases {
+		b := NewBreaker()
+		for _, trip := range c.trips {
+			b.Observe(trip, DefaultCreateGrace)
+		}
+		open, changed, reason, _ := b.Sync(cfg)
+		assert.Equal(t, c.open, open, c.name)
+		assert.Equal(t, c.open, changed, c.name)
+		if c.open {
+			assert.Equal(t, ReasonTooManyUnhealthy, reason, c.name)
+		}
+	}
+
+	// recovered pool closes the circuit
+	b := NewBreaker()
+	b.Observe(poolTriple("a", "z1", 2, 2), DefaultCreateGrace)
+	open, _, _, _ := b.Sync(cfg)
+	assert.True(t, open)
+	b.Observe(poolTriple("a", "z1", 4, 0), DefaultCreateGrace)
+	open, changed, reason, _ := b.Sync(cfg)
+	assert.False(t, open)
+	assert.True(t, changed)
+	assert.Equal(t, ReasonCircuitClosed, reason)
+
+	// masters are not observed
+	b = NewBreaker()
+	trip := poolTriple("", "z1", 0, 3)
+	trip.role = provider.JoinMasterUserdata
+	b.Observe(trip, DefaultCreateGrace)
+	open, _, _, _ = b.Sync(cfg)
+	assert.False(t, open)
+
+	// disabled breaker never opens
+	b = NewBreaker()
+	b.Observe(poolTriple("a", "z1", 0, 10), DefaultCreateGrace)
+	open, _, _, _ = b.Sync(api.CircuitBreaker{Disabled: true})
+	assert.False(t, open)
+}
+
+func TestBreakerFailures(t *testing.T) {
+	cfg := api.CircuitBreaker{MaxFailures: 2, FailureWindow: metav1.Duration{Duration: time.Hour}}
+	b := NewBreaker()
+	b.Failure()
+	open, _, _, _ := b.Sync(cfg)
+	assert.False(t, open)
+	b.Failure()
+	open, _, reason, _ := b.Sync(cfg)
+	assert.True(t, open)
+	assert.Equal(t, ReasonTooManyFailures, reason)
+
+	// failures out of window are forgotten
+	b.failures = []time.Time{time.Now().Add(-2 * time.Hour), time.Now()}
+	open, _, _, _ = b.Sync(cfg)
+	assert.False(t, open)
+
+	b.Failure()
+	b.Reset()
+	open, _, _, _ = b.Sync(cfg)
+	assert.False(t, open)
+}
+
+func TestBreakerResetUnhealthy(t *testing.T) {
+	cfg := api.CircuitBreaker{MaxUnhealthy: 3, MaxUnhealthyPercent: 40}
+	worker := &NodeInfo{Role: provider.WorkerUserdata, Instance: &provider.Instance{Id: "i-1"}}
+	reboot := api.RemediationStep{Action: api.RemediationReboot}
+	b := NewBreaker()
+	b.Observe(poolTriple("a", "z1", 2, 2), DefaultCreateGrace)
+	open, _, _, _ := b.Sync(cfg)
+	assert.True(t, open)
+
+	// reset holds the circuit closed while the pool is still unhealthy
+	b.Reset()
+	for i := 0; i < 2; i++ {
+		open, changed, reason, _ := b.Sync(cfg)
+		assert.False(t, open)
+		assert.Equal(t, i == 0, changed)
+		assert.Equal(t, ReasonCircuitReset, reason)
+		assert.Nil(t, b.Admit(worker, reboot))
+	}
+
+	// trips again once the pool has recovered and fails anew
+	b.Observe(poolTriple("a", "z1", 4, 0), DefaultCreateGrace)
+	open, _, reason, _ := b.Sync(cfg)
+	assert.False(t, open)
+	assert.Equal(t, ReasonCircuitClosed, reason)
+	b.Observe(poolTriple("a", "z1", 2, 2), DefaultCreateGrace)
+	open, _, _, _ = b.Sync(cfg)
+	assert.True(t, open)
+
+	// trip of another reason is not held
+	cfg.MaxFailures = 1
+	b = NewBreaker()
+	b.Observe(poolTriple("a", "z1", 2, 2), DefaultCreateGrace)
+	b.Sync(cfg)
+	b.Reset()
+	b.Observe(poolTriple("a", "z1", 4, 0), DefaultCreateGrace)
+	b.Failure()
+	open, _, reason, _ = b.Sync(cfg)
+	assert.True(t, open)
+	assert.Equal(t, ReasonTooManyFailures, reason)
+}
+
+func TestBreakerAdmit(t *testing.T) {
+	restart := api.RemediationStep{Action: api.RemediationRestartService, Service: "kubelet"}
+	reboot := api.RemediationStep{Action: api.RemediationReboot}
+	worker := &NodeInfo{Role: provider.WorkerUserdata, Instance: &provider.Instance{Id: "i-1"}}
+	master := &NodeInfo{Role: provider.JoinMasterUserdata, Instance: &provider.Instance{Id: "i-2"}}
+
+	b := NewBreaker()
+	assert.Nil(t, b.Admit(worker, reboot))
+	b.Observe(poolTriple("a", "z1", 0, 2), DefaultCreateGrace)
+	b.Sync(api.CircuitBreaker{})
+	assert.IsType(t, &CircuitOpen{}, b.Admit(worker, reboot))
+	assert.Nil(t, b.Admit(worker, restart))
+	assert.Nil(t, b.Admit(master, reboot))
+
+	// stopped step is recorded as throttled and not attempted
+	policy := &api.RemediationPolicy{
+		ObjectMeta: metav1.ObjectMeta{Name: "reboot"},
+		Spec:       api.RemediationPolicySpec{Steps: []api.RemediationStep{reboot}},
+	}
+	SetPolicyDefaults(&policy.Spec)
+	journal := newFakeJournal(t)
+	nop := &fakeOperation{}
+	ladder := NewLadder(journal).WithBreaker(b)
+	assert.IsType(t, &CircuitOpen{}, ladder.Climb(nop, worker, policy, api.NodeRepairSpec{NodeName: "node-1"}))
+	assert.Empty(t, nop.actions)
+	items := listRepairs(t, journal)
+	if assert.Len(t, items, 1) && assert.Len(t, items[0].Status.Steps, 1) {
+		assert.Equal(t, api.RepairStepThrottled, items[0].Status.Steps[0].Outcome)
+	}
+}
@@ -0,0 +1,335 @@
+package heal
+
+import (
+	mctx "context"
+	"fmt"
+	"github.com/aoxn/wdrip/pkg/actions/etcd"
+	api "github.com/aoxn/wdrip/pkg/apis/alibabacloud.com/v1"
+	pd "github.com/aoxn/wdrip/pkg/iaas/provider"
+	h "github.com/aoxn/wdrip/pkg/operator/controllers/help"
+	"github.com/pkg/errors"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sort"
+	"strings"
+	"time"
+)
+
+// inconsistency kinds found by diagnose only
+const (
+	EtcdMemberWithoutInstance = "EtcdMemberWithoutInstance"
+	InstanceWithoutEtcdMember = "InstanceWithoutEtcdMember"
+)
+
+// MemberLister list etcd members of the cluster with masters
+type MemberLister func(masters []api.Master, cluster *api.Cluster) ([]etcd.Member, error)
+
+// Diagnosis the Healet view of one node, or of an etcd member left behind
+type Diagnosis struct {
+	Role     string `json:"role"`
+	NodePool string `json:"nodePool,omitempty"`
+	Instance string `json:"instance,omitempty"`
+	IP       string `json:"ip,omitempty"`
+	// Status of the instance
+	Status string `json:"status,omitempty"`
+	Node   string `json:"node,omitempty"`
+	// Ready status of node Ready condition
+	Ready    string `json:"ready,omitempty"`
+	MasterCR string `json:"masterCR,omitempty"`
+	Etcd     string `json:"etcd,omitempty"`
+	// Problems inconsistencies and health signals found
+	Problems []string `json:"problems,omitempty"`
+	// Action the Healet would take, nodes are fixed one at a time
+	Action string `json:"action"`
+}
+
+// Report diagnosis of the whole cluster
+type Report struct {
+	DryRun bool `json:"dryRun,omitempty"`
+	// Circuit message of the open circuit breaker, empty when closed
+	Circuit string      `json:"circuit,omitempty"`
+	Errors  []string    `json:"errors,omitempty"`
+	Nodes   []Diagnosis `json:"nodes"`
+}
+
+func NewDiagnoser(getter TripleGetter, client client.Client, members MemberLister) *Diagnoser {
+	return &Diagnoser{getter: getter, client: client, members: members, certs: NewCertProber()}
+}
+
+// Diagnoser gathers the same inputs as the Healet, and tells what the
+// Healet sees and would do on each node without acting
+type Diagnoser struct {
+	getter  TripleGetter
+	client  client.Client
+	members MemberLister
+	certs   CertExpiry
+}
+
+// view inputs of one diagnosis shared by all triples
+type view struct {
+	now      time.Time
+	dryRun   bool
+	circuit  bool
+	policies []api.RemediationPolicy
+	repairs  []api.NodeRepair
+	members  []etcd.Member
+	listed   bool
+	certs    CertExpiry
+}
+
+func (d *Diagnoser) Diagnose() (*Report, error) {
+	trip, err := NewTripleMaster(d.getter)
+	if err != nil {
+		return nil, errors.Wrap(err, "get master triple")
+	}
+	report := &Report{DryRun: DryRunMode(trip.cluster)}
+	v := &view{now: time.Now(), dryRun: report.DryRun, certs: d.certs}
+	cond := meta.FindStatusCondition(trip.cluster.Status.Conditions, api.ConditionRemediationCircuitOpen)
+	if cond != nil && cond.Status == metav1.ConditionTrue {
+		v.circuit, report.Circuit = true, cond.Message
+	}
+
+	policies := &api.RemediationPolicyList{}
+	err = d.client.List(mctx.TODO(), policies)
+	if err != nil {
+		report.Errors = append(report.Errors, fmt.Sprintf("list remediation policy, default assumed: %s", err.Error()))
+	}
+	v.policies = policies.Items
+	repairs := &api.NodeRepairList{}
+	err = d.client.List(mctx.TODO(), repairs)
+	if err != nil {
+		report.Errors = append(report.Errors, fmt.Sprintf("list node repair: %s", err.Error()))
+	}
+	v.repairs = repairs.Items
+	if d.members != nil && len(trip.mCRDs) > 0 {
+		v.members, err = d.members(trip.mCRDs, trip.cluster)
+		if err != nil {
+			report.Errors = append(report.Errors, fmt.Sprintf("list etcd member: %s", err.Error()))
+		} else {
+			v.listed = true
+		}
+	}
+	report.Nodes = append(report.Nodes, v.diagnose(trip)...)
+	for _, mem := range trip.EtcdMemDiff(v.members) {
+		action := v.would("remove etcd member")
+		if Paused(trip.infoByIP(mem.IP), v.now) {
+			action = "none, paused by annotation"
+		}
+		report.Nodes = append(report.Nodes, Diagnosis{
+			Role:     roleLabel(trip.role),
+			IP:       mem.IP,
+			Etcd:     mem.Name,
+			Problems: []string{EtcdMemberWithoutInstance},
+			Action:   action,
+		})
+	}
+
+	pools := &api.NodePoolList{}
+	err = d.client.List(mctx.TODO(), pools)
+	if err != nil {
+		return report, errors.Wrap(err, "list nodepool")
+	}
+	for i := range pools.Items {
+		np := &pools.Items[i]
+		wtrip, err := NewTripleWorker(d.getter, np)
+		if err != nil {
+			report.Errors = append(report.Errors, fmt.Sprintf("nodepool %s: %s", np.Name, err.Error()))
+			continue
+		}
+		report.Nodes = append(report.Nodes, v.diagnose(wtrip)...)
+	}
+	return report, nil
+}
+
+func (v *view) diagnose(trip *Triple) []Diagnosis {
+	var result []Diagnosis
+	for i := range trip.nodeInfo {
+		info := &trip.nodeInfo[i]
+		diag := Diagnosis{Role: roleLabel(trip.role), NodePool: trip.pool, Ready: "-"}
+		if ins := info.Instance; ins != nil {
+			diag.Instance, diag.IP, diag.Status = ins.Id, ins.Ip, ins.Status
+		}
+		if info.Resource != nil {
+			diag.MasterCR = info.Resource.Name
+			if diag.IP == "" {
+				diag.IP = info.Resource.Spec.IP
+			}
+		}
+		if info.Node != nil {
+			diag.Node = info.Node.Name
+			if cond := readyCondition(info.Node); cond != nil {
+				diag.Ready = string(cond.Status)
+			}
+		}
+		if trip.role == pd.JoinMasterUserdata && v.listed && diag.IP != "" {
+			mem := etcd.FindMemberByIP(v.members, diag.IP)
+			if mem.ID != nil {
+				diag.Etcd = mem.Name
+			} else if info.Instance != nil {
+				diag.Problems = append(diag.Problems, InstanceWithoutEtcdMember)
+			}
+		}
+		diag.Problems = append(diag.Problems, v.problems(trip, info)...)
+		diag.Action = v.action(trip, info)
+		result = append(result, diag)
+	}
+	sort.Slice(
+		result,
+		func(i, j int) bool {
+			if result[i].IP != result[j].IP {
+				return result[i].IP < result[j].IP
+			}
+			return result[i].Node < result[j].Node
+		},
+	)
+	return result
+}
+
+// problems inconsistencies and health signals of info, see Inconsistencies
+func (v *view) problems(trip *Triple, info *NodeInfo) []string {
+	var problems []string
+	switch {
+	case info.Instance != nil && info.Node == nil:
+		problems = append(problems, InstanceWithoutNode)
+	case info.Instance == nil && info.Node != nil:
+		problems = append(problems, NodeWithoutInstance)
+	case info.Instance != nil && !h.NodeReady(info.Node):
+		problems = append(problems, NotReadyNode)
+	}
+	if trip.role == pd.JoinMasterUserdata {
+		if info.Resource != nil && info.Instance == nil {
+			problems = append(problems, MasterCRWithoutInstance)
+		}
+		if info.Resource == nil && info.Node != nil && info.Instance != nil {
+			problems = append(problems, NodeWithoutMasterCR)
+		}
+	}
+	if sig, _ := FiringSignal(v.policy(trip, info), info, v.now, v.certs); sig != nil {
+		problems = append(problems, sig.Type)
+	}
+	return problems
+}
+
+// action the Healet would take on info, following FixUpMeta and FixUpNode
+func (v *view) action(trip *Triple, info *NodeInfo) string {
+	if Paused(info, v.now) {
+		return "none, paused by annotation"
+	}
+	master := trip.role == pd.JoinMasterUserdata
+	switch {
+	case info.Instance == nil && master && info.Resource != nil:
+		if info.Node != nil {
+			return v.would("delete master CR and node object")
+		}
+		return v.would("delete master CR")
+	case info.Instance == nil && info.Node != nil:
+		return v.would("delete node object")
+	case info.Instance == nil:
+		return "-"
+	}
+	var actions []string
+	if master && info.Resource == nil && info.Node != nil {
+		actions = append(actions, v.would("create master CR"))
+	}
+	policy := v.policy(trip, info)
+	sig, _ := FiringSignal(policy, info, v.now, v.certs)
+	if sig == nil && info.Node != nil && h.NodeReady(info.Node) {
+		return strings.Join(append(actions, "-"), "; ")
+	}
+	signal := ""
+	if sig != nil {
+		signal = sig.Type
+		policy = signalPolicy(policy, sig)
+	}
+	if info.Node == nil && !h.After(info.Instance.CreatedAt, policy.Spec.Throttle.CreateGrace.Duration) {
+		return strings.Join(append(actions, "wait, instance is joining"), "; ")
+	}
+	step, ok := nextStep(policy, v.record(info.Instance.Id, policy.Name, signal), info)
+	switch {
+	case !ok:
+		actions = append(actions, "none, all steps failed, node needs manual repair")
+	case v.circuit && !master && step.Action != api.RemediationRestartService:
+		actions = append(actions, fmt.Sprintf("none, %s stopped by circuit breaker", describeStep(step)))
+	default:
+		actions = append(actions, v.would(fmt.Sprintf("%s following policy %s", describeStep(step), policy.Name)))
+	}
+	return strings.Join(actions, "; ")
+}
+
+func (v *view) would(action string) string {
+	if v.dryRun {
+		return fmt.Sprintf("dry-run, would %s", action)
+	}
+	return action
+}
+
+func (v *view) policy(trip *Triple, info *NodeInfo) *api.RemediationPolicy {
+	policy := SelectPolicy(v.policies, trip.pool, info)
+	if policy == nil {
+		return defaultPolicy(trip)
+	}
+	return policy
+}
+
+// record the latest running NodeRepair of instance id, nil for none
+func (v *view) record(id, policy, signal string) *api.NodeRepair {
+	var latest *api.NodeRepair
+	for i := range v.repairs {
+		r := &v.repairs[i]
+		if r.Spec.InstanceID != id ||
+			r.Spec.Policy != policy ||
+			r.Spec.Signal != signal ||
+			r.Status.Phase != api.NodeRepairRunning {
+			continue
+		}
+		if latest == nil || latest.Status.StartedAt.Before(&r.Status.StartedAt) {
+			latest = r
+		}
+	}
+	return latest
+}
+
+// nextStep the step of policy tried next on info after the steps of
+// record, false once all steps are used up
+func nextStep(policy *api.RemediationPolicy, record *api.NodeRepair, info *NodeInfo) (api.RemediationStep, bool) {
+	steps := policy.Spec.Steps
+	pos, attempts := 0, 0
+	if record != nil {
+		for _, s := range record.Status.Steps {
+			i := pos
+			for i < len(steps) && steps[i].Action != s.Action {
+				i++
+			}
+			if i >= len(steps) {
+				continue
+			}
+			if i != pos {
+				pos, attempts = i, 0
+			}
+			switch s.Outcome {
+			case api.RepairStepFailed:
+				attempts++
+				if steps[pos].MaxAttempts > 0 && attempts >= steps[pos].MaxAttempts {
+					pos, attempts = pos+1, 0
+				}
+			case api.RepairStepSkipped:
+				pos, attempts = pos+1, 0
+			}
+		}
+	}
+	for pos < len(steps) && destroys(steps[pos]) && SkipReimage(info) {
+		pos++
+	}
+	if pos >= len(steps) {
+		return api.RemediationStep{}, false
+	}
+	return steps[pos], true
+}
+
+func describeStep(step api.RemediationStep) string {
+	if step.Action == api.RemediationRestartService {
+		return fmt.Sprintf("%s(%s)", step.Action, step.Service)
+	}
+	return step.Action
+}
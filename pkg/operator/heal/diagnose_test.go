@@ -0,0 +1,119 @@
+package heal
+
+import (
+	"fmt"
+	"github.com/aoxn/wdrip/pkg/actions/etcd"
+	api "github.com/aoxn/wdrip/pkg/apis/alibabacloud.com/v1"
+	"github.com/aoxn/wdrip/pkg/iaas/provider"
+	"github.com/stretchr/testify/assert"
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"math/big"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+	"testing"
+)
+
+func member(id int64, ip string) etcd.Member {
+	return etcd.Member{
+		ID:       big.NewInt(id),
+		IP:       ip,
+		Name:     fmt.Sprintf("etcd-%d", id),
+		PeerURLs: []string{fmt.Sprintf("https://%s:2380", ip)},
+	}
+}
+
+func newDiagnoser(t *testing.T, cluster *api.Cluster, objs ...runtime.Object) *Diagnoser {
+	old := inst2
+	old.CreatedAt = "2021-01-01T00:00:00Z"
+	getter := fakeTripleGetter{
+		spec:        cluster,
+		masterNodes: []v1.Node{node1, node3},
+		masterCRDs:  []api.Master{master1, master2, master3},
+		masterInstances: map[string]provider.Instance{
+			inst1.Id: inst1,
+			old.Id:   old,
+		},
+	}
+	members := func(masters []api.Master, cluster *api.Cluster) ([]etcd.Member, error) {
+		return []etcd.Member{
+			member(103, "192.168.0.103"),
+			member(104, "192.168.0.104"),
+			member(109, "192.168.0.109"),
+		}, nil
+	}
+	scheme := runtime.NewScheme()
+	assert.Nil(t, api.AddToScheme(scheme))
+	client := fake.NewClientBuilder().WithScheme(scheme).WithRuntimeObjects(objs...).Build()
+	diag := NewDiagnoser(getter, client, members)
+	diag.certs = fakeCerts{}
+	return diag
+}
+
+func TestDiagnose(t *testing.T) {
+	diag := newDiagnoser(t, &api.Cluster{})
+	report, err := diag.Diagnose()
+	assert.Nil(t, err)
+	assert.False(t, report.DryRun)
+	assert.Empty(t, report.Errors)
+	assert.Equal(t, 4, len(report.Nodes))
+
+	byIP := map[string]Diagnosis{}
+	for _, n := range report.Nodes {
+		byIP[n.IP] = n
+	}
+	healthy := byIP["192.168.0.103"]
+	assert.Equal(t, "etcd-103", healthy.Etcd)
+	assert.Empty(t, healthy.Problems)
+	assert.Equal(t, "-", healthy.Action)
+
+	missing := byIP["192.168.0.104"]
+	assert.Equal(t, []string{InstanceWithoutNode}, missing.Problems)
+	assert.Equal(t, "RestartService(kubelet) following policy default", missing.Action)
+
+	orphan := byIP["192.168.0.105"]
+	assert.Equal(t, []string{NodeWithoutInstance, MasterCRWithoutInstance}, orphan.Problems)
+	assert.Equal(t, "delete master CR and node object", orphan.Action)
+
+	left := byIP["192.168.0.109"]
+	assert.Equal(t, []string{EtcdMemberWithoutInstance}, left.Problems)
+	assert.Equal(t, "remove etcd member", left.Action)
+}
+
+func TestDiagnoseNextStep(t *testing.T) {
+	cluster := &api.Cluster{}
+	cluster.Annotations = map[string]string{api.HealDryRunAnnotation: "true"}
+	repair := &api.NodeRepair{
+		ObjectMeta: metav1.ObjectMeta{Name: "i-bp1c65aivl1e4vkm9e2n-x"},
+		Spec: api.NodeRepairSpec{
+			InstanceID: inst2.Id,
+			Policy:     "default",
+		},
+		Status: api.NodeRepairStatus{
+			Phase: api.NodeRepairRunning,
+			Steps: []api.RepairStep{
+				{Action: api.RemediationRestartService, Outcome: api.RepairStepFailed},
+			},
+		},
+	}
+	report, err := newDiagnoser(t, cluster, repair).Diagnose()
+	assert.Nil(t, err)
+	assert.True(t, report.DryRun)
+	for _, n := range report.Nodes {
+		if n.IP == "192.168.0.104" {
+			assert.Equal(t, "dry-run, would Reimage following policy default", n.Action)
+		}
+	}
+
+	policy := DefaultRemediationPolicy()
+	info := &NodeInfo{
+		Instance: &inst2,
+		Node: &v1.Node{
+			ObjectMeta: metav1.ObjectMeta{
+				Annotations: map[string]string{api.HealSkipReimageAnnotation: "true"},
+			},
+		},
+	}
+	_, ok := nextStep(policy, repair, info)
+	assert.False(t, ok, "reimage skipped, no step left")
+}
@@ -4,6 +4,7 @@ import (
 	mctx "context"
 	"fmt"
 	"github.com/aoxn/wdrip/pkg/actions/etcd"
+	"github.com/aoxn/wdrip/pkg/actions/kubeadm"
 	api "github.com/aoxn/wdrip/pkg/apis/alibabacloud.com/v1"
 	pd "github.com/aoxn/wdrip/pkg/iaas/provider"
 	h "github.com/aoxn/wdrip/pkg/operator/controllers/help"
@@ -14,12 +15,14 @@ import (
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/apimachinery/pkg/runtime"
 	"k8s.io/apimachinery/pkg/util/wait"
+	"k8s.io/client-go/tools/record"
 	"k8s.io/klog/v2"
 	"k8s.io/kubectl/pkg/drain"
 	"math/rand"
 	"sigs.k8s.io/controller-runtime/pkg/cache"
 	"sigs.k8s.io/controller-runtime/pkg/client"
 	"sigs.k8s.io/controller-runtime/pkg/manager"
+	"sort"
 	"strings"
 	"sync"
 	"time"
@@ -28,6 +31,10 @@ import (
 type Healet struct {
 	tripGetter TripleGetter
 	operation  Manager
+	ladder     *Ladder
+	breaker    *Breaker
+	journal    *CRJournal
+	certs      CertExpiry
 	nodes      chan *Event
 	nodepool   chan *Event
 	infra      Infra
@@ -35,8 +42,12 @@ type Healet struct {
 	mutex      sync.RWMutex
 	cache      cache.Cache
 	client     client.Client
+	recd       record.EventRecorder
 	initSpec   *api.Cluster
 
+	// proxyMembers etcd endpoints last rendered to etcd proxy of masters
+	proxyMembers string
+
 	//prvd       pd.Interface
 	isCtrlPlanInChecking bool
 }
@@ -60,6 +71,7 @@ func NewHealet(
 	client client.Client,
 	prvd pd.Interface,
 	drain *drain.Helper,
+	recd record.EventRecorder,
 ) (*Healet, error) {
 	var err error
 	if spec == nil {
@@ -74,15 +86,23 @@ func NewHealet(
 		return nil, errors.Wrapf(err, "new infra manager")
 	}
 
+	journal := NewJournal(client)
+	breaker := NewBreaker()
 	mem := &Healet{
 		initSpec:   spec,
 		tripGetter: NewTripleGetter(infra, client),
 		client:     client,
+		recd:       recd,
 		infra:      infra,
-		operation:  NewOperationMgr(prvd, nil, client, drain),
+		operation:  NewOperationMgr(prvd, recd, client, drain),
+		ladder:     NewLadder(journal).WithBreaker(breaker),
+		breaker:    breaker,
+		journal:    journal,
+		certs:      NewCertProber(),
 		nodes:      make(chan *Event, 0),
 		nodepool:   make(chan *Event, 0),
 	}
+	mem.ladder.WithVerifier(mem.signalCleared)
 	return mem, nil
 }
 
@@ -121,6 +141,7 @@ func (m *Healet) InjectCache(cache cache.Cache) error {
 
 func (m *Healet) InjectClient(me client.Client) error {
 	m.client = me
+	m.journal.client = me
 	return nil
 }
 
@@ -201,9 +222,11 @@ func InitializeDefaultCR(cfg client.Client) {
 	}
 }
 
-// RemoveFollower remove etcd follower
-// only works with exactly 2 etcd members
-func (m *Healet) RemoveFollower() (string, error) {
+// RemoveFollower remove one etcd follower on ips for any cluster size,
+// and returns the ip of the removed member. an ip without etcd member
+// might be a master still joining, it is returned only as the last
+// resort when no member on ips can be removed.
+func (m *Healet) RemoveFollower(ips []string) (string, error) {
 	masters, err := h.MasterCRDS(m.client)
 	if err != nil {
 		return "", fmt.Errorf("member master: %s", err.Error())
@@ -217,40 +240,40 @@ func (m *Healet) RemoveFollower() (string, error) {
 	if err != nil {
 		return "", fmt.Errorf("new etcd: %s", err.Error())
 	}
-	endps, err := metcd.Endpoints()
+	mems, err := metcd.MemberList()
 	if err != nil {
-		return "", fmt.Errorf("endpoint status fail: %s", err.Error())
+		return "", fmt.Errorf("member list: %s", err.Error())
 	}
-
-	if len(endps) != 2 {
-		return "", fmt.Errorf("remove follower can only work with 2 etcd member")
-	}
-
-	if len(endps) == 1 {
-		klog.Infof("only one etcd replica remain, skip remove operation")
-		return "", nil
+	var member, memberless []string
+	for _, ip := range ips {
+		if etcd.FindMemberByIP(mems.Members, ip).ID == nil {
+			memberless = append(memberless, ip)
+		} else {
+			member = append(member, ip)
+		}
 	}
-	for _, end := range endps {
-		klog.Infof("etcd endpoint: %+v", end)
-		leader := end.Status.Leader
-		memid := end.Status.Header.MemberID
-		if leader.Cmp(memid) == 0 {
-			continue
+	if len(member) > 0 {
+		mem, err := metcd.RemoveFollower(member...)
+		if err == nil {
+			return mem.IP, nil
 		}
-		mem := etcd.Member{ID: end.Status.Header.MemberID}
-		err = metcd.RemoveMember(mem)
-		if err != nil {
-			return end.Endpoint, fmt.Errorf("remove member: %x, %s", mem.ID, err.Error())
+		if len(memberless) == 0 {
+			return "", fmt.Errorf("remove follower: %s", err.Error())
 		}
-		return end.Endpoint, nil
+		klog.Warningf("remove follower: %s, fall back to ip without etcd member", err.Error())
+	}
+	if len(memberless) == 0 {
+		return "", fmt.Errorf("remove follower: no candidate in %v", ips)
 	}
-	return "", fmt.Errorf("empty endpoint: %v", endps)
+	klog.Infof("no etcd member on %s, remove directly", memberless[0])
+	return memberless[0], nil
 }
 
 // run deprecated
 func (m *Healet) run() {
 
 	tick := time.NewTicker(60 * time.Second)
+	prune := time.NewTicker(time.Hour)
 	for {
 		select {
 		case _ = <-m.nodes:
@@ -273,6 +296,11 @@ func (m *Healet) run() {
 				klog.Errorf("master steady check: %s", err.Error())
 			}
 			klog.Infof("[master.fix]routine check: 60 seconds steady tick finished")
+		case <-prune.C:
+			err := m.journal.Prune(NodeRepairRetention)
+			if err != nil {
+				klog.Errorf("prune node repair: %s", err.Error())
+			}
 		}
 	}
 }
@@ -318,8 +346,16 @@ func (m *Healet) FixNodePool(pool *api.NodePool) error {
 		}
 	}
 
+	cluster, err := m.tripGetter.GetClusterItem()
+	if err != nil {
+		return errors.Wrap(err, "get cluster")
+	}
 	klog.Infof("[%s] %d node has no nodepool labels", pool.Name, len(names))
 	for _, n := range names {
+		info := &NodeInfo{Role: pd.WorkerUserdata, Node: &n}
+		if m.hold(cluster, info, fmt.Sprintf("label node with nodepool %s", pool.Name)) {
+			continue
+		}
 		diff := func(copy runtime.Object) (client.Object, error) {
 			node := copy.(*v1.Node)
 			if node.Labels == nil {
@@ -395,13 +431,6 @@ func (m *Healet) FixMasterNode() error {
 	return m.FixUpNode(trip)
 }
 
-const (
-	AdmitECSThrottleTime  = 3 * time.Minute
-	AdmitNodeThrottleTime = 1 * time.Minute
-
-	AdmitCreateThrottleTime = 5 * time.Minute
-)
-
 func (m *Healet) FixMasterCRD(nodes []NodeInfo) error {
 	for _, n := range nodes {
 		me := api.Master{
@@ -421,15 +450,23 @@ func (m *Healet) FixMasterCRD(nodes []NodeInfo) error {
 		err := m.client.Create(mctx.TODO(), &me)
 		if err != nil {
 			klog.Errorf("create master crd fail: %s", err.Error())
+			continue
 		}
+		m.recd.Eventf(n.Node, v1.EventTypeNormal, ReasonMasterCRCreated, "master CR %s created for node", me.Name)
 	}
 	return nil
 }
 
 func (m *Healet) FixUpNode(trip *Triple) error {
+	observeTriple(trip)
+	m.breaker.Observe(trip, DefaultCreateGrace)
+	m.syncBreaker(trip.cluster)
 	addition, deletion := trip.InstanceNodeDiff()
 	klog.Infof("try to clean up node meta, addition=%d, deletion=%d", len(addition), len(deletion))
 	for _, n := range deletion {
+		if m.hold(trip.cluster, &n, fmt.Sprintf("delete node %s", n.Node.Name)) {
+			continue
+		}
 		// corresponding ecs has been deleted. delete node together
 		err := m.client.Delete(mctx.TODO(), n.Node)
 		if err != nil {
@@ -438,15 +475,37 @@ func (m *Healet) FixUpNode(trip *Triple) error {
 
 		klog.Infof("corresponding ecs has been "+
 			"deleted. delete node together, %s", n.Node.Spec.ProviderID)
+		m.recd.Eventf(trip.cluster, v1.EventTypeNormal, ReasonNodeDeleted,
+			"ecs of node %s has been deleted, node object deleted", n.Node.Name)
 	}
 
 	nop, err := m.operation.NewOperation(trip)
 	if err != nil {
 		return errors.Wrapf(err, "new node operation: %s", trip)
 	}
+	if DryRunMode(trip.cluster) {
+		nop = NewDryRunOperation(nop)
+	}
+	// ladder of nodes healthy again starts over on next failure, ready
+	// nodes with a health signal firing are fixed after not ready ones
+	signaled := unpaused(m.signaled(trip))
+	firing := map[string]bool{}
+	for _, n := range signaled {
+		firing[n.Instance.Id] = true
+	}
+	for _, n := range trip.nodeInfo {
+		if n.Instance != nil && n.Node != nil && h.NodeReady(n.Node) && !firing[n.Instance.Id] {
+			m.ladder.Forget(n.Instance.Id)
+		}
+	}
 
+	// nodes paused by annotation are left alone
+	addition = unpaused(addition)
 	if len(addition) <= 0 {
-		info := trip.UnReadyNodeList()
+		info := unpaused(trip.UnReadyNodeList())
+		if len(info) <= 0 {
+			info = signaled
+		}
 		if len(info) <= 0 {
 			// nothing to fix
 			return nil
@@ -454,71 +513,48 @@ func (m *Healet) FixUpNode(trip *Triple) error {
 		klog.Infof("unready nodes %d, trying to fix", len(info))
 		// todo: fix master first
 		// fix not ready node one by one randomly. wait for next tick.
-		return fixUpHard(nop, &info[randn(len(info))])
+		return m.fixUpHard(trip, nop, &info[randn(len(info))])
 	}
 	noinfo := addition[0]
 	klog.Infof("new ecs %s without ready node object, trying to fix", noinfo.GetNodeName())
 	// fix one by one
-	return fixUpHard(nop, &noinfo)
-}
-
-// fixUpSoft deprecated
-func fixUpSoft(nop Operation, info *NodeInfo) error {
-	klog.Infof("[%s]trying to fix unready node", info.Instance.Id)
-	klog.Infof("[%s]trying to restart kubelet", info.Instance.Id)
-	// 1. runcommand restart kubelet & wait
-	err := nop.RunCommand(info, "systemctl restart kubelet")
-	if err == nil {
-		klog.Infof("[%s]fixed node with [RestartKubelet]", info.Instance.Id)
-		return nil
-	}
-	klog.Warningf("[%s]failed to fix[NotFixed] with [RestartKubelet], %s", info.Instance.Id, err.Error())
-	// todo: node without master label
-	klog.Infof("[%s]try restart ECS           ", info.Instance.Id)
-
-	// 2. restart ecs & wait
-	err = nop.Restart(info)
-	if err == nil {
-		klog.Infof("[%s]fixed node with [RestartECS]", info.Instance.Id)
-		return nil
-	}
-	klog.Warningf("[%s]failed to fix[NotFixed] with [RestartECS], %s", info.Instance.Id, err.Error())
-	klog.Infof("[%s]trying to reset ECS", info.Instance.Id)
-	//3. reset node & wait
-	err = nop.Reset(info)
-	if err == nil {
-		klog.Infof("[%s]fixed node with [ResetECS]", info.Instance.Id)
-		return nil
-	}
-	klog.Infof("[%s]failed to fix[NotFixed] with [ResetECS], %s", info.Instance.Id, err.Error())
-	return nil
+	return m.fixUpHard(trip, nop, &noinfo)
 }
 
-func fixUpHard(nop Operation, info *NodeInfo) error {
-	klog.Infof("[%s]trying to restart kubelet", info.Instance.Id)
-	// 1. runcommand restart kubelet & wait
-	err := nop.RunCommand(info, "systemctl restart kubelet")
-	if err == nil {
-		klog.Infof("[%s]fixed node with [RestartKubelet]", info.Instance.Id)
-		if info.Role != pd.JoinMasterUserdata {
-			return nil
-		}
-		klog.Infof("[%s]mark controlplane labels: master,control-plane", info.Instance.Id)
-		lbl := map[string]string{
-			"node-role.kubernetes.io/master":        "",
-			"node-role.kubernetes.io/control-plane": "",
+// fixUpHard climb the remediation ladder of info. masters fixed are
+// labeled with control plane roles.
+func (m *Healet) fixUpHard(trip *Triple, nop Operation, info *NodeInfo) error {
+	policy := m.policyFor(trip, info)
+	repair := NewRepairSpec(trip, info, policy)
+	if sig, message := FiringSignal(policy, info, time.Now(), m.certs); sig != nil {
+		// remediate the signal with its own steps
+		repair.Signal, repair.Reason, repair.Symptom = sig.Type, sig.Type, message
+		policy = signalPolicy(policy, sig)
+	}
+	err := m.ladder.Climb(nop, info, policy, repair)
+	if err != nil {
+		switch err.(type) {
+		case *Retry, *CircuitOpen:
+		case *DryRun:
+			m.recd.Eventf(eventTarget(trip.cluster, info), v1.EventTypeNormal, ReasonDryRun,
+				"repair %s following policy %s: %s", info.GetNodeName(), policy.Name, err.Error())
+		default:
+			m.recd.Eventf(eventTarget(trip.cluster, info), v1.EventTypeWarning, ReasonRepairFailed,
+				"repair %s: %s", info.GetNodeName(), err.Error())
 		}
-		return nop.LabelNode(info, lbl)
+		return err
 	}
-	klog.Warningf("[%s]failed to fix[NotFixed] with [RestartKubelet], %s", info.Instance.Id, err.Error())
-	klog.Infof("[%s]trying to fix unready node with reset", info.Instance.Id)
-	err = nop.Reset(info)
-	if err == nil {
-		klog.Infof("[%s]fixed node with [ResetECS]", info.Instance.Id)
+	m.recd.Eventf(eventTarget(trip.cluster, info), v1.EventTypeNormal, ReasonRepaired,
+		"node %s repaired following policy %s", info.GetNodeName(), policy.Name)
+	if info.Role != pd.JoinMasterUserdata {
 		return nil
 	}
-	klog.Infof("[%s]failed to fix[NotFixed] with [ResetECS], %s", info.Instance.Id, err.Error())
-	return nil
+	klog.Infof("[%s]mark controlplane labels: master,control-plane", info.Instance.Id)
+	lbl := map[string]string{
+		"node-role.kubernetes.io/master":        "",
+		"node-role.kubernetes.io/control-plane": "",
+	}
+	return nop.LabelNode(info, lbl)
 }
 
 func (m *Healet) FixUpEtcd(trip *Triple) error {
@@ -535,12 +571,67 @@ func (m *Healet) FixUpEtcd(trip *Triple) error {
 		return fmt.Errorf("clean up etcd: member %s", err.Error())
 	}
 
-	for _, m := range trip.EtcdMemDiff(mems.Members) {
-		klog.Infof("ecs[%s] has been removed, clean up corresponding etcd member.", m.IP)
-		err = metcd.RemoveMember(m)
+	// one member at a time, the rest is cleaned up on next round
+	for _, mem := range trip.EtcdMemDiff(mems.Members) {
+		if m.hold(trip.cluster, trip.infoByIP(mem.IP), fmt.Sprintf("remove etcd member %s", mem.IP)) {
+			continue
+		}
+		klog.Infof("ecs[%s] has been removed, clean up corresponding etcd member.", mem.IP)
+		err = metcd.RemoveMemberSafely(mem)
 		if err != nil {
 			return fmt.Errorf("member center: remove member, %s", err.Error())
 		}
+		m.recd.Eventf(trip.cluster, v1.EventTypeNormal, ReasonEtcdMemberRemoved,
+			"ecs %s has been removed, etcd member removed", mem.IP)
+		return nil
+	}
+	return m.syncEtcdProxy(trip, mems.Members)
+}
+
+// syncEtcdProxy point etcd proxy of every master to the voting members
+// of etcd once membership differs from what was rendered last time.
+func (m *Healet) syncEtcdProxy(trip *Triple, mems []etcd.Member) error {
+	if !kubeadm.EtcdProxyEnabled(&trip.cluster.Spec) {
+		return nil
+	}
+	var members []string
+	for _, mem := range etcd.Voters(mems) {
+		if mem.IP == "" {
+			continue
+		}
+		members = append(members, fmt.Sprintf("https://%s:2379", mem.IP))
+	}
+	sort.Strings(members)
+	key := strings.Join(members, ",")
+	if len(members) == 0 || key == m.proxyMembers {
+		return nil
+	}
+	nop, err := m.operation.NewOperation(trip)
+	if err != nil {
+		return errors.Wrapf(err, "sync etcd proxy: new operation")
+	}
+	synced := true
+	cmd := kubeadm.EtcdProxyEndpointsCommand(members)
+	for i := range trip.nodeInfo {
+		info := &trip.nodeInfo[i]
+		if info.Instance == nil || info.Node == nil {
+			// not joined yet, proxy is rendered with current peers on join
+			continue
+		}
+		if m.hold(trip.cluster, info, fmt.Sprintf("point etcd proxy to %s", key)) {
+			synced = false
+			continue
+		}
+		err = nop.RunCommand(info, cmd, time.Minute)
+		if err != nil {
+			return errors.Wrapf(err, "sync etcd proxy on %s", info)
+		}
+	}
+	if synced {
+		klog.Infof("etcd proxy of masters synced to members: %s", key)
+		m.recd.Eventf(trip.cluster, v1.EventTypeNormal, ReasonEtcdProxySynced,
+			"etcd proxy of masters point to %s", key)
+		m.proxyMembers = key
 	}
 	return nil
 }
@@ -550,6 +641,9 @@ func (m *Healet) FixUpMeta(trip *Triple) error {
 	deletions, missed := trip.MasterCRDDiff()
 
 	for _, d := range deletions {
+		if m.hold(trip.cluster, &d, fmt.Sprintf("delete master CR %s", d.Resource.Name)) {
+			continue
+		}
 		klog.Infof("ecs has been removed, "+
 			"clean up corresponding MasterCRD %s", d.Resource.Name)
 		// 2. clean up master CRDs
@@ -557,10 +651,18 @@ func (m *Healet) FixUpMeta(trip *Triple) error {
 		if err != nil {
 			return fmt.Errorf("remove master object: %s", err.Error())
 		}
+		m.recd.Eventf(trip.cluster, v1.EventTypeNormal, ReasonMasterCRDeleted,
+			"ecs of master CR %s has been removed, master CR deleted", d.Resource.Name)
 	}
 	klog.Infof("master clean up succeed")
 
-	return m.FixMasterCRD(missed)
+	var creations []NodeInfo
+	for i := range missed {
+		if !m.hold(trip.cluster, &missed[i], "create master CR") {
+			creations = append(creations, missed[i])
+		}
+	}
+	return m.FixMasterCRD(creations)
 }
 
 func (m *Healet) FixWDRIP() error {
@@ -594,12 +696,15 @@ func (m *Healet) FixWDRIP() error {
 		return errors.Wrapf(err, "fix wdrip and meta")
 	}
 	fixup := func(info *NodeInfo) error {
+		if m.hold(trip.cluster, info, "restart kubelet and label control plane to fix wdrip") {
+			return nil
+		}
 		klog.Infof("trying to fix wdrip: %s", info)
 		nop, err := m.operation.NewOperation(trip)
 		if err != nil {
 			return errors.Wrapf(err, "[FixWDRIP] new operations")
 		}
-		err = nop.RunCommand(info, "systemctl restart kubelet")
+		err = nop.RunCommand(info, "systemctl restart kubelet", 30*time.Second)
 		if err != nil {
 			klog.Warningf("[FixWDRIP] restart kubelet: %s", err.Error())
 		}
@@ -45,6 +45,7 @@ func NewInfraManager(
 func (i *InfraManager) ControlPlaneECS() (map[string]pd.Instance, error) {
 	cctx := pd.NewContextWithCluster(&i.spec.Spec).WithStack(i.stack)
 	detail, err := i.Infra.ScalingGroupDetail(cctx, "", pd.Option{Action: "InstanceIDS"})
+	h.CountAPIError("ScalingGroupDetail", err)
 	if err != nil {
 		return nil, fmt.Errorf("scaling group: %s", err.Error())
 	}
@@ -62,6 +63,7 @@ func (i *InfraManager) NodePoolECS(nps api.NodePool) (map[string]pd.Instance, er
 		cctx, bind.ScalingGroupId,
 		pd.Option{Action: alibaba.ActionInstanceIDS},
 	)
+	h.CountAPIError("ScalingGroupDetail", err)
 	if err != nil {
 
 		return detail.Instances, errors.Wrapf(err, "group %s detail", bind.ScalingGroupId)
@@ -0,0 +1,159 @@
+package heal
+
+import (
+	"fmt"
+	api "github.com/aoxn/wdrip/pkg/apis/alibabacloud.com/v1"
+	v1 "k8s.io/api/core/v1"
+	"k8s.io/klog/v2"
+	"time"
+)
+
+// DryRunMode whether cluster is annotated with HealDryRunAnnotation
+func DryRunMode(cluster *api.Cluster) bool {
+	return cluster != nil && cluster.Annotations[api.HealDryRunAnnotation] == "true"
+}
+
+// Paused whether heal of info is paused at now by HealPauseUntilAnnotation
+// on its node or Master CR
+func Paused(info *NodeInfo, now time.Time) bool {
+	until := annotation(info, api.HealPauseUntilAnnotation)
+	if until == "" {
+		return false
+	}
+	t, err := time.Parse(time.RFC3339, until)
+	if err != nil {
+		klog.Warningf("%s: heal paused until annotation removed, "+
+			"%s is not a RFC3339 time", info, until)
+		return true
+	}
+	return now.Before(t)
+}
+
+// SkipReimage whether Reimage and Replace steps of info are skipped by
+// HealSkipReimageAnnotation
+func SkipReimage(info *NodeInfo) bool {
+	return annotation(info, api.HealSkipReimageAnnotation) == "true"
+}
+
+// annotation key of info, node first then Master CR
+func annotation(info *NodeInfo, key string) string {
+	if info.Node != nil {
+		if v := info.Node.Annotations[key]; v != "" {
+			return v
+		}
+	}
+	if info.Resource != nil {
+		return info.Resource.Annotations[key]
+	}
+	return ""
+}
+
+// destroys whether step destroys the disk of the node
+func destroys(step api.RemediationStep) bool {
+	return step.Action == api.RemediationReimage || step.Action == api.RemediationReplace
+}
+
+// Skipped error of a step skipped by annotation of the node
+type Skipped struct {
+	Annotation string
+}
+
+func (s *Skipped) Error() string {
+	return fmt.Sprintf("skipped by annotation %s", s.Annotation)
+}
+
+// DryRun error of an action not taken in dry-run mode
+type DryRun struct {
+	Action string
+}
+
+func (d *DryRun) Error() string {
+	return fmt.Sprintf("dry-run, would %s", d.Action)
+}
+
+// NewDryRunOperation operation which logs actions of nop instead of
+// taking them, every action returns DryRun
+func NewDryRunOperation(nop Operation) Operation { return &dryRunOperation{nop: nop} }
+
+type dryRunOperation struct {
+	nop Operation
+}
+
+func (d *dryRunOperation) dry(info *NodeInfo, action string) error {
+	klog.Infof("[dry-run]%s: would %s", info, action)
+	return &DryRun{Action: action}
+}
+
+func (d *dryRunOperation) Cordon(info *NodeInfo, cordon bool) error {
+	return d.dry(info, fmt.Sprintf("cordon=%t node", cordon))
+}
+
+func (d *dryRunOperation) Drain(info *NodeInfo) error { return d.dry(info, "drain node") }
+
+func (d *dryRunOperation) Restart(info *NodeInfo, timeout time.Duration) error {
+	return d.dry(info, "restart ecs")
+}
+
+func (d *dryRunOperation) Reset(info *NodeInfo, throttle api.RemediationThrottle, timeout time.Duration) error {
+	return d.dry(info, "replace system disk of ecs")
+}
+
+func (d *dryRunOperation) Replace(info *NodeInfo, throttle api.RemediationThrottle, timeout time.Duration) error {
+	return d.dry(info, "replace ecs through scaling group")
+}
+
+func (d *dryRunOperation) RunCommand(info *NodeInfo, cmd string, timeout time.Duration) error {
+	return d.dry(info, fmt.Sprintf("run [%s]", cmd))
+}
+
+func (d *dryRunOperation) LabelNode(info *NodeInfo, lbl map[string]string) error {
+	return d.dry(info, fmt.Sprintf("label node with %v", lbl))
+}
+
+// hold whether action on info is held back, by a pause annotation of the
+// node or by dry-run mode of the cluster
+func (m *Healet) hold(cluster *api.Cluster, info *NodeInfo, action string) bool {
+	if Paused(info, time.Now()) {
+		klog.Infof("%s: heal paused by annotation, skip %s", info, action)
+		return true
+	}
+	if DryRunMode(cluster) {
+		klog.Infof("[dry-run]%s: would %s", info, action)
+		m.recd.Eventf(eventTarget(cluster, info), v1.EventTypeNormal, ReasonDryRun, "dry-run, would %s", action)
+		return true
+	}
+	return false
+}
+
+// unpaused nodes of infos whose heal is not paused
+func unpaused(infos []NodeInfo) []NodeInfo {
+	var result []NodeInfo
+	now := time.Now()
+	for i := range infos {
+		if Paused(&infos[i], now) {
+			klog.Infof("%s: heal paused by annotation, skip", infos[i])
+			continue
+		}
+		result = append(result, infos[i])
+	}
+	return result
+}
+
+// infoByIP Master CR and node of ip in trip, for etcd members
+func (t *Triple) infoByIP(ip string) *NodeInfo {
+	info := &NodeInfo{Role: t.role}
+	for i := range t.mCRDs {
+		if t.mCRDs[i].Spec.IP == ip {
+			info.Resource = &t.mCRDs[i]
+			break
+		}
+	}
+	for i := range t.mnodes {
+		for _, addr := range t.mnodes[i].Status.Addresses {
+			if addr.Type == v1.NodeInternalIP && addr.Address == ip {
+				info.Node = &t.mnodes[i]
+			}
+		}
+	}
+	return info
+}
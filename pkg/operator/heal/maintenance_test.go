@@ -0,0 +1,94 @@
+package heal
+
+import (
+	api "github.com/aoxn/wdrip/pkg/apis/alibabacloud.com/v1"
+	"github.com/aoxn/wdrip/pkg/iaas/provider"
+	"github.com/stretchr/testify/assert"
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/tools/record"
+	"testing"
+	"time"
+)
+
+func annotated(anno map[string]string) *NodeInfo {
+	return &NodeInfo{
+		Role:     provider.JoinMasterUserdata,
+		Instance: &provider.Instance{Id: "i-1"},
+		Node:     &v1.Node{ObjectMeta: metav1.ObjectMeta{Name: "node-1", Annotations: anno}},
+	}
+}
+
+func TestPaused(t *testing.T) {
+	now := time.Now()
+	future := now.Add(time.Hour).Format(time.RFC3339)
+	past := now.Add(-time.Hour).Format(time.RFC3339)
+	assert.False(t, Paused(annotated(nil), now))
+	assert.True(t, Paused(annotated(map[string]string{api.HealPauseUntilAnnotation: future}), now))
+	assert.False(t, Paused(annotated(map[string]string{api.HealPauseUntilAnnotation: past}), now))
+	assert.True(t, Paused(annotated(map[string]string{api.HealPauseUntilAnnotation: "forever"}), now))
+
+	// annotation of Master CR for master without node
+	info := &NodeInfo{
+		Role: provider.JoinMasterUserdata,
+		Resource: &api.Master{
+			ObjectMeta: metav1.ObjectMeta{Annotations: map[string]string{api.HealPauseUntilAnnotation: future}},
+		},
+	}
+	assert.True(t, Paused(info, now))
+	assert.Len(t, unpaused([]NodeInfo{*info, *annotated(nil)}), 1)
+}
+
+func TestHold(t *testing.T) {
+	recd := record.NewFakeRecorder(10)
+	m := &Healet{recd: recd}
+	cluster := &api.Cluster{}
+	assert.False(t, m.hold(cluster, annotated(nil), "delete node node-1"))
+	assert.True(t, m.hold(cluster, annotated(map[string]string{api.HealPauseUntilAnnotation: "true"}), "delete node node-1"))
+	assert.Len(t, recd.Events, 0)
+
+	cluster.Annotations = map[string]string{api.HealDryRunAnnotation: "true"}
+	assert.True(t, m.hold(cluster, annotated(nil), "delete node node-1"))
+	assert.Contains(t, <-recd.Events, ReasonDryRun)
+}
+
+func TestLadderMaintenance(t *testing.T) {
+	policy := DefaultRemediationPolicy()
+	spec := api.NodeRepairSpec{NodeName: "node-1", InstanceID: "i-1"}
+
+	// reimage skipped by annotation exhausts the ladder
+	info := annotated(map[string]string{api.HealSkipReimageAnnotation: "true"})
+	journal := newFakeJournal(t)
+	ladder := NewLadder(journal)
+	nop := &fakeOperation{fail: map[string]error{"systemctl restart kubelet": assert.AnError}}
+	assert.NotNil(t, ladder.Climb(nop, info, policy, spec))
+	assert.NotNil(t, ladder.Climb(nop, info, policy, spec))
+	assert.NotContains(t, nop.actions, api.RemediationReimage)
+	items := listRepairs(t, journal)
+	if assert.Len(t, items, 1) {
+		steps := items[0].Status.Steps
+		if assert.Len(t, steps, 2) {
+			assert.Equal(t, api.RemediationReimage, steps[1].Action)
+			assert.Equal(t, api.RepairStepSkipped, steps[1].Outcome)
+		}
+		assert.Equal(t, api.NodeRepairFailed, items[0].Status.Phase)
+	}
+
+	// dry run takes no action and does not climb
+	journal = newFakeJournal(t)
+	ladder = NewLadder(journal)
+	nop = &fakeOperation{}
+	dry := NewDryRunOperation(nop)
+	spec.DryRun = true
+	assert.IsType(t, &DryRun{}, ladder.Climb(dry, annotated(nil), policy, spec))
+	assert.IsType(t, &DryRun{}, ladder.Climb(dry, annotated(nil), policy, spec))
+	assert.Empty(t, nop.actions)
+	items = listRepairs(t, journal)
+	if assert.Len(t, items, 1) {
+		assert.True(t, items[0].Spec.DryRun)
+		if assert.Len(t, items[0].Status.Steps, 1) {
+			assert.Equal(t, api.RemediationRestartService, items[0].Status.Steps[0].Action)
+			assert.Equal(t, api.RepairStepDryRun, items[0].Status.Steps[0].Outcome)
+		}
+	}
+}
@@ -0,0 +1,84 @@
+package heal
+
+import (
+	api "github.com/aoxn/wdrip/pkg/apis/alibabacloud.com/v1"
+	pd "github.com/aoxn/wdrip/pkg/iaas/provider"
+	"github.com/prometheus/client_golang/prometheus"
+	"sigs.k8s.io/controller-runtime/pkg/metrics"
+	"time"
+)
+
+var (
+	repairs = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Namespace: "wdrip",
+			Subsystem: "heal",
+			Name:      "repairs_total",
+			Help:      "Node repairs finished per role, reason and result.",
+		}, []string{"role", "reason", "result"},
+	)
+	repairSteps = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Namespace: "wdrip",
+			Subsystem: "heal",
+			Name:      "repair_steps_total",
+			Help:      "Remediation steps run per action and outcome.",
+		}, []string{"action", "outcome"},
+	)
+	timeToRepair = prometheus.NewHistogramVec(
+		prometheus.HistogramOpts{
+			Namespace: "wdrip",
+			Subsystem: "heal",
+			Name:      "time_to_repair_seconds",
+			Help:      "Time from the first remediation step until the node is ready again.",
+			Buckets:   prometheus.ExponentialBuckets(30, 2, 9),
+		}, []string{"role"},
+	)
+	circuitOpen = prometheus.NewGauge(
+		prometheus.GaugeOpts{
+			Namespace: "wdrip",
+			Subsystem: "heal",
+			Name:      "circuit_open",
+			Help:      "Whether remediation of workers is stopped by the circuit breaker.",
+		},
+	)
+	inconsistencies = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Namespace: "wdrip",
+			Subsystem: "heal",
+			Name:      "inconsistencies",
+			Help:      "Inconsistencies between instances, nodes and Master CRs seen on last check.",
+		}, []string{"scope", "kind"},
+	)
+)
+
+func init() {
+	metrics.Registry.MustRegister(repairs, repairSteps, timeToRepair, circuitOpen, inconsistencies)
+}
+
+// roleLabel metric label of userdata role
+func roleLabel(role string) string {
+	if role == pd.JoinMasterUserdata {
+		return api.RemediationRoleMaster
+	}
+	return api.RemediationRoleWorker
+}
+
+func observeRepair(r *rung, phase string) {
+	repairs.WithLabelValues(r.Role, r.Reason, phase).Inc()
+	if phase == api.NodeRepairSucceeded {
+		timeToRepair.WithLabelValues(r.Role).Observe(time.Since(r.Started).Seconds())
+	}
+}
+
+// observeTriple set inconsistencies of trip, scope is the nodepool name
+// or master
+func observeTriple(trip *Triple) {
+	scope := trip.pool
+	if scope == "" {
+		scope = api.RemediationRoleMaster
+	}
+	for kind, cnt := range trip.Inconsistencies() {
+		inconsistencies.WithLabelValues(scope, kind).Set(float64(cnt))
+	}
+}
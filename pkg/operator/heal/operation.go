@@ -4,6 +4,7 @@ import (
 	"context"
 	"fmt"
 	"github.com/aoxn/wdrip/pkg/actions/etcd"
+	api "github.com/aoxn/wdrip/pkg/apis/alibabacloud.com/v1"
 	pd "github.com/aoxn/wdrip/pkg/iaas/provider"
 	h "github.com/aoxn/wdrip/pkg/operator/controllers/help"
 	"github.com/pkg/errors"
@@ -11,6 +12,7 @@ import (
 	apierrors "k8s.io/apimachinery/pkg/api/errors"
 	"k8s.io/apimachinery/pkg/runtime"
 	gerror "k8s.io/apimachinery/pkg/util/errors"
+	"k8s.io/apimachinery/pkg/util/wait"
 	"k8s.io/client-go/tools/record"
 	"k8s.io/klog/v2"
 	"k8s.io/kubectl/pkg/drain"
@@ -19,6 +21,34 @@ import (
 	"time"
 )
 
+// event reasons
+const (
+	ReasonRestartECS        = "NodeHealRestartECS"
+	ReasonReimage           = "NodeHealReimage"
+	ReasonReplace           = "NodeHealReplace"
+	ReasonRunCommand        = "NodeHealRunCommand"
+	ReasonRepaired          = "NodeRepaired"
+	ReasonRepairFailed      = "NodeRepairFailed"
+	ReasonNodeDeleted       = "NodeObjectDeleted"
+	ReasonMasterCRCreated   = "MasterCRCreated"
+	ReasonMasterCRDeleted   = "MasterCRDeleted"
+	ReasonEtcdMemberRemoved = "EtcdMemberRemoved"
+	ReasonEtcdProxySynced   = "EtcdProxySynced"
+	ReasonDryRun            = "HealDryRun"
+)
+
+// eventTarget object events about info are recorded on: the node, the
+// Master CR, or the Cluster when neither exists
+func eventTarget(cluster *api.Cluster, info *NodeInfo) runtime.Object {
+	switch {
+	case info.Node != nil:
+		return info.Node
+	case info.Resource != nil:
+		return info.Resource
+	}
+	return cluster
+}
+
 type Manager interface {
 	NewOperation(trip *Triple) (Operation, error)
 }
@@ -63,32 +93,34 @@ func (m *Retry) Error() string {
 type Operation interface {
 	Cordon(info *NodeInfo, cordon bool) error
 	Drain(info *NodeInfo) error
-	Restart(info *NodeInfo) error
-	Reset(info *NodeInfo) error
-	RunCommand(info *NodeInfo, cmd string) error
+	Restart(info *NodeInfo, timeout time.Duration) error
+	Reset(info *NodeInfo, throttle api.RemediationThrottle, timeout time.Duration) error
+	Replace(info *NodeInfo, throttle api.RemediationThrottle, timeout time.Duration) error
+	RunCommand(info *NodeInfo, cmd string, timeout time.Duration) error
 	LabelNode(info *NodeInfo, lbl map[string]string) error
 }
 
-func (m *NodeOperation) Restart(info *NodeInfo) error {
+func (m *NodeOperation) Restart(info *NodeInfo, timeout time.Duration) error {
 	if info.Instance == nil {
 		return fmt.Errorf("empty instance id: %s", info)
 	}
-	//m.recd.Event(m.node, v1.EventTypeNormal, "NodeHeal.TryRestartECS", m.node.Name)
+	m.manager.recd.Eventf(eventTarget(m.trip.cluster, info), v1.EventTypeNormal,
+		ReasonRestartECS, "restart ecs %s to fix node", info.Instance.Id)
 
 	klog.Infof("try to restart ecs[%s] to fix node problem", info.Instance.Id)
 	err := m.manager.prvd.RestartECS(pd.NewEmptyContext(), info.Instance.Id)
+	h.CountAPIError("RestartECS", err)
 	if err != nil {
 		// restart ecs fail, fail immediately
 		return errors.Wrapf(err, "restart ecs")
 	}
 	// todo: fix nodename
 
-	err = h.WaitHeartbeat(m.manager.client, info.GetNodeName(), 3*time.Minute)
+	err = h.WaitHeartbeat(m.manager.client, info.GetNodeName(), timeout)
 	if err != nil {
 		return errors.Wrapf(err, "wait node "+
 			"ready failed while restarting ecs: %s", info.Instance.Id)
 	}
-	//m.recd.Event(m.node, v1.EventTypeNormal, "NodeHeal.Succeed", m.node.Name)
 	return nil
 }
 
@@ -120,6 +152,7 @@ func (m *NodeOperation) AdmitECS(info *NodeInfo, duration time.Duration) bool {
 		pd.NewEmptyContext(), eid.Id,
 		pd.Value{Key: WdripLastUpdate, Val: h.Now()},
 	)
+	h.CountAPIError("TagECS", err)
 	if err != nil {
 		klog.Warningf("canAdmin: mark operation time,%s, %s", eid.Id, err.Error())
 	}
@@ -167,29 +200,41 @@ func (m *NodeOperation) AdmitNode(info *NodeInfo, duration time.Duration) bool {
 	return ready
 }
 
-func (m *NodeOperation) Reset(info *NodeInfo) error {
+// admit disruptive step on info with throttle, returns Retry when not
+// admitted yet
+func (m *NodeOperation) admit(info *NodeInfo, throttle api.RemediationThrottle) error {
 	eid := info.Instance
 	min := 1 * time.Minute
-	if !h.After(eid.CreatedAt, AdmitCreateThrottleTime) {
+	if !h.After(eid.CreatedAt, throttle.CreateGrace.Duration) {
 		klog.Infof("ECS has just been created at %s,"+
 			" throttle for %s minutes .............................", eid.CreatedAt, min/time.Minute)
 		time.Sleep(min)
 		return NewRetry(min)
 	}
 
-	// it has been 5 minutes since ecs started.
+	// it has been CreateGrace since ecs started.
 	// but node still in unknown failed status. try to repair
-	if !m.AdmitECS(info, AdmitECSThrottleTime) {
+	if !m.AdmitECS(info, throttle.InstanceInterval.Duration) {
 		klog.Infof("[NodeOperation] ecs has been "+
-			"processed in less than %d minutes, wait for next retry", AdmitECSThrottleTime/time.Minute)
+			"processed in less than %s, wait for next retry", throttle.InstanceInterval.Duration)
 		return NewRetry(min)
 	}
 
-	if !m.AdmitNode(info, AdmitNodeThrottleTime) {
+	if !m.AdmitNode(info, throttle.NotReadyFor.Duration) {
 		klog.Infof("node %s %s is not "+
 			"allowed to repair. wait for some proper time", eid.Id, eid.Ip)
 		return NewRetry(min)
 	}
+	return nil
+}
+
+func (m *NodeOperation) Reset(
+	info *NodeInfo, throttle api.RemediationThrottle, timeout time.Duration,
+) error {
+	eid := info.Instance
+	if err := m.admit(info, throttle); err != nil {
+		return err
+	}
 
 	// todo: drain first
 	if err := m.Drain(info); err != nil {
@@ -207,7 +252,10 @@ func (m *NodeOperation) Reset(info *NodeInfo) error {
 	if err != nil {
 		return errors.Wrapf(err, "new worker userdata")
 	}
+	m.manager.recd.Eventf(eventTarget(m.trip.cluster, info), v1.EventTypeNormal,
+		ReasonReimage, "replace system disk of ecs %s to fix node", eid.Id)
 	err = m.manager.prvd.ReplaceSystemDisk(spectx, eid.Id, data, pd.Option{})
+	h.CountAPIError("ReplaceSystemDisk", err)
 	if err != nil {
 
 		klog.Warningf("[NodeOperation] replace system disk "+
@@ -216,13 +264,14 @@ func (m *NodeOperation) Reset(info *NodeInfo) error {
 		return fmt.Errorf("replace system disk failed: %s", err.Error())
 	}
 	err = m.manager.prvd.TagECS(spectx, eid.Id, pd.Value{Key: WdripLastUpdate, Val: h.Now()})
+	h.CountAPIError("TagECS", err)
 	if err != nil {
 		klog.Warningf("[NodeOperation] replace"+
 			"succeed, but tag update time failed: %s", err.Error())
 	}
 	name := fmt.Sprintf("%s.%s", eid.Ip, eid.Id)
 	klog.Infof("[NodeOperation] wait for node becoming ready, %s", name)
-	err = h.WaitHeartbeat(m.manager.client, name, 3*time.Minute)
+	err = h.WaitHeartbeat(m.manager.client, name, timeout)
 	if err != nil {
 		return fmt.Errorf("repair failed, continue on next node, %s", err.Error())
 	}
@@ -231,6 +280,96 @@ func (m *NodeOperation) Reset(info *NodeInfo) error {
 	return nil
 }
 
+// Replace drain the worker, remove its ecs from the scaling group of the
+// nodepool keeping the desired capacity, and wait for the new ecs created
+// by the group to join as a ready node.
+func (m *NodeOperation) Replace(
+	info *NodeInfo, throttle api.RemediationThrottle, timeout time.Duration,
+) error {
+	eid := info.Instance
+	if eid == nil {
+		return fmt.Errorf("empty instance id: %s", info)
+	}
+	if info.Role == pd.JoinMasterUserdata || m.trip.nodepool == nil {
+		return fmt.Errorf("replace is supported on workers only: %s", eid.Id)
+	}
+	bind := m.trip.nodepool.Spec.Infra.Bind
+	if bind == nil || bind.ScalingGroupId == "" {
+		return fmt.Errorf("nodepool %s has no scaling group bound", m.trip.pool)
+	}
+	if err := m.admit(info, throttle); err != nil {
+		return err
+	}
+	if err := m.Drain(info); err != nil {
+		klog.Warningf("drain node: %s, %s", info.GetNodeName(), err.Error())
+	}
+	m.manager.recd.Eventf(eventTarget(m.trip.cluster, info), v1.EventTypeNormal,
+		ReasonReplace, "remove ecs %s from scaling group %s to replace node", eid.Id, bind.ScalingGroupId)
+	start := time.Now()
+	err := m.manager.prvd.RemoveScalingGroupECS(
+		pd.NewContextWithCluster(&m.trip.cluster.Spec),
+		bind.ScalingGroupId, eid.Id, pd.Option{Action: pd.ActionKeepCapacity},
+	)
+	h.CountAPIError("RemoveScalingGroupECS", err)
+	if err != nil {
+		return errors.Wrapf(err, "remove ecs %s from scaling group", eid.Id)
+	}
+	if info.Node != nil {
+		err = m.manager.client.Delete(context.TODO(), info.Node)
+		if err != nil && !apierrors.IsNotFound(err) {
+			klog.Warningf("[NodeOperation] delete node %s of removed ecs: %s", info.Node.Name, err.Error())
+		}
+	}
+	fresh, err := m.waitReplacement(timeout)
+	if err != nil {
+		return errors.Wrapf(err, "wait replacement of ecs %s", eid.Id)
+	}
+	remain := timeout - time.Since(start)
+	if remain < time.Minute {
+		remain = time.Minute
+	}
+	name := fmt.Sprintf("%s.%s", fresh.Ip, fresh.Id)
+	klog.Infof("[NodeOperation] ecs %s replaced by %s, wait for node becoming ready", eid.Id, fresh.Id)
+	err = h.WaitHeartbeat(m.manager.client, name, remain)
+	if err != nil {
+		return fmt.Errorf("replacement %s of ecs %s not ready: %s", fresh.Id, eid.Id, err.Error())
+	}
+	klog.Infof("[NodeOperation] replace node %s finished.", eid.Id)
+	return nil
+}
+
+// waitReplacement wait for an ecs of the nodepool not seen by the triple,
+// which is created by the scaling group in place of the removed one
+func (m *NodeOperation) waitReplacement(timeout time.Duration) (*pd.Instance, error) {
+	if m.trip.getter == nil {
+		return nil, fmt.Errorf("triple of nodepool %s without getter", m.trip.pool)
+	}
+	known := map[string]bool{}
+	for _, ins := range m.trip.instances {
+		known[ins.Id] = true
+	}
+	var fresh *pd.Instance
+	err := wait.PollImmediate(
+		10*time.Second, timeout,
+		func() (done bool, err error) {
+			detail, err := m.trip.getter.GetNodePoolECS(m.trip.nodepool)
+			if err != nil {
+				klog.Warningf("[NodeOperation] wait replacement: %s", err.Error())
+				return false, nil
+			}
+			for id, ins := range detail {
+				if !known[id] && ins.Ip != "" {
+					i := ins
+					fresh = &i
+					return true, nil
+				}
+			}
+			return false, nil
+		},
+	)
+	return fresh, err
+}
+
 // remove etcd member
 func (m *NodeOperation) removeEtcd(ip string) error {
 	// remove etcd member first.
@@ -242,7 +381,7 @@ func (m *NodeOperation) removeEtcd(ip string) error {
 	if err != nil {
 		return fmt.Errorf("clean up etcd: member %s", err.Error())
 	}
-	return metcd.RemoveMember(etcd.FindMemberByIP(mems.Members, ip))
+	return metcd.RemoveMemberSafely(etcd.FindMemberByIP(mems.Members, ip))
 }
 
 func (m *NodeOperation) Drain(info *NodeInfo) error {
@@ -299,17 +438,20 @@ func (m *NodeOperation) Cordon(info *NodeInfo, cordon bool) error {
 	return nil
 }
 
-func (m *NodeOperation) RunCommand(info *NodeInfo, cmd string) error {
+func (m *NodeOperation) RunCommand(info *NodeInfo, cmd string, timeout time.Duration) error {
 	eid := info.Instance
 	if eid == nil {
 		return fmt.Errorf("empty instance information: %s", info)
 	}
+	m.manager.recd.Eventf(eventTarget(m.trip.cluster, info), v1.EventTypeNormal,
+		ReasonRunCommand, "run [%s] on ecs %s to fix node", cmd, eid.Id)
 	ctx := pd.NewContextWithCluster(&m.trip.cluster.Spec)
 	_, err := m.manager.prvd.RunCommand(ctx, eid.Id, cmd)
+	h.CountAPIError("RunCommand", err)
 	if err != nil {
 		return errors.Wrapf(err, "run command[%s]", cmd)
 	}
-	return h.WaitHeartbeat(m.manager.client, info.GetNodeName(), 30*time.Second)
+	return h.WaitHeartbeat(m.manager.client, info.GetNodeName(), timeout)
 }
 
 func (m *NodeOperation) LabelNode(info *NodeInfo, lbl map[string]string) error {
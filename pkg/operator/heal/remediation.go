@@ -0,0 +1,469 @@
+package heal
+
+import (
+	mctx "context"
+	"fmt"
+	api "github.com/aoxn/wdrip/pkg/apis/alibabacloud.com/v1"
+	pd "github.com/aoxn/wdrip/pkg/iaas/provider"
+	"github.com/pkg/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/klog/v2"
+	"regexp"
+	"sort"
+	"sync"
+	"time"
+)
+
+// DefaultPolicyName name of the policy used when no RemediationPolicy
+// selects a node
+const DefaultPolicyName = "default"
+
+// DefaultReplacePolicyName name of the default policy of workers in a
+// nodepool of Replace mode, see NodePoolSpec.RemediationMode
+const DefaultReplacePolicyName = "default-replace"
+
+// default throttle of disruptive steps
+const (
+	DefaultCreateGrace      = 5 * time.Minute
+	DefaultInstanceInterval = 3 * time.Minute
+	DefaultNotReadyFor      = 1 * time.Minute
+)
+
+var serviceName = regexp.MustCompile(`^[a-zA-Z0-9@._-]+$`)
+
+// DefaultRemediationPolicy restart kubelet once, then reimage the node
+// until it is ready again.
+func DefaultRemediationPolicy() *api.RemediationPolicy {
+	policy := &api.RemediationPolicy{
+		ObjectMeta: metav1.ObjectMeta{Name: DefaultPolicyName},
+		Spec: api.RemediationPolicySpec{
+			Steps: []api.RemediationStep{
+				{Action: api.RemediationRestartService, MaxAttempts: 1},
+				{Action: api.RemediationReimage},
+			},
+		},
+	}
+	SetPolicyDefaults(&policy.Spec)
+	return policy
+}
+
+// SetPolicyDefaults fill in the defaults of unset fields
+func SetPolicyDefaults(spec *api.RemediationPolicySpec) {
+	if len(spec.Steps) == 0 {
+		spec.Steps = DefaultRemediationPolicy().Spec.Steps
+	}
+	setStepDefaults(spec.Steps)
+	setSignalDefaults(spec)
+	throttle := &spec.Throttle
+	if throttle.CreateGrace.Duration == 0 {
+		throttle.CreateGrace.Duration = DefaultCreateGrace
+	}
+	if throttle.InstanceInterval.Duration == 0 {
+		throttle.InstanceInterval.Duration = DefaultInstanceInterval
+	}
+	if throttle.NotReadyFor.Duration == 0 {
+		throttle.NotReadyFor.Duration = DefaultNotReadyFor
+	}
+}
+
+func setStepDefaults(steps []api.RemediationStep) {
+	for i := range steps {
+		step := &steps[i]
+		if step.Action == api.RemediationRestartService && step.Service == "" {
+			step.Service = "kubelet"
+		}
+		if step.Timeout.Duration == 0 {
+			switch step.Action {
+			case api.RemediationRestartService:
+				step.Timeout.Duration = 30 * time.Second
+			case api.RemediationReplace:
+				// a new instance is created and joins the cluster
+				step.Timeout.Duration = 10 * time.Minute
+			default:
+				step.Timeout.Duration = 3 * time.Minute
+			}
+		}
+	}
+}
+
+// ValidatePolicy returns error on unknown action or malformed field
+func ValidatePolicy(spec *api.RemediationPolicySpec) error {
+	switch spec.Selector.Role {
+	case "", api.RemediationRoleMaster, api.RemediationRoleWorker:
+	default:
+		return fmt.Errorf("unknown role: %s", spec.Selector.Role)
+	}
+	if spec.Selector.Labels != nil {
+		_, err := metav1.LabelSelectorAsSelector(spec.Selector.Labels)
+		if err != nil {
+			return errors.Wrapf(err, "label selector")
+		}
+	}
+	if err := validateSteps(spec.Selector.Role, spec.Steps); err != nil {
+		return err
+	}
+	return validateSignals(spec.Selector.Role, spec.Signals)
+}
+
+func validateSteps(role string, steps []api.RemediationStep) error {
+	for i, step := range steps {
+		switch step.Action {
+		case api.RemediationRestartService:
+			if step.Service != "" && !serviceName.MatchString(step.Service) {
+				return fmt.Errorf("step %d: invalid service name %q", i, step.Service)
+			}
+		case api.RemediationReboot, api.RemediationReimage:
+		case api.RemediationReplace:
+			// masters are etcd members, scaled by masterset only
+			if role == api.RemediationRoleMaster {
+				return fmt.Errorf("step %d: %s is not supported for masters", i, step.Action)
+			}
+		default:
+			return fmt.Errorf("step %d: unknown action %q", i, step.Action)
+		}
+		if step.MaxAttempts < 0 || step.Timeout.Duration < 0 || step.Backoff.Duration < 0 {
+			return fmt.Errorf("step %d: negative attempts or duration", i)
+		}
+	}
+	return nil
+}
+
+// Matches whether selector selects node info of nodepool pool
+func Matches(sel api.RemediationSelector, pool string, info *NodeInfo) bool {
+	switch sel.Role {
+	case api.RemediationRoleMaster:
+		if info.Role != pd.JoinMasterUserdata {
+			return false
+		}
+	case api.RemediationRoleWorker:
+		if info.Role != pd.WorkerUserdata {
+			return false
+		}
+	}
+	if len(sel.NodePools) > 0 {
+		found := false
+		for _, np := range sel.NodePools {
+			if np == pool {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return false
+		}
+	}
+	if sel.Labels == nil {
+		return true
+	}
+	selector, err := metav1.LabelSelectorAsSelector(sel.Labels)
+	if err != nil {
+		return false
+	}
+	if selector.Empty() {
+		return true
+	}
+	if info.Node == nil {
+		return false
+	}
+	return selector.Matches(labels.Set(info.Node.Labels))
+}
+
+// SelectPolicy the valid policy of the highest priority selecting info,
+// ties are broken by name. nil when none selects info.
+func SelectPolicy(policies []api.RemediationPolicy, pool string, info *NodeInfo) *api.RemediationPolicy {
+	var matched []api.RemediationPolicy
+	for _, p := range policies {
+		if err := ValidatePolicy(&p.Spec); err != nil {
+			klog.Warningf("skip invalid remediation policy %s: %s", p.Name, err.Error())
+			continue
+		}
+		if Matches(p.Spec.Selector, pool, info) {
+			matched = append(matched, p)
+		}
+	}
+	if len(matched) == 0 {
+		return nil
+	}
+	sort.SliceStable(
+		matched,
+		func(i, j int) bool {
+			if matched[i].Spec.Priority != matched[j].Spec.Priority {
+				return matched[i].Spec.Priority > matched[j].Spec.Priority
+			}
+			return matched[i].Name < matched[j].Name
+		},
+	)
+	policy := matched[0].DeepCopy()
+	SetPolicyDefaults(&policy.Spec)
+	return policy
+}
+
+// policyFor remediation policy of info in trip, the default policy when
+// none selects it
+func (m *Healet) policyFor(trip *Triple, info *NodeInfo) *api.RemediationPolicy {
+	policies := &api.RemediationPolicyList{}
+	err := m.client.List(mctx.TODO(), policies)
+	if err != nil {
+		klog.Warningf("list remediation policy, use default: %s", err.Error())
+		return DefaultRemediationPolicy()
+	}
+	policy := SelectPolicy(policies.Items, trip.pool, info)
+	if policy == nil {
+		return defaultPolicy(trip)
+	}
+	return policy
+}
+
+// defaultPolicy the default policy of trip. workers of a nodepool in
+// Replace mode are replaced through the scaling group instead of reimaged.
+func defaultPolicy(trip *Triple) *api.RemediationPolicy {
+	policy := DefaultRemediationPolicy()
+	if trip.nodepool == nil ||
+		trip.nodepool.Spec.RemediationMode != api.RemediationReplace {
+		return policy
+	}
+	policy.Name = DefaultReplacePolicyName
+	for i := range policy.Spec.Steps {
+		step := &policy.Spec.Steps[i]
+		if step.Action == api.RemediationReimage {
+			step.Action, step.Timeout.Duration = api.RemediationReplace, 0
+		}
+	}
+	setStepDefaults(policy.Spec.Steps)
+	return policy
+}
+
+// rung position of a node on its remediation ladder
+type rung struct {
+	// Policy name and generation of the policy climbed, the ladder
+	// starts over when the policy changes
+	Policy   string
+	Step     int
+	Attempts int
+	Last     time.Time
+	// Record name of the NodeRepair record, Closed once finished
+	Record string
+	Closed bool
+	// Role Reason Started of the repair, for metrics
+	Role    string
+	Reason  string
+	Started time.Time
+}
+
+// NewLadder ladder recording remediation to journal, nil for none
+func NewLadder(journal Journal) *Ladder {
+	return &Ladder{rungs: map[string]*rung{}, journal: journal}
+}
+
+// WithBreaker stop steps not admitted by breaker, failed steps are
+// counted by breaker
+func (l *Ladder) WithBreaker(breaker *Breaker) *Ladder {
+	l.breaker = breaker
+	return l
+}
+
+// Verifier checks the signal repair was opened for is gone after a step
+// succeeded, an error makes the step a failed attempt
+type Verifier func(info *NodeInfo, policy *api.RemediationPolicy, repair api.NodeRepairSpec) error
+
+// WithVerifier verify signal repairs with verify once a step succeeded,
+// a signal still firing does not close the repair as fixed
+func (l *Ladder) WithVerifier(verify Verifier) *Ladder {
+	l.verify = verify
+	return l
+}
+
+// Ladder tracks the remediation step of each node under repair keyed by
+// instance id. a step is retried after its backoff up to MaxAttempts,
+// then the next step is tried. a node is forgotten once it is fixed or
+// seen ready.
+type Ladder struct {
+	mutex   sync.Mutex
+	rungs   map[string]*rung
+	journal Journal
+	breaker *Breaker
+	verify  Verifier
+}
+
+// Forget drop the ladder state of instance ids, nodes ready again
+func (l *Ladder) Forget(ids ...string) {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+	for _, id := range ids {
+		if r, ok := l.rungs[id]; ok {
+			l.close(r, api.NodeRepairSucceeded, "node is ready again")
+			delete(l.rungs, id)
+		}
+	}
+}
+
+func (l *Ladder) rung(id, policy string) *rung {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+	r, ok := l.rungs[id]
+	if !ok || r.Policy != policy {
+		if ok {
+			l.close(r, api.NodeRepairFailed, fmt.Sprintf("policy changed to %s", policy))
+		}
+		r = &rung{Policy: policy}
+		l.rungs[id] = r
+	}
+	return r
+}
+
+// Climb run the current step of policy on info, escalating to the next
+// step in the same round once a step has used up its attempts. a throttled
+// step, see Retry, does not count as an attempt. steps are recorded in
+// the journal with repair as the record of a new remediation.
+func (l *Ladder) Climb(
+	nop Operation,
+	info *NodeInfo,
+	policy *api.RemediationPolicy,
+	repair api.NodeRepairSpec,
+) error {
+	id := info.Instance.Id
+	r := l.rung(id, fmt.Sprintf("%s/%d/%s", policy.Name, policy.Generation, repair.Signal))
+	l.open(r, repair)
+	steps := policy.Spec.Steps
+	for r.Step < len(steps) {
+		step := steps[r.Step]
+		if destroys(step) && SkipReimage(info) {
+			klog.Infof("[%s]skip [%s] by annotation %s", id, step.Action, api.HealSkipReimageAnnotation)
+			l.step(r, step, r.Attempts+1, time.Now(), &Skipped{Annotation: api.HealSkipReimageAnnotation})
+			r.Step++
+			r.Attempts = 0
+			r.Last = time.Time{}
+			continue
+		}
+		if wait := step.Backoff.Duration - time.Since(r.Last); r.Attempts > 0 && wait > 0 {
+			klog.Infof("[%s]backoff [%s] for %s", id, step.Action, wait)
+			return NewRetry(wait)
+		}
+		if l.breaker != nil {
+			if err := l.breaker.Admit(info, step); err != nil {
+				klog.Warningf("[%s]skip [%s]: %s", id, step.Action, err.Error())
+				l.step(r, step, r.Attempts+1, time.Now(), err)
+				return err
+			}
+		}
+		klog.Infof("[%s]trying to fix node with [%s], policy=%s, attempt=%d",
+			id, step.Action, policy.Name, r.Attempts+1)
+		start := time.Now()
+		err := remediate(nop, info, step, policy.Spec.Throttle)
+		if err == nil && repair.Signal != "" && l.verify != nil {
+			// a node firing a signal is ready, the step succeeding
+			// does not tell the signal is gone
+			err = l.verify(info, policy, repair)
+		}
+		l.step(r, step, r.Attempts+1, start, err)
+		if err == nil {
+			klog.Infof("[%s]fixed node with [%s]", id, step.Action)
+			l.mutex.Lock()
+			l.close(r, api.NodeRepairSucceeded, fmt.Sprintf("fixed with %s", step.Action))
+			delete(l.rungs, id)
+			l.mutex.Unlock()
+			return nil
+		}
+		switch err.(type) {
+		case *Retry, *DryRun:
+			return err
+		}
+		r.Attempts++
+		r.Last = time.Now()
+		klog.Warningf("[%s]failed to fix[NotFixed] with [%s], attempt %d: %s",
+			id, step.Action, r.Attempts, err.Error())
+		if step.MaxAttempts == 0 || r.Attempts < step.MaxAttempts {
+			return err
+		}
+		r.Step++
+		r.Attempts = 0
+		r.Last = time.Time{}
+	}
+	l.mutex.Lock()
+	l.close(r, api.NodeRepairFailed, "all remediation steps failed, node needs manual repair")
+	l.mutex.Unlock()
+	return fmt.Errorf("[%s]all %d remediation steps of policy %s failed, "+
+		"node needs manual repair", id, len(steps), policy.Name)
+}
+
+// open record of r on its first step
+func (l *Ladder) open(r *rung, repair api.NodeRepairSpec) {
+	if !r.Started.IsZero() {
+		return
+	}
+	r.Started = time.Now()
+	r.Role = roleLabel(repair.Role)
+	r.Reason = repair.Reason
+	if l.journal == nil {
+		return
+	}
+	name, err := l.journal.Open(repair)
+	if err != nil {
+		klog.Warningf("[%s]open node repair record: %s", repair.InstanceID, err.Error())
+		return
+	}
+	r.Record = name
+}
+
+func (l *Ladder) step(r *rung, step api.RemediationStep, attempt int, start time.Time, err error) {
+	record := api.RepairStep{
+		Action:    step.Action,
+		Attempt:   attempt,
+		StartedAt: metav1.NewTime(start),
+		Duration:  metav1.Duration{Duration: time.Since(start).Round(time.Second)},
+		Outcome:   api.RepairStepSucceeded,
+	}
+	if err != nil {
+		record.Outcome = api.RepairStepFailed
+		switch err.(type) {
+		case *Retry, *CircuitOpen:
+			record.Outcome = api.RepairStepThrottled
+		case *Skipped:
+			record.Outcome = api.RepairStepSkipped
+		case *DryRun:
+			record.Outcome = api.RepairStepDryRun
+		}
+		record.Message = err.Error()
+	}
+	if record.Outcome == api.RepairStepFailed && l.breaker != nil {
+		l.breaker.Failure()
+	}
+	repairSteps.WithLabelValues(record.Action, record.Outcome).Inc()
+	if l.journal == nil || r.Record == "" {
+		return
+	}
+	if err := l.journal.Step(r.Record, record); err != nil {
+		klog.Warningf("record step of node repair %s: %s", r.Record, err.Error())
+	}
+}
+
+// close record of r, l.mutex must be held
+func (l *Ladder) close(r *rung, phase, message string) {
+	if r.Closed || r.Started.IsZero() {
+		return
+	}
+	r.Closed = true
+	observeRepair(r, phase)
+	if l.journal == nil || r.Record == "" {
+		return
+	}
+	if err := l.journal.Close(r.Record, phase, message); err != nil {
+		klog.Warningf("close node repair %s: %s", r.Record, err.Error())
+	}
+}
+
+// remediate run step on info
+func remediate(nop Operation, info *NodeInfo, step api.RemediationStep, throttle api.RemediationThrottle) error {
+	switch step.Action {
+	case api.RemediationRestartService:
+		return nop.RunCommand(info, fmt.Sprintf("systemctl restart %s", step.Service), step.Timeout.Duration)
+	case api.RemediationReboot:
+		return nop.Restart(info, step.Timeout.Duration)
+	case api.RemediationReimage:
+		return nop.Reset(info, throttle, step.Timeout.Duration)
+	case api.RemediationReplace:
+		return nop.Replace(info, throttle, step.Timeout.Duration)
+	}
+	return fmt.Errorf("remediation action %s is not supported", step.Action)
+}
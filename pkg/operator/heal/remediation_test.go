@@ -0,0 +1,243 @@
+package heal
+
+import (
+	"fmt"
+	api "github.com/aoxn/wdrip/pkg/apis/alibabacloud.com/v1"
+	"github.com/aoxn/wdrip/pkg/iaas/provider"
+	"github.com/stretchr/testify/assert"
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"testing"
+	"time"
+)
+
+// fakeOperation records actions taken, and fails actions in fail
+type fakeOperation struct {
+	actions []string
+	fail    map[string]error
+}
+
+func (f *fakeOperation) do(action string) error {
+	f.actions = append(f.actions, action)
+	return f.fail[action]
+}
+
+func (f *fakeOperation) Cordon(info *NodeInfo, cordon bool) error { return nil }
+
+func (f *fakeOperation) Drain(info *NodeInfo) error { return nil }
+
+func (f *fakeOperation) Restart(info *NodeInfo, timeout time.Duration) error {
+	return f.do(api.RemediationReboot)
+}
+
+func (f *fakeOperation) Reset(info *NodeInfo, throttle api.RemediationThrottle, timeout time.Duration) error {
+	return f.do(api.RemediationReimage)
+}
+
+func (f *fakeOperation) Replace(info *NodeInfo, throttle api.RemediationThrottle, timeout time.Duration) error {
+	return f.do(api.RemediationReplace)
+}
+
+func (f *fakeOperation) RunCommand(info *NodeInfo, cmd string, timeout time.Duration) error {
+	return f.do(cmd)
+}
+
+func (f *fakeOperation) LabelNode(info *NodeInfo, lbl map[string]string) error { return nil }
+
+func TestSelectPolicy(t *testing.T) {
+	policy := func(name string, priority int, sel api.RemediationSelector) api.RemediationPolicy {
+		return api.RemediationPolicy{
+			ObjectMeta: metav1.ObjectMeta{Name: name},
+			Spec:       api.RemediationPolicySpec{Selector: sel, Priority: priority},
+		}
+	}
+	gpu := &metav1.LabelSelector{MatchLabels: map[string]string{"gpu": "true"}}
+	policies := []api.RemediationPolicy{
+		policy("masters", 0, api.RemediationSelector{Role: api.RemediationRoleMaster}),
+		policy("pool-a", 0, api.RemediationSelector{NodePools: []string{"pool-a"}}),
+		policy("gpu", 10, api.RemediationSelector{Labels: gpu}),
+		policy("invalid", 100, api.RemediationSelector{Role: "unknown"}),
+	}
+	node := func(lbl map[string]string) *v1.Node {
+		return &v1.Node{ObjectMeta: metav1.ObjectMeta{Labels: lbl}}
+	}
+	cases := []struct {
+		name string
+		pool string
+		info NodeInfo
+		want string
+	}{
+		{"master", "", NodeInfo{Role: provider.JoinMasterUserdata, Node: node(nil)}, "masters"},
+		{"worker of pool", "pool-a", NodeInfo{Role: provider.WorkerUserdata, Node: node(nil)}, "pool-a"},
+		{"higher priority wins", "pool-a", NodeInfo{Role: provider.WorkerUserdata, Node: node(map[string]string{"gpu": "true"})}, "gpu"},
+		{"label selector without node", "pool-a", NodeInfo{Role: provider.WorkerUserdata}, "pool-a"},
+		{"none selected", "pool-b", NodeInfo{Role: provider.WorkerUserdata, Node: node(nil)}, ""},
+	}
+	for _, c := range cases {
+		p := SelectPolicy(policies, c.pool, &c.info)
+		if c.want == "" {
+			assert.Nil(t, p, c.name)
+			continue
+		}
+		if assert.NotNil(t, p, c.name) {
+			assert.Equal(t, c.want, p.Name, c.name)
+			assert.Equal(t, DefaultCreateGrace, p.Spec.Throttle.CreateGrace.Duration, c.name)
+		}
+	}
+}
+
+func TestValidatePolicy(t *testing.T) {
+	steps := func(step api.RemediationStep) *api.RemediationPolicySpec {
+		return &api.RemediationPolicySpec{Steps: []api.RemediationStep{step}}
+	}
+	assert.Nil(t, ValidatePolicy(&DefaultRemediationPolicy().Spec))
+	assert.Nil(t, ValidatePolicy(steps(api.RemediationStep{Action: api.RemediationRestartService, Service: "docker.service"})))
+	assert.NotNil(t, ValidatePolicy(steps(api.RemediationStep{Action: api.RemediationRestartService, Service: "kubelet; reboot"})))
+	assert.NotNil(t, ValidatePolicy(steps(api.RemediationStep{Action: "Explode"})))
+	assert.NotNil(t, ValidatePolicy(steps(api.RemediationStep{Action: api.RemediationReboot, MaxAttempts: -1})))
+
+	replace := steps(api.RemediationStep{Action: api.RemediationReplace})
+	replace.Selector.Role = api.RemediationRoleWorker
+	assert.Nil(t, ValidatePolicy(replace))
+	replace.Selector.Role = api.RemediationRoleMaster
+	assert.NotNil(t, ValidatePolicy(replace))
+	replace.Steps = nil
+	replace.Signals = []api.RemediationSignal{
+		{Type: "Ready", Steps: []api.RemediationStep{{Action: api.RemediationReplace}}},
+	}
+	assert.NotNil(t, ValidatePolicy(replace))
+}
+
+func TestLadder(t *testing.T) {
+	restart := "systemctl restart kubelet"
+	broken := fmt.Errorf("still not ready")
+	policy := &api.RemediationPolicy{
+		ObjectMeta: metav1.ObjectMeta{Name: "ladder"},
+		Spec: api.RemediationPolicySpec{
+			Steps: []api.RemediationStep{
+				{Action: api.RemediationRestartService, MaxAttempts: 2},
+				{Action: api.RemediationReboot, MaxAttempts: 1},
+				{Action: api.RemediationReimage, MaxAttempts: 1},
+			},
+		},
+	}
+	SetPolicyDefaults(&policy.Spec)
+	info := &NodeInfo{Instance: &provider.Instance{Id: "i-1"}}
+
+	nop := &fakeOperation{fail: map[string]error{restart: broken, api.RemediationReboot: broken}}
+	ladder := NewLadder(nil)
+	// restart kubelet twice over two rounds, then escalate
+	assert.NotNil(t, ladder.Climb(nop, info, policy, api.NodeRepairSpec{}))
+	assert.Equal(t, []string{restart}, nop.actions)
+	assert.Nil(t, ladder.Climb(nop, info, policy, api.NodeRepairSpec{}))
+	assert.Equal(t, []string{restart, restart, api.RemediationReboot, api.RemediationReimage}, nop.actions)
+
+	// fixed node starts over
+	nop.actions = nil
+	assert.NotNil(t, ladder.Climb(nop, info, policy, api.NodeRepairSpec{}))
+	assert.Equal(t, []string{restart}, nop.actions)
+
+	// throttled step is not an attempt
+	nop = &fakeOperation{fail: map[string]error{restart: broken, api.RemediationReboot: broken, api.RemediationReimage: NewRetry(time.Minute)}}
+	ladder = NewLadder(nil)
+	assert.NotNil(t, ladder.Climb(nop, info, policy, api.NodeRepairSpec{}))
+	assert.NotNil(t, ladder.Climb(nop, info, policy, api.NodeRepairSpec{}))
+	assert.IsType(t, &Retry{}, ladder.Climb(nop, info, policy, api.NodeRepairSpec{}))
+	assert.Equal(t, api.RemediationReimage, nop.actions[len(nop.actions)-1])
+
+	// exhausted ladder asks for manual repair
+	nop.fail[api.RemediationReimage] = broken
+	assert.NotNil(t, ladder.Climb(nop, info, policy, api.NodeRepairSpec{}))
+	nop.actions = nil
+	assert.NotNil(t, ladder.Climb(nop, info, policy, api.NodeRepairSpec{}))
+	assert.Empty(t, nop.actions)
+
+	// backoff between attempts of a step
+	policy.Spec.Steps[0].Backoff.Duration = time.Hour
+	policy.Generation++
+	nop.actions = nil
+	assert.NotNil(t, ladder.Climb(nop, info, policy, api.NodeRepairSpec{}))
+	assert.IsType(t, &Retry{}, ladder.Climb(nop, info, policy, api.NodeRepairSpec{}))
+	assert.Equal(t, []string{restart}, nop.actions)
+}
+
+func TestLadderSignalPersists(t *testing.T) {
+	restart := "systemctl restart kubelet"
+	policy := &api.RemediationPolicy{
+		ObjectMeta: metav1.ObjectMeta{Name: "signal"},
+		Spec: api.RemediationPolicySpec{
+			Steps: []api.RemediationStep{
+				{Action: api.RemediationRestartService, MaxAttempts: 2},
+				{Action: api.RemediationReboot, MaxAttempts: 1},
+			},
+		},
+	}
+	SetPolicyDefaults(&policy.Spec)
+	info := &NodeInfo{Instance: &provider.Instance{Id: "i-1"}}
+	repair := api.NodeRepairSpec{Signal: api.SignalDiskPressure}
+
+	var verified []string
+	firing := true
+	verify := func(info *NodeInfo, policy *api.RemediationPolicy, repair api.NodeRepairSpec) error {
+		verified = append(verified, repair.Signal)
+		if firing {
+			return fmt.Errorf("signal %s still firing", repair.Signal)
+		}
+		return nil
+	}
+	// every step succeeds, but the signal does not clear
+	nop := &fakeOperation{}
+	ladder := NewLadder(nil).WithVerifier(verify)
+	assert.NotNil(t, ladder.Climb(nop, info, policy, repair))
+	assert.Equal(t, []string{restart}, nop.actions)
+	// restart again, then escalate to reboot
+	assert.NotNil(t, ladder.Climb(nop, info, policy, repair))
+	assert.Equal(t, []string{restart, restart, api.RemediationReboot}, nop.actions)
+	assert.Equal(t, 3, len(verified))
+	// ladder is not closed as fixed, the exhausted ladder stays
+	assert.NotNil(t, ladder.Climb(nop, info, policy, repair))
+	assert.Equal(t, []string{restart, restart, api.RemediationReboot}, nop.actions)
+
+	// cleared signal closes the repair
+	firing = false
+	nop.actions = nil
+	ladder = NewLadder(nil).WithVerifier(verify)
+	assert.Nil(t, ladder.Climb(nop, info, policy, repair))
+	assert.Equal(t, []string{restart}, nop.actions)
+
+	// repairs of not ready nodes are not verified
+	verified = nil
+	firing = true
+	assert.Nil(t, ladder.Climb(nop, info, policy, api.NodeRepairSpec{}))
+	assert.Empty(t, verified)
+}
+
+func TestDefaultPolicyReplace(t *testing.T) {
+	assert.Equal(t, DefaultPolicyName, defaultPolicy(&Triple{}).Name)
+
+	np := &api.NodePool{Spec: api.NodePoolSpec{RemediationMode: api.RemediationReplace}}
+	policy := defaultPolicy(&Triple{pool: "pool-a", nodepool: np})
+	assert.Equal(t, DefaultReplacePolicyName, policy.Name)
+	assert.Equal(t, api.RemediationRestartService, policy.Spec.Steps[0].Action)
+	assert.Equal(t, api.RemediationReplace, policy.Spec.Steps[1].Action)
+	assert.Equal(t, 10*time.Minute, policy.Spec.Steps[1].Timeout.Duration)
+	assert.Nil(t, ValidatePolicy(&policy.Spec))
+
+	restart := "systemctl restart kubelet"
+	nop := &fakeOperation{fail: map[string]error{restart: fmt.Errorf("still not ready")}}
+	info := &NodeInfo{Instance: &provider.Instance{Id: "i-1"}, Role: provider.WorkerUserdata}
+	assert.Nil(t, NewLadder(nil).Climb(nop, info, policy, api.NodeRepairSpec{}))
+	assert.Equal(t, []string{restart, api.RemediationReplace}, nop.actions)
+}
+
+func TestReplacePrecondition(t *testing.T) {
+	ins := &provider.Instance{Id: "i-1"}
+	master := &NodeOperation{trip: &Triple{role: provider.JoinMasterUserdata}}
+	err := master.Replace(&NodeInfo{Instance: ins, Role: provider.JoinMasterUserdata}, api.RemediationThrottle{}, time.Minute)
+	assert.NotNil(t, err)
+
+	unbound := &NodeOperation{trip: &Triple{pool: "pool-a", nodepool: &api.NodePool{}}}
+	err = unbound.Replace(&NodeInfo{Instance: ins, Role: provider.WorkerUserdata}, api.RemediationThrottle{}, time.Minute)
+	assert.NotNil(t, err)
+	assert.Contains(t, err.Error(), "no scaling group")
+}
@@ -0,0 +1,239 @@
+package heal
+
+import (
+	mctx "context"
+	"fmt"
+	api "github.com/aoxn/wdrip/pkg/apis/alibabacloud.com/v1"
+	h "github.com/aoxn/wdrip/pkg/operator/controllers/help"
+	"github.com/pkg/errors"
+	v1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/util/retry"
+	"k8s.io/klog/v2"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"strings"
+	"time"
+)
+
+// NodeRepairRetention finished NodeRepair records are kept this long
+const NodeRepairRetention = 7 * 24 * time.Hour
+
+// Journal keeps a record of every remediation, see NodeRepair
+type Journal interface {
+	// Open a record of remediation, returns its name
+	Open(spec api.NodeRepairSpec) (string, error)
+	// Step append an attempted step to record name
+	Step(name string, step api.RepairStep) error
+	// Close record name with its final phase
+	Close(name, phase, message string) error
+}
+
+// NewRepairSpec the repair of info in trip following policy
+func NewRepairSpec(trip *Triple, info *NodeInfo, policy *api.RemediationPolicy) api.NodeRepairSpec {
+	spec := api.NodeRepairSpec{
+		NodeName: info.GetNodeName(),
+		Role:     info.Role,
+		NodePool: trip.pool,
+		Policy:   policy.Name,
+		Symptom:  Symptom(info),
+		Reason:   Reason(info),
+		DryRun:   DryRunMode(trip.cluster),
+		Snapshot: api.RepairSnapshot{
+			Instances: len(trip.instances),
+			Nodes:     len(trip.mnodes),
+			MasterCRs: len(trip.mCRDs),
+		},
+	}
+	if ins := info.Instance; ins != nil {
+		spec.InstanceID = ins.Id
+		spec.Snapshot.InstanceIP = ins.Ip
+		spec.Snapshot.InstanceStatus = ins.Status
+		spec.Snapshot.CreatedAt = ins.CreatedAt
+	}
+	if cond := readyCondition(info.Node); cond != nil {
+		spec.Snapshot.NodeReady = string(cond.Status)
+		spec.Snapshot.NodeReason = cond.Reason
+		spec.Snapshot.LastHeartbeat = cond.LastHeartbeatTime.DeepCopy()
+	}
+	if info.Resource != nil {
+		spec.Snapshot.MasterCR = info.Resource.Name
+	}
+	return spec
+}
+
+// Symptom why info needs repair
+func Symptom(info *NodeInfo) string {
+	if info.Node == nil {
+		return "instance without node object"
+	}
+	cond := readyCondition(info.Node)
+	if cond == nil {
+		return "node without Ready condition"
+	}
+	return fmt.Sprintf("node Ready=%s: %s", cond.Status, cond.Reason)
+}
+
+// repair reasons
+const (
+	SymptomNodeMissing      = "NodeMissing"
+	SymptomNodeNotReady     = "NodeNotReady"
+	SymptomNodeUnknown      = "NodeStatusUnknown"
+	SymptomNoReadyCondition = "NoReadyCondition"
+)
+
+// Reason short form of Symptom for metrics and events
+func Reason(info *NodeInfo) string {
+	if info.Node == nil {
+		return SymptomNodeMissing
+	}
+	cond := readyCondition(info.Node)
+	switch {
+	case cond == nil:
+		return SymptomNoReadyCondition
+	case cond.Status == v1.ConditionUnknown:
+		return SymptomNodeUnknown
+	}
+	return SymptomNodeNotReady
+}
+
+func readyCondition(node *v1.Node) *v1.NodeCondition {
+	if node == nil {
+		return nil
+	}
+	for i := range node.Status.Conditions {
+		if node.Status.Conditions[i].Type == v1.NodeReady {
+			return &node.Status.Conditions[i]
+		}
+	}
+	return nil
+}
+
+func NewJournal(client client.Client) *CRJournal { return &CRJournal{client: client} }
+
+// CRJournal keeps records as NodeRepair objects owned by the Cluster
+// object, and prunes them after NodeRepairRetention.
+type CRJournal struct {
+	client client.Client
+}
+
+func (j *CRJournal) Open(spec api.NodeRepairSpec) (string, error) {
+	repair := &api.NodeRepair{
+		TypeMeta: metav1.TypeMeta{
+			Kind:       api.NodeRepairKind,
+			APIVersion: api.SchemeGroupVersion.String(),
+		},
+		ObjectMeta: metav1.ObjectMeta{
+			GenerateName: repairPrefix(spec),
+		},
+		Spec: spec,
+		Status: api.NodeRepairStatus{
+			Phase:     api.NodeRepairRunning,
+			StartedAt: metav1.Now(),
+		},
+	}
+	cluster, err := h.Cluster(j.client, api.KUBERNETES_CLUSTER)
+	if err != nil {
+		klog.Warningf("node repair without owner: %s", err.Error())
+	} else {
+		repair.OwnerReferences = []metav1.OwnerReference{
+			{
+				APIVersion: api.SchemeGroupVersion.String(),
+				Kind:       api.ClusterKind,
+				Name:       cluster.Name,
+				UID:        cluster.UID,
+			},
+		}
+	}
+	err = j.client.Create(mctx.TODO(), repair)
+	if err != nil {
+		return "", errors.Wrapf(err, "create node repair for %s", spec.NodeName)
+	}
+	return repair.Name, nil
+}
+
+func (j *CRJournal) Step(name string, step api.RepairStep) error {
+	return j.update(
+		name,
+		func(repair *api.NodeRepair) {
+			steps := repair.Status.Steps
+			// a step throttled or dry run round after round is kept once
+			if n := len(steps); n > 0 &&
+				(step.Outcome == api.RepairStepThrottled || step.Outcome == api.RepairStepDryRun) &&
+				steps[n-1].Outcome == step.Outcome &&
+				steps[n-1].Action == step.Action {
+				steps[n-1].Message = step.Message
+				return
+			}
+			repair.Status.Steps = append(steps, step)
+		},
+	)
+}
+
+func (j *CRJournal) Close(name, phase, message string) error {
+	return j.update(
+		name,
+		func(repair *api.NodeRepair) {
+			now := metav1.Now()
+			repair.Status.Phase = phase
+			repair.Status.Message = message
+			repair.Status.FinishedAt = &now
+		},
+	)
+}
+
+func (j *CRJournal) update(name string, mutate func(repair *api.NodeRepair)) error {
+	return retry.RetryOnConflict(
+		retry.DefaultBackoff,
+		func() error {
+			repair := &api.NodeRepair{}
+			err := j.client.Get(mctx.TODO(), client.ObjectKey{Name: name}, repair)
+			if err != nil {
+				return err
+			}
+			mutate(repair)
+			return j.client.Update(mctx.TODO(), repair)
+		},
+	)
+}
+
+// Prune delete records finished, or started and never finished, more
+// than retention ago.
+func (j *CRJournal) Prune(retention time.Duration) error {
+	repairs := &api.NodeRepairList{}
+	err := j.client.List(mctx.TODO(), repairs)
+	if err != nil {
+		return errors.Wrapf(err, "list node repair")
+	}
+	cutoff := time.Now().Add(-retention)
+	for i := range repairs.Items {
+		repair := &repairs.Items[i]
+		last := repair.Status.StartedAt
+		if repair.Status.FinishedAt != nil {
+			last = *repair.Status.FinishedAt
+		}
+		if last.Time.After(cutoff) {
+			continue
+		}
+		klog.Infof("prune node repair %s of %s, last update at %s",
+			repair.Name, repair.Spec.NodeName, last.Format(time.RFC3339))
+		err := j.client.Delete(mctx.TODO(), repair)
+		if err != nil && !apierrors.IsNotFound(err) {
+			return errors.Wrapf(err, "delete node repair %s", repair.Name)
+		}
+	}
+	return nil
+}
+
+// repairPrefix generate name prefix of repair record
+func repairPrefix(spec api.NodeRepairSpec) string {
+	name := spec.InstanceID
+	if name == "" {
+		name = spec.NodeName
+	}
+	name = strings.ToLower(name)
+	if len(name) > 40 {
+		name = name[:40]
+	}
+	return fmt.Sprintf("%s-", strings.Trim(name, ".-"))
+}
@@ -0,0 +1,136 @@
+package heal
+
+import (
+	mctx "context"
+	"fmt"
+	api "github.com/aoxn/wdrip/pkg/apis/alibabacloud.com/v1"
+	"github.com/aoxn/wdrip/pkg/iaas/provider"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/assert"
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+	"testing"
+	"time"
+)
+
+func newFakeJournal(t *testing.T, objs ...runtime.Object) *CRJournal {
+	scheme := runtime.NewScheme()
+	assert.Nil(t, api.AddToScheme(scheme))
+	return NewJournal(fake.NewClientBuilder().WithScheme(scheme).WithRuntimeObjects(objs...).Build())
+}
+
+func listRepairs(t *testing.T, j *CRJournal) []api.NodeRepair {
+	list := &api.NodeRepairList{}
+	assert.Nil(t, j.client.List(mctx.TODO(), list))
+	return list.Items
+}
+
+func TestLadderJournal(t *testing.T) {
+	restart := "systemctl restart kubelet"
+	broken := fmt.Errorf("still not ready")
+	policy := DefaultRemediationPolicy()
+	policy.Spec.Steps[1].MaxAttempts = 1
+	info := &NodeInfo{Instance: &provider.Instance{Id: "i-1"}}
+	spec := api.NodeRepairSpec{
+		NodeName:   "node-1",
+		InstanceID: "i-1",
+		Symptom:    "node Ready=False",
+		Reason:     SymptomNodeNotReady,
+	}
+	fixed := repairs.WithLabelValues(api.RemediationRoleWorker, SymptomNodeNotReady, api.NodeRepairSucceeded)
+	gaveUp := repairs.WithLabelValues(api.RemediationRoleWorker, SymptomNodeNotReady, api.NodeRepairFailed)
+	base := testutil.ToFloat64(fixed)
+
+	journal := newFakeJournal(t)
+	ladder := NewLadder(journal)
+	nop := &fakeOperation{fail: map[string]error{restart: broken, api.RemediationReimage: NewRetry(time.Minute)}}
+	assert.NotNil(t, ladder.Climb(nop, info, policy, spec))
+	assert.IsType(t, &Retry{}, ladder.Climb(nop, info, policy, spec))
+	assert.IsType(t, &Retry{}, ladder.Climb(nop, info, policy, spec))
+
+	items := listRepairs(t, journal)
+	if assert.Len(t, items, 1) {
+		repair := items[0]
+		assert.Equal(t, "node-1", repair.Spec.NodeName)
+		assert.Equal(t, api.NodeRepairRunning, repair.Status.Phase)
+		// throttled steps in a row are recorded once
+		if assert.Len(t, repair.Status.Steps, 2) {
+			assert.Equal(t, api.RepairStepFailed, repair.Status.Steps[0].Outcome)
+			assert.Equal(t, api.RemediationReimage, repair.Status.Steps[1].Action)
+			assert.Equal(t, api.RepairStepThrottled, repair.Status.Steps[1].Outcome)
+		}
+	}
+
+	// node ready again closes the record
+	ladder.Forget("i-1")
+	assert.Equal(t, base+1, testutil.ToFloat64(fixed))
+	base = testutil.ToFloat64(gaveUp)
+	items = listRepairs(t, journal)
+	if assert.Len(t, items, 1) {
+		assert.Equal(t, api.NodeRepairSucceeded, items[0].Status.Phase)
+		assert.NotNil(t, items[0].Status.FinishedAt)
+	}
+
+	// exhausted ladder fails the record once
+	nop.fail[api.RemediationReimage] = broken
+	assert.NotNil(t, ladder.Climb(nop, info, policy, spec))
+	assert.NotNil(t, ladder.Climb(nop, info, policy, spec))
+	nop.actions = nil
+	assert.NotNil(t, ladder.Climb(nop, info, policy, spec))
+	assert.Empty(t, nop.actions)
+	items = listRepairs(t, journal)
+	if assert.Len(t, items, 2) {
+		failed := items[0]
+		if failed.Status.Phase != api.NodeRepairFailed {
+			failed = items[1]
+		}
+		assert.Equal(t, api.NodeRepairFailed, failed.Status.Phase)
+		assert.Len(t, failed.Status.Steps, 2)
+	}
+	assert.Equal(t, base+1, testutil.ToFloat64(gaveUp))
+}
+
+func TestReason(t *testing.T) {
+	node := func(status v1.ConditionStatus) *v1.Node {
+		return &v1.Node{
+			Status: v1.NodeStatus{
+				Conditions: []v1.NodeCondition{{Type: v1.NodeReady, Status: status}},
+			},
+		}
+	}
+	assert.Equal(t, SymptomNodeMissing, Reason(&NodeInfo{}))
+	assert.Equal(t, SymptomNoReadyCondition, Reason(&NodeInfo{Node: &v1.Node{}}))
+	assert.Equal(t, SymptomNodeUnknown, Reason(&NodeInfo{Node: node(v1.ConditionUnknown)}))
+	assert.Equal(t, SymptomNodeNotReady, Reason(&NodeInfo{Node: node(v1.ConditionFalse)}))
+}
+
+func TestJournalPrune(t *testing.T) {
+	repair := func(name string, started time.Time, finished *time.Time) *api.NodeRepair {
+		r := &api.NodeRepair{
+			ObjectMeta: metav1.ObjectMeta{Name: name},
+			Status:     api.NodeRepairStatus{StartedAt: metav1.NewTime(started)},
+		}
+		if finished != nil {
+			at := metav1.NewTime(*finished)
+			r.Status.FinishedAt = &at
+		}
+		return r
+	}
+	now := time.Now()
+	old := now.Add(-2 * NodeRepairRetention)
+	journal := newFakeJournal(
+		t,
+		repair("finished-long-ago", old, &old),
+		repair("finished-just-now", old, &now),
+		repair("running", now, nil),
+		repair("never-finished", old, nil),
+	)
+	assert.Nil(t, journal.Prune(NodeRepairRetention))
+	var names []string
+	for _, r := range listRepairs(t, journal) {
+		names = append(names, r.Name)
+	}
+	assert.ElementsMatch(t, []string{"finished-just-now", "running"}, names)
+}
@@ -0,0 +1,289 @@
+package heal
+
+import (
+	mctx "context"
+	"crypto/tls"
+	"fmt"
+	api "github.com/aoxn/wdrip/pkg/apis/alibabacloud.com/v1"
+	h "github.com/aoxn/wdrip/pkg/operator/controllers/help"
+	"github.com/pkg/errors"
+	v1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/util/wait"
+	"k8s.io/klog/v2"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// default thresholds of health signals
+const (
+	DefaultPressureFor      = 10 * time.Minute
+	DefaultCertExpiryWithin = 7 * 24 * time.Hour
+
+	// CertProbeInterval kubelet certificate of a node is probed at most
+	// once within
+	CertProbeInterval = time.Hour
+
+	// SignalSettle a signal is given up to to clear after a step succeeded
+	SignalSettle = 2 * time.Minute
+)
+
+// setSignalDefaults fill in the defaults of signals of spec, signals
+// without steps take the steps of spec
+func setSignalDefaults(spec *api.RemediationPolicySpec) {
+	for i := range spec.Signals {
+		sig := &spec.Signals[i]
+		if sig.Status == "" {
+			sig.Status = string(v1.ConditionTrue)
+		}
+		if sig.For.Duration == 0 {
+			switch sig.Type {
+			case api.SignalDiskPressure, api.SignalPIDPressure:
+				sig.For.Duration = DefaultPressureFor
+			case api.SignalKubeletCertificateExpiry:
+				sig.For.Duration = DefaultCertExpiryWithin
+			}
+		}
+		if len(sig.Steps) == 0 {
+			sig.Steps = append([]api.RemediationStep{}, spec.Steps...)
+		}
+		setStepDefaults(sig.Steps)
+	}
+}
+
+func validateSignals(role string, signals []api.RemediationSignal) error {
+	for i, sig := range signals {
+		if sig.Type == "" {
+			return fmt.Errorf("signal %d: empty type", i)
+		}
+		switch v1.ConditionStatus(sig.Status) {
+		case "", v1.ConditionTrue, v1.ConditionFalse, v1.ConditionUnknown:
+		default:
+			return fmt.Errorf("signal %d: unknown status %q", i, sig.Status)
+		}
+		if sig.For.Duration < 0 {
+			return fmt.Errorf("signal %d: negative duration", i)
+		}
+		if err := validateSteps(role, sig.Steps); err != nil {
+			return errors.Wrapf(err, "signal %s", sig.Type)
+		}
+	}
+	return nil
+}
+
+// CertExpiry tells when the kubelet serving certificate of node expires
+type CertExpiry interface {
+	NotAfter(node *v1.Node) (time.Time, error)
+}
+
+// FiringSignal the first signal of policy firing on info at now, and a
+// message telling why. nil when none fires or info has no node object.
+func FiringSignal(
+	policy *api.RemediationPolicy,
+	info *NodeInfo,
+	now time.Time,
+	certs CertExpiry,
+) (*api.RemediationSignal, string) {
+	if info.Node == nil {
+		return nil, ""
+	}
+	for i := range policy.Spec.Signals {
+		sig := &policy.Spec.Signals[i]
+		if message := firing(sig, info.Node, now, certs); message != "" {
+			return sig, message
+		}
+	}
+	return nil, ""
+}
+
+// firing message of sig firing on node, empty when it does not
+func firing(sig *api.RemediationSignal, node *v1.Node, now time.Time, certs CertExpiry) string {
+	switch sig.Type {
+	case api.SignalKubeletCertificateExpiry:
+		if certs == nil {
+			return ""
+		}
+		notAfter, err := certs.NotAfter(node)
+		if err != nil {
+			klog.V(4).Infof("[%s]probe kubelet certificate: %s", node.Name, err.Error())
+			return ""
+		}
+		if notAfter.Sub(now) > sig.For.Duration {
+			return ""
+		}
+		return fmt.Sprintf("kubelet certificate expires at %s", notAfter.Format(time.RFC3339))
+	case api.SignalContainerRuntimeUnhealthy:
+		ready := readyCondition(node)
+		if ready != nil &&
+			ready.Status != v1.ConditionTrue &&
+			runtimeDown(ready.Message) &&
+			now.Sub(ready.LastTransitionTime.Time) >= sig.For.Duration {
+			return fmt.Sprintf("node not ready: %s", ready.Message)
+		}
+	}
+	for _, cond := range node.Status.Conditions {
+		if string(cond.Type) != sig.Type || string(cond.Status) != sig.Status {
+			continue
+		}
+		if since := now.Sub(cond.LastTransitionTime.Time); since < sig.For.Duration {
+			return ""
+		}
+		return fmt.Sprintf("node condition %s=%s since %s: %s",
+			cond.Type, cond.Status, cond.LastTransitionTime.Format(time.RFC3339), cond.Message)
+	}
+	return ""
+}
+
+// runtimeDown whether message of Ready condition tells the container
+// runtime is not responding
+func runtimeDown(message string) bool {
+	return strings.Contains(message, "container runtime is down") ||
+		strings.Contains(message, "container runtime not ready") ||
+		strings.Contains(message, "PLEG is not healthy")
+}
+
+// signalPolicy policy whose steps are the steps of sig
+func signalPolicy(policy *api.RemediationPolicy, sig *api.RemediationSignal) *api.RemediationPolicy {
+	policy = policy.DeepCopy()
+	policy.Spec.Steps = sig.Steps
+	return policy
+}
+
+// signaled ready nodes of trip with a health signal of their policy firing
+func (m *Healet) signaled(trip *Triple) []NodeInfo {
+	policies := &api.RemediationPolicyList{}
+	err := m.client.List(mctx.TODO(), policies)
+	if err != nil {
+		klog.Warningf("list remediation policy, skip health signals: %s", err.Error())
+		return nil
+	}
+	var result []NodeInfo
+	now := time.Now()
+	for i := range trip.nodeInfo {
+		info := &trip.nodeInfo[i]
+		if info.Instance == nil || info.Node == nil || !h.NodeReady(info.Node) {
+			continue
+		}
+		// the default policy has no signals
+		policy := SelectPolicy(policies.Items, trip.pool, info)
+		if policy == nil {
+			continue
+		}
+		if sig, message := FiringSignal(policy, info, now, m.certs); sig != nil {
+			klog.Infof("[%s]health signal %s: %s", info.Node.Name, sig.Type, message)
+			result = append(result, *info)
+		}
+	}
+	return result
+}
+
+// signalCleared re-checks the signal repair was opened for on a fresh
+// node object until it clears, errors when it is still firing after
+// SignalSettle. the duration of a condition is not waited for again.
+func (m *Healet) signalCleared(
+	info *NodeInfo,
+	policy *api.RemediationPolicy,
+	repair api.NodeRepairSpec,
+) error {
+	var sig *api.RemediationSignal
+	for i := range policy.Spec.Signals {
+		if policy.Spec.Signals[i].Type == repair.Signal {
+			sig = policy.Spec.Signals[i].DeepCopy()
+			break
+		}
+	}
+	if sig == nil {
+		return nil
+	}
+	if sig.Type != api.SignalKubeletCertificateExpiry {
+		sig.For.Duration = 0
+	}
+	var message string
+	err := wait.PollImmediate(10*time.Second, SignalSettle, func() (bool, error) {
+		node, err := h.Node(m.client, info.GetNodeName())
+		if err != nil {
+			klog.Warningf("[%s]get node to verify signal %s: %s", info.GetNodeName(), sig.Type, err.Error())
+			return false, nil
+		}
+		if prober, ok := m.certs.(*CertProber); ok {
+			prober.Forget(node.Name)
+		}
+		message = firing(sig, node, time.Now(), m.certs)
+		return message == "", nil
+	})
+	if err != nil {
+		return fmt.Errorf("signal %s still firing after %s: %s", sig.Type, SignalSettle, message)
+	}
+	return nil
+}
+
+func NewCertProber() *CertProber { return &CertProber{probes: map[string]certProbe{}} }
+
+// CertProber reads the kubelet serving certificate of node over TLS, the
+// result is cached for CertProbeInterval
+type CertProber struct {
+	mutex  sync.Mutex
+	probes map[string]certProbe
+}
+
+type certProbe struct {
+	At       time.Time
+	NotAfter time.Time
+	Err      error
+}
+
+func (c *CertProber) NotAfter(node *v1.Node) (time.Time, error) {
+	c.mutex.Lock()
+	probe, ok := c.probes[node.Name]
+	c.mutex.Unlock()
+	if ok && time.Since(probe.At) < CertProbeInterval {
+		return probe.NotAfter, probe.Err
+	}
+	probe = certProbe{At: time.Now()}
+	probe.NotAfter, probe.Err = probeKubeletCert(node)
+	c.mutex.Lock()
+	c.probes[node.Name] = probe
+	c.mutex.Unlock()
+	return probe.NotAfter, probe.Err
+}
+
+// Forget the cached probe of node, probed again on next NotAfter
+func (c *CertProber) Forget(node string) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	delete(c.probes, node)
+}
+
+func probeKubeletCert(node *v1.Node) (time.Time, error) {
+	var ip string
+	for _, addr := range node.Status.Addresses {
+		if addr.Type == v1.NodeInternalIP {
+			ip = addr.Address
+			break
+		}
+	}
+	if ip == "" {
+		return time.Time{}, fmt.Errorf("no internal ip of node %s", node.Name)
+	}
+	port := int(node.Status.DaemonEndpoints.KubeletEndpoint.Port)
+	if port == 0 {
+		port = 10250
+	}
+	conn, err := tls.DialWithDialer(
+		&net.Dialer{Timeout: 5 * time.Second},
+		"tcp", net.JoinHostPort(ip, strconv.Itoa(port)),
+		// only the expiry of the certificate is read
+		&tls.Config{InsecureSkipVerify: true},
+	)
+	if err != nil {
+		return time.Time{}, errors.Wrapf(err, "dial kubelet %s", node.Name)
+	}
+	defer conn.Close()
+	certs := conn.ConnectionState().PeerCertificates
+	if len(certs) == 0 {
+		return time.Time{}, fmt.Errorf("no certificate presented by kubelet %s", node.Name)
+	}
+	return certs[0].NotAfter, nil
+}
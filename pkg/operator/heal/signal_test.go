@@ -0,0 +1,117 @@
+package heal
+
+import (
+	api "github.com/aoxn/wdrip/pkg/apis/alibabacloud.com/v1"
+	"github.com/aoxn/wdrip/pkg/iaas/provider"
+	"github.com/stretchr/testify/assert"
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"testing"
+	"time"
+)
+
+type fakeCerts map[string]time.Time
+
+func (f fakeCerts) NotAfter(node *v1.Node) (time.Time, error) { return f[node.Name], nil }
+
+func TestSignalDefaults(t *testing.T) {
+	spec := api.RemediationPolicySpec{
+		Steps: []api.RemediationStep{{Action: api.RemediationReboot}},
+		Signals: []api.RemediationSignal{
+			{Type: api.SignalKernelDeadlock},
+			{Type: api.SignalDiskPressure, Steps: []api.RemediationStep{{Action: api.RemediationRestartService}}},
+			{Type: api.SignalKubeletCertificateExpiry},
+		},
+	}
+	SetPolicyDefaults(&spec)
+	assert.Nil(t, ValidatePolicy(&spec))
+	assert.Equal(t, "True", spec.Signals[0].Status)
+	assert.Equal(t, api.RemediationReboot, spec.Signals[0].Steps[0].Action)
+	assert.Equal(t, DefaultPressureFor, spec.Signals[1].For.Duration)
+	assert.Equal(t, "kubelet", spec.Signals[1].Steps[0].Service)
+	assert.Equal(t, DefaultCertExpiryWithin, spec.Signals[2].For.Duration)
+
+	spec.Signals = append(spec.Signals, api.RemediationSignal{Type: "Custom", Status: "Maybe"})
+	assert.NotNil(t, ValidatePolicy(&spec))
+}
+
+func TestFiringSignal(t *testing.T) {
+	now := time.Now()
+	condition := func(typ string, status v1.ConditionStatus, since time.Duration, message string) v1.NodeCondition {
+		return v1.NodeCondition{
+			Type:               v1.NodeConditionType(typ),
+			Status:             status,
+			LastTransitionTime: metav1.NewTime(now.Add(-since)),
+			Message:            message,
+		}
+	}
+	policy := &api.RemediationPolicy{
+		Spec: api.RemediationPolicySpec{
+			Signals: []api.RemediationSignal{
+				{Type: api.SignalKernelDeadlock},
+				{Type: api.SignalDiskPressure},
+				{Type: api.SignalContainerRuntimeUnhealthy},
+				{Type: api.SignalKubeletCertificateExpiry},
+				{Type: "NetworkUnavailable", Status: "True", For: metav1.Duration{Duration: time.Minute}},
+			},
+		},
+	}
+	SetPolicyDefaults(&policy.Spec)
+	certs := fakeCerts{"expiring": now.Add(24 * time.Hour), "fresh": now.Add(300 * 24 * time.Hour)}
+	cases := []struct {
+		name  string
+		node  string
+		conds []v1.NodeCondition
+		want  string
+	}{
+		{"healthy", "fresh", []v1.NodeCondition{condition("KernelDeadlock", v1.ConditionFalse, time.Hour, "")}, ""},
+		{"kernel deadlock", "fresh", []v1.NodeCondition{condition("KernelDeadlock", v1.ConditionTrue, 0, "task hung")}, api.SignalKernelDeadlock},
+		{"disk pressure just now", "fresh", []v1.NodeCondition{condition("DiskPressure", v1.ConditionTrue, time.Minute, "")}, ""},
+		{"disk pressure persists", "fresh", []v1.NodeCondition{condition("DiskPressure", v1.ConditionTrue, time.Hour, "")}, api.SignalDiskPressure},
+		{
+			"runtime down", "fresh",
+			[]v1.NodeCondition{condition("Ready", v1.ConditionFalse, time.Minute, "container runtime is down")},
+			api.SignalContainerRuntimeUnhealthy,
+		},
+		{"runtime unhealthy by npd", "fresh", []v1.NodeCondition{condition("ContainerRuntimeUnhealthy", v1.ConditionTrue, 0, "")}, api.SignalContainerRuntimeUnhealthy},
+		{"certificate expiring", "expiring", nil, api.SignalKubeletCertificateExpiry},
+		{"user defined", "fresh", []v1.NodeCondition{condition("NetworkUnavailable", v1.ConditionTrue, time.Hour, "")}, "NetworkUnavailable"},
+	}
+	for _, c := range cases {
+		info := &NodeInfo{
+			Role:     provider.WorkerUserdata,
+			Instance: &provider.Instance{Id: "i-1"},
+			Node: &v1.Node{
+				ObjectMeta: metav1.ObjectMeta{Name: c.node},
+				Status:     v1.NodeStatus{Conditions: c.conds},
+			},
+		}
+		sig, message := FiringSignal(policy, info, now, certs)
+		if c.want == "" {
+			assert.Nil(t, sig, c.name)
+			continue
+		}
+		if assert.NotNil(t, sig, c.name) {
+			assert.Equal(t, c.want, sig.Type, c.name)
+			assert.NotEmpty(t, message, c.name)
+		}
+	}
+	sig, _ := FiringSignal(policy, &NodeInfo{Instance: &provider.Instance{Id: "i-1"}}, now, certs)
+	assert.Nil(t, sig)
+}
+
+func TestLadderSignal(t *testing.T) {
+	policy := DefaultRemediationPolicy()
+	policy.Spec.Signals = []api.RemediationSignal{{Type: api.SignalKernelDeadlock}}
+	policy.Spec.Signals[0].Steps = []api.RemediationStep{{Action: api.RemediationReboot, MaxAttempts: 1}}
+	SetPolicyDefaults(&policy.Spec)
+	info := &NodeInfo{Instance: &provider.Instance{Id: "i-1"}}
+	nop := &fakeOperation{fail: map[string]error{"systemctl restart kubelet": assert.AnError, api.RemediationReimage: assert.AnError}}
+	ladder := NewLadder(nil)
+	assert.NotNil(t, ladder.Climb(nop, info, policy, api.NodeRepairSpec{}))
+
+	// signal is remediated with its own steps from the start
+	sig := &policy.Spec.Signals[0]
+	assert.Nil(t, ladder.Climb(nop, info, signalPolicy(policy, sig), api.NodeRepairSpec{Signal: sig.Type}))
+	assert.Equal(t, []string{"systemctl restart kubelet", api.RemediationReimage, api.RemediationReboot}, nop.actions)
+}
@@ -60,6 +60,11 @@ type Triple struct {
 	instances []pd.Instance
 
 	nodeInfo []NodeInfo
+
+	// pool name of nodepool, empty for masters
+	pool string
+	// nodepool of workers, nil for masters
+	nodepool *api.NodePool
 }
 
 func (t *Triple) With(ins []pd.Instance) { t.instances = ins }
@@ -78,7 +83,7 @@ func (t *Triple) GetNode(id string) *v1.Node {
 }
 
 func NewTripleWorker(wgetter TripleGetter, np *api.NodePool) (*Triple, error) {
-	trip := &Triple{role: pd.WorkerUserdata, getter: wgetter}
+	trip := &Triple{role: pd.WorkerUserdata, pool: np.Name, nodepool: np, getter: wgetter}
 	spec, err := trip.getter.GetClusterItem()
 	if err != nil {
 		return trip, errors.Wrap(err, "get cluster")
@@ -97,12 +102,14 @@ func NewTripleWorker(wgetter TripleGetter, np *api.NodePool) (*Triple, error) {
 	trip.instances = ToList(detail)
 
 	for _, d := range detail {
-		info := NodeInfo{Instance: &d, Role: trip.role}
+		i := d
+		info := NodeInfo{Instance: &i, Role: trip.role}
 		// 1. match node
 		for _, n := range mNodes {
-			nid := n.Spec.ProviderID
-			if strings.Contains(nid, d.Id) {
-				info.Node = &n
+			node := n
+			nid := node.Spec.ProviderID
+			if strings.Contains(nid, i.Id) {
+				info.Node = &node
 				break
 			}
 		}
@@ -124,7 +131,8 @@ func NewTripleWorker(wgetter TripleGetter, np *api.NodePool) (*Triple, error) {
 			}
 		}
 		if !ifound {
-			info := NodeInfo{Node: &n, Role: trip.role}
+			node := n
+			info := NodeInfo{Node: &node, Role: trip.role}
 			trip.nodeInfo = append(trip.nodeInfo, info)
 		}
 	}
@@ -135,7 +143,7 @@ func NewTripleWorkerB(
 	m client.Client, infra Infra, np *api.NodePool,
 ) (*Triple, error) {
 
-	trip := &Triple{role: pd.WorkerUserdata}
+	trip := &Triple{role: pd.WorkerUserdata, pool: np.Name, nodepool: np}
 	spec, err := h.Cluster(m, api.KUBERNETES_CLUSTER)
 	if err != nil {
 		return trip, errors.Wrap(err, "get cluster")
@@ -154,12 +162,14 @@ func NewTripleWorkerB(
 	trip.instances = ToList(detail)
 
 	for _, d := range detail {
-		info := NodeInfo{Instance: &d, Role: trip.role}
+		i := d
+		info := NodeInfo{Instance: &i, Role: trip.role}
 		// 1. match node
 		for _, n := range mNodes {
-			nid := n.Spec.ProviderID
-			if strings.Contains(nid, d.Id) {
-				info.Node = &n
+			node := n
+			nid := node.Spec.ProviderID
+			if strings.Contains(nid, i.Id) {
+				info.Node = &node
 				break
 			}
 		}
@@ -178,7 +188,8 @@ func NewTripleWorkerB(
 			}
 		}
 		if !ifound {
-			info := NodeInfo{Node: &n, Role: trip.role}
+			node := n
+			info := NodeInfo{Node: &node, Role: trip.role}
 			trip.nodeInfo = append(trip.nodeInfo, info)
 		}
 	}
@@ -407,6 +418,32 @@ func (t *Triple) SystemInConsistency() bool {
 	return addl == 0
 }
 
+// inconsistency kinds of a Triple
+const (
+	NotReadyNode            = "NotReadyNode"
+	InstanceWithoutNode     = "InstanceWithoutNode"
+	NodeWithoutInstance     = "NodeWithoutInstance"
+	MasterCRWithoutInstance = "MasterCRWithoutInstance"
+	NodeWithoutMasterCR     = "NodeWithoutMasterCR"
+)
+
+// Inconsistencies count of each inconsistency kind, Master CR kinds are
+// counted for masters only
+func (t *Triple) Inconsistencies() map[string]int {
+	addition, deletion := t.InstanceNodeDiff()
+	kinds := map[string]int{
+		NotReadyNode:        len(t.UnReadyNodeList()),
+		InstanceWithoutNode: len(addition),
+		NodeWithoutInstance: len(deletion),
+	}
+	if t.role == pd.JoinMasterUserdata {
+		del, missed := t.MasterCRDDiff()
+		kinds[MasterCRWithoutInstance] = len(del)
+		kinds[NodeWithoutMasterCR] = len(missed)
+	}
+	return kinds
+}
+
 func (t *Triple) UnReadyNodeList() []NodeInfo {
 	var infos []NodeInfo
 	for _, n := range t.nodeInfo {
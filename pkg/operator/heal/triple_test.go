@@ -363,3 +363,20 @@ func TestNewTripleMaster(t *testing.T) {
 	}
 	assert.Equal(t, len(trip.nodeInfo), 3)
 }
+
+func TestInconsistencies(t *testing.T) {
+	getter := fakeTripleGetter{
+		masterNodes:     []v1.Node{node1, node2},
+		masterCRDs:      []api.Master{master2},
+		masterInstances: map[string]provider.Instance{inst1.Id: inst1},
+	}
+	trip, err := NewTripleMaster(getter)
+	if err != nil {
+		t.Fatalf("new triple master: %s", err.Error())
+	}
+	kinds := trip.Inconsistencies()
+	assert.Equal(t, 0, kinds[InstanceWithoutNode])
+	assert.Equal(t, 1, kinds[NodeWithoutInstance])
+	assert.Equal(t, 1, kinds[MasterCRWithoutInstance])
+	assert.Equal(t, 1, kinds[NodeWithoutMasterCR])
+}
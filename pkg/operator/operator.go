@@ -13,6 +13,7 @@ import (
 	"github.com/aoxn/wdrip/pkg/iaas/provider"
 	"github.com/aoxn/wdrip/pkg/iaas/provider/alibaba"
 	"github.com/aoxn/wdrip/pkg/operator/controllers/backup"
+	"github.com/aoxn/wdrip/pkg/operator/controllers/maintain"
 	"github.com/aoxn/wdrip/pkg/operator/heal"
 	"k8s.io/client-go/kubernetes"
 	"k8s.io/kubectl/pkg/drain"
@@ -269,7 +270,10 @@ func (v *Operator) startManager(spec *api.Cluster) error {
 		SkipWaitForDeleteTimeoutSeconds: 60,
 	}
 	// start member heal
-	mh, err := heal.NewHealet(spec, v.Mgr.GetClient(), v.Provider, drainer)
+	mh, err := heal.NewHealet(
+		spec, v.Mgr.GetClient(), v.Provider,
+		drainer, mgr.GetEventRecorderFor("healet"),
+	)
 	if err != nil {
 		return errors.Wrap(err, "master heal")
 	}
@@ -277,10 +281,14 @@ func (v *Operator) startManager(spec *api.Cluster) error {
 	if err != nil {
 		klog.Errorf("add Healet runner: %s", err.Error())
 	}
-	err = mgr.Add(backup.NewSnapshot())
+	err = mgr.Add(backup.NewSnapshot(mgr.GetEventRecorderFor("etcd-backup")))
 	if err != nil {
 		klog.Errorf("add Snapshot runner: %s", err.Error())
 	}
+	err = mgr.Add(maintain.NewEtcdMaintainer(mgr.GetEventRecorderFor("etcd-maintainer")))
+	if err != nil {
+		klog.Errorf("add EtcdMaintainer runner: %s", err.Error())
+	}
 
 	pctx, err := LoadContextIAAS(v.Provider, spec)
 	if err != nil {
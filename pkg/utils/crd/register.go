@@ -41,6 +41,8 @@ func doRegisterCRD(cfg *rest.Config) error {
 		NewMasterCRD(client),
 		NewMasterSetCRD(client),
 		NewNodePoolCRD(client),
+		NewRemediationPolicyCRD(client),
+		NewNodeRepairCRD(client),
 	} {
 		err := crd.Initialize()
 		if err != nil {
@@ -235,3 +237,59 @@ func (p *NodePoolCRD) GetListerWatcher() cache.ListerWatcher {
 
 // GetObject satisfies resource.crd interface (and retrieve.Retriever).
 func (p *NodePoolCRD) GetObject() runtime.Object { return &v1.NodePool{} }
+
+// RemediationPolicyCRD is the remediation policy crd.
+type RemediationPolicyCRD struct {
+	crdc Interface
+}
+
+func NewRemediationPolicyCRD(crdClient Interface) *RemediationPolicyCRD {
+	return &RemediationPolicyCRD{crdc: crdClient}
+}
+
+// Initialize satisfies resource.crd interface.
+func (p *RemediationPolicyCRD) Initialize() error {
+	crd := Conf{
+		Kind:       v1.RemediationPolicyKind,
+		NamePlural: v1.RemediationPolicyPlural,
+		Group:      v1.SchemeGroupVersion.Group,
+		Version:    v1.SchemeGroupVersion.Version,
+		Scope:      apiextv1beta1.ClusterScoped,
+	}
+
+	return p.crdc.EnsurePresent(crd)
+}
+
+// GetListerWatcher satisfies resource.crd interface (and retrieve.Retriever).
+func (p *RemediationPolicyCRD) GetListerWatcher() cache.ListerWatcher { return nil }
+
+// GetObject satisfies resource.crd interface (and retrieve.Retriever).
+func (p *RemediationPolicyCRD) GetObject() runtime.Object { return &v1.RemediationPolicy{} }
+
+// NodeRepairCRD is the node repair crd.
+type NodeRepairCRD struct {
+	crdc Interface
+}
+
+func NewNodeRepairCRD(crdClient Interface) *NodeRepairCRD {
+	return &NodeRepairCRD{crdc: crdClient}
+}
+
+// Initialize satisfies resource.crd interface.
+func (p *NodeRepairCRD) Initialize() error {
+	crd := Conf{
+		Kind:       v1.NodeRepairKind,
+		NamePlural: v1.NodeRepairPlural,
+		Group:      v1.SchemeGroupVersion.Group,
+		Version:    v1.SchemeGroupVersion.Version,
+		Scope:      apiextv1beta1.ClusterScoped,
+	}
+
+	return p.crdc.EnsurePresent(crd)
+}
+
+// GetListerWatcher satisfies resource.crd interface (and retrieve.Retriever).
+func (p *NodeRepairCRD) GetListerWatcher() cache.ListerWatcher { return nil }
+
+// GetObject satisfies resource.crd interface (and retrieve.Retriever).
+func (p *NodeRepairCRD) GetObject() runtime.Object { return &v1.NodeRepair{} }
@@ -59,18 +59,22 @@ func BuildClientGetter(
 }
 
 func ApplyInCluster(yml string) error {
-	return doApply(bytes.NewBufferString(yml), "")
+	return doApply(bytes.NewBufferString(yml), BuildClientGetter(""))
 }
 
 func ApplyWithKubeconfig(yml, kubeconfig string) error {
-	return doApply(bytes.NewBufferString(yml), kubeconfig)
+	return doApply(bytes.NewBufferString(yml), BuildClientGetter(kubeconfig))
+}
+
+func ApplyWithGetter(yml string, getter genericclioptions.RESTClientGetter) error {
+	return doApply(bytes.NewBufferString(yml), getter)
 }
 
 func doApply(
 	reader io.Reader,
-	kubeconfig string,
+	getter genericclioptions.RESTClientGetter,
 ) error {
-	f := cmdutil.NewFactory(BuildClientGetter(kubeconfig))
+	f := cmdutil.NewFactory(getter)
 	schema, err := f.Validator(true)
 	if err != nil {
 		return err
@@ -0,0 +1,232 @@
+package kubeclient
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"github.com/ghodss/yaml"
+	"io"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/util/sets"
+	kyaml "k8s.io/apimachinery/pkg/util/yaml"
+	"k8s.io/cli-runtime/pkg/genericclioptions"
+	"k8s.io/client-go/discovery"
+	"k8s.io/client-go/dynamic"
+	"k8s.io/klog/v2"
+	"strings"
+)
+
+var (
+	// ignoredResources are either rebuilt by controllers or
+	// meaningless out of the cluster they are created in.
+	ignoredResources = sets.NewString(
+		"events", "endpoints", "endpointslices", "leases",
+		"pods", "replicasets", "controllerrevisions", "jobs",
+		"localsubjectaccessreviews", "bindings",
+	)
+
+	// systemNamespaces are skipped unless specified explicitly
+	systemNamespaces = sets.NewString(
+		"kube-system", "kube-public", "kube-node-lease",
+	)
+)
+
+// ExportOption select api objects to export or restore.
+// empty Namespaces means all namespaces except system namespaces,
+// empty Kinds means all kinds. Kinds are matched by kind or by resource
+// name in plural or singular, eg. Deployment, deployments.
+type ExportOption struct {
+	Namespaces []string
+	Kinds      []string
+	Selector   string
+}
+
+func (o *ExportOption) matchNamespace(ns string) bool {
+	if len(o.Namespaces) == 0 {
+		return !systemNamespaces.Has(ns)
+	}
+	for _, n := range o.Namespaces {
+		if n == ns {
+			return true
+		}
+	}
+	return false
+}
+
+// matchKind resource names are derived from kind, the same on export
+// and restore where no discovery is at hand
+func (o *ExportOption) matchKind(kind string) bool {
+	if len(o.Kinds) == 0 {
+		return true
+	}
+	plural, singular := meta.UnsafeGuessKindToResource(schema.GroupVersionKind{Kind: kind})
+	for _, k := range o.Kinds {
+		if strings.EqualFold(k, kind) ||
+			strings.EqualFold(k, plural.Resource) ||
+			strings.EqualFold(k, singular.Resource) {
+			return true
+		}
+	}
+	return false
+}
+
+// ExportResources export namespaced api objects and the namespaces
+// they belong to as multi-document yaml. server populated fields are
+// stripped so that the result can be re-applied to any cluster.
+func ExportResources(
+	getter genericclioptions.RESTClientGetter, opt ExportOption,
+) ([]byte, error) {
+	cfg, err := getter.ToRESTConfig()
+	if err != nil {
+		return nil, fmt.Errorf("build rest config: %s", err.Error())
+	}
+	client, err := dynamic.NewForConfig(cfg)
+	if err != nil {
+		return nil, fmt.Errorf("build dynamic client: %s", err.Error())
+	}
+	disc, err := getter.ToDiscoveryClient()
+	if err != nil {
+		return nil, fmt.Errorf("build discovery client: %s", err.Error())
+	}
+	resources, err := disc.ServerPreferredNamespacedResources()
+	if err != nil {
+		if !discovery.IsGroupDiscoveryFailedError(err) {
+			return nil, fmt.Errorf("discovery resources: %s", err.Error())
+		}
+		klog.Warningf("partial discovery failure, continue: %s", err.Error())
+	}
+	var (
+		out        = bytes.NewBufferString("")
+		namespaces = sets.NewString()
+		objects    []unstructured.Unstructured
+	)
+	for _, list := range resources {
+		gv, err := schema.ParseGroupVersion(list.GroupVersion)
+		if err != nil {
+			klog.Warningf("skip group version %s: %s", list.GroupVersion, err.Error())
+			continue
+		}
+		for _, r := range list.APIResources {
+			if strings.Contains(r.Name, "/") ||
+				ignoredResources.Has(r.Name) ||
+				!sets.NewString(r.Verbs...).Has("list") ||
+				!opt.matchKind(r.Kind) {
+				continue
+			}
+			items, err := client.Resource(gv.WithResource(r.Name)).
+				Namespace(metav1.NamespaceAll).
+				List(context.TODO(), metav1.ListOptions{LabelSelector: opt.Selector})
+			if err != nil {
+				return nil, fmt.Errorf("list %s: %s", r.Name, err.Error())
+			}
+			for _, item := range items.Items {
+				if !opt.matchNamespace(item.GetNamespace()) ||
+					!exportable(&item) {
+					continue
+				}
+				namespaces.Insert(item.GetNamespace())
+				objects = append(objects, item)
+			}
+		}
+	}
+	// namespaces go first so that objects can be applied in order
+	for _, ns := range namespaces.List() {
+		item, err := client.Resource(schema.GroupVersionResource{Version: "v1", Resource: "namespaces"}).
+			Get(context.TODO(), ns, metav1.GetOptions{})
+		if err != nil {
+			return nil, fmt.Errorf("get namespace %s: %s", ns, err.Error())
+		}
+		if err := writeObject(out, item); err != nil {
+			return nil, err
+		}
+	}
+	for i := range objects {
+		if err := writeObject(out, &objects[i]); err != nil {
+			return nil, err
+		}
+	}
+	klog.Infof("exported %d objects in %d namespaces", len(objects), namespaces.Len())
+	return out.Bytes(), nil
+}
+
+// FilterResources select objects from exported yaml by namespace and kind.
+// Namespace objects are kept for selected namespaces.
+func FilterResources(data []byte, opt ExportOption) ([]byte, error) {
+	out := bytes.NewBufferString("")
+	decoder := kyaml.NewYAMLOrJSONDecoder(bytes.NewReader(data), 4096)
+	for {
+		item := unstructured.Unstructured{}
+		err := decoder.Decode(&item.Object)
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, fmt.Errorf("decode resources: %s", err.Error())
+		}
+		if len(item.Object) == 0 {
+			continue
+		}
+		if item.GetKind() == "Namespace" {
+			if !opt.matchNamespace(item.GetName()) {
+				continue
+			}
+		} else {
+			if !opt.matchNamespace(item.GetNamespace()) ||
+				!opt.matchKind(item.GetKind()) {
+				continue
+			}
+		}
+		if err := writeObject(out, &item); err != nil {
+			return nil, err
+		}
+	}
+	return out.Bytes(), nil
+}
+
+// exportable objects are not owned by others, and not generated
+// by kubernetes itself.
+func exportable(item *unstructured.Unstructured) bool {
+	if len(item.GetOwnerReferences()) != 0 {
+		return false
+	}
+	switch item.GetKind() {
+	case "ConfigMap":
+		return item.GetName() != "kube-root-ca.crt"
+	case "Secret":
+		kind, _, _ := unstructured.NestedString(item.Object, "type")
+		return kind != "kubernetes.io/service-account-token"
+	case "ServiceAccount":
+		return item.GetName() != "default"
+	}
+	return true
+}
+
+func writeObject(out io.Writer, item *unstructured.Unstructured) error {
+	obj := item.DeepCopy()
+	for _, field := range []string{
+		"uid", "resourceVersion", "creationTimestamp",
+		"generation", "managedFields", "selfLink",
+	} {
+		unstructured.RemoveNestedField(obj.Object, "metadata", field)
+	}
+	unstructured.RemoveNestedField(obj.Object, "status")
+	switch obj.GetKind() {
+	case "Service":
+		// cluster ip is allocated by apiserver
+		unstructured.RemoveNestedField(obj.Object, "spec", "clusterIP")
+		unstructured.RemoveNestedField(obj.Object, "spec", "clusterIPs")
+	case "PersistentVolumeClaim":
+		unstructured.RemoveNestedField(obj.Object, "spec", "volumeName")
+	case "Namespace":
+		unstructured.RemoveNestedField(obj.Object, "spec", "finalizers")
+	}
+	data, err := yaml.Marshal(obj.Object)
+	if err != nil {
+		return fmt.Errorf("marshal %s/%s: %s", obj.GetNamespace(), obj.GetName(), err.Error())
+	}
+	_, err = fmt.Fprintf(out, "---\n%s", data)
+	return err
+}
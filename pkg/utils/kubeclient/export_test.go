@@ -0,0 +1,61 @@
+package kubeclient
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+const exported = `---
+apiVersion: v1
+kind: Namespace
+metadata:
+  name: app
+---
+apiVersion: v1
+kind: ConfigMap
+metadata:
+  name: cfg
+  namespace: app
+---
+apiVersion: apps/v1
+kind: Deployment
+metadata:
+  name: web
+  namespace: app
+---
+apiVersion: v1
+kind: ConfigMap
+metadata:
+  name: cfg
+  namespace: db
+`
+
+func TestFilterResources(t *testing.T) {
+	data, err := FilterResources([]byte(exported), ExportOption{Namespaces: []string{"app"}})
+	assert.Nil(t, err)
+	assert.Equal(t, 3, strings.Count(string(data), "---"))
+	assert.NotContains(t, string(data), "namespace: db")
+
+	data, err = FilterResources([]byte(exported), ExportOption{Kinds: []string{"configmap"}})
+	assert.Nil(t, err)
+	assert.Equal(t, 3, strings.Count(string(data), "---"))
+	assert.NotContains(t, string(data), "Deployment")
+
+	// plural resource name as accepted on export
+	data, err = FilterResources([]byte(exported), ExportOption{Kinds: []string{"deployments"}})
+	assert.Nil(t, err)
+	assert.Equal(t, 2, strings.Count(string(data), "---"))
+	assert.Contains(t, string(data), "kind: Deployment")
+	assert.NotContains(t, string(data), "ConfigMap")
+}
+
+func TestMatchKind(t *testing.T) {
+	opt := ExportOption{Kinds: []string{"networkpolicies", "ingress", "Secret"}}
+	assert.True(t, opt.matchKind("NetworkPolicy"))
+	assert.True(t, opt.matchKind("Ingress"))
+	assert.True(t, opt.matchKind("Secret"))
+	assert.False(t, opt.matchKind("ConfigMap"))
+	assert.True(t, (&ExportOption{}).matchKind("ConfigMap"))
+}
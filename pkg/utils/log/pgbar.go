@@ -118,6 +118,45 @@ func (b *Pgmbar) AddEvent(newr Resource) {
 	}
 }
 
+// Transfer returns a progress callback which shows the transfer of
+// object key as an event of the bar.
+func (b *Pgmbar) Transfer(key string) func(transferred, total int64) {
+	started := time.Now()
+	return func(transferred, total int64) {
+		status := fmt.Sprintf("Transferring %s", bytesize(transferred))
+		if total > 0 {
+			status = fmt.Sprintf("Transferring %d%% %s/%s",
+				transferred*100/total, bytesize(transferred), bytesize(total))
+			if transferred >= total {
+				status = "Complete"
+			}
+		}
+		b.AddEvents([]Resource{
+			{
+				ResourceId:     key,
+				ResourceType:   "WDRIP::OSS::OBJECT",
+				ResourceName:   key,
+				ResourceStatus: status,
+				StartedTime:    started.Format("2006-01-02T15:04:05"),
+				UpdatedTime:    fmt.Sprintf("%ds", time.Since(started)/time.Second),
+			},
+		})
+	}
+}
+
+func bytesize(n int64) string {
+	const unit = 1024
+	if n < unit {
+		return fmt.Sprintf("%dB", n)
+	}
+	div, exp := int64(unit), 0
+	for m := n / unit; m >= unit; m /= unit {
+		div *= unit
+		exp++
+	}
+	return fmt.Sprintf("%.1f%cB", float64(n)/float64(div), "KMGTPE"[exp])
+}
+
 var (
 	SUCCESS = "SUCCESS"
 )